@@ -0,0 +1,401 @@
+// Package aof implements a cache that logs the puts and the deletes to an
+// append-only file, so a node without raft recovers its entries after a crash
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned when the log is rewritten after the cache is closed
+var ErrClosed = errors.New("aof: the cache is closed")
+
+// FsyncPolicy is when the log is synced to the disk
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs every write, no acknowledged write is lost
+	FsyncAlways FsyncPolicy = iota
+	// FsyncEverySecond syncs once a second, up to a second of the writes
+	// is lost if the machine crashes
+	FsyncEverySecond
+	// FsyncNever leaves the syncs to the operating system, the writes
+	// survive a crash of the process but not one of the machine
+	FsyncNever
+)
+
+// ParseFsyncPolicy returns the policy of the name, always, everysec or no
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch strings.ToLower(name) {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySecond, nil
+	case "no":
+		return FsyncNever, nil
+	}
+	return FsyncAlways, fmt.Errorf("unknown fsync policy %s", name)
+}
+
+// record is a line of the log
+type record struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// AOFCache wraps a cache, the puts and the deletes are applied to the cache
+// and appended to the log under a single lock, so the log replays them in
+// the order the cache applied them. The log is replayed into the cache when
+// it is created and rewritten to the entries of the cache once it grows
+type AOFCache struct {
+	cache.UnImplementedCache
+	sync.Mutex
+	path        string
+	file        *os.File
+	policy      FsyncPolicy
+	dirty       bool
+	size        int64
+	base        int64
+	rewriteSize int64
+	rewriting   bool
+	pending     [][]byte
+	rewrites    sync.Mutex
+	done        chan struct{}
+	stopped     chan struct{}
+	closed      bool
+}
+
+// NewCache AOFCache constructor, it replays the log at path into c and
+// appends to it from then on. The log is rewritten in the background once
+// it is over rewriteSize bytes and has doubled since the last rewrite, 0
+// means it is only rewritten by Rewrite
+func NewCache(c cache.UnImplementedCache, path string, policy FsyncPolicy, rewriteSize int64) (*AOFCache, error) {
+	size, err := replay(path, c)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &AOFCache{
+		UnImplementedCache: c,
+		path:               path,
+		file:               file,
+		policy:             policy,
+		size:               size,
+		base:               size,
+		rewriteSize:        rewriteSize,
+		done:               make(chan struct{}),
+		stopped:            make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// replay applies the records of the log to c and returns the size of the
+// log. A partial record at the end, which a crash in the middle of a write
+// leaves, is truncated, a malformed record before the end is an error
+func replay(path string, c cache.UnImplementedCache) (size int64, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var r record
+		err := dec.Decode(&r)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err == nil {
+			err = apply(c, &r)
+		}
+		if err != nil {
+			return truncate(f, path, size, err)
+		}
+		size = dec.InputOffset()
+	}
+}
+
+// truncate truncates the log at size if the rest of it is a partial record
+func truncate(f *os.File, path string, size int64, cause error) (int64, error) {
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return 0, err
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	partial := bytes.TrimLeft(rest, " \t\r\n")
+	if bytes.IndexByte(partial, '\n') >= 0 {
+		return 0, fmt.Errorf("aof: malformed record at %d of %s: %v", size, path, cause)
+	}
+	size += int64(len(rest) - len(partial))
+	log.Warnf("Truncated a partial record at %d of the append-only file %s", size, path)
+	return size, os.Truncate(path, size)
+}
+
+// apply applies the record to c
+func apply(c cache.UnImplementedCache, r *record) error {
+	switch r.Op {
+	case "put":
+		c.Put(r.Key, r.Value)
+	case "del":
+		c.Delete(r.Key)
+	default:
+		return fmt.Errorf("unknown op %q", r.Op)
+	}
+	return nil
+}
+
+// run syncs the log every second for FsyncEverySecond and rewrites it once
+// it grows over the rewrite size
+func (a *AOFCache) run() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.Lock()
+			if a.policy == FsyncEverySecond && a.dirty {
+				a.sync()
+			}
+			grown := a.rewriteSize > 0 && a.size >= a.rewriteSize && a.size >= 2*a.base
+			a.Unlock()
+			if grown {
+				if err := a.Rewrite(); err != nil {
+					log.Errorf("Cannot rewrite the append-only file %s: %v", a.path, err)
+				}
+			}
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// Put updates or inserts the entry in the cache and logs it, a put after
+// Close is ignored and returns false
+func (a *AOFCache) Put(key string, value string) (created bool) {
+	defer a.Unlock()
+	a.Lock()
+	if a.closed {
+		return false
+	}
+	created = a.UnImplementedCache.Put(key, value)
+	a.append(&record{Op: "put", Key: key, Value: value})
+	return created
+}
+
+// Delete deletes the key from the cache and logs it if it was there
+func (a *AOFCache) Delete(key string) (ok bool) {
+	defer a.Unlock()
+	a.Lock()
+	if a.closed {
+		return false
+	}
+	if ok = a.UnImplementedCache.Delete(key); ok {
+		a.append(&record{Op: "del", Key: key})
+	}
+	return ok
+}
+
+// append writes the record to the log and to the pending records of a
+// rewrite in progress, the caller must hold the lock
+func (a *AOFCache) append(r *record) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		log.Errorf("Cannot encode the record of %s: %v", r.Key, err)
+		return
+	}
+	b = append(b, '\n')
+	if a.rewriting {
+		a.pending = append(a.pending, b)
+	}
+	n, err := a.file.Write(b)
+	a.size += int64(n)
+	if err != nil {
+		log.Errorf("Cannot append to %s: %v", a.path, err)
+		return
+	}
+	a.dirty = true
+	if a.policy == FsyncAlways {
+		a.sync()
+	}
+}
+
+// sync syncs the log, the caller must hold the lock
+func (a *AOFCache) sync() {
+	if err := a.file.Sync(); err != nil {
+		log.Errorf("Cannot sync %s: %v", a.path, err)
+		return
+	}
+	a.dirty = false
+}
+
+// Size returns the size of the log in bytes
+func (a *AOFCache) Size() int64 {
+	defer a.Unlock()
+	a.Lock()
+	return a.size
+}
+
+// Rewrite replaces the log with a put for each entry of the cache, the
+// least recently used first if the cache is a cache.OrderedCache. The
+// writes go on during the rewrite, they are appended to both logs. The
+// cache must be a cache.ScanCache, otherwise it returns
+// cache.ErrNotSupported
+func (a *AOFCache) Rewrite() (err error) {
+	defer a.rewrites.Unlock()
+	a.rewrites.Lock()
+	keys, err := a.keys()
+	if err != nil {
+		return err
+	}
+	a.Lock()
+	if a.closed {
+		a.Unlock()
+		return ErrClosed
+	}
+	a.rewriting = true
+	a.pending = nil
+	a.Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(a.path), filepath.Base(a.path)+".tmp")
+	if err == nil {
+		err = a.write(tmp, keys)
+	}
+	defer a.Unlock()
+	a.Lock()
+	a.rewriting = false
+	if err == nil && a.closed {
+		err = ErrClosed
+	}
+	for _, b := range a.pending {
+		if err == nil {
+			_, err = tmp.Write(b)
+		}
+	}
+	a.pending = nil
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if tmp != nil {
+		if e := tmp.Close(); err == nil {
+			err = e
+		}
+	}
+	if err == nil {
+		err = a.swap(tmp.Name())
+	}
+	if err != nil && tmp != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// keys returns the keys of the cache to rewrite, the next to be evicted first
+func (a *AOFCache) keys() ([]string, error) {
+	if ordered, ok := a.UnImplementedCache.(cache.OrderedCache); ok {
+		keys := ordered.OrderedKeys()
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		return keys, nil
+	}
+	if scan, ok := a.UnImplementedCache.(cache.ScanCache); ok {
+		return scan.Keys(), nil
+	}
+	return nil, cache.ErrNotSupported
+}
+
+// write writes a put of the current value of each key to w, the keys that
+// are not in the cache any more are skipped
+func (a *AOFCache) write(w io.Writer, keys []string) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	peek, _ := a.UnImplementedCache.(cache.PeekCache)
+	for _, key := range keys {
+		var value string
+		var ok bool
+		if peek != nil {
+			value, ok = peek.Peek(key)
+		} else {
+			value, ok = a.UnImplementedCache.Get(key)
+		}
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(&record{Op: "put", Key: key, Value: value}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// swap renames the rewritten log over the log and appends to it from then
+// on, the caller must hold the lock
+func (a *AOFCache) swap(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(name, a.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := a.file.Close(); err != nil {
+		log.Errorf("Cannot close the old append-only file %s: %v", a.path, err)
+	}
+	a.file = file
+	a.size = info.Size()
+	a.base = a.size
+	a.dirty = false
+	return nil
+}
+
+// Close stops the background syncs and rewrites, syncs and closes the log
+// and then closes the wrapped cache
+func (a *AOFCache) Close() error {
+	a.Lock()
+	if a.closed {
+		a.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.done)
+	a.Unlock()
+	<-a.stopped
+	defer a.rewrites.Unlock()
+	a.rewrites.Lock()
+	a.Lock()
+	err := a.file.Sync()
+	if e := a.file.Close(); err == nil {
+		err = e
+	}
+	a.Unlock()
+	if e := cache.Close(a.UnImplementedCache); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package aof
+
+import (
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, path string, capacity bytesize.ByteSize) (*AOFCache, *lrucache.LRUCache) {
+	t.Helper()
+	c := lrucache.NewCache(capacity)
+	a, err := NewCache(c, path, FsyncAlways, 0)
+	if err != nil {
+		t.Fatalf("Cannot open %s %v", path, err)
+	}
+	return a, c
+}
+
+func TestAOFCache_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	a, _ := open(t, path, 100)
+	a.Put("1", "one")
+	a.Put("2", "two")
+	a.Put("1", "uno")
+	if !a.Delete("2") || a.Delete("3") {
+		t.Errorf("Expected only 2 to be deleted")
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if a.Put("4", "four") {
+		t.Errorf("Expected a put after Close to be ignored")
+	}
+
+	a, c := open(t, path, 100)
+	defer a.Close()
+	if value, ok := c.Get("1"); !ok || value != "uno" {
+		t.Errorf("Expected 1 to be uno but got %s", value)
+	}
+	if _, ok := c.Get("2"); ok {
+		t.Errorf("Expected 2 to stay deleted")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected a single entry but got %d", c.Len())
+	}
+}
+
+func TestAOFCache_PartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	log := `{"op":"put","key":"1","value":"one"}` + "\n" + `{"op":"put","key":"2","va`
+	if err := ioutil.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, c := open(t, path, 100)
+	if _, ok := c.Get("2"); ok || c.Len() != 1 {
+		t.Errorf("Expected the partial record to be dropped")
+	}
+	a.Put("3", "three")
+	a.Close()
+
+	a, c = open(t, path, 100)
+	defer a.Close()
+	if value, ok := c.Get("3"); !ok || value != "three" || c.Len() != 2 {
+		t.Errorf("Expected the records after the truncation to be replayed")
+	}
+}
+
+func TestAOFCache_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	for _, log := range []string{
+		`{"op":"put","key":"1"` + "\n" + `{"op":"put","key":"2"}` + "\n",
+		`{"op":"drop","key":"1"}` + "\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(log), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewCache(lrucache.NewCache(100), path, FsyncNever, 0); err == nil {
+			t.Errorf("Expected %q to be rejected", log)
+		}
+	}
+}
+
+func TestAOFCache_Rewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	a, _ := open(t, path, 3)
+	for i := 0; i < 100; i++ {
+		a.Put(strconv.Itoa(i%5), "x")
+	}
+	a.Delete("4")
+	before := a.Size()
+	if err := a.Rewrite(); err != nil {
+		t.Fatalf("Rewrite failed %v", err)
+	}
+	if a.Size() >= before {
+		t.Errorf("Expected the rewrite to shrink the log from %d but got %d", before, a.Size())
+	}
+	a.Put("5", "x")
+	a.Close()
+	b, _ := ioutil.ReadFile(path)
+	if lines := strings.Count(string(b), "\n"); lines != 3 {
+		t.Errorf("Expected the 2 entries and the put after the rewrite but got %d lines", lines)
+	}
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(matches) != 0 {
+		t.Errorf("Expected no temporary files but got %v", matches)
+	}
+
+	// the rewrite keeps the order, the least recently used 2 is evicted
+	a, c := open(t, path, 3)
+	defer a.Close()
+	a.Put("6", "x")
+	for key, expected := range map[string]bool{"2": false, "3": true, "5": true, "6": true} {
+		if _, ok := c.Peek(key); ok != expected {
+			t.Errorf("Expected %s to be in the cache %v", key, expected)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if err := a.Rewrite(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed but got %v", err)
+	}
+}
+
+func TestAOFCache_AutoRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	a, err := NewCache(lrucache.NewCache(100), path, FsyncEverySecond, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	for i := 0; i < 50; i++ {
+		a.Put("1", "x")
+	}
+	grown := a.Size()
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Size() >= grown {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the log of %d bytes to be rewritten", grown)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != a.Size() {
+		t.Errorf("Expected the log to be rewritten on the disk but got %v %v", info, err)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	for name, expected := range map[string]FsyncPolicy{"always": FsyncAlways, "EVERYSEC": FsyncEverySecond, "no": FsyncNever} {
+		if policy, err := ParseFsyncPolicy(name); err != nil || policy != expected {
+			t.Errorf("Expected %s to be %d but got %d %v", name, expected, policy, err)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Errorf("Expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,289 @@
+// Package arccache implements ARC (Adaptive Replacement Cache)
+package arccache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+// list is one of the four lists of ARC
+type list int
+
+const (
+	// t1 holds the resident entries that are accessed once
+	t1 list = iota
+	// t2 holds the resident entries that are accessed more than once
+	t2
+	// b1 remembers the keys evicted from t1
+	b1
+	// b2 remembers the keys evicted from t2
+	b2
+)
+
+type entry struct {
+	node *dlinklist.Node
+	list list
+}
+
+// ARCCache data structure, the resident entries are split between t1 for
+// recency and t2 for frequency, and the keys evicted from each are kept
+// without their value in the ghost lists b1 and b2. A put of a key in b1
+// means t1 was too small so its target size p grows, a put of a key in b2
+// shrinks it, so the cache adapts between LRU and LFU with the workload.
+// The sizes are in bytes, a ghost keeps the size of its evicted entry
+type ARCCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	entries  map[string]*entry
+	lists    [4]*dlinklist.DLinkedList
+	sizes    [4]bytesize.ByteSize
+	capacity bytesize.ByteSize
+	p        bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache ARCCache constructor
+func NewCache(capacity bytesize.ByteSize) *ARCCache {
+	c := &ARCCache{
+		entries:  map[string]*entry{},
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+	for i := range c.lists {
+		c.lists[i] = dlinklist.NewLinkedList()
+	}
+	return c
+}
+
+// cost sizes an entry by the length of its value, at least a byte
+func cost(value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+// Get returns the value for the key, a hit moves the entry to t2
+func (c *ARCCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok || e.list == b1 || e.list == b2 {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.move(e, t2)
+	return e.node.Value, true
+}
+
+// Put updates or inserts a new entry, a new key enters t1 and a key
+// remembered by a ghost list enters t2 after adapting the target size of
+// t1. An entry larger than the capacity is rejected
+func (c *ARCCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	size := cost(value)
+	if size > c.capacity {
+		return false
+	}
+	e, ok := c.entries[key]
+	if ok && (e.list == t1 || e.list == t2) {
+		c.unlink(e)
+		e.node.Value = value
+		e.node.Size = int64(size)
+		c.link(e, t2)
+		c.replace(false)
+		return false
+	}
+
+	c.counters.Adds.Inc()
+	if !ok {
+		e = &entry{node: &dlinklist.Node{Key: key}}
+		c.entries[key] = e
+		e.node.Value = value
+		e.node.Size = int64(size)
+		c.link(e, t1)
+		c.replace(false)
+		return true
+	}
+
+	ghost := e.list
+	c.adapt(ghost, bytesize.ByteSize(e.node.Size))
+	c.unlink(e)
+	e.node.Value = value
+	e.node.Size = int64(size)
+	c.link(e, t2)
+	c.replace(ghost == b2)
+	return true
+}
+
+// adapt moves the target size of t1 after a put of a key in a ghost list,
+// by the size of the ghost scaled by the ratio of the ghost lists
+func (c *ARCCache) adapt(ghost list, size bytesize.ByteSize) {
+	if ghost == b1 {
+		delta := size
+		if c.sizes[b1] > 0 && c.sizes[b2] > c.sizes[b1] {
+			delta = size * c.sizes[b2] / c.sizes[b1]
+		}
+		if c.p += delta; c.p > c.capacity {
+			c.p = c.capacity
+		}
+		return
+	}
+	delta := size
+	if c.sizes[b2] > 0 && c.sizes[b1] > c.sizes[b2] {
+		delta = size * c.sizes[b1] / c.sizes[b2]
+	}
+	if delta > c.p {
+		c.p = 0
+	} else {
+		c.p -= delta
+	}
+}
+
+// replace evicts from t1 to b1 while t1 is over its target size and from
+// t2 to b2 otherwise until the resident entries fit in the capacity, then
+// it forgets the oldest ghosts so the directory is at most twice the
+// capacity. fromB2 prefers t1 when it is exactly at its target size
+func (c *ARCCache) replace(fromB2 bool) {
+	for c.sizes[t1]+c.sizes[t2] > c.capacity {
+		from, to := t2, b2
+		if c.lists[t1].Size() > 0 && (c.sizes[t1] > c.p || fromB2 && c.sizes[t1] == c.p || c.lists[t2].Size() == 0) {
+			from, to = t1, b1
+		}
+		tail := c.lists[from].Tail()
+		if tail == nil {
+			metrics.Anomalies.Inc()
+			return
+		}
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		e := c.entries[tail.Key]
+		c.unlink(e)
+		e.node.Value = ""
+		c.link(e, to)
+	}
+	for c.sizes[t1]+c.sizes[b1] > c.capacity && c.lists[b1].Size() > 0 {
+		c.forget(b1)
+	}
+	for c.sizes[t1]+c.sizes[t2]+c.sizes[b1]+c.sizes[b2] > 2*c.capacity && c.lists[b2].Size() > 0 {
+		c.forget(b2)
+	}
+}
+
+// forget drops the oldest ghost of the list
+func (c *ARCCache) forget(ghost list) {
+	tail := c.lists[ghost].Tail()
+	c.unlink(c.entries[tail.Key])
+	delete(c.entries, tail.Key)
+}
+
+// move moves the entry to the head of the list
+func (c *ARCCache) move(e *entry, to list) {
+	c.unlink(e)
+	c.link(e, to)
+}
+
+// link adds the entry to the head of the list
+func (c *ARCCache) link(e *entry, to list) {
+	e.list = to
+	c.lists[to].AddNode(e.node)
+	c.sizes[to] += bytesize.ByteSize(e.node.Size)
+}
+
+// unlink removes the entry from its list
+func (c *ARCCache) unlink(e *entry) {
+	c.lists[e.list].RemoveNode(e.node)
+	c.sizes[e.list] -= bytesize.ByteSize(e.node.Size)
+}
+
+// Delete deletes the key from the cache, a ghost of the key is
+// forgotten but it is not counted as a delete
+func (c *ARCCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.unlink(e)
+	delete(c.entries, key)
+	if e.list == b1 || e.list == b2 {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *ARCCache) Close() error {
+	return nil
+}
+
+// Len returns the number of resident entries in the cache
+func (c *ARCCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lists[t1].Size() + c.lists[t2].Size()
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *ARCCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.sizes[t1]+c.sizes[t2], c.lists[t1].Size()+c.lists[t2].Size())
+}
+
+func (c *ARCCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := make(map[string]string)
+
+	for k, e := range c.entries {
+		if e.list == t1 || e.list == t2 {
+			o[k] = e.node.Value
+		}
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+func (c *ARCCache) Restore(closer io.ReadCloser) error {
+	// Set the state from the snapshot as it is decoded, no lock required
+	// according to Hashicorp docs.
+	return cache.ReadSnapshot(closer, func(k, v string) {
+		c.Put(k, v)
+	})
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
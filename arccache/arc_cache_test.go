@@ -0,0 +1,174 @@
+package arccache
+
+import (
+	"bytes"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// check verifies the sizes of the lists and the bounds of ARC
+func check(t *testing.T, c *ARCCache) {
+	t.Helper()
+	entries := 0
+	for i, l := range c.lists {
+		size := bytesize.ByteSize(0)
+		l.Walk(func(node *dlinklist.Node) {
+			if e := c.entries[node.Key]; e == nil || e.node != node || e.list != list(i) {
+				t.Fatalf("Expected %s to be an entry of list %d", node.Key, i)
+			}
+			size += bytesize.ByteSize(node.Size)
+		})
+		if size != c.sizes[i] {
+			t.Fatalf("Expected the size of list %d to be %d but got %d", i, size, c.sizes[i])
+		}
+		entries += l.Size()
+	}
+	if entries != len(c.entries) {
+		t.Fatalf("Expected %d entries in the lists but got %d", len(c.entries), entries)
+	}
+	if c.sizes[t1]+c.sizes[t2] > c.capacity || c.sizes[t1]+c.sizes[b1] > c.capacity ||
+		c.sizes[t1]+c.sizes[t2]+c.sizes[b1]+c.sizes[b2] > 2*c.capacity || c.p > c.capacity {
+		t.Fatalf("Expected the lists to fit in the capacity but got %v p %d", c.sizes, c.p)
+	}
+}
+
+func TestARCCache(t *testing.T) {
+	c := NewCache(3)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Get("a")
+	c.Put("c", "c")
+	c.Put("d", "d")
+	check(t, c)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Expected b to be evicted from t1")
+	}
+	if e := c.entries["b"]; e == nil || e.list != b1 {
+		t.Errorf("Expected b to be remembered in b1")
+	}
+	if value, ok := c.Get("a"); !ok || value != "a" {
+		t.Errorf("Expected a in t2 but got %s", value)
+	}
+
+	// the ghost hit grows the target size of t1 and enters t2
+	if !c.Put("b", "b") {
+		t.Errorf("Expected the ghost b to be created")
+	}
+	check(t, c)
+	if c.p != 1 || c.entries["b"].list != t2 {
+		t.Errorf("Expected p to grow to 1 and b to enter t2 but got %d", c.p)
+	}
+	if c.Len() != 3 {
+		t.Errorf("Expected 3 entries but got %d", c.Len())
+	}
+}
+
+func TestARCCache_ScanResistance(t *testing.T) {
+	c := NewCache(10)
+	lru := lrucache.NewCache(10)
+	hot := []string{"a", "b", "c"}
+	for _, key := range hot {
+		c.Put(key, key)
+		c.Get(key)
+		lru.Put(key, key)
+		lru.Get(key)
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		c.Put(key, key[:1])
+		lru.Put(key, key[:1])
+		check(t, c)
+	}
+	for _, key := range hot {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected the hot key %s to survive the scan", key)
+		}
+		if _, ok := lru.Get(key); ok {
+			t.Errorf("Expected the hot key %s to be evicted from LRU by the scan", key)
+		}
+	}
+}
+
+func TestARCCache_Delete(t *testing.T) {
+	c := NewCache(2)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	if !c.Delete("b") || c.Delete("b") {
+		t.Errorf("Expected b to be deleted once")
+	}
+	if c.Delete("a") {
+		t.Errorf("Expected the ghost a not to be deleted")
+	}
+	check(t, c)
+	if len(c.entries) != 1 || c.Len() != 1 {
+		t.Errorf("Expected only c to be left but got %d", len(c.entries))
+	}
+}
+
+func TestARCCache_Oversized(t *testing.T) {
+	c := NewCache(3)
+	c.Put("a", "a")
+	if c.Put("b", "bbbb") {
+		t.Errorf("Expected the oversized b to be rejected")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("Expected a to be kept")
+	}
+}
+
+func TestARCCache_Random(t *testing.T) {
+	c := NewCache(50)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(r.Intn(1 + r.Intn(200)))
+		switch r.Intn(6) {
+		case 0:
+			c.Delete(key)
+		case 1, 2:
+			c.Put(key, strings.Repeat("x", r.Intn(8)))
+		default:
+			c.Get(key)
+		}
+		check(t, c)
+	}
+}
+
+type sink struct {
+	bytes.Buffer
+}
+
+func (s *sink) ID() string    { return "" }
+func (s *sink) Cancel() error { return nil }
+func (s *sink) Close() error  { return nil }
+
+var _ raft.SnapshotSink = (*sink)(nil)
+
+func TestARCCache_Snapshot(t *testing.T) {
+	c := NewCache(2)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	c.Put("3", "3")
+	snapshot, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed %v", err)
+	}
+	s := &sink{}
+	if err := snapshot.Persist(s); err != nil {
+		t.Fatalf("Persist failed %v", err)
+	}
+	restored := NewCache(10)
+	if err := restored.Restore(ioutil.NopCloser(&s.Buffer)); err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	if value, ok := restored.Get("3"); !ok || value != "3" || restored.Len() != 2 {
+		t.Errorf("Expected the resident entries without the ghost to be restored")
+	}
+}
@@ -0,0 +1,191 @@
+// Package auth authenticates the clients of the HTTP and gRPC APIs with
+// bearer tokens of a read-only, a read-write or a node role, and with client
+// certificates verified against a CA by mutual TLS
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrUnauthenticated is returned when the token is missing or unknown
+	ErrUnauthenticated = errors.New("auth: missing or unknown token")
+	// ErrForbidden is returned when the role of the token is below the role
+	// the request needs
+	ErrForbidden = errors.New("auth: the role of the token does not allow the request")
+)
+
+// Role is what a token is allowed to do
+type Role int
+
+const (
+	// ReadOnly tokens only read the entries, the stats and the metrics
+	ReadOnly Role = iota + 1
+	// ReadWrite tokens also put and delete the entries and manage the
+	// namespaces
+	ReadWrite
+	// Node tokens are those of the nodes of the cluster, they also join and
+	// leave the cluster and apply the commands to the raft log
+	Node
+)
+
+// ParseRole parses read, ro or readonly, write, rw or readwrite and node
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(s) {
+	case "read", "ro", "readonly":
+		return ReadOnly, nil
+	case "write", "rw", "readwrite":
+		return ReadWrite, nil
+	case "node":
+		return Node, nil
+	}
+	return 0, fmt.Errorf("auth: unknown role %q", s)
+}
+
+func (r Role) String() string {
+	switch r {
+	case ReadOnly:
+		return "readonly"
+	case ReadWrite:
+		return "readwrite"
+	case Node:
+		return "node"
+	}
+	return "unknown"
+}
+
+// Tokens maps the bearer tokens to their roles, nil tokens authorize every
+// request
+type Tokens map[string]Role
+
+// LoadTokens reads the tokens of the file, see ParseTokens
+func LoadTokens(path string) (Tokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTokens(f)
+}
+
+// ParseTokens reads a token and its role separated by spaces per line, the
+// blank lines and the lines that start with # are skipped
+func ParseTokens(r io.Reader) (Tokens, error) {
+	tokens := Tokens{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("auth: line %d: expected a token and a role", line)
+		}
+		role, err := ParseRole(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("auth: line %d: %v", line, err)
+		}
+		tokens[fields[0]] = role
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Authorize returns nil if the role of the token is need or above,
+// otherwise ErrUnauthenticated or ErrForbidden. The token is compared with
+// all the tokens in constant time
+func (t Tokens) Authorize(token string, need Role) error {
+	if t == nil {
+		return nil
+	}
+	var role Role
+	for known, r := range t {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			role = r
+		}
+	}
+	switch {
+	case role == 0 || token == "":
+		return ErrUnauthenticated
+	case role < need:
+		return ErrForbidden
+	}
+	return nil
+}
+
+// BearerToken returns the token of the value of an Authorization header in
+// the form Bearer <token>, empty if it is not one
+func BearerToken(header string) string {
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// ServerTLSConfig returns the TLS config of the certificate and the key of
+// a server, with a client CA the clients must present a certificate it
+// signed
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pool, err := loadPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientTLSConfig returns the TLS config of a client that presents the
+// certificate and the key, if any, and verifies the servers against the CA,
+// if any, otherwise against the roots of the system
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// loadPool returns the pool of the PEM certificates of the file
+func loadPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("auth: no certificate in %s", path)
+	}
+	return pool, nil
+}
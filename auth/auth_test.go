@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens(strings.NewReader(`
+# clients
+reader readonly
+writer  rw
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens["reader"] != ReadOnly || tokens["writer"] != ReadWrite {
+		t.Errorf("Expected the roles of the tokens but got %v", tokens)
+	}
+	for _, text := range []string{"token", "token admin", "token read write"} {
+		if _, err := ParseTokens(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected %q to be invalid", text)
+		}
+	}
+	if _, err := LoadTokens(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing file to be an error but got %v", err)
+	}
+}
+
+func TestTokens_Authorize(t *testing.T) {
+	tokens := Tokens{"reader": ReadOnly, "writer": ReadWrite, "node": Node}
+	tests := []struct {
+		token string
+		need  Role
+		err   error
+	}{
+		{"reader", ReadOnly, nil},
+		{"reader", ReadWrite, ErrForbidden},
+		{"writer", ReadWrite, nil},
+		{"writer", ReadOnly, nil},
+		{"writer", Node, ErrForbidden},
+		{"node", Node, nil},
+		{"node", ReadWrite, nil},
+		{"", ReadOnly, ErrUnauthenticated},
+		{"other", ReadOnly, ErrUnauthenticated},
+		{"write", ReadWrite, ErrUnauthenticated},
+	}
+	for _, test := range tests {
+		if err := tokens.Authorize(test.token, test.need); err != test.err {
+			t.Errorf("Expected %v for %s %v but got %v", test.err, test.token, test.need, err)
+		}
+	}
+	if err := Tokens(nil).Authorize("", Node); err != nil {
+		t.Errorf("Expected nil tokens to authorize every request but got %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer abc":  "abc",
+		"bearer  abc": "abc",
+		"Basic abc":   "",
+		"abc":         "",
+		"":            "",
+	} {
+		if got := BearerToken(header); got != want {
+			t.Errorf("Expected %q for %q but got %q", want, header, got)
+		}
+	}
+}
+
+// writeCert writes the PEM certificate and key of a new certificate signed
+// by the parent, self-signed if it is nil, and returns them
+func writeCert(t *testing.T, dir, name string, template *x509.Certificate,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	template := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+	}
+	caTemplate := template(1, "ca")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	ca, caKey := writeCert(t, dir, "ca", caTemplate, nil, nil)
+	serverTemplate := template(2, "server")
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	writeCert(t, dir, "server", serverTemplate, ca, caKey)
+	writeCert(t, dir, "client", template(3, "client"), ca, caKey)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConfig, err := ServerTLSConfig(path("server.crt"), path("server.key"), path("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	clientConfig, err := ClientTLSConfig(path("client.crt"), path("client.key"), path("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the client certificate to be accepted but got %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "client" {
+		t.Errorf("Expected the server to see the client certificate but got %s", body)
+	}
+
+	anonymous, _ := ClientTLSConfig("", "", path("ca.crt"))
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: anonymous}}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("Expected a client without a certificate to be rejected")
+	}
+	if _, err := ServerTLSConfig(path("server.crt"), path("server.key"), path("server.key")); err == nil {
+		t.Errorf("Expected a client CA without a certificate to be an error")
+	}
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader holds the certificate, the key and the CA of their files and
+// loads them again once the files change, so the certificates rotate
+// without a restart. The configs it returns take the current ones on every
+// handshake
+type Reloader struct {
+	certFile, keyFile, caFile string
+	mu                        sync.RWMutex
+	cert                      *tls.Certificate
+	pool                      *x509.CertPool
+	modTime                   time.Time
+	done                      chan struct{}
+	once                      sync.Once
+}
+
+// NewReloader loads the certificate, the key and the CA, if any, and checks
+// the files for changes every interval, 0 means only Reload loads them again
+func NewReloader(certFile, keyFile, caFile string, interval time.Duration) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, done: make(chan struct{})}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go r.watch(interval)
+	}
+	return r, nil
+}
+
+// watch reloads the files every interval until the reloader is closed
+func (r *Reloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Errorf("Cannot reload the certificate %s: %v", r.certFile, err)
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Reload loads the files again if any of them changed since the last load,
+// on an error the certificates loaded before stay in use
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	log.Infof("Reloaded the certificate %s", r.certFile)
+	return nil
+}
+
+// lastModified returns the latest modification time of the files
+func (r *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load loads all the files
+func (r *Reloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		if pool, err = loadPool(r.caFile); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// current returns the certificate and the CA in use
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig returns the TLS config of a server that presents the current
+// certificate, with verifyClients the clients must present a certificate
+// signed by the current CA
+func (r *Reloader) ServerConfig(verifyClients bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+	if verifyClients {
+		// the chain is verified here rather than by ClientCAs so a rotated
+		// CA applies to the listeners already running
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyPeerCertificate = r.verifyClient
+	}
+	return config
+}
+
+// verifyClient verifies the certificate chain of a client against the
+// current CA
+func (r *Reloader) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	_, pool := r.current()
+	if pool == nil {
+		return errors.New("auth: no client CA")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		return errors.New("auth: no client certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// ClientConfig returns the TLS config of a client that presents the current
+// certificate and verifies the servers against the current CA, or against
+// the roots of the system without a CA. The CA is the one of the time of the
+// call, so a client that dials with DialContext follows its rotations
+func (r *Reloader) ClientConfig() *tls.Config {
+	_, pool := r.current()
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+}
+
+// DialContext dials a TLS connection with a config of ClientConfig
+func (r *Reloader) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &tls.Dialer{Config: r.ClientConfig()}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Close stops checking the files for changes
+func (r *Reloader) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	return nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	template := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+			IsCA:                  name == "ca",
+			BasicConstraintsValid: true,
+		}
+	}
+	ca, caKey := writeCert(t, dir, "ca", template(1, "ca"), nil, nil)
+	writeCert(t, dir, "node", template(2, "node1"), ca, caKey)
+	writeCert(t, dir, "other", template(3, "other"), nil, nil)
+
+	r, err := NewReloader(path("node.crt"), path("node.key"), path("ca.crt"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.ServerConfig(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	peer := func() (string, error) {
+		conn, err := r.DialContext(context.Background(), "tcp", listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+	if name, err := peer(); err != nil || name != "node1" {
+		t.Fatalf("Expected the certificate of node1 but got %s %v", name, err)
+	}
+
+	writeCert(t, dir, "node", template(4, "node2"), ca, caKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path("node.crt"), later, later)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := peer(); err != nil || name != "node2" {
+		t.Errorf("Expected the rotated certificate of node2 but got %s %v", name, err)
+	}
+
+	other, err := NewReloader(path("other.crt"), path("other.key"), path("ca.crt"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := other.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err == nil {
+		// the server may reject the certificate after the handshake of
+		// the client is done, so the rejection shows on the first read
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil {
+		t.Errorf("Expected a certificate of another CA to be rejected")
+	}
+
+	os.Remove(path("node.key"))
+	later = later.Add(time.Minute)
+	os.Chtimes(path("node.crt"), later, later)
+	if err := r.Reload(); err == nil {
+		t.Errorf("Expected a missing key to be an error")
+	}
+	if cert, _ := r.current(); cert == nil {
+		t.Errorf("Expected the certificate to stay after a failed reload")
+	}
+}
@@ -0,0 +1,42 @@
+package cache
+
+// GetMulti returns the values of the keys that are found in the cache, in
+// a single call if the cache implements BatchCache and key by key otherwise
+func GetMulti(c UnImplementedCache, keys []string) map[string]string {
+	if batch, ok := c.(BatchCache); ok {
+		return batch.GetMulti(keys)
+	}
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if value, ok := c.Get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// PutMulti updates or inserts all the entries, in a single call if the
+// cache implements BatchCache and entry by entry otherwise
+func PutMulti(c UnImplementedCache, entries map[string]string) {
+	if batch, ok := c.(BatchCache); ok {
+		batch.PutMulti(entries)
+		return
+	}
+	for key, value := range entries {
+		c.Put(key, value)
+	}
+}
+
+// DeleteMulti deletes the keys and returns the number of the deleted keys,
+// in a single call if the cache implements BatchCache and key by key otherwise
+func DeleteMulti(c UnImplementedCache, keys []string) (count int) {
+	if batch, ok := c.(BatchCache); ok {
+		return batch.DeleteMulti(keys)
+	}
+	for _, key := range keys {
+		if c.Delete(key) {
+			count++
+		}
+	}
+	return count
+}
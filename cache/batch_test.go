@@ -0,0 +1,23 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestMulti_Fallback(t *testing.T) {
+	c := mapCache{}
+	PutMulti(c, map[string]string{"1": "a", "2": "b", "3": "c"})
+	if len(c) != 3 {
+		t.Fatalf("Expected 3 entries but got %v", c)
+	}
+	values := GetMulti(c, []string{"1", "3", "4"})
+	if len(values) != 2 || values["1"] != "a" || values["3"] != "c" {
+		t.Errorf("Expected the keys 1 and 3 but got %v", values)
+	}
+	if count := DeleteMulti(c, []string{"1", "2", "4"}); count != 2 {
+		t.Errorf("Expected 2 deleted keys but got %d", count)
+	}
+	if len(c) != 1 || c["3"] != "c" {
+		t.Errorf("Expected only 3 left but got %v", c)
+	}
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// PutBytes puts the value without copying it to a string, the cache owns
+// the slice afterwards so the caller must not modify it
+func PutBytes(c UnImplementedCache, key string, value []byte) (created bool) {
+	return c.Put(key, UnsafeString(value))
+}
+
+// GetBytes returns the value without copying it from a string, the slice
+// shares the memory of the entry so the caller must not modify it
+func GetBytes(c UnImplementedCache, key string) (value []byte, ok bool) {
+	s, ok := c.Get(key)
+	return UnsafeBytes(s), ok
+}
+
+// UnsafeString returns a string that shares the memory of b without a
+// copy, b must not be modified afterwards
+func UnsafeString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// UnsafeBytes returns a slice that shares the memory of s without a
+// copy, the slice must not be modified
+func UnsafeBytes(s string) (b []byte) {
+	if s == "" {
+		return nil
+	}
+	sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
+	bh := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	bh.Data = sh.Data
+	bh.Len = sh.Len
+	bh.Cap = sh.Len
+	return b
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"testing"
+)
+
+// mapCache is the smallest cache to put the bytes in
+type mapCache map[string]string
+
+func (c mapCache) Put(key string, value string) (created bool) {
+	_, ok := c[key]
+	c[key] = value
+	return !ok
+}
+
+func (c mapCache) Get(key string) (value string, ok bool) {
+	value, ok = c[key]
+	return value, ok
+}
+
+func (c mapCache) Delete(key string) (ok bool) {
+	_, ok = c[key]
+	delete(c, key)
+	return ok
+}
+
+func (c mapCache) Close() error {
+	return nil
+}
+
+func TestPutBytes(t *testing.T) {
+	c := mapCache{}
+	value := []byte("hello")
+	if !PutBytes(c, "a", value) {
+		t.Errorf("Expected a to be created")
+	}
+	got, ok := GetBytes(c, "a")
+	if !ok || string(got) != "hello" {
+		t.Fatalf("Expected hello but got %s", got)
+	}
+	if &got[0] != &value[0] {
+		t.Errorf("Expected the value to share the memory of the put")
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		PutBytes(c, "a", value)
+		GetBytes(c, "a")
+	}); allocs != 0 {
+		t.Errorf("Expected no allocation but got %v", allocs)
+	}
+	if got, ok := GetBytes(c, "b"); ok || got != nil {
+		t.Errorf("Expected b not to be found")
+	}
+	PutBytes(c, "empty", nil)
+	if got, ok := GetBytes(c, "empty"); !ok || len(got) != 0 {
+		t.Errorf("Expected an empty value but got %s", got)
+	}
+}
@@ -1,9 +1,225 @@
 // Package cache general interface for cache
 package cache
 
+import (
+	"context"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"time"
+)
+
 // UnImplementedCache cache interface
 type UnImplementedCache interface {
 	Put(key string, value string) (created bool)
 	Get(key string) (value string, ok bool)
 	Delete(key string) (ok bool)
+	// Close stops the background work of the cache and flushes the
+	// pending work, it is idempotent. Each cache documents how it answers
+	// a Put or a Get after it is closed
+	Close() error
+}
+
+// Closer is implemented by the caches that run background goroutines,
+// Close stops them and flushes the pending work. It is idempotent
+type Closer interface {
+	Close() error
+}
+
+// Close closes the cache, it is kept for the callers that close a cache
+// they only know as a value
+func Close(c UnImplementedCache) error {
+	return c.Close()
+}
+
+// TTLCache is implemented by the caches that support expiring entries
+type TTLCache interface {
+	PutWithTTL(key string, value string, ttl time.Duration) (created bool)
+}
+
+// ExpiryCache is implemented by the caches that can report when an entry
+// expires, expiresAt is the zero time if the entry never expires
+type ExpiryCache interface {
+	GetWithExpiry(key string) (value string, expiresAt time.Time, ok bool)
+}
+
+// ExpireCache is implemented by the caches that can change the expiration
+// of an entry. TTL returns the time left until the key expires, zero if it
+// never expires. Expire sets the key to expire ttl from now, a ttl that is
+// not positive expires it at once, and Persist makes it never expire. They
+// return false if the key is not in the cache
+type ExpireCache interface {
+	TTL(key string) (ttl time.Duration, ok bool)
+	Expire(key string, ttl time.Duration) (ok bool)
+	Persist(key string) (ok bool)
+}
+
+// AbsentCache is implemented by the caches that can insert an entry only if
+// its key is not in the cache, a zero ttl never expires
+type AbsentCache interface {
+	PutIfAbsent(key string, value string) (created bool)
+	PutIfAbsentWithTTL(key string, value string, ttl time.Duration) (created bool)
+}
+
+// ReplaceCache is implemented by the caches that can update an entry only
+// if its key is in the cache, Replace restarts the default TTL of the entry
+// and a zero ttl never expires
+type ReplaceCache interface {
+	Replace(key string, value string) (ok bool)
+	ReplaceWithTTL(key string, value string, ttl time.Duration) (ok bool)
+}
+
+// EventCache is implemented by the caches that can stream their puts,
+// deletes, evictions and expirations to the subscribers
+type EventCache interface {
+	Subscribe() <-chan Event
+	Unsubscribe(ch <-chan Event)
+}
+
+// Sizer is implemented by the caches that can count their entries
+type Sizer interface {
+	Len() int
+}
+
+// EntryMeta is the access metadata of an entry
+type EntryMeta struct {
+	CreatedAt   time.Time
+	LastAccess  time.Time
+	AccessCount int
+	Size        bytesize.ByteSize
+}
+
+// PeekCache is implemented by the caches that can read an entry without
+// counting it as a use and use an entry without reading it. Peek leaves the
+// eviction order and the expiration as they are, Touch promotes the entry
+// like a hit and restarts its time to live. Touch returns false if the key
+// is not in the cache
+type PeekCache interface {
+	Peek(key string) (value string, ok bool)
+	Touch(key string) (ok bool)
+}
+
+// MetaCache is implemented by the caches that keep the access metadata of the entries
+type MetaCache interface {
+	Meta(key string) (EntryMeta, bool)
+}
+
+// OrderedCache is implemented by the caches that can list their keys in
+// the eviction order, from the last to be evicted to the next
+type OrderedCache interface {
+	OrderedKeys() []string
+}
+
+// ScanCache is implemented by the caches that can enumerate their keys, Keys
+// returns all of them at once and Scan a batch of them per call so the cache
+// is not locked for the whole iteration. Scan starts with the cursor zero and
+// is done once it returns the next cursor zero, a key that is in the cache
+// for the whole scan is returned exactly once
+type ScanCache interface {
+	Keys() []string
+	Scan(cursor uint64, count int) (keys []string, next uint64)
+}
+
+// PatternCache is implemented by the caches that can delete the keys that
+// match a glob pattern under a single acquisition of their lock
+type PatternCache interface {
+	DeleteByPattern(pattern string) (count int, err error)
+}
+
+// ResizeBatch is the number of the entries a cache evicts under a single
+// acquisition of its lock as it shrinks, the lock is released between the
+// batches so the other calls go on while it shrinks
+const ResizeBatch = 128
+
+// ResizeCache is implemented by the caches whose capacity can change at
+// runtime, a smaller capacity evicts the entries that no longer fit
+type ResizeCache interface {
+	Resize(capacity bytesize.ByteSize) error
+}
+
+// PinCache is implemented by the caches that can exempt entries from the eviction
+type PinCache interface {
+	Pin(key string) bool
+	Unpin(key string) bool
+}
+
+// BatchCache is implemented by the caches that can get, put or delete
+// many keys under a single acquisition of their lock
+type BatchCache interface {
+	GetMulti(keys []string) map[string]string
+	PutMulti(entries map[string]string)
+	DeleteMulti(keys []string) (count int)
+}
+
+// CounterCache is implemented by the caches that can update the decimal
+// integer values atomically, a missing key counts from zero and the
+// expiration of an existing counter is kept
+type CounterCache interface {
+	Incr(key string, delta int64) (value int64, err error)
+	Decr(key string, delta int64) (value int64, err error)
+}
+
+// AppendCache is implemented by the caches that can add to the value of an
+// existing entry in place, the entry keeps its expiration. They return false
+// if the key is not in the cache
+type AppendCache interface {
+	Append(key string, value string) (ok bool)
+	Prepend(key string, value string) (ok bool)
+}
+
+// VersionCache is implemented by the caches that give every value a version
+// token that increases monotonically with the updates, Cas replaces the value
+// only if the token still matches so a concurrent update is not overwritten
+type VersionCache interface {
+	Gets(key string) (value string, version uint64, ok bool)
+	Cas(key string, value string, version uint64) error
+}
+
+// Checker is implemented by the caches that can verify the consistency of
+// their internal structures, CheckInvariants returns the first violation
+type Checker interface {
+	CheckInvariants() error
+}
+
+// NamespaceCache is implemented by the caches that host other independent
+// caches addressed by the name of their namespace, each one with a config
+// of its own
+type NamespaceCache interface {
+	Namespace(name string) (c UnImplementedCache, ok bool)
+	NamespaceConfig(name string) (config NamespaceConfig, ok bool)
+	CreateNamespace(name string, config NamespaceConfig) (created bool, err error)
+	DropNamespace(name string) (ok bool, err error)
+	Namespaces() []string
+}
+
+// ExportCache is implemented by the caches that can export their entries
+// in the portable format of Dump
+type ExportCache interface {
+	Export(w io.Writer) error
+}
+
+// DumpCache is implemented by the caches that can also import the entries
+// of an export
+type DumpCache interface {
+	ExportCache
+	Import(r io.Reader) error
+}
+
+// LoaderCache is implemented by the caches that can load the missing
+// entries, the concurrent loads of the same key are deduplicated
+type LoaderCache interface {
+	GetOrLoad(key string, loader Loader) (value string, err error)
+}
+
+// NegativeCache is implemented by the caches that can remember the keys
+// that are known to be absent from the backing store
+type NegativeCache interface {
+	PutNegative(key string, ttl time.Duration)
+	Lookup(key string) (value string, ok bool, absent bool)
+}
+
+// ContextCache is implemented by the caches that give up on the operations
+// once the context is done
+type ContextCache interface {
+	GetContext(ctx context.Context, key string) (value string, ok bool, err error)
+	PutContext(ctx context.Context, key string, value string) (created bool, err error)
 }
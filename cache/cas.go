@@ -0,0 +1,13 @@
+package cache
+
+import "errors"
+
+var (
+	// ErrNotFound is returned by Cas when the key is not in the cache
+	ErrNotFound = errors.New("cache: the key is not found")
+	// ErrVersionMismatch is returned by Cas when the value was updated
+	// since its version token was read
+	ErrVersionMismatch = errors.New("cache: the version does not match")
+	// ErrNotSupported is returned when the cache does not support the operation
+	ErrNotSupported = errors.New("cache: the operation is not supported")
+)
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnknownCompression is returned when the header of a snapshot names a
+// compression this version does not know
+var ErrUnknownCompression = errors.New("cache: unknown snapshot compression")
+
+// Compression is the codec of the raft snapshots and the snapshot files
+type Compression byte
+
+const (
+	// NoCompression writes the snapshots as they are, without a header
+	NoCompression Compression = iota
+	// Gzip compresses the snapshots with gzip
+	Gzip
+)
+
+// compressionMagic starts the header of a compressed snapshot and is
+// followed by the byte of its Compression. A snapshot that does not start
+// with it is not compressed, so the snapshots of the earlier versions and
+// the ones of NoCompression can still be read
+var compressionMagic = []byte("GRDZ")
+
+// String returns the name of the compression
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	}
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+// ParseCompression returns the compression of the name, none or gzip
+func ParseCompression(name string) (Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return NoCompression, nil
+	case "gzip":
+		return Gzip, nil
+	}
+	return NoCompression, fmt.Errorf("%w %s", ErrUnknownCompression, name)
+}
+
+// nopWriteCloser is a writer whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewCompressWriter writes the header of the compression to w and returns
+// a writer that compresses to w. Close flushes the compressed data but does
+// not close w. NoCompression writes no header and nothing is compressed
+func NewCompressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case NoCompression:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		header := append(append([]byte{}, compressionMagic...), byte(c))
+		if _, err := w.Write(header); err != nil {
+			return nil, err
+		}
+		return gzip.NewWriter(w), nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+// NewDecompressReader returns a reader of the snapshot r decompressed by the
+// compression of its header, a snapshot without a header is read as it is
+func NewDecompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(compressionMagic) + 1)
+	if err != nil || !bytes.Equal(header[:len(compressionMagic)], compressionMagic) {
+		// too short for a header, the reader of the snapshot reports it
+		return br, nil
+	}
+	c := Compression(header[len(compressionMagic)])
+	if _, err := br.Discard(len(header)); err != nil {
+		return nil, err
+	}
+	switch c {
+	case Gzip:
+		return gzip.NewReader(br)
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnknownCompression, byte(c))
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	data := strings.Repeat(`{"key":"k","value":"v"}`, 1000)
+	for _, c := range []Compression{NoCompression, Gzip} {
+		var buf bytes.Buffer
+		w, err := NewCompressWriter(&buf, c)
+		if err != nil {
+			t.Fatalf("Expected a %s writer but got %v", c, err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatalf("Expected %s to be written but got %v", c, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Expected %s to be closed but got %v", c, err)
+		}
+		if c == Gzip && buf.Len() >= len(data)/10 {
+			t.Errorf("Expected %s to compress %d bytes but got %d", c, len(data), buf.Len())
+		}
+		if c == NoCompression && buf.String() != data {
+			t.Errorf("Expected %s to write the data as it is", c)
+		}
+		r, err := NewDecompressReader(&buf)
+		if err != nil {
+			t.Fatalf("Expected a %s reader but got %v", c, err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != data {
+			t.Errorf("Expected %s to read the data back but got %v", c, err)
+		}
+	}
+
+	for _, short := range []string{"", "{}", "GRDZ"} {
+		r, err := NewDecompressReader(strings.NewReader(short))
+		if err != nil {
+			t.Fatalf("Expected %q to be read as it is but got %v", short, err)
+		}
+		if b, _ := ioutil.ReadAll(r); string(b) != short {
+			t.Errorf("Expected %q but got %q", short, b)
+		}
+	}
+	if _, err := NewDecompressReader(strings.NewReader("GRDZ\x09...")); !errors.Is(err, ErrUnknownCompression) {
+		t.Errorf("Expected ErrUnknownCompression but got %v", err)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for name, expected := range map[string]Compression{"": NoCompression, "none": NoCompression, "GZIP": Gzip} {
+		if c, err := ParseCompression(name); err != nil || c != expected {
+			t.Errorf("Expected %q to be %s but got %s %v", name, expected, c, err)
+		}
+	}
+	if _, err := ParseCompression("snappy"); !errors.Is(err, ErrUnknownCompression) {
+		t.Errorf("Expected ErrUnknownCompression but got %v", err)
+	}
+}
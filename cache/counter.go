@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var (
+	// ErrNotInteger is returned when the value of a counter is not a decimal integer
+	ErrNotInteger = errors.New("cache: the value is not an integer")
+	// ErrOverflow is returned when a counter would overflow an int64
+	ErrOverflow = errors.New("cache: the counter overflows")
+)
+
+// AddInt parses value as a decimal integer and adds delta to it
+func AddInt(value string, delta int64) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+		return 0, ErrOverflow
+	}
+	return n + delta, nil
+}
+
+// Incr adds delta to the counter of the key, a missing key counts from zero.
+// It is atomic if the cache implements CounterCache, otherwise it gets and
+// puts the value and the concurrent updates of the key may be lost
+func Incr(c UnImplementedCache, key string, delta int64) (int64, error) {
+	if counter, ok := c.(CounterCache); ok {
+		return counter.Incr(key, delta)
+	}
+	value, ok := c.Get(key)
+	if !ok {
+		value = "0"
+	}
+	n, err := AddInt(value, delta)
+	if err != nil {
+		return 0, err
+	}
+	c.Put(key, strconv.FormatInt(n, 10))
+	return n, nil
+}
+
+// Decr subtracts delta from the counter of the key like Incr
+func Decr(c UnImplementedCache, key string, delta int64) (int64, error) {
+	if counter, ok := c.(CounterCache); ok {
+		return counter.Decr(key, delta)
+	}
+	return Incr(c, key, -delta)
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAddInt(t *testing.T) {
+	tests := []struct {
+		value    string
+		delta    int64
+		expected int64
+		err      error
+	}{
+		{"0", 1, 1, nil},
+		{"-5", 3, -2, nil},
+		{"10", -15, -5, nil},
+		{"", 1, 0, ErrNotInteger},
+		{"1.5", 1, 0, ErrNotInteger},
+		{"9223372036854775807", 1, 0, ErrOverflow},
+		{"-9223372036854775808", -1, 0, ErrOverflow},
+		{"0", math.MinInt64, math.MinInt64, nil},
+	}
+	for _, test := range tests {
+		value, err := AddInt(test.value, test.delta)
+		if value != test.expected || err != test.err {
+			t.Errorf("AddInt(%q, %d) expected %d %v but got %d %v",
+				test.value, test.delta, test.expected, test.err, value, err)
+		}
+	}
+}
+
+func TestIncr_Fallback(t *testing.T) {
+	c := mapCache{}
+	if value, err := Incr(c, "n", 3); err != nil || value != 3 {
+		t.Errorf("Expected 3 but got %d %v", value, err)
+	}
+	if value, err := Decr(c, "n", 5); err != nil || value != -2 || c["n"] != "-2" {
+		t.Errorf("Expected -2 but got %d %v", value, err)
+	}
+	c["s"] = "abc"
+	if _, err := Incr(c, "s", 1); err != ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger but got %v", err)
+	}
+}
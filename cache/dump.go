@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DumpVersion is the version of the export format
+const DumpVersion = 1
+
+// Dump is the portable export format of the caches, it is a JSON object
+//
+//	{"version": 1, "entries": [{"key": "k", "value": "v", "freq": 2, "expires": 1600000000000000000}]}
+//
+// The entries are ordered from the first to be evicted to the last, so
+// importing them in order restores the eviction order. freq is the
+// frequency or the access count of the entry and expires is the expiration
+// time in Unix nanoseconds, both are omitted if they are zero
+type Dump struct {
+	Version int         `json:"version"`
+	Entries []DumpEntry `json:"entries"`
+}
+
+// DumpEntry is an entry of the export format
+type DumpEntry struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Freq    int    `json:"freq,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+// WriteDump writes the entries in the export format
+func WriteDump(w io.Writer, entries []DumpEntry) error {
+	return json.NewEncoder(w).Encode(&Dump{Version: DumpVersion, Entries: entries})
+}
+
+// ReadDump reads the entries in the export format, ReadDumpEntries streams them
+func ReadDump(r io.Reader) ([]DumpEntry, error) {
+	var dump Dump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, err
+	}
+	if dump.Version != DumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", dump.Version)
+	}
+	return dump.Entries, nil
+}
+
+// ReadDumpEntries reads the entries in the export format and calls put for
+// each entry as it is decoded, without reading the whole dump into memory
+func ReadDumpEntries(r io.Reader, put func(e DumpEntry)) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "version":
+			var version int
+			if err := dec.Decode(&version); err != nil {
+				return err
+			}
+			if version != DumpVersion {
+				return fmt.Errorf("unsupported dump version %d", version)
+			}
+		case "entries":
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var e DumpEntry
+				if err := dec.Decode(&e); err != nil {
+					return err
+				}
+				put(e)
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token and returns an error if it is not delim
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("dump expected %v but got %v", delim, tok)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadDumpEntries(t *testing.T) {
+	var buf bytes.Buffer
+	entries := []DumpEntry{{Key: "1", Value: "one", Freq: 2}, {Key: "2", Value: "two", Expires: 42}}
+	if err := WriteDump(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	var read []DumpEntry
+	if err := ReadDumpEntries(&buf, func(e DumpEntry) { read = append(read, e) }); err != nil {
+		t.Fatalf("ReadDumpEntries failed %v", err)
+	}
+	if len(read) != 2 || read[0] != entries[0] || read[1] != entries[1] {
+		t.Errorf("Expected the entries in order but got %v", read)
+	}
+
+	for _, dump := range []string{
+		`{"version":2,"entries":[]}`,
+		`["1"]`,
+		`{"version":1,"entries":{}}`,
+		`{"version":1,"entries":[{"key":"1"}`,
+	} {
+		if err := ReadDumpEntries(strings.NewReader(dump), func(DumpEntry) {}); err == nil {
+			t.Errorf("Expected %s to be rejected", dump)
+		}
+	}
+	if err := ReadDumpEntries(strings.NewReader(`{"node":"a","version":1}`), func(DumpEntry) {}); err != nil {
+		t.Errorf("Expected the unknown fields to be skipped but got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"github.com/arazmj/gerdu/metrics"
+	"sync"
+	"sync/atomic"
+)
+
+// eventBuffer is the number of events a subscriber can fall behind before
+// the events are dropped
+const eventBuffer = 128
+
+// EventType is the type of a cache mutation
+type EventType int
+
+const (
+	// EventPut an entry is inserted or overwritten
+	EventPut EventType = iota
+	// EventDelete an entry is deleted
+	EventDelete
+	// EventEvict an entry is evicted to make room for the others
+	EventEvict
+	// EventExpire the time to live of an entry passed
+	EventExpire
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventEvict:
+		return "evict"
+	case EventExpire:
+		return "expire"
+	}
+	return "unknown"
+}
+
+// Event is a cache mutation, Value is only set for the puts
+type Event struct {
+	Type  EventType
+	Key   string
+	Value string
+}
+
+// Broadcaster sends the cache events to the subscribers, a subscriber that
+// is too slow misses the events instead of stalling the cache
+type Broadcaster struct {
+	sync.Mutex
+	subscribers []chan Event
+	active      int32
+}
+
+// Subscribe returns a channel that receives the events
+func (b *Broadcaster) Subscribe() <-chan Event {
+	defer b.Unlock()
+	b.Lock()
+	ch := make(chan Event, eventBuffer)
+	b.subscribers = append(b.subscribers, ch)
+	atomic.StoreInt32(&b.active, int32(len(b.subscribers)))
+	return ch
+}
+
+// Unsubscribe stops sending the events to the channel and closes it
+func (b *Broadcaster) Unsubscribe(ch <-chan Event) {
+	defer b.Unlock()
+	b.Lock()
+	for i, sub := range b.subscribers {
+		if sub == ch {
+			close(sub)
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			break
+		}
+	}
+	atomic.StoreInt32(&b.active, int32(len(b.subscribers)))
+}
+
+// Active reports whether there is any subscriber, the caches
+// only record the events while there is one
+func (b *Broadcaster) Active() bool {
+	return atomic.LoadInt32(&b.active) > 0
+}
+
+// Publish sends the events returned by drain to the subscribers. drain is
+// called while the publishes are serialized, so the subscribers receive the
+// events in the order they are recorded
+func (b *Broadcaster) Publish(drain func() []Event) {
+	if !b.Active() {
+		return
+	}
+	defer b.Unlock()
+	b.Lock()
+	for _, event := range drain() {
+		for _, sub := range b.subscribers {
+			select {
+			case sub <- event:
+			default:
+				metrics.Dropped.Inc()
+			}
+		}
+	}
+}
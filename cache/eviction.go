@@ -0,0 +1,40 @@
+package cache
+
+// EvictionReason is why an entry left the cache
+type EvictionReason int
+
+const (
+	// EvictionCapacity the entry is evicted to make room for the others
+	EvictionCapacity EvictionReason = iota
+	// EvictionExpired the time to live of the entry passed
+	EvictionExpired
+	// EvictionDeleted the entry is explicitly deleted
+	EvictionDeleted
+)
+
+// String returns the name of the reason
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionCapacity:
+		return "capacity"
+	case EvictionExpired:
+		return "expired"
+	case EvictionDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// EvictionListener is notified of the entries that leave the cache, it is
+// called while the cache is locked so it must not call the cache
+type EvictionListener interface {
+	OnEvict(key string, value string, reason EvictionReason)
+}
+
+// EvictionListenerFunc is a function used as an EvictionListener
+type EvictionListenerFunc func(key string, value string, reason EvictionReason)
+
+// OnEvict calls f
+func (f EvictionListenerFunc) OnEvict(key string, value string, reason EvictionReason) {
+	f(key, value, reason)
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// snapshotBufferSize is the size of the chunks WriteSnapshot writes
+const snapshotBufferSize = 64 << 10
+
+// WriteSnapshot writes the entries of a raft snapshot as a JSON object of
+// the keys to the values. The entries are encoded one at a time and written
+// in chunks, so the snapshot is never held in memory as a whole. The format
+// is the one json.Marshal writes for the map, so the snapshots of the
+// earlier versions can still be restored
+func WriteSnapshot(w io.Writer, entries map[string]string) error {
+	bw := bufio.NewWriterSize(w, snapshotBufferSize)
+	if err := bw.WriteByte('{'); err != nil {
+		return err
+	}
+	first := true
+	for key, value := range entries {
+		if !first {
+			if err := bw.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err := writeString(bw, key); err != nil {
+			return err
+		}
+		if err := bw.WriteByte(':'); err != nil {
+			return err
+		}
+		if err := writeString(bw, value); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte('}'); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeString writes s as a JSON string
+func writeString(w *bufio.Writer, s string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteSnapshotEntries writes the entries of a raft snapshot with their
+// eviction metadata as a JSON array of DumpEntry, in the order of the
+// entries. Like WriteSnapshot the entries are encoded one at a time
+func WriteSnapshotEntries(w io.Writer, entries []DumpEntry) error {
+	bw := bufio.NewWriterSize(w, snapshotBufferSize)
+	if err := bw.WriteByte('['); err != nil {
+		return err
+	}
+	for i := range entries {
+		if i > 0 {
+			if err := bw.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		b, err := json.Marshal(&entries[i])
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte(']'); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot WriteSnapshot or WriteSnapshotEntries wrote
+// and calls put for each entry as it is decoded, without reading the whole
+// snapshot into memory
+func ReadSnapshot(r io.Reader, put func(key, value string)) error {
+	return ReadSnapshotEntries(r, func(e DumpEntry) {
+		put(e.Key, e.Value)
+	})
+}
+
+// ReadSnapshotEntries reads a snapshot like ReadSnapshot with the eviction
+// metadata of the entries, the entries of a snapshot WriteSnapshot wrote
+// have none
+func ReadSnapshotEntries(r io.Reader, put func(e DumpEntry)) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, snapshotBufferSize))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var value string
+			if err := dec.Decode(&value); err != nil {
+				return err
+			}
+			put(DumpEntry{Key: tok.(string), Value: value})
+		}
+	case json.Delim('['):
+		for dec.More() {
+			var e DumpEntry
+			if err := dec.Decode(&e); err != nil {
+				return err
+			}
+			put(e)
+		}
+	default:
+		return fmt.Errorf("snapshot is not an object or an array but %v", tok)
+	}
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// chunkWriter records the sizes of the writes
+type chunkWriter struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	entries := map[string]string{"": "", "a": "1", "quote\"": "new\nline", "<tag>": "é "}
+	for i := 0; i < 10000; i++ {
+		entries[strconv.Itoa(i)] = strings.Repeat("x", 100)
+	}
+	var w chunkWriter
+	if err := WriteSnapshot(&w, entries); err != nil {
+		t.Fatalf("Expected the snapshot to be written but got %v", err)
+	}
+	for _, n := range w.writes {
+		if n > snapshotBufferSize {
+			t.Fatalf("Expected the writes to be at most %d bytes but got %d", snapshotBufferSize, n)
+		}
+	}
+	if len(w.writes) < 2 {
+		t.Errorf("Expected the snapshot to be written in chunks but got %d writes", len(w.writes))
+	}
+
+	var decoded map[string]string
+	if err := json.Unmarshal(w.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected the snapshot to be a JSON object but got %v", err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("Expected the JSON object to have the entries")
+	}
+
+	read := map[string]string{}
+	if err := ReadSnapshot(&w.Buffer, func(key, value string) { read[key] = value }); err != nil {
+		t.Fatalf("Expected the snapshot to be read but got %v", err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("Expected to read the entries back")
+	}
+
+	w = chunkWriter{}
+	if err := WriteSnapshot(&w, nil); err != nil || w.String() != "{}" {
+		t.Errorf("Expected an empty object but got %q %v", w.String(), err)
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	legacy, _ := json.Marshal(map[string]string{"a": "1", "b": "2"})
+	read := map[string]string{}
+	if err := ReadSnapshot(bytes.NewReader(legacy), func(key, value string) { read[key] = value }); err != nil {
+		t.Fatalf("Expected the json.Marshal snapshot to be read but got %v", err)
+	}
+	if len(read) != 2 || read["a"] != "1" || read["b"] != "2" {
+		t.Errorf("Expected a and b but got %v", read)
+	}
+	for _, snapshot := range []string{``, `"a"`, `["a"]`, `{"a":1}`, `{"a":"a"`, `{"a":"a",}`, `[{"key":"a"}`} {
+		if err := ReadSnapshot(strings.NewReader(snapshot), func(key, value string) {}); err == nil {
+			t.Errorf("Expected %q to be rejected", snapshot)
+		}
+	}
+}
+
+func TestWriteSnapshotEntries(t *testing.T) {
+	entries := []DumpEntry{
+		{Key: "b", Value: "2", Freq: 1},
+		{Key: "a", Value: "1\n", Freq: 5, Expires: 1600000000000000000},
+		{Key: "c"},
+	}
+	var w bytes.Buffer
+	if err := WriteSnapshotEntries(&w, entries); err != nil {
+		t.Fatalf("Expected the snapshot to be written but got %v", err)
+	}
+	var read []DumpEntry
+	if err := ReadSnapshotEntries(&w, func(e DumpEntry) { read = append(read, e) }); err != nil {
+		t.Fatalf("Expected the snapshot to be read but got %v", err)
+	}
+	if !reflect.DeepEqual(read, entries) {
+		t.Errorf("Expected %v in order but got %v", entries, read)
+	}
+
+	w.Reset()
+	if err := WriteSnapshotEntries(&w, nil); err != nil || w.String() != "[]" {
+		t.Errorf("Expected an empty array but got %q %v", w.String(), err)
+	}
+}
@@ -0,0 +1,134 @@
+package cache
+
+import "errors"
+
+// ErrBadPattern is returned when a glob pattern is malformed
+var ErrBadPattern = errors.New("cache: syntax error in pattern")
+
+// Match reports whether key matches the glob pattern. * matches any sequence
+// of bytes, ? a single byte, [abc], [a-z] and [^a] a byte in or not in the
+// class and \ escapes the next byte. Unlike path.Match a slash is not special
+// so user:*:session matches user:a/b:session
+func Match(pattern, key string) (bool, error) {
+	if err := ValidPattern(pattern); err != nil {
+		return false, err
+	}
+	return match(pattern, key), nil
+}
+
+// ValidPattern returns ErrBadPattern if an escape or a class of the pattern
+// is not complete
+func ValidPattern(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i++; i >= len(pattern) {
+				return ErrBadPattern
+			}
+		case '[':
+			width := classWidth(pattern[i:])
+			if width < 0 {
+				return ErrBadPattern
+			}
+			i += width - 1
+		}
+	}
+	return nil
+}
+
+// match matches the valid pattern, a * is retried on a longer part of the
+// key when the rest of the pattern does not match
+func match(pattern, key string) bool {
+	px, kx := 0, 0
+	starPx, starKx := -1, -1
+	for px < len(pattern) || kx < len(key) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starKx = px, kx
+				px++
+				continue
+			case '?':
+				if kx < len(key) {
+					px++
+					kx++
+					continue
+				}
+			case '[':
+				width := classWidth(pattern[px:])
+				if kx < len(key) && inClass(pattern[px:px+width], key[kx]) {
+					px += width
+					kx++
+					continue
+				}
+			case '\\':
+				if kx < len(key) && key[kx] == pattern[px+1] {
+					px += 2
+					kx++
+					continue
+				}
+			default:
+				if kx < len(key) && key[kx] == c {
+					px++
+					kx++
+					continue
+				}
+			}
+		}
+		if starPx >= 0 && starKx < len(key) {
+			starKx++
+			px, kx = starPx+1, starKx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// classWidth returns the length of the class at the start of the pattern
+// including its brackets, -1 if the class is not closed
+func classWidth(pattern string) int {
+	i := 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case ']':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// inClass reports whether b is matched by the class, including its brackets
+func inClass(class string, b byte) bool {
+	class = class[1 : len(class)-1]
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		lo := class[i]
+		if lo == '\\' && i+1 < len(class) {
+			i++
+			lo = class[i]
+		}
+		hi := lo
+		if i+2 < len(class) && class[i+1] == '-' {
+			hi = class[i+2]
+			if hi == '\\' && i+3 < len(class) {
+				hi = class[i+3]
+				i++
+			}
+			i += 2
+		}
+		if lo <= b && b <= hi {
+			matched = true
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		matched bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*:session", "user:42:session", true},
+		{"user:*:session", "user:a/b:session", true},
+		{"user:*:session", "user:42:profile", false},
+		{"user:*", "user:", true},
+		{"user:*", "use", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"*a", "bbba", true},
+		{"**", "x", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{`[\]]`, "]", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, test := range tests {
+		matched, err := Match(test.pattern, test.key)
+		if err != nil || matched != test.matched {
+			t.Errorf("Match(%q, %q) expected %t but got %t %v", test.pattern, test.key, test.matched, matched, err)
+		}
+	}
+}
+
+func TestMatch_BadPattern(t *testing.T) {
+	for _, pattern := range []string{"[abc", `abc\`, "a[^"} {
+		if _, err := Match(pattern, "abc"); err != ErrBadPattern {
+			t.Errorf("Match(%q) expected ErrBadPattern but got %v", pattern, err)
+		}
+	}
+}
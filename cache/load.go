@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoaderPanicked is returned to the callers that wait for a loader that panicked
+var ErrLoaderPanicked = errors.New("cache: the loader panicked")
+
+// Loader loads the value of a key that is missing from the cache
+type Loader func(key string) (value string, err error)
+
+// call is a load in flight, done is closed once value and err are set
+type call struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// Group deduplicates the concurrent loads of the same key, the zero
+// value is ready to use
+type Group struct {
+	sync.Mutex
+	calls map[string]*call
+}
+
+// GetOrLoad returns the value for the key from c, or loads it with loader
+// and puts it in c if it is missing. The concurrent callers that miss the
+// same key wait for a single load and share its result, an error is
+// returned to all of them and nothing is put
+func (g *Group) GetOrLoad(c UnImplementedCache, key string, loader Loader) (value string, err error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+	g.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if cl, ok := g.calls[key]; ok {
+		g.Unlock()
+		<-cl.done
+		return cl.value, cl.err
+	}
+	cl := &call{done: make(chan struct{}), err: ErrLoaderPanicked}
+	g.calls[key] = cl
+	g.Unlock()
+
+	defer func() {
+		g.Lock()
+		delete(g.calls, key)
+		g.Unlock()
+		close(cl.done)
+	}()
+	value, err = loader(key)
+	cl.value, cl.err = value, err
+	if err == nil {
+		c.Put(key, value)
+	}
+	return value, err
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGroup_GetOrLoad(t *testing.T) {
+	c := mapCache{}
+	var g Group
+	value, err := g.GetOrLoad(c, "a", func(key string) (string, error) {
+		return "loaded " + key, nil
+	})
+	if err != nil || value != "loaded a" || c["a"] != "loaded a" {
+		t.Errorf("Expected a to be loaded and put but got %s %v", value, err)
+	}
+	value, err = g.GetOrLoad(c, "a", func(key string) (string, error) {
+		t.Errorf("Expected the cached a not to be loaded again")
+		return "", nil
+	})
+	if err != nil || value != "loaded a" {
+		t.Errorf("Expected the cached a but got %s %v", value, err)
+	}
+
+	failed := errors.New("failed")
+	if _, err := g.GetOrLoad(c, "b", func(key string) (string, error) {
+		return "", failed
+	}); err != failed {
+		t.Errorf("Expected the error of the loader but got %v", err)
+	}
+	if _, ok := c["b"]; ok {
+		t.Errorf("Expected the failed load not to be put")
+	}
+}
+
+func TestGroup_GetOrLoadPanic(t *testing.T) {
+	c := mapCache{}
+	var g Group
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected the panic of the loader to propagate")
+			}
+		}()
+		_, _ = g.GetOrLoad(c, "a", func(key string) (string, error) {
+			panic("loader")
+		})
+	}()
+	if len(g.calls) != 0 {
+		t.Errorf("Expected the call of the panicked loader to be dropped")
+	}
+	if value, err := g.GetOrLoad(c, "a", func(key string) (string, error) {
+		return "a", nil
+	}); err != nil || value != "a" {
+		t.Errorf("Expected a to be loaded after the panic but got %s %v", value, err)
+	}
+}
@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/inhies/go-bytesize"
+	"unsafe"
+)
+
+// the maps keep 6.5 entries per bucket of 8 on average before they grow, so
+// an entry takes 16/13 of its key, value and top hash byte
+const (
+	// nodeMapOverhead is an entry of the map from the keys to the nodes
+	nodeMapOverhead = (16 + 8 + 1) * 16 / 13
+	// indexMapOverhead is an entry of the scan index of the keys
+	indexMapOverhead = (16 + 1) * 16 / 13
+)
+
+// EntryOverhead is the memory an entry of the linked list caches takes in
+// addition to its key and value: the node and its entries in the maps
+var EntryOverhead = bytesize.ByteSize(unsafe.Sizeof(dlinklist.Node{})) + nodeMapOverhead + indexMapOverhead
+
+// MemoryCost sizes the entries by the memory they take, the key, the value
+// and the overhead of the entry
+func MemoryCost(key string, value string) bytesize.ByteSize {
+	return bytesize.ByteSize(len(key)+len(value)) + EntryOverhead
+}
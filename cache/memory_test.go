@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/inhies/go-bytesize"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestMemoryCost(t *testing.T) {
+	if cost := MemoryCost("key", "value"); cost != 8+EntryOverhead {
+		t.Errorf("Expected %d but got %d", 8+EntryOverhead, cost)
+	}
+	if EntryOverhead < 100 {
+		t.Errorf("Expected the overhead to include the node but got %d", EntryOverhead)
+	}
+}
+
+// TestMemoryCost_Estimate checks that the estimate is not below the memory a
+// map of nodes with short keys and values takes
+func TestMemoryCost_Estimate(t *testing.T) {
+	const n = 100000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	nodes := map[string]*dlinklist.Node{}
+	keys := KeyIndex{}
+	var estimate bytesize.ByteSize
+	for i := 0; i < n; i++ {
+		key := "k" + strconv.Itoa(i)
+		nodes[key] = &dlinklist.Node{Key: key, Value: "v"}
+		keys.Add(key)
+		estimate += MemoryCost(key, "v")
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	used := bytesize.ByteSize(after.HeapAlloc - before.HeapAlloc)
+	if estimate < used*3/4 {
+		t.Errorf("Expected the estimate %d to be close to the used memory %d", estimate, used)
+	}
+	runtime.KeepAlive(nodes)
+	runtime.KeepAlive(&keys)
+}
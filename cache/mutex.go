@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// maxReaders is the most readers that can hold an RWMutex at once
+const maxReaders = 1 << 30
+
+// RWMutex is a reader/writer lock like sync.RWMutex whose writers can give
+// up once a context is done. The lock of the writers is a channel, so a
+// waiter that gives up never takes the lock and LockContext starts no
+// goroutine. The readers are counted like in sync.RWMutex, a writer waits
+// for the readers that hold the lock and the new readers wait for it
+type RWMutex struct {
+	write       chan struct{}
+	writerSem   chan struct{}
+	readerSem   chan struct{}
+	readerCount int32
+	readerWait  int32
+}
+
+// NewRWMutex RWMutex constructor
+func NewRWMutex() *RWMutex {
+	return &RWMutex{
+		write:     make(chan struct{}, 1),
+		writerSem: make(chan struct{}, 1),
+		readerSem: make(chan struct{}, maxReaders),
+	}
+}
+
+// Lock locks the mutex for writing
+func (m *RWMutex) Lock() {
+	m.write <- struct{}{}
+	m.waitReaders()
+}
+
+// LockContext locks the mutex for writing unless the context is done
+// before the other writers release it, in which case the mutex is not
+// locked and ctx.Err() is returned. The readers that already hold the lock
+// are waited for, they hold it for a single lookup
+func (m *RWMutex) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case m.write <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.waitReaders()
+	return nil
+}
+
+// waitReaders stops the new readers and waits for the readers that hold
+// the lock, the caller must hold the lock of the writers
+func (m *RWMutex) waitReaders() {
+	r := atomic.AddInt32(&m.readerCount, -maxReaders) + maxReaders
+	if r != 0 && atomic.AddInt32(&m.readerWait, r) != 0 {
+		<-m.writerSem
+	}
+}
+
+// Unlock unlocks the mutex for writing and wakes up the waiting readers
+func (m *RWMutex) Unlock() {
+	r := atomic.AddInt32(&m.readerCount, maxReaders)
+	for i := int32(0); i < r; i++ {
+		m.readerSem <- struct{}{}
+	}
+	<-m.write
+}
+
+// RLock locks the mutex for reading
+func (m *RWMutex) RLock() {
+	if atomic.AddInt32(&m.readerCount, 1) < 0 {
+		<-m.readerSem
+	}
+}
+
+// RUnlock undoes a single RLock call and wakes up the writer once the
+// last reader it waits for is done
+func (m *RWMutex) RUnlock() {
+	if atomic.AddInt32(&m.readerCount, -1) < 0 && atomic.AddInt32(&m.readerWait, -1) == 0 {
+		m.writerSem <- struct{}{}
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRWMutex_LockContext(t *testing.T) {
+	m := NewRWMutex()
+	m.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded but got %v", err)
+	}
+	m.Unlock()
+
+	// the waiter that gave up must not take the lock later
+	if err := m.LockContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.Unlock()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.LockContext(cancelled); err != context.Canceled {
+		t.Errorf("Expected context.Canceled on a free lock but got %v", err)
+	}
+	m.Lock()
+	m.Unlock()
+}
+
+func TestRWMutex_Readers(t *testing.T) {
+	m := NewRWMutex()
+	m.RLock()
+	m.RLock()
+	locked := make(chan struct{})
+	go func() {
+		m.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("Expected the writer to wait for the readers")
+	case <-time.After(10 * time.Millisecond):
+	}
+	m.RUnlock()
+	m.RUnlock()
+	<-locked
+	read := make(chan struct{})
+	go func() {
+		m.RLock()
+		close(read)
+	}()
+	select {
+	case <-read:
+		t.Fatalf("Expected the reader to wait for the writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+	m.Unlock()
+	<-read
+	m.RUnlock()
+}
+
+func TestRWMutex_Concurrent(t *testing.T) {
+	m := NewRWMutex()
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Lock()
+				counter++
+				m.Unlock()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.RLock()
+				_ = counter
+				m.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 8000 {
+		t.Errorf("Expected 8000 but got %d", counter)
+	}
+}
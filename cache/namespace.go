@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/inhies/go-bytesize"
+	"time"
+)
+
+// ErrNamespaceConflict is returned when a namespace is created with a config
+// other than the one of the existing namespace of the name
+var ErrNamespaceConflict = errors.New("cache: the namespace exists with another config")
+
+// NamespaceConfig is the capacity, the eviction policy and the default time
+// to live of the cache of a namespace, a zero field means the default of
+// the server
+type NamespaceConfig struct {
+	Capacity bytesize.ByteSize
+	Policy   string
+	TTL      time.Duration
+}
+
+// namespaceConfigJSON is the JSON form of NamespaceConfig with the capacity
+// and the ttl as strings, e.g. {"capacity":"128MB","policy":"lfu","ttl":"10m"}
+type namespaceConfigJSON struct {
+	Capacity string `json:"capacity,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	TTL      string `json:"ttl,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (c NamespaceConfig) MarshalJSON() ([]byte, error) {
+	j := namespaceConfigJSON{Policy: c.Policy}
+	if c.Capacity != 0 {
+		j.Capacity = c.Capacity.String()
+	}
+	if c.TTL != 0 {
+		j.TTL = c.TTL.String()
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (c *NamespaceConfig) UnmarshalJSON(data []byte) error {
+	var j namespaceConfigJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	config := NamespaceConfig{Policy: j.Policy}
+	if j.Capacity != "" {
+		capacity, err := bytesize.Parse(j.Capacity)
+		if err != nil {
+			return err
+		}
+		config.Capacity = capacity
+	}
+	if j.TTL != "" {
+		ttl, err := time.ParseDuration(j.TTL)
+		if err != nil {
+			return err
+		}
+		config.TTL = ttl
+	}
+	*c = config
+	return nil
+}
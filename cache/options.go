@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/inhies/go-bytesize"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Unlimited is a capacity that never evicts by the size, the caches with it
+// are limited by MaxEntries instead
+const Unlimited bytesize.ByteSize = math.MaxUint64
+
+// Options are the settings shared by the cache implementations
+type Options struct {
+	// DefaultTTL is the time to live of the entries, zero means they never expire
+	DefaultTTL time.Duration
+	// SlidingTTL makes each hit expire the entry a TTL after the hit instead
+	// of a TTL after it is put, so only the idle entries expire
+	SlidingTTL bool
+	// OnEvict is called with the entries that are evicted or expired, it is
+	// called while the cache is locked so it must not call the cache
+	OnEvict func(key string, value string)
+	// EvictionListener is notified of the entries that are evicted, expired
+	// or deleted with the reason they left the cache
+	EvictionListener EvictionListener
+	// Cost returns the size an entry takes from the capacity
+	Cost func(key string, value string) bytesize.ByteSize
+	// MetricsName is the cache label of the Prometheus counters
+	MetricsName string
+	// Recorder is the backend the metrics of the cache are reported to
+	Recorder metrics.Recorder
+	// MaxEntries is the maximum number of entries, zero means no limit
+	MaxEntries int
+	// MinEntries is the number of entries the capacity eviction keeps even
+	// if the size is still over the capacity, so a single huge value does
+	// not evict the whole working set. The size overshoots the capacity
+	// until the entries are deleted, replaced by smaller values or evicted
+	// by the later puts, EvictN and TrimTo are not limited by it
+	MinEntries int
+	// Now returns the current time, it is used to expire the entries
+	Now func() time.Time
+	// DecayInterval is the time between the frequency decays of LFU, zero
+	// means the frequencies never decay
+	DecayInterval time.Duration
+	// DecayFactor multiplies the frequencies of LFU on every decay
+	DecayFactor float64
+	// DoorkeeperWindow is the number of keys the admission doorkeeper of LFU
+	// remembers before it is cleared, zero means no doorkeeper
+	DoorkeeperWindow int
+	// DoorkeeperFPRate is the target false positive rate of the doorkeeper
+	DoorkeeperFPRate float64
+	// SnapshotPath is the file the entries are periodically exported to
+	// and loaded from on startup, empty means no periodic snapshots
+	SnapshotPath string
+	// SnapshotInterval is the time between the periodic snapshots
+	SnapshotInterval time.Duration
+	// SnapshotMutations is the number of the mutations after which a
+	// snapshot is written, zero means the mutations do not trigger one
+	SnapshotMutations int
+	// SnapshotCompression compresses the periodic snapshots, the snapshot
+	// file is loaded by the compression of its header whatever it is
+	SnapshotCompression Compression
+	// TTLJitter is the fraction of the TTL the expiration of each entry is
+	// randomly moved by, so the entries put together do not expire together
+	TTLJitter float64
+	// Rand returns a random number in [0, 1), it draws the TTL jitter
+	Rand func() float64
+	// ExpirationTick is the time between the sweeps of the expired entries,
+	// zero means the entries are only expired when they are accessed
+	ExpirationTick time.Duration
+	// ExpirationBatch is the maximum number of the entries a sweep expires
+	// under a single hold of the lock
+	ExpirationBatch int
+}
+
+// Option sets one of the cache options
+type Option func(*Options)
+
+// NewOptions returns the default options updated by opts
+func NewOptions(opts ...Option) *Options {
+	o := &Options{
+		Cost:        valueCost,
+		MetricsName: metrics.DefaultName,
+		Recorder:    metrics.Prometheus,
+		Now:         time.Now,
+		Rand:        rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// valueCost sizes the entries by the length of their value, an empty value
+// still takes a byte so the entry is evictable by the capacity
+func valueCost(key string, value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+// Jitter returns the ttl moved by a random part of TTLJitter of it, earlier
+// or later, it is at least a nanosecond so the entry still expires. A zero
+// ttl is returned as is
+func (o *Options) Jitter(ttl time.Duration) time.Duration {
+	if o.TTLJitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	ttl += time.Duration(float64(ttl) * o.TTLJitter * (2*o.Rand() - 1))
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
+
+// WithDefaultTTL expires the entries ttl after they are put
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.DefaultTTL = ttl
+	}
+}
+
+// WithSlidingTTL expires the entries a TTL after they are last accessed
+// instead of a TTL after they are put
+func WithSlidingTTL() Option {
+	return func(o *Options) {
+		o.SlidingTTL = true
+	}
+}
+
+// WithOnEvict calls fn with the entries that are evicted or expired
+func WithOnEvict(fn func(key string, value string)) Option {
+	return func(o *Options) {
+		o.OnEvict = fn
+	}
+}
+
+// WithEvictionListener notifies l of the entries that are evicted, expired
+// or deleted
+func WithEvictionListener(l EvictionListener) Option {
+	return func(o *Options) {
+		o.EvictionListener = l
+	}
+}
+
+// WithCostFunc sizes the entries by fn instead of the length of the value,
+// MemoryCost sizes them by the memory they take
+func WithCostFunc(fn func(key string, value string) bytesize.ByteSize) Option {
+	return func(o *Options) {
+		o.Cost = fn
+	}
+}
+
+// WithMetricsName labels the Prometheus counters of the cache with name
+func WithMetricsName(name string) Option {
+	return func(o *Options) {
+		o.MetricsName = name
+	}
+}
+
+// WithRecorder reports the metrics of the cache to r instead of Prometheus
+func WithRecorder(r metrics.Recorder) Option {
+	return func(o *Options) {
+		o.Recorder = r
+	}
+}
+
+// WithMaxEntries limits the number of entries in addition to the capacity,
+// whichever is reached first evicts. With the capacity Unlimited only the
+// number of entries is limited
+func WithMaxEntries(n int) Option {
+	return func(o *Options) {
+		o.MaxEntries = n
+	}
+}
+
+// WithMinEntries stops the eviction once the cache is down to n entries,
+// even if the size is still over the capacity
+func WithMinEntries(n int) Option {
+	return func(o *Options) {
+		o.MinEntries = n
+	}
+}
+
+// WithFrequencyDecay multiplies the frequencies of LFU by factor every
+// interval, so the entries that were hot long ago become evictable again
+func WithFrequencyDecay(interval time.Duration, factor float64) Option {
+	return func(o *Options) {
+		o.DecayInterval = interval
+		o.DecayFactor = factor
+	}
+}
+
+// WithDoorkeeper only lets a new key into LFU once it is accessed a second
+// time by a put or a missed get, a missed get followed by the put of the key
+// is one access. The keys are remembered in a bloom filter with the false
+// positive rate fpRate that is cleared after window keys, with the TinyLFU
+// admission the filter is cleared with the sketch instead
+func WithDoorkeeper(window int, fpRate float64) Option {
+	return func(o *Options) {
+		o.DoorkeeperWindow = window
+		o.DoorkeeperFPRate = fpRate
+	}
+}
+
+// WithClock replaces time.Now as the time source of the expiration
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) {
+		o.Now = now
+	}
+}
+
+// WithPeriodicSnapshot exports the entries to path every interval and
+// loads them from it when the cache is created
+func WithPeriodicSnapshot(path string, interval time.Duration) Option {
+	return func(o *Options) {
+		o.SnapshotPath = path
+		o.SnapshotInterval = interval
+	}
+}
+
+// WithSnapshotMutations also writes the snapshot of WithPeriodicSnapshot
+// once the entries are put or removed mutations times since the last one
+func WithSnapshotMutations(mutations int) Option {
+	return func(o *Options) {
+		o.SnapshotMutations = mutations
+	}
+}
+
+// WithSnapshotCompression compresses the periodic snapshots with c
+func WithSnapshotCompression(c Compression) Option {
+	return func(o *Options) {
+		o.SnapshotCompression = c
+	}
+}
+
+// WithTTLJitter moves the expiration of each entry by up to fraction of its
+// TTL, earlier or later, so the entries warmed up together with the same TTL
+// do not expire in a stampede on the backing store
+func WithTTLJitter(fraction float64) Option {
+	return func(o *Options) {
+		o.TTLJitter = fraction
+	}
+}
+
+// WithRand replaces math/rand as the source of the TTL jitter, rnd
+// must return a number in [0, 1) and be safe for concurrent use
+func WithRand(rnd func() float64) Option {
+	return func(o *Options) {
+		o.Rand = rnd
+	}
+}
+
+// WithActiveExpiration reclaims the expired entries every tick instead of
+// only when they are accessed, at most batch of them under a single hold
+// of the lock so the sweeps do not stall the other calls
+func WithActiveExpiration(tick time.Duration, batch int) Option {
+	return func(o *Options) {
+		o.ExpirationTick = tick
+		o.ExpirationBatch = batch
+	}
+}
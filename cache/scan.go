@@ -0,0 +1,74 @@
+package cache
+
+// scanBuckets is the number of the buckets of KeyIndex, a power of two
+const scanBuckets = 1 << 10
+
+// defaultScanCount is the number of keys Scan returns if count is not positive
+const defaultScanCount = 10
+
+// KeyIndex groups the keys into a fixed number of buckets by their hash so
+// they can be scanned a few buckets at a time. A key stays in its bucket
+// while it is in the index, so a key that is in the index for the whole scan
+// is returned exactly once. The zero value is ready to use, it is not safe
+// for concurrent use
+type KeyIndex struct {
+	buckets [scanBuckets]map[string]struct{}
+}
+
+// Add adds the key to the index
+func (x *KeyIndex) Add(key string) {
+	b := bucket(key)
+	if x.buckets[b] == nil {
+		x.buckets[b] = map[string]struct{}{}
+	}
+	x.buckets[b][key] = struct{}{}
+}
+
+// Remove removes the key from the index
+func (x *KeyIndex) Remove(key string) {
+	delete(x.buckets[bucket(key)], key)
+}
+
+// Scan returns the keys of the buckets from cursor on until at least count
+// keys are collected and the cursor of the next call, which is zero once
+// all the buckets are scanned. A scan starts with the cursor zero
+func (x *KeyIndex) Scan(cursor uint64, count int) (keys []string, next uint64) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	for b := cursor; b < scanBuckets; b++ {
+		for key := range x.buckets[b] {
+			keys = append(keys, key)
+		}
+		if len(keys) >= count {
+			return keys, (b + 1) % scanBuckets
+		}
+	}
+	return keys, 0
+}
+
+// ScanMatch scans a batch of the keys of c like Scan and returns the ones
+// that match the glob pattern, the batch may be empty before the scan is done
+func ScanMatch(c ScanCache, cursor uint64, count int, pattern string) (keys []string, next uint64, err error) {
+	if err := ValidPattern(pattern); err != nil {
+		return nil, 0, err
+	}
+	keys, next = c.Scan(cursor, count)
+	matched := keys[:0]
+	for _, key := range keys {
+		if match(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	return matched, next, nil
+}
+
+// bucket returns the bucket of the key by its 32-bit FNV-1a hash
+func bucket(key string) uint64 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return uint64(h & (scanBuckets - 1))
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyIndex_Scan(t *testing.T) {
+	var x KeyIndex
+	for i := 0; i < 1000; i++ {
+		x.Add(strconv.Itoa(i))
+	}
+	seen := map[string]int{}
+	var cursor uint64
+	calls := 0
+	for {
+		keys, next := x.Scan(cursor, 50)
+		for _, key := range keys {
+			seen[key]++
+		}
+		calls++
+		// the keys removed or added during the scan may be returned or not
+		x.Remove(strconv.Itoa(calls))
+		x.Add("new" + strconv.Itoa(calls))
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if calls < 1000/50 {
+		t.Errorf("Expected at least %d calls but got %d", 1000/50, calls)
+	}
+	for i := calls + 1; i < 1000; i++ {
+		if key := strconv.Itoa(i); seen[key] != 1 {
+			t.Errorf("Expected %s to be returned once but got %d", key, seen[key])
+		}
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Expected %s to be returned once but got %d", key, n)
+		}
+	}
+}
+
+func TestKeyIndex_ScanEmpty(t *testing.T) {
+	var x KeyIndex
+	if keys, next := x.Scan(0, 10); len(keys) != 0 || next != 0 {
+		t.Errorf("Expected no keys but got %v %d", keys, next)
+	}
+	x.Add("a")
+	x.Remove("a")
+	if keys, next := x.Scan(0, 0); len(keys) != 0 || next != 0 {
+		t.Errorf("Expected no keys but got %v %d", keys, next)
+	}
+}
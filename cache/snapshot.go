@@ -0,0 +1,178 @@
+package cache
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshotter writes the export of a cache to a file periodically or once
+// the cache is mutated enough times, so a single node without raft can
+// recover its entries after a crash
+type Snapshotter struct {
+	path        string
+	export      func(io.Writer) error
+	compression Compression
+	every       int64
+	mutations   int64
+	trigger     chan struct{}
+	running     int32
+	writes      sync.WaitGroup
+	done        chan struct{}
+	stopped     chan struct{}
+	stopOnce    sync.Once
+}
+
+// StartSnapshots loads the snapshot file of the options with load if the
+// file exists and then writes export to it every snapshot interval and
+// after every snapshot mutations, without either it is only written by
+// Stop. It returns nil if the options have no snapshot path
+func StartSnapshots(o *Options, export func(io.Writer) error, load func(io.Reader) error) *Snapshotter {
+	if o.SnapshotPath == "" {
+		return nil
+	}
+	if f, err := os.Open(o.SnapshotPath); err == nil {
+		if err := loadSnapshot(f, load); err != nil {
+			log.Errorf("Cannot load the snapshot %s: %v", o.SnapshotPath, err)
+		}
+		_ = f.Close()
+	} else if !os.IsNotExist(err) {
+		log.Errorf("Cannot open the snapshot %s: %v", o.SnapshotPath, err)
+	}
+	s := &Snapshotter{
+		path:        o.SnapshotPath,
+		export:      export,
+		compression: o.SnapshotCompression,
+		every:       int64(o.SnapshotMutations),
+		trigger:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	if o.SnapshotInterval > 0 || o.SnapshotMutations > 0 {
+		go s.run(o.SnapshotInterval)
+	} else {
+		close(s.stopped)
+	}
+	return s
+}
+
+// run starts a snapshot every interval and when the mutations trigger one,
+// a snapshot is skipped if the previous one is still being written
+func (s *Snapshotter) run(interval time.Duration) {
+	defer close(s.stopped)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			s.start()
+		case <-s.trigger:
+			s.start()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// start writes a snapshot in the background unless one is being written
+func (s *Snapshotter) start() {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		log.Debugf("Skipped the snapshot %s, the previous one is still running", s.path)
+		return
+	}
+	s.writes.Add(1)
+	go func() {
+		defer s.writes.Done()
+		defer atomic.StoreInt32(&s.running, 0)
+		if err := s.Save(); err != nil {
+			log.Errorf("Cannot write the snapshot %s: %v", s.path, err)
+		}
+	}()
+}
+
+// Mutated counts a mutation of the cache and triggers a snapshot once there
+// are snapshot mutations since the last one. It does not block so it can be
+// called under the lock of the cache, it is a no-op on a nil Snapshotter
+func (s *Snapshotter) Mutated() {
+	if s == nil || s.every <= 0 {
+		return
+	}
+	if atomic.AddInt64(&s.mutations, 1) >= s.every {
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Save writes the export to a temporary file next to the snapshot
+// and renames it over the snapshot, so the snapshot is never partial
+func (s *Snapshotter) Save() error {
+	// the mutations during the save count towards the next one
+	atomic.StoreInt64(&s.mutations, 0)
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = s.write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// write writes the export to w compressed by the compression of the snapshots
+func (s *Snapshotter) write(w io.Writer) error {
+	cw, err := NewCompressWriter(w, s.compression)
+	if err != nil {
+		return err
+	}
+	if err := s.export(cw); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// loadSnapshot loads the snapshot file with load, decompressed by the
+// compression of its header
+func loadSnapshot(f io.Reader, load func(io.Reader) error) error {
+	r, err := NewDecompressReader(f)
+	if err != nil {
+		return err
+	}
+	return load(r)
+}
+
+// Stop stops the periodic snapshots, waits for the running one and writes
+// a last snapshot. It is a no-op on a nil Snapshotter
+func (s *Snapshotter) Stop() error {
+	if s == nil {
+		return nil
+	}
+	err := error(nil)
+	s.stopOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+		s.writes.Wait()
+		err = s.Save()
+	})
+	return err
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/inhies/go-bytesize"
+)
+
+// Stats are the counters and the utilization of a single cache
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Adds      uint64
+	Deletes   uint64
+	Evictions uint64
+	// Size is the size of the entries, the number of entries for the
+	// caches that are limited by the number of entries
+	Size    bytesize.ByteSize
+	Entries int
+}
+
+// StatsCache is implemented by the caches that keep their own stats
+type StatsCache interface {
+	Stats() Stats
+}
+
+// NewStats returns the stats of the counters of a cache and its size and
+// number of entries
+func NewStats(counters *metrics.Counters, size bytesize.ByteSize, entries int) Stats {
+	return Stats{
+		Hits:      counters.Hits.Count(),
+		Misses:    counters.Miss.Count(),
+		Adds:      counters.Adds.Count(),
+		Deletes:   counters.Deletes.Count(),
+		Evictions: counters.Evictions.Count(),
+		Size:      size,
+		Entries:   entries,
+	}
+}
@@ -0,0 +1,17 @@
+package cache
+
+// Store is a backing store, such as a database, that a cache sits in front
+// of. Load and Delete return ErrNotFound if the key is not in the store
+type Store interface {
+	Load(key string) (value string, err error)
+	Save(key string, value string) error
+	Delete(key string) error
+}
+
+// BatchStore is implemented by the stores that can save many entries in a
+// single call, such as a single transaction of a database. The entries are
+// saved all together or none of them is
+type BatchStore interface {
+	SaveMulti(entries map[string]string) error
+}
+
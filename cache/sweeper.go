@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultExpirationBatch is the batch of a sweep if the options have none
+const defaultExpirationBatch = 128
+
+// Sweeper runs the sweeps of the expired entries of a cache every
+// expiration tick until it is stopped
+type Sweeper struct {
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// StartSweeper calls sweep every expiration tick of the options with the
+// expiration batch as its limit, and calls it again while it expires the
+// whole batch, so a backlog is reclaimed in the same tick a batch at a
+// time. sweep must lock the cache itself. It returns nil if the options
+// have no expiration tick
+func StartSweeper(o *Options, sweep func(limit int) (count int)) *Sweeper {
+	if o.ExpirationTick <= 0 {
+		return nil
+	}
+	batch := o.ExpirationBatch
+	if batch <= 0 {
+		batch = defaultExpirationBatch
+	}
+	s := &Sweeper{
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run(o.ExpirationTick, batch, sweep)
+	return s
+}
+
+// run sweeps every tick until the sweeper is stopped
+func (s *Sweeper) run(tick time.Duration, batch int, sweep func(limit int) (count int)) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for sweep(batch) == batch {
+				select {
+				case <-s.done:
+					return
+				default:
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Stop stops the sweeps and waits for the running one. It is a no-op on a
+// nil Sweeper
+func (s *Sweeper) Stop() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() {
+		close(s.done)
+		<-s.stopped
+	})
+}
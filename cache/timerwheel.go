@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"time"
+)
+
+const (
+	// wheelBits is the log2 of the number of the slots of a wheel level
+	wheelBits = 6
+	// wheelSlots is the number of the slots of a wheel level
+	wheelSlots = 1 << wheelBits
+	// wheelLevels is the number of the levels, the last one holds the
+	// timers that are more than wheelSlots^(wheelLevels-1) ticks away
+	wheelLevels = 4
+)
+
+// wheelTimer is the position of a scheduled key in the wheel
+type wheelTimer struct {
+	tick  int64
+	level int
+	slot  int
+}
+
+// TimerWheel is a hierarchical timer wheel of the expiration times of the
+// keys. Each level has 64 slots of 64 times the span of the slots of the
+// level below, so scheduling and cancelling a key takes a constant time and
+// a key is cascaded down at most once per level before it is due. The times
+// are rounded up to the tick, so a key is never due before its time. It is
+// not safe for concurrent use, the nil wheel schedules nothing
+type TimerWheel struct {
+	tick    time.Duration
+	current int64
+	timers  map[string]*wheelTimer
+	slots   [wheelLevels][wheelSlots]map[string]struct{}
+	counts  [wheelLevels]int
+	due     map[string]struct{}
+}
+
+// NewTimerWheel returns an empty wheel of the tick that starts at now
+func NewTimerWheel(tick time.Duration, now time.Time) *TimerWheel {
+	w := &TimerWheel{
+		tick:   tick,
+		timers: map[string]*wheelTimer{},
+		due:    map[string]struct{}{},
+	}
+	w.current = w.ticks(now, false)
+	return w
+}
+
+// ticks returns the number of the ticks since the epoch at t rounded down,
+// or up if ceil is set
+func (w *TimerWheel) ticks(t time.Time, ceil bool) int64 {
+	n := t.UnixNano()
+	ticks := n / int64(w.tick)
+	if ceil && n%int64(w.tick) > 0 {
+		ticks++
+	}
+	return ticks
+}
+
+// Len returns the number of the scheduled keys
+func (w *TimerWheel) Len() int {
+	if w == nil {
+		return 0
+	}
+	return len(w.timers)
+}
+
+// Schedule sets the key to be due at expires, replacing its previous time.
+// A zero expires cancels the key
+func (w *TimerWheel) Schedule(key string, expires time.Time) {
+	if w == nil {
+		return
+	}
+	w.Cancel(key)
+	if expires.IsZero() {
+		return
+	}
+	timer := &wheelTimer{tick: w.ticks(expires, true)}
+	w.timers[key] = timer
+	w.place(key, timer)
+}
+
+// Cancel removes the key from the wheel
+func (w *TimerWheel) Cancel(key string) {
+	if w == nil {
+		return
+	}
+	timer, ok := w.timers[key]
+	if !ok {
+		return
+	}
+	delete(w.timers, key)
+	if timer.level < 0 {
+		delete(w.due, key)
+	} else {
+		delete(w.slots[timer.level][timer.slot], key)
+		w.counts[timer.level]--
+	}
+}
+
+// place puts the timer in the lowest level that spans its tick, or in the
+// due keys if its tick has passed
+func (w *TimerWheel) place(key string, timer *wheelTimer) {
+	delta := timer.tick - w.current
+	if delta <= 0 {
+		timer.level = -1
+		w.due[key] = struct{}{}
+		return
+	}
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	slot := int(timer.tick>>(wheelBits*level)) & (wheelSlots - 1)
+	timer.level, timer.slot = level, slot
+	if w.slots[level][slot] == nil {
+		w.slots[level][slot] = map[string]struct{}{}
+	}
+	w.slots[level][slot][key] = struct{}{}
+	w.counts[level]++
+}
+
+// Advance moves the wheel to now and removes and returns up to limit of
+// the keys that are due, the rest stay due for the next call. A limit that
+// is not positive returns all of them. The ticks at which no slot can
+// cascade are skipped, so an idle wheel catches up in a few steps
+func (w *TimerWheel) Advance(now time.Time, limit int) (keys []string) {
+	if w == nil {
+		return nil
+	}
+	target := w.ticks(now, false)
+	for w.current < target {
+		lowest := 0
+		for lowest < wheelLevels && w.counts[lowest] == 0 {
+			lowest++
+		}
+		if lowest == wheelLevels {
+			w.current = target
+			break
+		}
+		span := int64(1) << (wheelBits * lowest)
+		next := (w.current/span + 1) * span
+		if next > target {
+			w.current = target
+			break
+		}
+		w.current = next
+		for level := wheelLevels - 1; level >= 0; level-- {
+			if w.current&(1<<(wheelBits*level)-1) != 0 {
+				continue
+			}
+			slot := int(w.current>>(wheelBits*level)) & (wheelSlots - 1)
+			keys := w.slots[level][slot]
+			w.slots[level][slot] = nil
+			w.counts[level] -= len(keys)
+			for key := range keys {
+				w.place(key, w.timers[key])
+			}
+		}
+	}
+	for key := range w.due {
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		keys = append(keys, key)
+		delete(w.due, key)
+		delete(w.timers, key)
+	}
+	return keys
+}
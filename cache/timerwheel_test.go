@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTimerWheel_Advance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	w := NewTimerWheel(time.Second, start)
+	w.Schedule("a", start.Add(1500*time.Millisecond))
+	w.Schedule("b", start.Add(90*time.Second))
+	w.Schedule("c", start.Add(5*time.Hour))
+	w.Schedule("d", start.Add(time.Second))
+	w.Schedule("e", start.Add(time.Minute))
+	w.Cancel("e")
+	w.Schedule("f", time.Time{})
+
+	if keys := w.Advance(start.Add(999*time.Millisecond), 0); len(keys) != 0 {
+		t.Errorf("Expected no due keys but got %v", keys)
+	}
+	if keys := w.Advance(start.Add(time.Second), 0); len(keys) != 1 || keys[0] != "d" {
+		t.Errorf("Expected d but got %v", keys)
+	}
+	if keys := w.Advance(start.Add(89*time.Second), 0); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Expected a but got %v", keys)
+	}
+	if keys := w.Advance(start.Add(90*time.Second), 0); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("Expected b but got %v", keys)
+	}
+	if keys := w.Advance(start.Add(5*time.Hour-time.Second), 0); len(keys) != 0 {
+		t.Errorf("Expected no due keys but got %v", keys)
+	}
+	if keys := w.Advance(start.Add(5*time.Hour), 0); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("Expected c but got %v", keys)
+	}
+	if w.Len() != 0 {
+		t.Errorf("Expected an empty wheel but got %d keys", w.Len())
+	}
+}
+
+func TestTimerWheel_Limit(t *testing.T) {
+	start := time.Unix(1000, 0)
+	w := NewTimerWheel(time.Millisecond, start)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		w.Schedule(key, start.Add(time.Second))
+	}
+	w.Schedule("c", start.Add(time.Hour))
+	var keys []string
+	for batch := w.Advance(start.Add(2*time.Second), 2); len(batch) > 0; batch = w.Advance(start.Add(2*time.Second), 2) {
+		if len(batch) > 2 {
+			t.Errorf("Expected at most 2 keys but got %v", batch)
+		}
+		keys = append(keys, batch...)
+	}
+	sort.Strings(keys)
+	if len(keys) != 4 || keys[0] != "a" || keys[1] != "b" || keys[2] != "d" || keys[3] != "e" {
+		t.Errorf("Expected a, b, d and e but got %v", keys)
+	}
+	if w.Len() != 1 {
+		t.Errorf("Expected c to be left but got %d keys", w.Len())
+	}
+}
+
+func TestTimerWheel_Far(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := NewTimerWheel(time.Millisecond, start)
+	far := start.Add(500 * time.Hour)
+	w.Schedule("a", far)
+	if keys := w.Advance(far.Add(-time.Millisecond), 0); len(keys) != 0 {
+		t.Errorf("Expected no due keys but got %v", keys)
+	}
+	if keys := w.Advance(far, 0); len(keys) != 1 {
+		t.Errorf("Expected a but got %v", keys)
+	}
+}
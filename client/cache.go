@@ -0,0 +1,64 @@
+package client
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	log "github.com/sirupsen/logrus"
+)
+
+// Cache is a cache over the nodes of a Ring, so a dataset larger than the
+// memory of a node is sharded across the nodes instead of being replicated
+// to all of them. It can be used wherever a local cache is, an error of the
+// transport is logged and counts as a miss
+type Cache struct {
+	ring *Ring
+}
+
+var _ cache.UnImplementedCache = (*Cache)(nil)
+
+// NewCache Cache constructor, replicas is the number of virtual nodes of
+// each node, DefaultReplicas if it is not positive
+func NewCache(transport Transport, replicas int, nodes ...string) *Cache {
+	return &Cache{ring: NewRing(transport, replicas, nodes...)}
+}
+
+// Ring returns the ring of the nodes, the nodes can be added and removed
+// while the cache is in use
+func (c *Cache) Ring() *Ring {
+	return c.ring
+}
+
+// Get returns the value for the key from its node
+func (c *Cache) Get(key string) (value string, ok bool) {
+	value, ok, err := c.ring.Get(key)
+	if err != nil {
+		log.Errorf("Cannot get %s from the ring %v", key, err)
+		return "", false
+	}
+	return value, ok
+}
+
+// Put updates or inserts the entry on the node of the key
+func (c *Cache) Put(key string, value string) (created bool) {
+	created, err := c.ring.Put(key, value)
+	if err != nil {
+		log.Errorf("Cannot put %s on the ring %v", key, err)
+		return false
+	}
+	return created
+}
+
+// Delete deletes the key from its node
+func (c *Cache) Delete(key string) (ok bool) {
+	ok, err := c.ring.Delete(key)
+	if err != nil {
+		log.Errorf("Cannot delete %s from the ring %v", key, err)
+		return false
+	}
+	return ok
+}
+
+// Close does nothing, the nodes own their caches and the cache stays usable
+// after it is closed
+func (c *Cache) Close() error {
+	return nil
+}
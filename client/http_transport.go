@@ -0,0 +1,82 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPTransport sends the cache operations to the HTTP frontend of the
+// nodes, a node is the address of its HTTP server, e.g. 127.0.0.1:8080
+type HTTPTransport struct {
+	Client *http.Client
+	// Scheme is http or https
+	Scheme string
+}
+
+// NewHTTPTransport HTTPTransport constructor with the default HTTP client
+func NewHTTPTransport() *HTTPTransport {
+	return &HTTPTransport{Client: http.DefaultClient, Scheme: "http"}
+}
+
+func (t *HTTPTransport) do(method, node, key, value string) (*http.Response, []byte, error) {
+	u := t.Scheme + "://" + node + "/cache/" + url.PathEscape(key)
+	request, err := http.NewRequest(method, u, strings.NewReader(value))
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := t.Client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	return response, body, err
+}
+
+// Get returns the value for the key from the node
+func (t *HTTPTransport) Get(node string, key string) (value string, ok bool, err error) {
+	response, body, err := t.do(http.MethodGet, node, key, "")
+	if err != nil {
+		return "", false, err
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return string(body), true, nil
+	case http.StatusNotFound:
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("get %s from %s: %s", key, node, response.Status)
+}
+
+// Put updates or inserts the entry on the node
+func (t *HTTPTransport) Put(node string, key string, value string) (created bool, err error) {
+	response, _, err := t.do(http.MethodPut, node, key, value)
+	if err != nil {
+		return false, err
+	}
+	switch response.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	}
+	return false, fmt.Errorf("put %s to %s: %s", key, node, response.Status)
+}
+
+// Delete deletes the key from the node
+func (t *HTTPTransport) Delete(node string, key string) (ok bool, err error) {
+	response, _, err := t.do(http.MethodDelete, node, key, "")
+	if err != nil {
+		return false, err
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("delete %s from %s: %s", key, node, response.Status)
+}
@@ -0,0 +1,64 @@
+// Package client implements a client that shards the keys across several
+// Gerdu nodes by consistent hashing
+package client
+
+import (
+	"errors"
+	"github.com/arazmj/gerdu/ring"
+)
+
+// DefaultReplicas is the default number of virtual nodes of each node
+const DefaultReplicas = ring.DefaultReplicas
+
+// ErrNoNodes is returned when the ring has no node to route the key to
+var ErrNoNodes = errors.New("no nodes in the ring")
+
+// Transport sends the cache operations to a node
+type Transport interface {
+	Get(node string, key string) (value string, ok bool, err error)
+	Put(node string, key string, value string) (created bool, err error)
+	Delete(node string, key string) (ok bool, err error)
+}
+
+// Ring sends the cache operations of each key to the node the consistent
+// hash ring maps it to
+type Ring struct {
+	*ring.Ring
+	transport Transport
+}
+
+// NewRing Ring constructor, replicas is the number of virtual nodes of each
+// node, DefaultReplicas if it is not positive
+func NewRing(transport Transport, replicas int, nodes ...string) *Ring {
+	return &Ring{
+		Ring:      ring.New(replicas, nodes...),
+		transport: transport,
+	}
+}
+
+// Get returns the value for the key from its node
+func (r *Ring) Get(key string) (value string, ok bool, err error) {
+	node, ok := r.Node(key)
+	if !ok {
+		return "", false, ErrNoNodes
+	}
+	return r.transport.Get(node, key)
+}
+
+// Put updates or inserts the entry on the node of the key
+func (r *Ring) Put(key string, value string) (created bool, err error) {
+	node, ok := r.Node(key)
+	if !ok {
+		return false, ErrNoNodes
+	}
+	return r.transport.Put(node, key, value)
+}
+
+// Delete deletes the key from its node
+func (r *Ring) Delete(key string) (ok bool, err error) {
+	node, ok := r.Node(key)
+	if !ok {
+		return false, ErrNoNodes
+	}
+	return r.transport.Delete(node, key)
+}
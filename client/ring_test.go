@@ -0,0 +1,141 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockTransport keeps the entries of each node in a map
+type mockTransport struct {
+	sync.Mutex
+	nodes map[string]map[string]string
+}
+
+func newMockTransport() *mockTransport {
+	return &mockTransport{nodes: map[string]map[string]string{}}
+}
+
+func (m *mockTransport) Get(node string, key string) (string, bool, error) {
+	defer m.Unlock()
+	m.Lock()
+	value, ok := m.nodes[node][key]
+	return value, ok, nil
+}
+
+func (m *mockTransport) Put(node string, key string, value string) (bool, error) {
+	defer m.Unlock()
+	m.Lock()
+	if m.nodes[node] == nil {
+		m.nodes[node] = map[string]string{}
+	}
+	_, ok := m.nodes[node][key]
+	m.nodes[node][key] = value
+	return !ok, nil
+}
+
+func (m *mockTransport) Delete(node string, key string) (bool, error) {
+	defer m.Unlock()
+	m.Lock()
+	_, ok := m.nodes[node][key]
+	delete(m.nodes[node], key)
+	return ok, nil
+}
+
+func TestRing_Routing(t *testing.T) {
+	transport := newMockTransport()
+	r := NewRing(transport, 0, "a", "b", "c")
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if created, err := r.Put(key, key); !created || err != nil {
+			t.Fatalf("Expected %s to be created", key)
+		}
+		node, _ := r.Node(key)
+		if transport.nodes[node][key] != key {
+			t.Fatalf("Expected %s to be stored on %s", key, node)
+		}
+		if value, ok, _ := r.Get(key); !ok || value != key {
+			t.Fatalf("Expected %s but got %s", key, value)
+		}
+	}
+	if ok, _ := r.Delete("1"); !ok {
+		t.Errorf("Expected 1 to be deleted")
+	}
+	if _, ok, _ := r.Get("1"); ok {
+		t.Errorf("Expected 1 to be missed")
+	}
+	if _, _, err := NewRing(transport, 0).Get("1"); err != ErrNoNodes {
+		t.Errorf("Expected an empty ring to fail")
+	}
+}
+
+func TestCache(t *testing.T) {
+	transport := newMockTransport()
+	c := NewCache(transport, 0, "a", "b")
+	if !c.Put("1", "1") || c.Put("1", "2") {
+		t.Errorf("Expected 1 to be created and then updated")
+	}
+	node, _ := c.Ring().Node("1")
+	if transport.nodes[node]["1"] != "2" {
+		t.Errorf("Expected 1 to be stored on %s", node)
+	}
+	if value, ok := c.Get("1"); !ok || value != "2" {
+		t.Errorf("Expected 2 but got %s", value)
+	}
+	if !c.Delete("1") || c.Delete("1") {
+		t.Errorf("Expected 1 to be deleted once")
+	}
+	c.Ring().Remove("a")
+	c.Ring().Remove("b")
+	if _, ok := c.Get("1"); ok || c.Put("1", "1") {
+		t.Errorf("Expected an empty ring to miss")
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	entries := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/cache/")
+		_, ok := entries[key]
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			entries[key] = string(body)
+			if !ok {
+				w.WriteHeader(http.StatusCreated)
+			}
+		case http.MethodGet:
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			_, _ = w.Write([]byte(entries[key]))
+		case http.MethodDelete:
+			delete(entries, key)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}
+	}))
+	defer server.Close()
+
+	r := NewRing(NewHTTPTransport(), 0, strings.TrimPrefix(server.URL, "http://"))
+	if created, err := r.Put("a b", "1"); !created || err != nil {
+		t.Fatalf("Expected a b to be created but got %v", err)
+	}
+	if created, _ := r.Put("a b", "2"); created {
+		t.Errorf("Expected a b to be updated")
+	}
+	if value, ok, err := r.Get("a b"); !ok || value != "2" || err != nil {
+		t.Errorf("Expected 2 but got %s %v", value, err)
+	}
+	if ok, _ := r.Delete("a b"); !ok {
+		t.Errorf("Expected a b to be deleted")
+	}
+	if _, ok, err := r.Get("a b"); ok || err != nil {
+		t.Errorf("Expected a b to be missed but got %v", err)
+	}
+}
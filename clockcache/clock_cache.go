@@ -0,0 +1,140 @@
+// Package clockcache implements Clock (second-chance) cache, an approximation
+// of LRU that keeps a reference bit per entry instead of a linked list
+package clockcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/inhies/go-bytesize"
+	"sync"
+	"sync/atomic"
+)
+
+type slot struct {
+	key        string
+	value      string
+	used       bool
+	referenced uint32
+}
+
+// ClockCache data structure, entries live in a ring of slots. Get only sets
+// the reference bit of the slot, the eviction sweeps the clock hand over the
+// ring clearing the reference bits until it finds an unreferenced slot.
+type ClockCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	index    map[string]int
+	slots    []slot
+	free     []int
+	hand     int
+	capacity int
+	counters *metrics.Counters
+}
+
+// NewCache ClockCache constructor, capacity is the maximum number of entries
+func NewCache(capacity int) *ClockCache {
+	return &ClockCache{
+		index:    map[string]int{},
+		slots:    make([]slot, 0, capacity),
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+}
+
+// Get returns the value for the key and gives the entry a second chance
+func (c *ClockCache) Get(key string) (value string, ok bool) {
+	defer c.RUnlock()
+	c.RLock()
+	i, ok := c.index[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	s := &c.slots[i]
+	atomic.StoreUint32(&s.referenced, 1)
+	return s.value, true
+}
+
+// Put updates or inserts a new entry, once the cache is full the
+// entry takes the slot of the first unreferenced entry under the hand
+func (c *ClockCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	if c.capacity <= 0 {
+		return false
+	}
+	if i, ok := c.index[key]; ok {
+		c.slots[i].value = value
+		c.slots[i].referenced = 1
+		return false
+	}
+	c.counters.Adds.Inc()
+	var i int
+	if n := len(c.free); n > 0 {
+		i = c.free[n-1]
+		c.free = c.free[:n-1]
+	} else if len(c.slots) < c.capacity {
+		c.slots = append(c.slots, slot{})
+		i = len(c.slots) - 1
+	} else {
+		i = c.evict()
+	}
+	c.slots[i] = slot{key: key, value: value, used: true}
+	c.index[key] = i
+	return true
+}
+
+// Delete deletes the key and frees its slot
+func (c *ClockCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	i, ok := c.index[key]
+	if !ok {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	delete(c.index, key)
+	c.slots[i] = slot{}
+	c.free = append(c.free, i)
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *ClockCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *ClockCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.index)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *ClockCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, bytesize.ByteSize(len(c.index)), len(c.index))
+}
+
+// evict advances the hand clearing the reference bits until it finds
+// an unreferenced slot, removes its entry and returns the slot index.
+// It is only called when all the slots are in use
+func (c *ClockCache) evict() int {
+	for {
+		s := &c.slots[c.hand]
+		i := c.hand
+		c.hand = (c.hand + 1) % len(c.slots)
+		if s.referenced == 1 {
+			s.referenced = 0
+			continue
+		}
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		delete(c.index, s.key)
+		return i
+	}
+}
@@ -0,0 +1,152 @@
+package clockcache
+
+import (
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestClockCache(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	if value, ok := cache.Get("1"); ok {
+		t.Errorf("Expected value 1 to be evicted but got %s %t", value, ok)
+	}
+	if value, ok := cache.Get("2"); value != "2" || !ok {
+		t.Errorf("Expected value 2 but got %s %t", value, ok)
+	}
+	if value, ok := cache.Get("3"); value != "3" || !ok {
+		t.Errorf("Expected value 3 but got %s %t", value, ok)
+	}
+}
+
+func TestClockCache_SecondChance(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	cache.Get("1")
+
+	// 1 is referenced, the hand clears its bit and evicts 2 instead
+	cache.Put("4", "4")
+	if _, ok := cache.Get("2"); ok {
+		t.Errorf("Expected 2 to be evicted")
+	}
+	if cache.slots[0].referenced != 0 {
+		t.Errorf("Expected the reference bit of 1 to be cleared by the sweep")
+	}
+
+	// 3 is referenced now while 1 has used up its second chance
+	cache.Get("3")
+	cache.Put("5", "5")
+	if _, ok := cache.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted")
+	}
+	for _, key := range []string{"3", "4", "5"} {
+		if value, ok := cache.Get(key); !ok || value != key {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+}
+
+func TestClockCache_AllReferenced(t *testing.T) {
+	cache := NewCache(3)
+	for _, key := range []string{"1", "2", "3"} {
+		cache.Put(key, key)
+		cache.Get(key)
+	}
+
+	// a full sweep clears all the bits and the hand comes back to 1
+	cache.Put("4", "4")
+	if _, ok := cache.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted after a full sweep")
+	}
+	if cache.Len() != 3 {
+		t.Errorf("Expected 3 entries but got %d", cache.Len())
+	}
+}
+
+func TestClockCache_Delete(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	if !cache.Delete("1") || cache.Delete("1") {
+		t.Fatal("Expected the key to be deleted once")
+	}
+	cache.Put("3", "3")
+	if _, ok := cache.Get("2"); !ok {
+		t.Errorf("Expected 2 to survive, the freed slot should be reused")
+	}
+	if _, ok := cache.Get("3"); !ok {
+		t.Errorf("Expected 3 to be inserted")
+	}
+}
+
+func TestClockCache_ZeroCapacity(t *testing.T) {
+	cache := NewCache(0)
+	if cache.Put("1", "1") {
+		t.Errorf("Expected zero capacity cache to reject the put")
+	}
+}
+
+func TestThreadSafety(t *testing.T) {
+	cache := NewCache(100)
+	var wg sync.WaitGroup
+	c := 200
+	wg.Add(c)
+
+	for i := 0; i < c; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < c; j++ {
+				key := strconv.Itoa((i + 1) * j)
+				cache.Put(key, key)
+				value, ok := cache.Get(key)
+				if ok && value != key {
+					t.Errorf("The value is not the same %s", value)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+type getter interface {
+	Get(key string) (value string, ok bool)
+	Put(key string, value string) (created bool)
+}
+
+func benchmarkReadHeavy(b *testing.B, cache getter) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		cache.Put(keys[i], keys[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := keys[r.Intn(len(keys))]
+			if r.Intn(100) == 0 {
+				cache.Put(key, key)
+			} else {
+				cache.Get(key)
+			}
+		}
+	})
+}
+
+func BenchmarkClockCache_ReadHeavy(b *testing.B) {
+	benchmarkReadHeavy(b, NewCache(1000))
+}
+
+func BenchmarkLRUCache_ReadHeavy(b *testing.B) {
+	capacity, _ := bytesize.Parse("1MB")
+	benchmarkReadHeavy(b, lrucache.NewCache(capacity))
+}
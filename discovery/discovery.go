@@ -0,0 +1,168 @@
+// Package discovery keeps the raft configuration and the client hash ring in
+// step with the nodes a membership protocol finds, so the nodes do not need a
+// static list of peers
+package discovery
+
+import (
+	"encoding/json"
+	"github.com/arazmj/gerdu/raftproxy"
+	"github.com/arazmj/gerdu/ring"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+)
+
+var (
+	_ Cluster = (*raftproxy.RaftProxy)(nil)
+	_ Ring    = (*ring.Ring)(nil)
+	_ Syncer  = (*Raft)(nil)
+	_ Syncer  = multiHandler(nil)
+)
+
+// Member is a node found by the membership protocol
+type Member struct {
+	// Name is the unique name of the node, it is its raft node ID
+	Name string `json:"-"`
+	// Raft is the raft bind address of the node
+	Raft string `json:"raft,omitempty"`
+	// API is the base URL of the HTTP API of the node
+	API string `json:"api,omitempty"`
+	// Nonvoter is set for the read replicas
+	Nonvoter bool `json:"nonvoter,omitempty"`
+}
+
+// Meta returns the metadata the node gossips about itself
+func (m Member) Meta() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// ParseMember returns the member of the name and the metadata it gossips
+func ParseMember(name string, meta []byte) (Member, error) {
+	m := Member{Name: name}
+	if len(meta) == 0 {
+		return m, nil
+	}
+	err := json.Unmarshal(meta, &m)
+	return m, err
+}
+
+// Handler is notified of the members that join and leave, graceful is set
+// if the member left on its own and not because it failed
+type Handler interface {
+	Join(m Member)
+	Leave(m Member, graceful bool)
+}
+
+// Syncer is implemented by the handlers that are caught up with all the
+// members from time to time, in case they missed a join
+type Syncer interface {
+	Sync(members []Member)
+}
+
+// Handlers returns a handler that notifies all the handlers in order, it
+// is a Syncer that syncs the handlers that are
+func Handlers(handlers ...Handler) Handler {
+	return multiHandler(handlers)
+}
+
+type multiHandler []Handler
+
+func (hs multiHandler) Join(m Member) {
+	for _, h := range hs {
+		h.Join(m)
+	}
+}
+
+func (hs multiHandler) Leave(m Member, graceful bool) {
+	for _, h := range hs {
+		h.Leave(m, graceful)
+	}
+}
+
+func (hs multiHandler) Sync(members []Member) {
+	for _, h := range hs {
+		if syncer, ok := h.(Syncer); ok {
+			syncer.Sync(members)
+		}
+	}
+}
+
+// Cluster is the raft cluster the members join, it is implemented by
+// raftproxy.RaftProxy
+type Cluster interface {
+	IsLeader() bool
+	Join(nodeID, addr, api string, nonvoter bool) error
+	Leave(nodeID string) error
+}
+
+// Raft adds the members to the raft cluster and removes the ones that
+// leave gracefully. A failed member stays a member so it can come back
+// without losing its log, it has to be removed by an explicit leave. Only
+// the leader changes the configuration, Sync catches a new leader up
+type Raft struct {
+	Cluster Cluster
+}
+
+// Join adds the member to the cluster if this node is the leader
+func (r *Raft) Join(m Member) {
+	if m.Raft == "" || !r.Cluster.IsLeader() {
+		return
+	}
+	if err := r.Cluster.Join(m.Name, m.Raft, m.API, m.Nonvoter); err != nil {
+		log.Errorf("Cannot add the discovered node %s to the cluster %v", m.Name, err)
+	}
+}
+
+// Leave removes the member from the cluster if it left gracefully and this
+// node is the leader
+func (r *Raft) Leave(m Member, graceful bool) {
+	if !graceful || !r.Cluster.IsLeader() {
+		return
+	}
+	if err := r.Cluster.Leave(m.Name); err != nil {
+		log.Errorf("Cannot remove the node %s that left from the cluster %v", m.Name, err)
+	}
+}
+
+// Sync adds all the members to the cluster if this node is the leader, the
+// members that are already in the cluster are left as they are
+func (r *Raft) Sync(members []Member) {
+	for _, m := range members {
+		r.Join(m)
+	}
+}
+
+// Ring is the hash ring of the client, it is implemented by ring.Ring
+type Ring interface {
+	Add(node string)
+	Remove(node string)
+}
+
+// RingNodes adds the HTTP API of the members to the ring and removes it when
+// they leave or fail. The nodes of the ring are the host:port of the API
+// like the ones of client.HTTPTransport
+type RingNodes struct {
+	Ring Ring
+}
+
+// Join adds the member to the ring
+func (r *RingNodes) Join(m Member) {
+	if node := apiHost(m.API); node != "" {
+		r.Ring.Add(node)
+	}
+}
+
+// Leave removes the member from the ring
+func (r *RingNodes) Leave(m Member, graceful bool) {
+	if node := apiHost(m.API); node != "" {
+		r.Ring.Remove(node)
+	}
+}
+
+// apiHost returns the host:port of the API URL, empty if it is not set
+func apiHost(api string) string {
+	u, err := url.Parse(api)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
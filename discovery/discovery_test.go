@@ -0,0 +1,81 @@
+package discovery
+
+import (
+	"github.com/arazmj/gerdu/ring"
+	"strings"
+	"testing"
+)
+
+// mockCluster records the joins and the leaves
+type mockCluster struct {
+	leader bool
+	joined []string
+	left   []string
+}
+
+func (c *mockCluster) IsLeader() bool {
+	return c.leader
+}
+
+func (c *mockCluster) Join(nodeID, addr, api string, nonvoter bool) error {
+	if nonvoter {
+		nodeID += "(nonvoter)"
+	}
+	c.joined = append(c.joined, nodeID+"@"+addr)
+	return nil
+}
+
+func (c *mockCluster) Leave(nodeID string) error {
+	c.left = append(c.left, nodeID)
+	return nil
+}
+
+func TestMember_Meta(t *testing.T) {
+	m := Member{Name: "node1", Raft: "127.0.0.1:12001", API: "http://127.0.0.1:8081", Nonvoter: true}
+	meta, err := m.Meta()
+	if err != nil {
+		t.Fatalf("Failed to encode the meta %v", err)
+	}
+	if parsed, err := ParseMember("node1", meta); err != nil || parsed != m {
+		t.Errorf("Expected %+v but got %+v %v", m, parsed, err)
+	}
+	if parsed, err := ParseMember("node2", nil); err != nil || parsed != (Member{Name: "node2"}) {
+		t.Errorf("Expected a member without meta but got %+v %v", parsed, err)
+	}
+}
+
+func TestRaft(t *testing.T) {
+	cluster := &mockCluster{}
+	r := &Raft{Cluster: cluster}
+	node1 := Member{Name: "node1", Raft: "127.0.0.1:12001"}
+	replica := Member{Name: "replica", Raft: "127.0.0.1:12002", Nonvoter: true}
+	r.Join(node1)
+	if len(cluster.joined) != 0 {
+		t.Errorf("Expected a follower not to change the cluster but got %v", cluster.joined)
+	}
+	cluster.leader = true
+	Handlers(r).(Syncer).Sync([]Member{node1, replica, {Name: "client"}})
+	if joined := strings.Join(cluster.joined, ","); joined != "node1@127.0.0.1:12001,replica(nonvoter)@127.0.0.1:12002" {
+		t.Errorf("Expected node1 and the replica to join but got %s", joined)
+	}
+	r.Leave(node1, false)
+	r.Leave(replica, true)
+	if left := strings.Join(cluster.left, ","); left != "replica" {
+		t.Errorf("Expected only the replica that left gracefully to be removed but got %s", left)
+	}
+}
+
+func TestRingNodes(t *testing.T) {
+	r := ring.New(0)
+	h := Handlers(&RingNodes{Ring: r})
+	h.Join(Member{Name: "node1", API: "http://127.0.0.1:8081"})
+	h.Join(Member{Name: "node2", API: "https://127.0.0.1:8082"})
+	h.Join(Member{Name: "node3"})
+	if nodes := strings.Join(r.Nodes(), ","); nodes != "127.0.0.1:8081,127.0.0.1:8082" {
+		t.Errorf("Expected the API of node1 and node2 but got %s", nodes)
+	}
+	h.Leave(Member{Name: "node1", API: "http://127.0.0.1:8081"}, false)
+	if nodes := strings.Join(r.Nodes(), ","); nodes != "127.0.0.1:8082" {
+		t.Errorf("Expected the failed node1 to be removed but got %s", nodes)
+	}
+}
@@ -0,0 +1,132 @@
+// Package gossip finds the Gerdu nodes and their failures by the gossip of
+// hashicorp/memberlist and notifies a discovery.Handler of them
+package gossip
+
+import (
+	"github.com/arazmj/gerdu/discovery"
+	"github.com/hashicorp/memberlist"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Gossip is the membership of the node in the gossip cluster
+type Gossip struct {
+	list *memberlist.Memberlist
+	done chan struct{}
+}
+
+// delegate gossips the metadata of the node and converts the events of
+// memberlist to the ones of the handler
+type delegate struct {
+	meta    []byte
+	handler discovery.Handler
+}
+
+func (d *delegate) NodeMeta(limit int) []byte {
+	if len(d.meta) > limit {
+		log.Errorf("The gossip metadata of the node is %d bytes, over the limit of %d", len(d.meta), limit)
+		return nil
+	}
+	return d.meta
+}
+
+func (d *delegate) NotifyMsg([]byte)                           {}
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *delegate) LocalState(join bool) []byte                { return nil }
+func (d *delegate) MergeRemoteState(buf []byte, join bool)     {}
+
+func (d *delegate) NotifyJoin(node *memberlist.Node) {
+	if m, ok := member(node); ok {
+		d.handler.Join(m)
+	}
+}
+
+func (d *delegate) NotifyLeave(node *memberlist.Node) {
+	if m, ok := member(node); ok {
+		d.handler.Leave(m, node.State == memberlist.StateLeft)
+	}
+}
+
+// NotifyUpdate joins the member again with its new metadata
+func (d *delegate) NotifyUpdate(node *memberlist.Node) {
+	d.NotifyJoin(node)
+}
+
+// member returns the member of the node, false if its metadata is not valid
+func member(node *memberlist.Node) (discovery.Member, bool) {
+	m, err := discovery.ParseMember(node.Name, node.Meta)
+	if err != nil {
+		log.Errorf("Invalid gossip metadata of node %s %v", node.Name, err)
+		return m, false
+	}
+	return m, true
+}
+
+// Start joins the gossip cluster of the seeds as self and notifies the
+// handler of the members that join and leave, the node itself included.
+// The name of the config is set to the name of self. Without seeds the node
+// starts a new cluster the others can join through it. If the handler is a
+// discovery.Syncer its Sync is called with the members every sync, so a new
+// raft leader adds the members that joined while there was none
+func Start(config *memberlist.Config, self discovery.Member, handler discovery.Handler,
+	sync time.Duration, seeds ...string) (*Gossip, error) {
+	meta, err := self.Meta()
+	if err != nil {
+		return nil, err
+	}
+	d := &delegate{meta: meta, handler: handler}
+	config.Name = self.Name
+	config.Delegate = d
+	config.Events = d
+	list, err := memberlist.Create(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) > 0 {
+		if _, err := list.Join(seeds); err != nil {
+			_ = list.Shutdown()
+			return nil, err
+		}
+	}
+	g := &Gossip{list: list, done: make(chan struct{})}
+	if syncer, ok := handler.(discovery.Syncer); ok && sync > 0 {
+		go g.sync(syncer, sync)
+	}
+	return g, nil
+}
+
+// sync calls Sync of the handler with the members every period until the
+// node leaves
+func (g *Gossip) sync(syncer discovery.Syncer, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			syncer.Sync(g.Members())
+		}
+	}
+}
+
+// Members returns the alive members of the cluster
+func (g *Gossip) Members() []discovery.Member {
+	var members []discovery.Member
+	for _, node := range g.list.Members() {
+		if m, ok := member(node); ok {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// Leave tells the others the node leaves, so they remove it gracefully, and
+// stops the gossip
+func (g *Gossip) Leave(timeout time.Duration) error {
+	close(g.done)
+	if err := g.list.Leave(timeout); err != nil {
+		return err
+	}
+	return g.list.Shutdown()
+}
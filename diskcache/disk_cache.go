@@ -0,0 +1,359 @@
+// Package diskcache implements a log-structured store of the entries on the
+// local disk and a cache that spills the entries evicted from memory to it
+package diskcache
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrClosed is returned when the store is written or compacted after it is
+// closed
+var ErrClosed = errors.New("diskcache: the store is closed")
+
+// ErrTooLarge is returned when an entry is bigger than the capacity
+var ErrTooLarge = errors.New("diskcache: the entry is bigger than the capacity")
+
+// headerSize is the size of the header of a record, the op followed by the
+// lengths of the key and the value
+const headerSize = 9
+
+// the ops of the records
+const (
+	opPut byte = 1
+	opDel byte = 2
+)
+
+// compactSize is the number of the garbage bytes the log can hold before it
+// is compacted, it is compacted once the garbage also outgrows the entries
+const compactSize = 4 << 20
+
+// entry is the location of the value of a key in the log
+type entry struct {
+	key    string
+	offset int64
+	length int
+	size   int64
+	elem   *list.Element
+}
+
+// DiskCache stores the entries in a log on the disk, a put or a delete is
+// appended to the log and an index in memory points each key to its value.
+// Once the entries outgrow the capacity the oldest ones are dropped, and
+// once the overwritten and the deleted records outgrow the entries the log
+// is compacted. The log is replayed when the store is opened, so the entries
+// survive a restart, but it is not synced until Close
+type DiskCache struct {
+	sync.Mutex
+	path        string
+	file        *os.File
+	capacity    int64
+	compactSize int64
+	index       map[string]*entry
+	order       *list.List
+	size        int64
+	live        int64
+	closed      bool
+}
+
+// the store is used as a cache by the spill cache
+var (
+	_ cache.UnImplementedCache = (*DiskCache)(nil)
+	_ cache.Sizer              = (*DiskCache)(nil)
+	_ cache.Closer             = (*DiskCache)(nil)
+)
+
+// NewCache DiskCache constructor, it opens or creates the log at path and
+// holds up to capacity bytes of the records, 0 means no limit
+func NewCache(path string, capacity bytesize.ByteSize) (*DiskCache, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := &DiskCache{
+		path:        path,
+		file:        file,
+		capacity:    int64(capacity),
+		compactSize: compactSize,
+		index:       map[string]*entry{},
+		order:       list.New(),
+	}
+	if err := c.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(c.size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	c.evict()
+	return c, nil
+}
+
+// replay rebuilds the index from the log, a partial record at the end,
+// which a crash in the middle of a write leaves, is truncated
+func (c *DiskCache) replay() error {
+	r := bufio.NewReader(c.file)
+	header := make([]byte, headerSize)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return nil
+		}
+		op, keyLen, valueLen := header[0], binary.BigEndian.Uint32(header[1:]), binary.BigEndian.Uint32(header[5:])
+		var key []byte
+		if err == nil {
+			key = make([]byte, keyLen)
+			_, err = io.ReadFull(r, key)
+		}
+		if err == nil {
+			_, err = r.Discard(int(valueLen))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return c.file.Truncate(c.size)
+		} else if err != nil {
+			return err
+		}
+		size := int64(headerSize) + int64(keyLen) + int64(valueLen)
+		switch op {
+		case opPut:
+			c.add(string(key), c.size+headerSize+int64(keyLen), int(valueLen), size)
+		case opDel:
+			c.remove(string(key))
+		default:
+			return fmt.Errorf("diskcache: unknown op %d at %d of %s", op, c.size, c.path)
+		}
+		c.size += size
+	}
+}
+
+// add points the key to its value in the log as the newest entry, the
+// caller must hold the lock
+func (c *DiskCache) add(key string, offset int64, length int, size int64) (created bool) {
+	created = !c.remove(key)
+	e := &entry{key: key, offset: offset, length: length, size: size}
+	e.elem = c.order.PushBack(e)
+	c.index[key] = e
+	c.live += size
+	return created
+}
+
+// remove drops the key from the index, the caller must hold the lock
+func (c *DiskCache) remove(key string) (ok bool) {
+	e, ok := c.index[key]
+	if !ok {
+		return false
+	}
+	c.order.Remove(e.elem)
+	delete(c.index, key)
+	c.live -= e.size
+	return true
+}
+
+// append writes a record to the end of the log and returns its offset, the
+// caller must hold the lock
+func (c *DiskCache) append(op byte, key, value string) (int64, error) {
+	b := make([]byte, headerSize+len(key)+len(value))
+	b[0] = op
+	binary.BigEndian.PutUint32(b[1:], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[5:], uint32(len(value)))
+	copy(b[headerSize:], key)
+	copy(b[headerSize+len(key):], value)
+	offset := c.size
+	n, err := c.file.Write(b)
+	c.size += int64(n)
+	return offset, err
+}
+
+// Put appends the entry to the log, an entry bigger than the capacity is
+// not stored and returns false like a put to a closed store
+func (c *DiskCache) Put(key string, value string) (created bool) {
+	created, _ = c.put(key, value)
+	return created
+}
+
+// put appends the entry to the log like Put and returns why the entry is
+// not stored
+func (c *DiskCache) put(key string, value string) (created bool, err error) {
+	defer c.Unlock()
+	c.Lock()
+	size := int64(headerSize + len(key) + len(value))
+	if c.closed {
+		return false, ErrClosed
+	}
+	if c.capacity > 0 && size > c.capacity {
+		return false, ErrTooLarge
+	}
+	offset, err := c.append(opPut, key, value)
+	if err != nil {
+		return false, err
+	}
+	created = c.add(key, offset+headerSize+int64(len(key)), len(value), size)
+	c.evict()
+	c.maybeCompact()
+	return created, nil
+}
+
+// Get reads the value of the key from the log
+func (c *DiskCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.index[key]
+	if !ok || c.closed {
+		return "", false
+	}
+	b := make([]byte, e.length)
+	if _, err := c.file.ReadAt(b, e.offset); err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// Delete appends the deletion of the key to the log if it is in the store
+func (c *DiskCache) Delete(key string) (ok bool) {
+	return c.erase(key) == nil
+}
+
+// erase appends the deletion of the key to the log like Delete, it returns
+// cache.ErrNotFound if the key is not in the store
+func (c *DiskCache) erase(key string) error {
+	defer c.Unlock()
+	c.Lock()
+	if c.closed {
+		return ErrClosed
+	}
+	if _, ok := c.index[key]; !ok {
+		return cache.ErrNotFound
+	}
+	if _, err := c.append(opDel, key, ""); err != nil {
+		return err
+	}
+	c.remove(key)
+	c.maybeCompact()
+	return nil
+}
+
+// evict drops the oldest entries until they fit in the capacity, the caller
+// must hold the lock
+func (c *DiskCache) evict() {
+	for c.capacity > 0 && c.live > c.capacity {
+		e := c.order.Front().Value.(*entry)
+		if _, err := c.append(opDel, e.key, ""); err != nil {
+			return
+		}
+		c.remove(e.key)
+	}
+}
+
+// maybeCompact compacts the log once its garbage outgrows both the entries
+// and the compact size, the caller must hold the lock
+func (c *DiskCache) maybeCompact() {
+	garbage := c.size - c.live
+	if garbage >= c.compactSize && garbage >= c.live {
+		_ = c.compact()
+	}
+}
+
+// Len returns the number of the entries
+func (c *DiskCache) Len() int {
+	defer c.Unlock()
+	c.Lock()
+	return len(c.index)
+}
+
+// Size returns the size of the log in bytes
+func (c *DiskCache) Size() int64 {
+	defer c.Unlock()
+	c.Lock()
+	return c.size
+}
+
+// Compact rewrites the log to the entries in their order and renames it
+// over the log, the store is locked while it is rewritten
+func (c *DiskCache) Compact() error {
+	defer c.Unlock()
+	c.Lock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.compact()
+}
+
+// compact rewrites the log, the caller must hold the lock
+func (c *DiskCache) compact() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	offsets, size, err := c.write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	c.file.Close()
+	c.file = tmp
+	c.size = size
+	for e, offset := range offsets {
+		e.offset = offset
+	}
+	return nil
+}
+
+// write writes a put record of each entry to w and returns the offsets of
+// their values and the size of the records
+func (c *DiskCache) write(w io.Writer) (map[*entry]int64, int64, error) {
+	bw := bufio.NewWriter(w)
+	offsets := make(map[*entry]int64, len(c.index))
+	var size int64
+	header := make([]byte, headerSize)
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*entry)
+		value := make([]byte, e.length)
+		if _, err := c.file.ReadAt(value, e.offset); err != nil {
+			return nil, 0, err
+		}
+		header[0] = opPut
+		binary.BigEndian.PutUint32(header[1:], uint32(len(e.key)))
+		binary.BigEndian.PutUint32(header[5:], uint32(e.length))
+		for _, b := range [][]byte{header, []byte(e.key), value} {
+			if _, err := bw.Write(b); err != nil {
+				return nil, 0, err
+			}
+		}
+		offsets[e] = size + headerSize + int64(len(e.key))
+		size += e.size
+	}
+	return offsets, size, bw.Flush()
+}
+
+// Close syncs and closes the log, it is idempotent
+func (c *DiskCache) Close() error {
+	defer c.Unlock()
+	c.Lock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.file.Sync()
+	if e := c.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package diskcache
+
+import (
+	"github.com/inhies/go-bytesize"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func open(t *testing.T, path string, capacity bytesize.ByteSize) *DiskCache {
+	t.Helper()
+	c, err := NewCache(path, capacity)
+	if err != nil {
+		t.Fatalf("Cannot open %s %v", path, err)
+	}
+	return c
+}
+
+func TestDiskCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.log")
+	c := open(t, path, 0)
+	if !c.Put("1", "one") || !c.Put("2", "two") || c.Put("1", "uno") {
+		t.Errorf("Expected only the first puts of the keys to create them")
+	}
+	if value, ok := c.Get("1"); !ok || value != "uno" {
+		t.Errorf("Expected uno but got %s", value)
+	}
+	if !c.Delete("2") || c.Delete("2") {
+		t.Errorf("Expected 2 to be deleted once")
+	}
+	if _, ok := c.Get("2"); ok || c.Len() != 1 {
+		t.Errorf("Expected a single entry but got %d", c.Len())
+	}
+	c.Put("3", "")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if c.Put("4", "four") {
+		t.Errorf("Expected a put after Close to be ignored")
+	}
+
+	c = open(t, path, 0)
+	defer c.Close()
+	for key, want := range map[string]string{"1": "uno", "3": ""} {
+		if value, ok := c.Get(key); !ok || value != want {
+			t.Errorf("Expected %q for %s after the replay but got %q", want, key, value)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries after the replay but got %d", c.Len())
+	}
+}
+
+func TestDiskCache_Capacity(t *testing.T) {
+	// each record is the header, a key of a byte and a value of 3 bytes
+	c := open(t, filepath.Join(t.TempDir(), "spill.log"), 3*(headerSize+4))
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		c.Put(strconv.Itoa(i), "xxx")
+	}
+	for key, expected := range map[string]bool{"0": false, "1": false, "2": true, "3": true, "4": true} {
+		if _, ok := c.Get(key); ok != expected {
+			t.Errorf("Expected %s to be in the store %v", key, expected)
+		}
+	}
+	if c.Put("5", string(make([]byte, 100))) {
+		t.Errorf("Expected an entry bigger than the capacity to be rejected")
+	}
+	if c.Len() != 3 {
+		t.Errorf("Expected 3 entries but got %d", c.Len())
+	}
+}
+
+func TestDiskCache_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.log")
+	c := open(t, path, 0)
+	c.compactSize = 1 << 10
+	for i := 0; i < 1000; i++ {
+		c.Put(strconv.Itoa(i%3), strconv.Itoa(i))
+	}
+	if size := c.Size(); size > 2<<10 {
+		t.Errorf("Expected the log to be compacted but it has %d bytes", size)
+	}
+	c.Delete("0")
+	if err := c.Compact(); err != nil {
+		t.Fatalf("Compact failed %v", err)
+	}
+	if size := c.Size(); size != 2*(headerSize+1+3) {
+		t.Errorf("Expected the 2 entries only but got %d bytes", size)
+	}
+	c.Put("3", "three")
+	c.Close()
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(matches) != 0 {
+		t.Errorf("Expected no temporary files but got %v", matches)
+	}
+	if err := c.Compact(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed but got %v", err)
+	}
+
+	c = open(t, path, 0)
+	defer c.Close()
+	for key, want := range map[string]string{"1": "997", "2": "998", "3": "three"} {
+		if value, ok := c.Get(key); !ok || value != want {
+			t.Errorf("Expected %s for %s after the compaction but got %s", want, key, value)
+		}
+	}
+}
+
+func TestDiskCache_PartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.log")
+	c := open(t, path, 0)
+	c.Put("1", "one")
+	c.Put("2", "two")
+	c.Close()
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	c = open(t, path, 0)
+	if _, ok := c.Get("2"); ok || c.Len() != 1 {
+		t.Errorf("Expected the partial record to be dropped")
+	}
+	c.Put("3", "three")
+	c.Close()
+	c = open(t, path, 0)
+	defer c.Close()
+	if value, ok := c.Get("3"); !ok || value != "three" || c.Len() != 2 {
+		t.Errorf("Expected the records after the truncation to be replayed")
+	}
+}
+
+func TestDiskCache_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.log")
+	if err := ioutil.WriteFile(path, []byte{7, 0, 0, 0, 1, 0, 0, 0, 0, 'k'}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCache(path, 0); err == nil {
+		t.Errorf("Expected an unknown op to be rejected")
+	}
+}
@@ -0,0 +1,134 @@
+package diskcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"sync"
+	"sync/atomic"
+)
+
+// SpillCache is a cache in memory whose entries evicted for the capacity are
+// spilled to a DiskCache instead of being lost. A miss in memory is read
+// back from the disk and the entry is moved back to memory, so a working set
+// larger than the memory is served by slower reads rather than misses. The
+// expired and the deleted entries are not spilled, and a spilled entry
+// loses its time to live
+type SpillCache struct {
+	sync.Mutex
+	memory     cache.UnImplementedCache
+	disk       *DiskCache
+	memoryHits uint64
+	diskHits   uint64
+	misses     uint64
+	spills     uint64
+}
+
+// the cache must satisfy the interfaces used by the servers
+var (
+	_ cache.UnImplementedCache = (*SpillCache)(nil)
+	_ cache.Sizer              = (*SpillCache)(nil)
+	_ cache.Closer             = (*SpillCache)(nil)
+)
+
+// Stats are the counters and the number of entries of memory and the disk
+type Stats struct {
+	MemoryHits uint64
+	DiskHits   uint64
+	Misses     uint64
+	Spills     uint64
+	MemoryLen  int
+	DiskLen    int
+}
+
+// NewSpillCache wraps the cache newMemory creates and the disk store,
+// newMemory must pass the option that spills the evicted entries to the cache
+func NewSpillCache(newMemory func(spill cache.Option) cache.UnImplementedCache, disk *DiskCache) *SpillCache {
+	c := &SpillCache{disk: disk}
+	c.memory = newMemory(cache.WithEvictionListener(cache.EvictionListenerFunc(c.spill)))
+	return c
+}
+
+// spill writes an entry evicted from memory to the disk, it is called while
+// the memory is locked so it must not call the memory
+func (c *SpillCache) spill(key, value string, reason cache.EvictionReason) {
+	if reason != cache.EvictionCapacity {
+		return
+	}
+	if c.disk.Put(key, value) {
+		atomic.AddUint64(&c.spills, 1)
+	}
+}
+
+// Get reads the memory first, an entry found on the disk is moved back to
+// memory and may spill the others in its place
+func (c *SpillCache) Get(key string) (value string, ok bool) {
+	if value, ok = c.memory.Get(key); ok {
+		atomic.AddUint64(&c.memoryHits, 1)
+		return value, true
+	}
+	defer c.Unlock()
+	c.Lock()
+	// another Get may have moved the entry back in the meantime
+	if value, ok = c.memory.Get(key); ok {
+		atomic.AddUint64(&c.memoryHits, 1)
+		return value, true
+	}
+	if value, ok = c.disk.Get(key); ok {
+		atomic.AddUint64(&c.diskHits, 1)
+		c.memory.Put(key, value)
+		c.disk.Delete(key)
+		return value, true
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return "", false
+}
+
+// Put drops the stale copy of the disk if there is any and writes the entry
+// to memory
+func (c *SpillCache) Put(key, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	stale := c.disk.Delete(key)
+	return c.memory.Put(key, value) && !stale
+}
+
+// Delete deletes the key from memory and the disk
+func (c *SpillCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	ok1 := c.memory.Delete(key)
+	ok2 := c.disk.Delete(key)
+	return ok1 || ok2
+}
+
+// Len returns the number of the entries in memory and on the disk
+func (c *SpillCache) Len() int {
+	return memoryLen(c.memory) + c.disk.Len()
+}
+
+// Stats returns the counters and the number of entries of memory and the disk
+func (c *SpillCache) Stats() Stats {
+	return Stats{
+		MemoryHits: atomic.LoadUint64(&c.memoryHits),
+		DiskHits:   atomic.LoadUint64(&c.diskHits),
+		Misses:     atomic.LoadUint64(&c.misses),
+		Spills:     atomic.LoadUint64(&c.spills),
+		MemoryLen:  memoryLen(c.memory),
+		DiskLen:    c.disk.Len(),
+	}
+}
+
+// Close closes the memory and then the disk and returns the first error
+func (c *SpillCache) Close() error {
+	err := cache.Close(c.memory)
+	if e := c.disk.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func memoryLen(memory cache.UnImplementedCache) int {
+	if sizer, ok := memory.(cache.Sizer); ok {
+		return sizer.Len()
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package diskcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSpillCache(t *testing.T, opts ...cache.Option) *SpillCache {
+	t.Helper()
+	disk := open(t, filepath.Join(t.TempDir(), "spill.log"), 0)
+	return NewSpillCache(func(spill cache.Option) cache.UnImplementedCache {
+		return lrucache.NewCache(1<<20, append(opts, cache.WithMaxEntries(2), spill)...)
+	}, disk)
+}
+
+func TestSpillCache(t *testing.T) {
+	c := newSpillCache(t)
+	defer c.Close()
+	for i := 1; i <= 4; i++ {
+		c.Put(strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	if stats := c.Stats(); stats.Spills != 2 || stats.MemoryLen != 2 || stats.DiskLen != 2 {
+		t.Errorf("Expected 1 and 2 to be spilled but got %+v", stats)
+	}
+	if c.Len() != 4 {
+		t.Errorf("Expected 4 entries but got %d", c.Len())
+	}
+
+	// 1 is moved back to memory and spills the least recently used 3
+	if value, ok := c.Get("1"); !ok || value != "v1" {
+		t.Errorf("Expected v1 from the disk but got %s", value)
+	}
+	if _, ok := c.disk.Get("1"); ok {
+		t.Errorf("Expected 1 to be moved off the disk")
+	}
+	if value, ok := c.disk.Get("3"); !ok || value != "v3" {
+		t.Errorf("Expected 3 to be spilled in place of 1")
+	}
+	if _, ok := c.Get("5"); ok {
+		t.Errorf("Expected a miss")
+	}
+	if stats := c.Stats(); stats.DiskHits != 1 || stats.Misses != 1 || stats.Spills != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	if c.Put("2", "two") {
+		t.Errorf("Expected the spilled 2 to be updated and not created")
+	}
+	if value, ok := c.Get("2"); !ok || value != "two" {
+		t.Errorf("Expected the stale copy of 2 on the disk to be dropped but got %s", value)
+	}
+	if !c.Delete("3") || c.Delete("3") {
+		t.Errorf("Expected the spilled 3 to be deleted once")
+	}
+	if _, ok := c.Get("3"); ok {
+		t.Errorf("Expected 3 to be deleted")
+	}
+}
+
+func TestSpillCache_Expired(t *testing.T) {
+	now := time.Now()
+	c := newSpillCache(t, cache.WithDefaultTTL(time.Minute), cache.WithClock(func() time.Time { return now }))
+	defer c.Close()
+	c.Put("1", "one")
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to expire")
+	}
+	if c.Stats().Spills != 0 || c.disk.Len() != 0 {
+		t.Errorf("Expected the expired entry not to be spilled")
+	}
+}
+
+func TestSpillCache_Concurrent(t *testing.T) {
+	c := newSpillCache(t)
+	defer c.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := strconv.Itoa((g + i) % 10)
+				c.Put(key, key)
+				if value, ok := c.Get(key); ok && value != key {
+					t.Errorf("Expected %s but got %s", key, value)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		key := strconv.Itoa(i)
+		if value, ok := c.Get(key); !ok || value != key {
+			t.Errorf("Expected %s to be in memory or on the disk", key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package diskcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/inhies/go-bytesize"
+)
+
+// Store is a backing store of the entries in a DiskCache, so a
+// write-through or a write-behind cache persists its writes to the local
+// disk and loads them back after a restart
+type Store struct {
+	disk *DiskCache
+}
+
+var _ cache.Store = (*Store)(nil)
+
+// NewStore Store constructor, it opens or creates the log at path like
+// NewCache
+func NewStore(path string, capacity bytesize.ByteSize) (*Store, error) {
+	disk, err := NewCache(path, capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{disk: disk}, nil
+}
+
+// Load returns the value of the key, cache.ErrNotFound if it is not in the
+// store
+func (s *Store) Load(key string) (value string, err error) {
+	value, ok := s.disk.Get(key)
+	if !ok {
+		return "", cache.ErrNotFound
+	}
+	return value, nil
+}
+
+// Save appends the entry to the log
+func (s *Store) Save(key string, value string) error {
+	_, err := s.disk.put(key, value)
+	return err
+}
+
+// Delete appends the deletion of the key to the log, it returns
+// cache.ErrNotFound if the key is not in the store
+func (s *Store) Delete(key string) error {
+	return s.disk.erase(key)
+}
+
+// Close syncs and closes the log
+func (s *Store) Close() error {
+	return s.disk.Close()
+}
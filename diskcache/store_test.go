@@ -0,0 +1,45 @@
+package diskcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s, err := NewStore(path, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("1", "one"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("2", "a value bigger than the capacity"); err != ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge but got %v", err)
+	}
+	if err := s.Delete("2"); err != cache.ErrNotFound {
+		t.Errorf("Expected ErrNotFound but got %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if err := s.Save("3", "three"); err != ErrClosed {
+		t.Errorf("Expected ErrClosed but got %v", err)
+	}
+
+	s, err = NewStore(path, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if value, err := s.Load("1"); err != nil || value != "one" {
+		t.Errorf("Expected one to be loaded after a restart but got %s %v", value, err)
+	}
+	if err := s.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("1"); err != cache.ErrNotFound {
+		t.Errorf("Expected ErrNotFound but got %v", err)
+	}
+}
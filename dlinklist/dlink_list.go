@@ -1,13 +1,31 @@
 // Package dlinklist implements a doubly linked list as backing data structure for cache operations
 package dlinklist
 
+import "time"
+
 // Node data structure
 type Node struct {
-	next  *Node
-	prev  *Node
-	Key   string
-	Value string
-	Freq  int
+	next    *Node
+	prev    *Node
+	Key     string
+	Value   string
+	Freq    int
+	Size    int64
+	Expires time.Time
+	TTL     time.Duration
+	Created time.Time
+	Touched time.Time
+	// Version is the token of the value, it changes on every update
+	Version uint64
+	// Hits and Seen are the hits and the time of the last hit that are
+	// recorded under a read lock, they are updated atomically
+	Hits int32
+	Seen int64
+}
+
+// Expired reports whether the node has an expiration time that is passed
+func (n *Node) Expired(now time.Time) bool {
+	return !n.Expires.IsZero() && !now.Before(n.Expires)
 }
 
 // DLinkedList data structure
@@ -48,13 +66,51 @@ func (c *DLinkedList) RemoveNode(node *Node) {
 	c.size--
 }
 
-// PopTail pops a node from the beginning of the linked list
+// PopTail pops a node from the beginning of the linked list, nil if the linked list is empty
 func (c *DLinkedList) PopTail() *Node {
+	if c.size == 0 {
+		return nil
+	}
 	prev := c.tail.prev
 	c.RemoveNode(prev)
 	return prev
 }
 
+// Head returns the first node without removing it, nil if the linked list is empty
+func (c *DLinkedList) Head() *Node {
+	if c.size == 0 {
+		return nil
+	}
+	return c.head.next
+}
+
+// Tail returns the last node without removing it, nil if the linked list is empty
+func (c *DLinkedList) Tail() *Node {
+	if c.size == 0 {
+		return nil
+	}
+	return c.tail.prev
+}
+
+// Walk calls fn for the nodes from the tail to the head of the linked list,
+// fn must not add or remove the nodes
+func (c *DLinkedList) Walk(fn func(node *Node)) {
+	for node := c.tail.prev; node != c.head; node = node.prev {
+		fn(node)
+	}
+}
+
+// Find returns the first node from the tail to the head for which fn
+// returns true, nil if there is none
+func (c *DLinkedList) Find(fn func(node *Node) bool) *Node {
+	for node := c.tail.prev; node != c.head; node = node.prev {
+		if fn(node) {
+			return node
+		}
+	}
+	return nil
+}
+
 //Size returns the size of link list
 func (c *DLinkedList) Size() int {
 	return c.size
@@ -0,0 +1,21 @@
+package dlinklist
+
+import "testing"
+
+func TestDLinkedList_PopTail(t *testing.T) {
+	list := NewLinkedList()
+	if node := list.PopTail(); node != nil || list.Size() != 0 {
+		t.Fatalf("Expected nil from an empty list")
+	}
+	list.AddNode(&Node{Key: "1"})
+	list.AddNode(&Node{Key: "2"})
+	if node := list.PopTail(); node == nil || node.Key != "1" {
+		t.Errorf("Expected 1 to be popped")
+	}
+	if node := list.PopTail(); node == nil || node.Key != "2" {
+		t.Errorf("Expected 2 to be popped")
+	}
+	if node := list.PopTail(); node != nil || list.Head() != nil || list.Tail() != nil {
+		t.Errorf("Expected the list to be empty")
+	}
+}
@@ -0,0 +1,232 @@
+// Package fifocache implements a cache that evicts the oldest entries first,
+// it is a cheap baseline to compare the other eviction policies with
+package fifocache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+// FIFOCache data structure, the entries are linked in the order they are
+// inserted and neither a hit nor an update moves them, so a Get only takes
+// the read lock
+type FIFOCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	node     map[string]*dlinklist.Node
+	linklist *dlinklist.DLinkedList
+	capacity bytesize.ByteSize
+	size     bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache FIFOCache constructor
+func NewCache(capacity bytesize.ByteSize) *FIFOCache {
+	return &FIFOCache{
+		node:     map[string]*dlinklist.Node{},
+		linklist: dlinklist.NewLinkedList(),
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+}
+
+// cost sizes an entry by the length of its value, at least a byte
+func cost(value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+// Get returns the value for the key, nothing is reordered on a hit
+func (c *FIFOCache) Get(key string) (value string, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	node, ok := c.node[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	return node.Value, true
+}
+
+// Put updates or inserts a new entry, an update keeps the place of the
+// entry. The oldest entries are evicted while the size is over the capacity
+// and an entry larger than the capacity is rejected
+func (c *FIFOCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	size := cost(value)
+	if size > c.capacity {
+		return false
+	}
+	node, ok := c.node[key]
+	if ok {
+		c.size += size - bytesize.ByteSize(node.Size)
+	} else {
+		c.counters.Adds.Inc()
+		node = &dlinklist.Node{Key: key}
+		c.node[key] = node
+		c.linklist.AddNode(node)
+		c.size += size
+	}
+	node.Value = value
+	node.Size = int64(size)
+	for c.size > c.capacity {
+		tail := c.linklist.Tail()
+		if tail == node {
+			// the updated entry is the oldest, evict the next one
+			tail = c.linklist.Find(func(n *dlinklist.Node) bool { return n != node })
+		}
+		if tail == nil {
+			metrics.Anomalies.Inc()
+			break
+		}
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		c.remove(tail)
+	}
+	return !ok
+}
+
+// Resize changes the capacity of the cache, a smaller capacity evicts the
+// oldest entries in batches of cache.ResizeBatch
+func (c *FIFOCache) Resize(capacity bytesize.ByteSize) error {
+	c.Lock()
+	c.capacity = capacity
+	c.Unlock()
+	for more := true; more; {
+		c.Lock()
+		for n := 0; n < cache.ResizeBatch && c.size > c.capacity; n++ {
+			c.counters.Deletes.Inc()
+			c.counters.Evictions.Inc()
+			c.remove(c.linklist.Tail())
+		}
+		more = c.size > c.capacity
+		c.Unlock()
+	}
+	return nil
+}
+
+// remove unlinks the node and reclaims its size
+func (c *FIFOCache) remove(node *dlinklist.Node) {
+	c.linklist.RemoveNode(node)
+	delete(c.node, node.Key)
+	c.size -= bytesize.ByteSize(node.Size)
+}
+
+// Delete deletes the key from the cache
+func (c *FIFOCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	node, ok := c.node[key]
+	if !ok {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	c.remove(node)
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *FIFOCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *FIFOCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.node)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *FIFOCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.size, len(c.node))
+}
+
+func (c *FIFOCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := make(map[string]string)
+
+	for k, v := range c.node {
+		o[k] = v.Value
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+// Restore inserts the entries of the snapshot in a single pass under the
+// lock without evicting, the oldest entries are evicted in a single sweep
+// once they are all in
+func (c *FIFOCache) Restore(closer io.ReadCloser) error {
+	// no lock is required according to the Hashicorp docs, it is taken
+	// once so the entries are not locked and evicted one by one
+	defer c.Unlock()
+	c.Lock()
+	before := len(c.node)
+	err := cache.ReadSnapshot(closer, func(k, v string) {
+		size := cost(v)
+		if size > c.capacity {
+			return
+		}
+		node, ok := c.node[k]
+		if ok {
+			c.size += size - bytesize.ByteSize(node.Size)
+		} else {
+			node = &dlinklist.Node{Key: k}
+			c.node[k] = node
+			c.linklist.AddNode(node)
+			c.size += size
+		}
+		node.Value = v
+		node.Size = int64(size)
+	})
+	c.counters.Adds.Add(float64(len(c.node) - before))
+	for c.size > c.capacity {
+		tail := c.linklist.Tail()
+		if tail == nil {
+			metrics.Anomalies.Inc()
+			break
+		}
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		c.remove(tail)
+	}
+	return err
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
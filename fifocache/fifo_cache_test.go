@@ -0,0 +1,119 @@
+package fifocache
+
+import (
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/arazmj/gerdu/randomcache"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFIFOCache(t *testing.T) {
+	c := NewCache(3)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	c.Get("a")
+	c.Put("d", "d")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected the oldest a to be evicted despite its hit")
+	}
+	if c.Put("b", "bb") {
+		t.Errorf("Expected b to be updated")
+	}
+	// b is still the oldest entry so it makes room for its own growth
+	if _, ok := c.Get("c"); ok {
+		t.Errorf("Expected c to be evicted to fit the update of the oldest b")
+	}
+	if value, ok := c.Get("b"); !ok || value != "bb" || c.size != 3 {
+		t.Errorf("Expected bb in a full cache but got %s of %d", value, c.size)
+	}
+	if c.Put("big", "xxxx") {
+		t.Errorf("Expected the oversized entry to be rejected")
+	}
+	if !c.Delete("d") || c.Delete("d") || c.Len() != 1 {
+		t.Errorf("Expected d to be deleted once")
+	}
+}
+
+func TestFIFOCache_Restore(t *testing.T) {
+	c := NewCache(3)
+	c.Put("a", "a")
+	snapshot := `{"b":"b","big":"xxxx","c":"cc"}`
+	if err := c.Restore(ioutil.NopCloser(strings.NewReader(snapshot))); err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	if _, ok := c.Get("big"); ok {
+		t.Errorf("Expected the oversized entry to be rejected")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected the oldest a to be evicted once the snapshot is in")
+	}
+	if c.Len() != 2 || c.size != 3 || c.linklist.Size() != 2 {
+		t.Errorf("Expected 2 entries of size 3 but got %d of %d", c.Len(), c.size)
+	}
+	if stats := c.Stats(); stats.Adds != 3 || stats.Evictions != 1 {
+		t.Errorf("Expected 3 adds and an eviction but got %+v", stats)
+	}
+}
+
+// getPutter is the part of the cache the baseline benchmark uses
+type getPutter interface {
+	Get(key string) (value string, ok bool)
+	Put(key string, value string) (created bool)
+}
+
+// benchmarkUniform accesses the keys uniformly at random, the keyspace
+// is twice the capacity so every policy hits about half of the time
+func benchmarkUniform(b *testing.B, c getPutter) {
+	r := rand.New(rand.NewSource(1))
+	hits := 0
+	for i := 0; i < b.N; i++ {
+		key := strconv.Itoa(r.Intn(2000))
+		if _, ok := c.Get(key); ok {
+			hits++
+		} else {
+			c.Put(key, "x")
+		}
+	}
+	b.ReportMetric(float64(hits)/float64(b.N), "hits/op")
+}
+
+func BenchmarkFIFOCache_Uniform(b *testing.B) {
+	benchmarkUniform(b, NewCache(1000))
+}
+
+func BenchmarkRandomCache_Uniform(b *testing.B) {
+	benchmarkUniform(b, randomcache.NewCacheWithSeed(1000, 1))
+}
+
+func BenchmarkLRUCache_Uniform(b *testing.B) {
+	benchmarkUniform(b, lrucache.NewCache(1000))
+}
+
+func TestFIFOCache_Resize(t *testing.T) {
+	c := NewCache(1000)
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if err := c.Resize(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 || c.size != 10 {
+		t.Errorf("Expected 10 entries of size 10 but got %d entries of size %d", c.Len(), c.size)
+	}
+	if _, ok := c.Get("499"); !ok {
+		t.Errorf("Expected the newest entry to survive the shrink")
+	}
+	if err := c.Resize(1000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if c.Len() != 510 {
+		t.Errorf("Expected the grown cache to keep all the entries but got %d", c.Len())
+	}
+}
@@ -2,13 +2,23 @@ package grpcserver
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"github.com/arazmj/gerdu/auth"
 	"github.com/arazmj/gerdu/cache"
 	"github.com/arazmj/gerdu/proto"
+	"github.com/arazmj/gerdu/ratelimit"
+	"github.com/arazmj/gerdu/tracing"
 	log "github.com/sirupsen/logrus"
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
 	"net"
+	"net/http"
 )
 
 type server struct {
@@ -16,7 +26,15 @@ type server struct {
 	gerdu cache.UnImplementedCache
 }
 
-func grpcServe(s *grpc.Server, host string, gerdu cache.UnImplementedCache) {
+func grpcServe(s *grpc.Server, host string, guard *ratelimit.Guard, gerdu cache.UnImplementedCache) {
+	if err := s.Serve(grpcListen(s, host, guard, gerdu)); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+// grpcListen registers the cache with the server and returns the listener
+// of the host within the limits of the guard
+func grpcListen(s *grpc.Server, host string, guard *ratelimit.Guard, gerdu cache.UnImplementedCache) net.Listener {
 	lis, err := net.Listen("tcp", host)
 	log.Printf("Gerdu started listening gRPC at %s\n", host)
 	if err != nil {
@@ -25,15 +43,13 @@ func grpcServe(s *grpc.Server, host string, gerdu cache.UnImplementedCache) {
 	proto.RegisterGerduServer(s, &server{
 		gerdu: gerdu,
 	})
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
-	}
+	return guard.Listener(lis, nil)
 }
 
 //GrpcServe start gRPC server in non-secure mode
 func GrpcServe(host string, gerdu cache.UnImplementedCache) {
-	s := grpc.NewServer()
-	grpcServe(s, host, gerdu)
+	s := grpc.NewServer(grpc.UnaryInterceptor(traceInterceptor), grpc.StreamInterceptor(traceStreamInterceptor))
+	grpcServe(s, host, nil, gerdu)
 }
 
 //GrpcServeTLS start gRPC server secure
@@ -43,14 +59,245 @@ func GrpcServeTLS(host string, tlsCert, tlsKey string, gerdu cache.UnImplemented
 		log.Fatalf("Failed to setup TLS for gRPC service: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.Creds(credentials))
-	grpcServe(s, host, gerdu)
+	s := grpc.NewServer(grpc.Creds(credentials), grpc.UnaryInterceptor(traceInterceptor),
+		grpc.StreamInterceptor(traceStreamInterceptor))
+	grpcServe(s, host, nil, gerdu)
+}
+
+// GrpcServeAuth starts the gRPC server with the TLS config, plain text if it
+// is nil, that only serves the calls the tokens authorize, all of them if
+// the tokens are nil, within the limits of the guard, none if it is nil
+func GrpcServeAuth(host string, config *tls.Config, tokens auth.Tokens, guard *ratelimit.Guard,
+	gerdu cache.UnImplementedCache) {
+	grpcServe(newServer(config, tokens, guard), host, guard, gerdu)
+}
+
+// GrpcStartAuth starts the gRPC server of GrpcServeAuth in the background, it
+// returns the shutdown of the server that stops accepting the connections
+// and the calls and waits for the calls in flight, the connections are
+// closed once the context is done
+func GrpcStartAuth(host string, config *tls.Config, tokens auth.Tokens, guard *ratelimit.Guard,
+	gerdu cache.UnImplementedCache) (shutdown func(ctx context.Context) error) {
+	s := newServer(config, tokens, guard)
+	lis := grpcListen(s, host, guard, gerdu)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			s.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// newServer returns the gRPC server with the TLS config, the tokens and the
+// guard of GrpcServeAuth
+func newServer(config *tls.Config, tokens auth.Tokens, guard *ratelimit.Guard) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{traceInterceptor}
+	stream := []grpc.StreamServerInterceptor{traceStreamInterceptor}
+	if guard != nil {
+		unary = append(unary, limitInterceptor(guard))
+		stream = append(stream, limitStreamInterceptor(guard))
+	}
+	if tokens != nil {
+		unary = append(unary, authInterceptor(tokens))
+		stream = append(stream, authStreamInterceptor(tokens))
+		if guard != nil {
+			unary = append(unary, tokenLimitInterceptor(guard))
+			stream = append(stream, tokenLimitStreamInterceptor(guard))
+		}
+	}
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+	if config != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	return grpc.NewServer(opts...)
+}
+
+// allow checks the call against the rate of the address of the peer
+func allow(ctx context.Context, guard *ratelimit.Guard) error {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	if !guard.Allow(addr) {
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+// allowToken checks the call against the rate of the bearer token of its
+// metadata, the token must be authenticated first
+func allowToken(ctx context.Context, guard *ratelimit.Guard) error {
+	if !guard.AllowToken(token(ctx)) {
+		return status.Error(codes.ResourceExhausted, "token rate limit exceeded")
+	}
+	return nil
+}
+
+// tokenLimitInterceptor rejects the calls over the rate of their token, it
+// goes after authInterceptor so only the authenticated tokens are limited
+func tokenLimitInterceptor(guard *ratelimit.Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if err := allowToken(ctx, guard); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tokenLimitStreamInterceptor rejects the streaming calls over the rate of
+// their token, it goes after authStreamInterceptor
+func tokenLimitStreamInterceptor(guard *ratelimit.Guard) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		if err := allowToken(stream.Context(), guard); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// limitInterceptor rejects the calls over the rate of the client IP of the guard
+func limitInterceptor(guard *ratelimit.Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if err := allow(ctx, guard); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// limitStreamInterceptor rejects the streaming calls over the rate of the
+// client IP of the guard
+func limitStreamInterceptor(guard *ratelimit.Guard) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		if err := allow(stream.Context(), guard); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// readMethods are the calls a read-only token is allowed to make
+var readMethods = map[string]bool{
+	"/gerdu.Gerdu/Get":   true,
+	"/gerdu.Gerdu/MGet":  true,
+	"/gerdu.Gerdu/Stats": true,
+}
+
+// authorize checks the bearer token of the authorization metadata of the
+// call against the tokens
+func authorize(ctx context.Context, tokens auth.Tokens, method string) error {
+	need := auth.ReadWrite
+	if readMethods[method] {
+		need = auth.ReadOnly
+	}
+	switch err := tokens.Authorize(token(ctx), need); err {
+	case auth.ErrUnauthenticated:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case auth.ErrForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// token returns the bearer token of the authorization metadata of the call
+func token(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			return auth.BearerToken(values[0])
+		}
+	}
+	return ""
+}
+
+// authInterceptor rejects the calls the tokens do not authorize
+func authInterceptor(tokens auth.Tokens) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, tokens, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStreamInterceptor rejects the streaming calls the tokens do not
+// authorize
+func authStreamInterceptor(tokens auth.Tokens) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		if err := authorize(stream.Context(), tokens, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// traceInterceptor starts the span of the call named by its method, it
+// continues the trace of the caller if the tracer can extract it from the
+// metadata
+func traceInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := tracing.Start(extract(ctx), info.FullMethod)
+	defer span.End()
+	resp, err := handler(ctx, req)
+	if err != nil && span.IsRecording() {
+		span.SetAttributes(tracing.String("rpc.error", err.Error()))
+	}
+	return resp, err
+}
+
+// extract returns ctx with the remote span of the metadata of the call
+func extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	header := http.Header{}
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	return tracing.Extract(ctx, header)
+}
+
+// traceStreamInterceptor starts the span of the streaming call like
+// traceInterceptor, it lasts until the stream is done
+func traceStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	_, span := tracing.Start(extract(stream.Context()), info.FullMethod)
+	defer span.End()
+	err := handler(srv, stream)
+	if err != nil && span.IsRecording() {
+		span.SetAttributes(tracing.String("rpc.error", err.Error()))
+	}
+	return err
 }
 
 func (s *server) Put(ctx context.Context, request *proto.PutRequest) (*proto.PutResponse, error) {
-	value := string(request.Value)
+	// the request is not reused, so the cache keeps its value without a copy
+	value := cache.UnsafeString(request.Value)
 	key := request.Key
+	span := tracing.StartOp(ctx, "cache.Put", key)
 	created := s.gerdu.Put(key, value)
+	tracing.EndOp(span, !created, len(value), 0)
 	if !created {
 		log.Printf("gRPC UPDATE Key: %s Value: %s\n", key, value)
 	} else {
@@ -62,19 +309,26 @@ func (s *server) Put(ctx context.Context, request *proto.PutRequest) (*proto.Put
 }
 
 func (s *server) Get(ctx context.Context, request *proto.GetRequest) (*proto.GetResponse, error) {
-	value, ok := s.gerdu.Get(request.Key)
+	span := tracing.StartOp(ctx, "cache.Get", request.Key)
+	value, ok := cache.GetBytes(s.gerdu, request.Key)
+	tracing.EndOp(span, ok, len(value), 0)
 	if ok {
 		log.Printf("gRPC RETREIVED Key: %s Value: %s\n", request.Key, value)
 		return &proto.GetResponse{
-			Value: []byte(value),
+			Value: value,
+			Found: true,
 		}, nil
 	}
 	log.Printf("gRPC MISSED Key: %s \n", request.Key)
-	return nil, errors.New("key not found")
+	return &proto.GetResponse{
+		Found: false,
+	}, nil
 }
 
 func (s *server) Delete(ctx context.Context, request *proto.DeleteRequest) (*proto.DeleteResponse, error) {
+	span := tracing.StartOp(ctx, "cache.Delete", request.Key)
 	ok := s.gerdu.Delete(request.Key)
+	tracing.EndOp(span, ok, 0, 0)
 	if ok {
 		log.Printf("gRPC DELETE Key: %s\n", request.Key)
 		return &proto.DeleteResponse{
@@ -84,3 +338,79 @@ func (s *server) Delete(ctx context.Context, request *proto.DeleteRequest) (*pro
 	log.Printf("gRPC MISSED Key: %s \n", request.Key)
 	return nil, errors.New("key not found")
 }
+
+func (s *server) MGet(ctx context.Context, request *proto.MGetRequest) (*proto.MGetResponse, error) {
+	found := cache.GetMulti(s.gerdu, request.Keys)
+	values := make(map[string][]byte, len(found))
+	for key, value := range found {
+		values[key] = cache.UnsafeBytes(value)
+	}
+	log.Printf("gRPC RETREIVED Keys: %v Found: %d\n", request.Keys, len(values))
+	return &proto.MGetResponse{
+		Values: values,
+	}, nil
+}
+
+func (s *server) MPut(ctx context.Context, request *proto.MPutRequest) (*proto.MPutResponse, error) {
+	// the request is not reused, so the cache keeps its values without a copy
+	entries := make(map[string]string, len(request.Entries))
+	for key, value := range request.Entries {
+		entries[key] = cache.UnsafeString(value)
+	}
+	cache.PutMulti(s.gerdu, entries)
+	log.Printf("gRPC PUT Entries: %d\n", len(entries))
+	return &proto.MPutResponse{}, nil
+}
+
+// BulkLoad puts the entries of every message of the stream as a batch until
+// the client closes it and returns the number of the loaded entries, so a
+// large dataset is loaded in messages under the maximum message size
+func (s *server) BulkLoad(stream proto.Gerdu_BulkLoadServer) error {
+	var loaded uint64
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			log.Printf("gRPC BULK LOADED Entries: %d\n", loaded)
+			return stream.SendAndClose(&proto.BulkLoadResponse{
+				Loaded: loaded,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		// the messages are not reused, so the cache keeps their values without a copy
+		entries := make(map[string]string, len(request.Entries))
+		for key, value := range request.Entries {
+			entries[key] = cache.UnsafeString(value)
+		}
+		cache.PutMulti(s.gerdu, entries)
+		loaded += uint64(len(entries))
+	}
+}
+
+func (s *server) MDelete(ctx context.Context, request *proto.MDeleteRequest) (*proto.MDeleteResponse, error) {
+	count := cache.DeleteMulti(s.gerdu, request.Keys)
+	log.Printf("gRPC DELETE Keys: %v Deleted: %d\n", request.Keys, count)
+	return &proto.MDeleteResponse{
+		Deleted: uint64(count),
+	}, nil
+}
+
+// Stats returns the stats of the served cache, all zero if the cache does
+// not keep its own stats
+func (s *server) Stats(ctx context.Context, request *proto.StatsRequest) (*proto.StatsResponse, error) {
+	statser, ok := s.gerdu.(cache.StatsCache)
+	if !ok {
+		return &proto.StatsResponse{}, nil
+	}
+	stats := statser.Stats()
+	return &proto.StatsResponse{
+		Hits:      stats.Hits,
+		Misses:    stats.Misses,
+		Adds:      stats.Adds,
+		Deletes:   stats.Deletes,
+		Evictions: stats.Evictions,
+		Size:      uint64(stats.Size),
+		Entries:   uint64(stats.Entries),
+	}, nil
+}
@@ -2,12 +2,21 @@ package grpcserver
 
 import (
 	"context"
+	"errors"
+	"github.com/arazmj/gerdu/auth"
 	"github.com/arazmj/gerdu/lrucache"
 	"github.com/arazmj/gerdu/proto"
+	"github.com/arazmj/gerdu/ratelimit"
+	"github.com/arazmj/gerdu/tracing"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"net"
+	"strconv"
 	"testing"
 )
 
@@ -65,12 +74,239 @@ func TestServerGrpc(t *testing.T) {
 		t.Fatalf("gRPC Get the value does not match expecting Value1, but got %v", value)
 	}
 
-	client.Delete(ctx, &proto.DeleteRequest{Key: "Key1"})
-	_, err = client.Get(ctx, &proto.GetRequest{
+	if !response.Found {
+		t.Fatalf("gRPC Get expected the key to be found")
+	}
+
+	deleted, err := client.Delete(ctx, &proto.DeleteRequest{Key: "Key1"})
+	if err != nil || !deleted.Deleted {
+		t.Fatalf("gRPC Delete failed: %v", err)
+	}
+
+	response, err = client.Get(ctx, &proto.GetRequest{
 		Key: "Key1",
 	})
 
+	if err != nil {
+		t.Fatalf("gRPC Get failed: %v", err)
+	}
+
+	if response.Found {
+		t.Fatalf("gRPC Deleted key should not be found")
+	}
+
+	_, err = client.Delete(ctx, &proto.DeleteRequest{Key: "Key1"})
+	if err == nil {
+		t.Fatalf("gRPC Delete of a missing key should fail")
+	}
+}
+
+func TestServerGrpc_Stats(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+	client := proto.NewGerduClient(conn)
+
+	before, err := client.Stats(ctx, &proto.StatsRequest{})
+	if err != nil {
+		t.Fatalf("gRPC Stats failed: %v", err)
+	}
+
+	_, _ = client.Put(ctx, &proto.PutRequest{Key: "Key2", Value: []byte("")})
+	_, _ = client.Get(ctx, &proto.GetRequest{Key: "Key2"})
+	_, _ = client.Get(ctx, &proto.GetRequest{Key: "Key3"})
+
+	after, err := client.Stats(ctx, &proto.StatsRequest{})
+	if err != nil {
+		t.Fatalf("gRPC Stats failed: %v", err)
+	}
+	if after.Adds != before.Adds+1 || after.Hits != before.Hits+1 || after.Misses != before.Misses+1 {
+		t.Fatalf("gRPC Stats did not count the operations, before %v after %v", before, after)
+	}
+}
+
+func TestServerGrpc_Multi(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+	client := proto.NewGerduClient(conn)
+
+	_, err = client.MPut(ctx, &proto.MPutRequest{
+		Entries: map[string][]byte{"Multi1": []byte("a"), "Multi2": []byte("b")},
+	})
+	if err != nil {
+		t.Fatalf("gRPC MPut failed: %v", err)
+	}
+
+	got, err := client.MGet(ctx, &proto.MGetRequest{Keys: []string{"Multi1", "Multi2", "Multi3"}})
+	if err != nil {
+		t.Fatalf("gRPC MGet failed: %v", err)
+	}
+	if len(got.Values) != 2 || string(got.Values["Multi1"]) != "a" || string(got.Values["Multi2"]) != "b" {
+		t.Fatalf("gRPC MGet expected Multi1 and Multi2 but got %v", got.Values)
+	}
+
+	deleted, err := client.MDelete(ctx, &proto.MDeleteRequest{Keys: []string{"Multi1", "Multi3"}})
+	if err != nil {
+		t.Fatalf("gRPC MDelete failed: %v", err)
+	}
+	if deleted.Deleted != 1 {
+		t.Fatalf("gRPC MDelete expected 1 deleted key but got %d", deleted.Deleted)
+	}
+}
+
+func TestServerGrpc_BulkLoad(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+	client := proto.NewGerduClient(conn)
+
+	stream, err := client.BulkLoad(ctx)
+	if err != nil {
+		t.Fatalf("gRPC BulkLoad failed: %v", err)
+	}
+	for batch := 0; batch < 3; batch++ {
+		entries := map[string][]byte{}
+		for i := 0; i < 10; i++ {
+			entries["Bulk"+strconv.Itoa(batch*10+i)] = []byte(strconv.Itoa(i))
+		}
+		if err := stream.Send(&proto.BulkLoadRequest{Entries: entries}); err != nil {
+			t.Fatalf("gRPC BulkLoad send failed: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil || resp.Loaded != 30 {
+		t.Fatalf("gRPC BulkLoad expected 30 loaded entries but got %v %v", resp, err)
+	}
+	got, err := client.Get(ctx, &proto.GetRequest{Key: "Bulk25"})
+	if err != nil || !got.Found || string(got.Value) != "5" {
+		t.Fatalf("gRPC Get expected the loaded Bulk25 but got %v %v", got, err)
+	}
+}
+
+func TestTraceInterceptor(t *testing.T) {
+	tracer := &tracing.MemoryTracer{}
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.Noop)
+	info := &grpc.UnaryServerInfo{FullMethod: "/proto.Gerdu/Get"}
+	var parent tracing.Span
+	_, err := traceInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, parent = tracing.Start(ctx, "cache.Get")
+		return nil, errors.New("key not found")
+	})
+	spans := tracer.Spans()
+	if err == nil || len(spans) != 2 || spans[0].Name != "/proto.Gerdu/Get" || !spans[0].Ended {
+		t.Fatalf("Expected the span of the call but got %+v", spans)
+	}
+	if parent.(*tracing.MemorySpan).Parent != spans[0] {
+		t.Errorf("Expected the handler to get the span of the call in its context")
+	}
+	if spans[0].Attributes["rpc.error"] != "key not found" {
+		t.Errorf("Expected the error of the call but got %v", spans[0].Attributes)
+	}
+}
+
+func TestServer_Tracing(t *testing.T) {
+	tracer := &tracing.MemoryTracer{}
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.Noop)
+	s := &server{gerdu: lrucache.NewCache(100)}
+	ctx, call := tracing.Start(context.Background(), "/gerdu.Gerdu/Put")
+	s.Put(ctx, &proto.PutRequest{Key: "1", Value: []byte("11")})
+	s.Get(ctx, &proto.GetRequest{Key: "1"})
+	s.Delete(ctx, &proto.DeleteRequest{Key: "1"})
+	spans := tracer.Spans()
+	if len(spans) != 4 {
+		t.Fatalf("Expected the span of the call and of 3 operations but got %+v", spans)
+	}
+	for i, name := range []string{"cache.Put", "cache.Get", "cache.Delete"} {
+		if span := spans[i+1]; span.Name != name || span.Parent != call || !span.Ended {
+			t.Errorf("Expected %s to be a child of the span of the call but got %+v", name, span)
+		}
+	}
+	if hit := spans[2].Attributes[tracing.HitAttribute]; hit != true {
+		t.Errorf("Expected the get to hit but got %v", hit)
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	tokens := auth.Tokens{"reader": auth.ReadOnly, "writer": auth.ReadWrite}
+	s := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor(tokens)),
+		grpc.StreamInterceptor(authStreamInterceptor(tokens)))
+	proto.RegisterGerduServer(s, &server{gerdu: lrucache.NewCache(1000)})
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	defer conn.Close()
+	client := proto.NewGerduClient(conn)
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	if _, err := client.Get(context.Background(), &proto.GetRequest{Key: "1"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a call without a token to be unauthenticated but got %v", err)
+	}
+	if _, err := client.Put(as("reader"), &proto.PutRequest{Key: "1", Value: []byte("1")}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected a put of a read-only token to be denied but got %v", err)
+	}
+	if _, err := client.Put(as("writer"), &proto.PutRequest{Key: "1", Value: []byte("1")}); err != nil {
+		t.Errorf("Expected a put of a read-write token but got %v", err)
+	}
+	if resp, err := client.Get(as("reader"), &proto.GetRequest{Key: "1"}); err != nil || !resp.Found {
+		t.Errorf("Expected a get of a read-only token but got %v %v", resp, err)
+	}
+	stream, err := client.BulkLoad(as("reader"))
 	if err == nil {
-		t.Fatalf("gRPC Deleted key should not get: %v", err)
+		_, err = stream.CloseAndRecv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected a bulk load of a read-only token to be denied but got %v", err)
+	}
+}
+
+func TestTokenLimitInterceptor(t *testing.T) {
+	interceptor := tokenLimitInterceptor(ratelimit.NewGuard(ratelimit.Limits{TokenRate: 1, TokenBurst: 1}))
+	info := &grpc.UnaryServerInfo{FullMethod: "/gerdu.Gerdu/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "served", nil
+	}
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Errorf("Expected a call without a token not to be limited by token but got %v", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer reader"))
+	if resp, err := interceptor(ctx, nil, info, handler); err != nil || resp != "served" {
+		t.Errorf("Expected the call within the rate to be served but got %v %v", resp, err)
+	}
+	if _, err := interceptor(ctx, nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected the call over the rate of the token to be exhausted but got %v", err)
+	}
+}
+
+func TestLimitInterceptor(t *testing.T) {
+	interceptor := limitInterceptor(ratelimit.NewGuard(ratelimit.Limits{Rate: 1, Burst: 1}))
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+	info := &grpc.UnaryServerInfo{FullMethod: "/gerdu.Gerdu/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "served", nil
+	}
+	if resp, err := interceptor(ctx, nil, info, handler); err != nil || resp != "served" {
+		t.Errorf("Expected the call within the rate to be served but got %v %v", resp, err)
+	}
+	if _, err := interceptor(ctx, nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected the call over the rate to be exhausted but got %v", err)
 	}
 }
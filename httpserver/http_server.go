@@ -1,14 +1,29 @@
 package httpserver
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/arazmj/gerdu/auth"
 	"github.com/arazmj/gerdu/cache"
 	"github.com/arazmj/gerdu/raftproxy"
+	"github.com/arazmj/gerdu/ratelimit"
+	"github.com/arazmj/gerdu/tracing"
 	"github.com/gorilla/mux"
+	"github.com/inhies/go-bytesize"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func newRouter(gerdu cache.UnImplementedCache) (router *mux.Router) {
@@ -22,16 +37,164 @@ func newRouter(gerdu cache.UnImplementedCache) (router *mux.Router) {
 	router.HandleFunc("/cache/{key}", func(w http.ResponseWriter, r *http.Request) {
 		deleteHandler(w, r, gerdu)
 	}).Methods(http.MethodDelete)
+	router.HandleFunc("/cache/{ns}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		inNamespace(w, r, gerdu, getHandler)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/cache/{ns}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		inNamespace(w, r, gerdu, putHandler)
+	}).Methods(http.MethodPut)
+	router.HandleFunc("/cache/{ns}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		inNamespace(w, r, gerdu, deleteHandler)
+	}).Methods(http.MethodDelete)
+	router.HandleFunc("/namespaces", func(w http.ResponseWriter, r *http.Request) {
+		namespacesHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{ns}", func(w http.ResponseWriter, r *http.Request) {
+		namespaceHandler(w, r, gerdu)
+	}).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	router.HandleFunc("/ttl/{key}", func(w http.ResponseWriter, r *http.Request) {
+		ttlHandler(w, r, gerdu)
+	}).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+	router.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		batchGetHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		batchPutHandler(w, r, gerdu)
+	}).Methods(http.MethodPut)
+	router.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		batchDeleteHandler(w, r, gerdu)
+	}).Methods(http.MethodDelete)
 	router.HandleFunc("/join", func(w http.ResponseWriter, r *http.Request) {
 		joinHandler(w, r, gerdu)
 	}).Methods(http.MethodPost)
 	router.HandleFunc("/leave", func(w http.ResponseWriter, r *http.Request) {
 		leaveHandler(w, r, gerdu)
 	}).Methods(http.MethodPost)
+	router.HandleFunc("/members", func(w http.ResponseWriter, r *http.Request) {
+		membersHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/raft/apply", func(w http.ResponseWriter, r *http.Request) {
+		applyHandler(w, r, gerdu)
+	}).Methods(http.MethodPost)
+	router.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		keysHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		deleteByPatternHandler(w, r, gerdu)
+	}).Methods(http.MethodDelete)
+	router.HandleFunc("/debug/keys", func(w http.ResponseWriter, r *http.Request) {
+		orderedKeysHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/dump", func(w http.ResponseWriter, r *http.Request) {
+		dumpHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		eventsHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/admin/flush", func(w http.ResponseWriter, r *http.Request) {
+		flushHandler(w, r, gerdu)
+	}).Methods(http.MethodPost)
+	router.HandleFunc("/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		adminStatsHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/admin/keys", func(w http.ResponseWriter, r *http.Request) {
+		adminKeysHandler(w, r, gerdu)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/admin/capacity", func(w http.ResponseWriter, r *http.Request) {
+		resizeHandler(w, r, gerdu)
+	}).Methods(http.MethodPut)
 	router.Handle("/metrics", promhttp.Handler())
+	router.Handle("/debug/vars", expvar.Handler())
+	router.Use(traceHandler)
 	return router
 }
 
+// traceHandler starts the span of the request named by its method and route,
+// it continues the trace of the caller if the tracer can extract it from the
+// headers and the handlers get the span in the context of the request
+func traceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "HTTP "+r.Method+" "+route)
+		defer span.End()
+		status := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(status, r.WithContext(ctx))
+		if span.IsRecording() {
+			span.SetAttributes(
+				tracing.String("http.method", r.Method),
+				tracing.String("http.route", route),
+				tracing.Int64("http.status_code", int64(status.status)),
+			)
+		}
+	})
+}
+
+// statusWriter keeps the status code written to the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush sends the buffered data to the client if the writer can
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// authHandler returns the middleware that rejects the requests without a
+// token of tokens with 401 and those the role of the token does not allow
+// with 403, see requiredRole
+func authHandler(tokens auth.Tokens) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch tokens.Authorize(auth.BearerToken(r.Header.Get("Authorization")), requiredRole(r)) {
+			case auth.ErrUnauthenticated:
+				log.Printf("HTTP UNAUTHENTICATED %s %s\n", r.Method, r.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gerdu"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			case auth.ErrForbidden:
+				log.Printf("HTTP FORBIDDEN %s %s\n", r.Method, r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// nodeRoutes are the routes only the nodes of the cluster call, they
+// change the membership or apply raw commands to the raft log
+var nodeRoutes = map[string]bool{
+	"/join":       true,
+	"/leave":      true,
+	"/raft/apply": true,
+}
+
+// requiredRole returns the role the request needs: node for the node
+// routes, read-write for the admin API, even to read, and for the requests
+// other than GET, HEAD and OPTIONS, read-only otherwise
+func requiredRole(r *http.Request) auth.Role {
+	switch {
+	case nodeRoutes[r.URL.Path]:
+		return auth.Node
+	case strings.HasPrefix(r.URL.Path, "/admin/"),
+		r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions:
+		return auth.ReadWrite
+	}
+	return auth.ReadOnly
+}
+
 //HTTPServe start http server in plain text
 func HTTPServe(host string, gerdu cache.UnImplementedCache) {
 	router := newRouter(gerdu)
@@ -46,18 +209,159 @@ func HTTPServeTLS(host string, tlsCert, tlsKey string, gerdu cache.UnImplemented
 	log.Fatal(http.ListenAndServeTLS(host, tlsCert, tlsKey, router))
 }
 
+// HTTPServeAuth starts the HTTP server with the TLS config, plain text if it
+// is nil, that only serves the requests the tokens authorize, all of them
+// if the tokens are nil, within the limits of the guard, none if it is nil
+func HTTPServeAuth(host string, config *tls.Config, tokens auth.Tokens, guard *ratelimit.Guard,
+	gerdu cache.UnImplementedCache) {
+	server, listener := newServer(host, config, tokens, guard, gerdu)
+	log.Fatal(serveListener(server, listener, host))
+}
+
+// HTTPStartAuth starts the HTTP server of HTTPServeAuth in the background, it
+// returns the shutdown of the server that stops accepting the connections
+// and waits for the requests in flight, the connections are closed once the
+// context is done
+func HTTPStartAuth(host string, config *tls.Config, tokens auth.Tokens, guard *ratelimit.Guard,
+	gerdu cache.UnImplementedCache) (shutdown func(ctx context.Context) error) {
+	server, listener := newServer(host, config, tokens, guard, gerdu)
+	go func() {
+		if err := serveListener(server, listener, host); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			server.Close()
+		}
+		return err
+	}
+}
+
+// newServer returns the HTTP server of HTTPServeAuth and its listener
+func newServer(host string, config *tls.Config, tokens auth.Tokens, guard *ratelimit.Guard,
+	gerdu cache.UnImplementedCache) (*http.Server, net.Listener) {
+	router := newRouter(gerdu)
+	if guard != nil {
+		router.Use(limitHandler(guard))
+	}
+	if tokens != nil {
+		router.Use(authHandler(tokens))
+		if guard != nil {
+			router.Use(tokenLimitHandler(guard))
+		}
+	}
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		log.Fatal(err)
+	}
+	listener = guard.Listener(listener, func(conn net.Conn) {
+		rejectConn(conn, config)
+	})
+	return &http.Server{Handler: router, TLSConfig: config}, listener
+}
+
+// serveListener serves the listener with TLS if the server has a TLS config
+func serveListener(server *http.Server, listener net.Listener, host string) error {
+	if server.TLSConfig == nil {
+		log.Infof("Gerdu started listening HTTP at %s\n", host)
+		return server.Serve(listener)
+	}
+	log.Printf("Gerdu started listening HTTPS TLS at %s\n", host)
+	return server.ServeTLS(listener, "", "")
+}
+
+// limitHandler returns the middleware that rejects the requests over the
+// rate of their client IP with 429
+func limitHandler(guard *ratelimit.Guard) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !guard.Allow(r.RemoteAddr) {
+				log.Printf("HTTP RATE LIMITED %s %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenLimitHandler returns the middleware that rejects the requests over
+// the rate of their token with 429, it goes after authHandler so only the
+// authenticated tokens are limited
+func tokenLimitHandler(guard *ratelimit.Guard) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !guard.AllowToken(auth.BearerToken(r.Header.Get("Authorization"))) {
+				log.Printf("HTTP TOKEN RATE LIMITED %s %s %s\n", r.RemoteAddr, r.Method, r.URL.Path)
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectConn answers the first request of a connection over the limit of
+// the connections with 429 and closes it
+func rejectConn(conn net.Conn, config *tls.Config) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if config != nil {
+		conn = tls.Server(conn, config)
+	}
+	if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+		return
+	}
+	_, _ = io.WriteString(conn, "HTTP/1.1 429 Too Many Requests\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+}
+
 func putHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
 	vars := mux.Vars(r)
 	key := vars["key"]
-	buf := new(bytes.Buffer)
-	_, err := buf.ReadFrom(r.Body)
+	ttl, err := parseTTL(r)
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Printf("HTTP INVALID TTL Key: %s %v\n", key, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		log.Printf("HTTP INVALID Body Key: %s %v\n", key, err)
+		http.Error(w, err.Error(), bodyStatus(err))
 		return
 	}
-	value := buf.String()
+	// the body is not reused, so the cache keeps it without a copy
+	value := cache.UnsafeString(body)
 
-	created := gerdu.Put(key, value)
+	if match := r.Header.Get("If-Match"); match != "" {
+		if ttl > 0 {
+			log.Printf("HTTP TTL WITH IF-MATCH Key: %s\n", key)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		casHandler(w, gerdu, key, value, match)
+		return
+	}
+
+	var created bool
+	span := tracing.StartOp(r.Context(), "cache.Put", key)
+	if ttl > 0 {
+		c, ok := gerdu.(cache.TTLCache)
+		if !ok {
+			span.End()
+			log.Printf("HTTP TTL NOT SUPPORTED Key: %s\n", key)
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		created = c.PutWithTTL(key, value, ttl)
+	} else {
+		created = gerdu.Put(key, value)
+	}
+	tracing.EndOp(span, !created, len(value), 0)
 	if !created {
 		log.Printf("HTTP UPDATE Key: %s Value: %s\n", key, value)
 	} else {
@@ -67,17 +371,123 @@ func putHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplemente
 	if created {
 		w.WriteHeader(http.StatusCreated)
 	} else {
-		w.WriteHeader(http.StatusNoContent)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// casHandler replaces the value only if the version token of the entry is
+// still the ETag in the If-Match header
+func casHandler(w http.ResponseWriter, gerdu cache.UnImplementedCache, key, value, match string) {
+	versions, ok := gerdu.(cache.VersionCache)
+	if !ok {
+		log.Printf("HTTP VERSIONS NOT SUPPORTED Key: %s\n", key)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	version, err := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+	if err != nil {
+		log.Printf("HTTP INVALID IF-MATCH Key: %s %s\n", key, match)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	switch err := versions.Cas(key, value, version); err {
+	case nil:
+		log.Printf("HTTP SWAPPED Key: %s Value: %s\n", key, value)
+		w.WriteHeader(http.StatusOK)
+	case cache.ErrVersionMismatch:
+		log.Printf("HTTP VERSION MISMATCH Key: %s\n", key)
+		w.WriteHeader(http.StatusPreconditionFailed)
+	case cache.ErrNotFound:
+		log.Printf("HTTP MISSED Key: %s \n", key)
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// MaxBodySize is the largest request body the HTTP server reads, a larger
+// one is answered with 413
+var MaxBodySize int64 = 64 << 20
+
+// errBodyTooLarge is returned by readBody for a body over MaxBodySize
+var errBodyTooLarge = errors.New("httpserver: the request body is too large")
+
+// readBody reads the request body up to MaxBodySize, into a slice of the
+// exact size if the content length is known and within the limit so the
+// cache does not keep unused capacity
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.ContentLength > MaxBodySize {
+		return nil, errBodyTooLarge
+	}
+	reader := http.MaxBytesReader(w, r.Body, MaxBodySize)
+	if r.ContentLength > 0 {
+		body := make([]byte, r.ContentLength)
+		_, err := io.ReadFull(reader, body)
+		return body, err
+	}
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(reader); err != nil {
+		// the reader fails once the body reaches the limit
+		if int64(buf.Len()) >= MaxBodySize {
+			return nil, errBodyTooLarge
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyStatus returns the status code of the error of readBody
+func bodyStatus(err error) int {
+	if err == errBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// parseTTL reads the optional time to live of an entry from either the ttl
+// query parameter or the X-TTL header, e.g. 30s or 5m
+func parseTTL(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("ttl")
+	if value == "" {
+		value = r.Header.Get("X-TTL")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return ttl, nil
+}
+
 func getHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
 	vars := mux.Vars(r)
 	key := vars["key"]
-	if value, ok := gerdu.Get(key); ok {
+	span := tracing.StartOp(r.Context(), "cache.Get", key)
+	if versions, ok := gerdu.(cache.VersionCache); ok {
+		value, version, ok := versions.Gets(key)
+		tracing.EndOp(span, ok, len(value), 0)
+		if ok {
+			log.Printf("HTTP RETREIVED Key: %s Value: %s\n", key, value)
+			w.Header().Set("ETag", `"`+strconv.FormatUint(version, 10)+`"`)
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(cache.UnsafeBytes(value))
+		} else {
+			log.Printf("HTTP MISSED Key: %s \n", key)
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+	value, ok := cache.GetBytes(gerdu, key)
+	tracing.EndOp(span, ok, len(value), 0)
+	if ok {
 		log.Printf("HTTP RETREIVED Key: %s Value: %s\n", key, value)
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(value))
+		_, _ = w.Write(value)
 	} else {
 		log.Printf("HTTP MISSED Key: %s \n", key)
 		w.WriteHeader(http.StatusNotFound)
@@ -87,7 +497,10 @@ func getHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplemente
 func deleteHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
 	vars := mux.Vars(r)
 	key := vars["key"]
-	if ok := gerdu.Delete(key); ok {
+	span := tracing.StartOp(r.Context(), "cache.Delete", key)
+	ok := gerdu.Delete(key)
+	tracing.EndOp(span, ok, 0, 0)
+	if ok {
 		log.Printf("HTTP DELETED Key: %s\n", key)
 		w.WriteHeader(http.StatusOK)
 	} else {
@@ -96,15 +509,378 @@ func deleteHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImpleme
 	}
 }
 
+// ttlHandler writes the time left until the key expires in milliseconds on
+// GET, -1 if the key never expires. PUT sets the key to expire after the
+// ttl query parameter or X-TTL header, and DELETE makes the key never expire
+func ttlHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	expirer, ok := gerdu.(cache.ExpireCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	key := mux.Vars(r)["key"]
+	switch r.Method {
+	case http.MethodGet:
+		ttl, ok := expirer.TTL(key)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ms := int64(-1)
+		if ttl > 0 {
+			ms = int64(ttl / time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ms)
+		return
+	case http.MethodPut:
+		ttl, err := parseTTL(r)
+		if err != nil || ttl == 0 {
+			log.Printf("HTTP INVALID TTL Key: %s %v\n", key, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ok = expirer.Expire(key, ttl)
+	case http.MethodDelete:
+		ok = expirer.Persist(key)
+	}
+	if !ok {
+		log.Printf("HTTP MISSED Key: %s \n", key)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	log.Printf("HTTP %s TTL Key: %s\n", r.Method, key)
+	w.WriteHeader(http.StatusOK)
+}
+
+// batchGetHandler writes the values of the key query parameters that are
+// found as a JSON object
+func batchGetHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	keys := r.URL.Query()["key"]
+	values := cache.GetMulti(gerdu, keys)
+	log.Printf("HTTP RETREIVED Keys: %v Found: %d\n", keys, len(values))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(values)
+}
+
+// batchPutHandler updates or inserts the entries of the JSON object in the body
+func batchPutHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	entries := map[string]string{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize)).Decode(&entries); err != nil {
+		log.Printf("HTTP INVALID BATCH %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	cache.PutMulti(gerdu, entries)
+	log.Printf("HTTP PUT Entries: %d\n", len(entries))
+	w.WriteHeader(http.StatusOK)
+}
+
+// batchDeleteHandler deletes the key query parameters and writes the
+// number of the deleted keys
+func batchDeleteHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	keys := r.URL.Query()["key"]
+	count := cache.DeleteMulti(gerdu, keys)
+	log.Printf("HTTP DELETED Keys: %v Deleted: %d\n", keys, count)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(count)
+}
+
+// deleteByPatternHandler deletes the keys that match the glob pattern of
+// the match query parameter and writes the number of the deleted keys
+func deleteByPatternHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	deleter, ok := gerdu.(cache.PatternCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	pattern := r.URL.Query().Get("match")
+	if pattern == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	count, err := deleter.DeleteByPattern(pattern)
+	if err != nil {
+		log.Printf("HTTP INVALID PATTERN %s %v\n", pattern, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	log.Printf("HTTP DELETED Pattern: %s Deleted: %d\n", pattern, count)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(count)
+}
+
+// scanResponse is a batch of keys and the cursor of the next batch
+type scanResponse struct {
+	Cursor uint64   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// keysHandler writes all the keys as a JSON array, or a batch of them and
+// the next cursor if the cursor query parameter is set. The keys are
+// filtered by the glob pattern of the match query parameter if it is set
+func keysHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	scanner, ok := gerdu.(cache.ScanCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	pattern := query.Get("match")
+	if pattern == "" {
+		pattern = "*"
+	}
+	if err := cache.ValidPattern(pattern); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if query.Get("cursor") == "" {
+		keys := []string{}
+		for _, key := range scanner.Keys() {
+			if matched, _ := cache.Match(pattern, key); matched {
+				keys = append(keys, key)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(keys)
+		return
+	}
+	cursor, err := strconv.ParseUint(query.Get("cursor"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	count := 0
+	if value := query.Get("count"); value != "" {
+		if count, err = strconv.Atoi(value); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	keys, next, _ := cache.ScanMatch(scanner, cursor, count, pattern)
+	if keys == nil {
+		keys = []string{}
+	}
+	_ = json.NewEncoder(w).Encode(scanResponse{Cursor: next, Keys: keys})
+}
+
+// orderedKeysHandler writes the keys in the eviction order as a JSON array,
+// from the last to be evicted to the next
+func orderedKeysHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	ordered, ok := gerdu.(cache.OrderedCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	keys := ordered.OrderedKeys()
+	if keys == nil {
+		keys = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}
+
+// inNamespace serves the request with handler and the cache of the
+// namespace of the route, 404 if there is no such namespace
+func inNamespace(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache,
+	handler func(http.ResponseWriter, *http.Request, cache.UnImplementedCache)) {
+	namespaces, ok := gerdu.(cache.NamespaceCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	c, ok := namespaces.Namespace(mux.Vars(r)["ns"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler(w, r, c)
+}
+
+// namespacesHandler writes the names of the namespaces as a JSON array
+func namespacesHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	namespaces, ok := gerdu.(cache.NamespaceCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	names := namespaces.Namespaces()
+	if names == nil {
+		names = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(names)
+}
+
+// namespaceStats is the body of the GET of a namespace
+type namespaceStats struct {
+	Name    string                `json:"name"`
+	Config  cache.NamespaceConfig `json:"config"`
+	Entries int                   `json:"entries"`
+	Stats   *cache.Stats          `json:"stats,omitempty"`
+}
+
+// namespaceHandler writes the config, the number of entries and the stats
+// of the namespace on GET, creates it on PUT with the optional config of the
+// body, e.g. {"capacity":"128MB","policy":"lfu","ttl":"10m"}, and drops it
+// with its entries on DELETE
+func namespaceHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	namespaces, ok := gerdu.(cache.NamespaceCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	name := mux.Vars(r)["ns"]
+	switch r.Method {
+	case http.MethodPut:
+		var config cache.NamespaceConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil && err != io.EOF {
+			log.Printf("HTTP INVALID Namespace config: %s %v\n", name, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		created, err := namespaces.CreateNamespace(name, config)
+		switch {
+		case err == cache.ErrNotSupported:
+			w.WriteHeader(http.StatusNotImplemented)
+		case err == cache.ErrNamespaceConflict:
+			w.WriteHeader(http.StatusConflict)
+		case err != nil:
+			log.Printf("HTTP CANNOT CREATE Namespace: %s %v\n", name, err)
+			w.WriteHeader(http.StatusBadRequest)
+		case created:
+			log.Printf("HTTP CREATED Namespace: %s\n", name)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	case http.MethodDelete:
+		ok, err := namespaces.DropNamespace(name)
+		if err != nil {
+			log.Errorf("Cannot close the namespace %s %v", name, err)
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.Printf("HTTP DROPPED Namespace: %s\n", name)
+		w.WriteHeader(http.StatusOK)
+	default:
+		c, ok := namespaces.Namespace(name)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		stats := namespaceStats{Name: name}
+		stats.Config, _ = namespaces.NamespaceConfig(name)
+		if sizer, ok := c.(cache.Sizer); ok {
+			stats.Entries = sizer.Len()
+		}
+		if statser, ok := c.(cache.StatsCache); ok {
+			s := statser.Stats()
+			stats.Stats = &s
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&stats)
+	}
+}
+
+// dumpHandler writes the entries of the local cache in the portable format
+// of cache.Dump, a node warms up from the dump of a peer
+func dumpHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	exporter, ok := gerdu.(cache.ExportCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := exporter.Export(w); err == cache.ErrNotSupported {
+		w.WriteHeader(http.StatusNotImplemented)
+	} else if err != nil {
+		log.Errorf("Cannot write the dump %v", err)
+	}
+}
+
+// event is a cache event streamed by eventsHandler
+type event struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// eventsHandler streams the sets, deletes, evictions and expirations of the
+// cache as server-sent events until the client goes away. If the prefix
+// query parameter is set only the keys that start with one of its values
+// are streamed. The events are dropped if the client falls behind
+func eventsHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	subscriber, ok := gerdu.(cache.EventCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	events := subscriber.Subscribe()
+	if events == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	defer subscriber.Unsubscribe(events)
+	prefixes := r.URL.Query()["prefix"]
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if !hasPrefix(e.Key, prefixes) {
+				continue
+			}
+			data, _ := json.Marshal(event{Type: e.Type.String(), Key: e.Key, Value: e.Value})
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// hasPrefix reports whether the key starts with any of the prefixes, any
+// key does if there is none
+func hasPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func joinHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
-	raftCache := gerdu.(*raftproxy.RaftProxy)
+	raftCache, ok := gerdu.(*raftproxy.RaftProxy)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
 	m := map[string]string{}
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if len(m) != 2 {
+	if len(m) < 2 || len(m) > 4 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -121,15 +897,32 @@ func joinHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplement
 		return
 	}
 
-	if err := raftCache.Join(nodeID, remoteAddr); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	var nonvoter bool
+	switch m["suffrage"] {
+	case "", "voter":
+	case "nonvoter":
+		nonvoter = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := raftCache.Join(nodeID, remoteAddr, m["api"], nonvoter); err == raftproxy.ErrMemberExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	log.Infof("Node %s, remoteAddr %s joined", nodeID, remoteAddr)
 }
 
 func leaveHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
-	raftCache := gerdu.(*raftproxy.RaftProxy)
+	raftCache, ok := gerdu.(*raftproxy.RaftProxy)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(r.Body)
 	nodeId := buf.String()
@@ -140,8 +933,175 @@ func leaveHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplemen
 	}
 
 	if err := raftCache.Leave(nodeId); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	log.Infof("Node %s has left", nodeId)
 }
+
+// membersHandler writes the servers of the raft cluster as a JSON array
+func membersHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	raftCache, ok := gerdu.(*raftproxy.RaftProxy)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	members, err := raftCache.Members()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(members)
+}
+
+// adminStats is the stats of the node written by adminStatsHandler
+type adminStats struct {
+	Role      string  `json:"role"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Adds      uint64  `json:"adds"`
+	Deletes   uint64  `json:"deletes"`
+	Evictions uint64  `json:"evictions"`
+	Size      uint64  `json:"size"`
+	Entries   int     `json:"entries"`
+}
+
+// adminStatsHandler writes the counters, the hit ratio, the size and the
+// number of entries of the cache and the raft role of the node, standalone
+// if it is not in a cluster
+func adminStatsHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	stats := adminStats{Role: "standalone"}
+	if raftCache, ok := gerdu.(*raftproxy.RaftProxy); ok {
+		stats.Role = raftCache.Role()
+	}
+	if statser, ok := gerdu.(cache.StatsCache); ok {
+		s := statser.Stats()
+		stats.Hits, stats.Misses, stats.Adds = s.Hits, s.Misses, s.Adds
+		stats.Deletes, stats.Evictions = s.Deletes, s.Evictions
+		stats.Size, stats.Entries = uint64(s.Size), s.Entries
+		if lookups := s.Hits + s.Misses; lookups > 0 {
+			stats.HitRatio = float64(s.Hits) / float64(lookups)
+		}
+	}
+	if sizer, ok := gerdu.(cache.Sizer); ok {
+		stats.Entries = sizer.Len()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&stats)
+}
+
+// flushHandler deletes all the entries of the cache, through the raft log
+// in a cluster, and writes the number of the deleted entries
+func flushHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	deleter, ok := gerdu.(cache.PatternCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	count, err := deleter.DeleteByPattern("*")
+	if err != nil {
+		log.Printf("HTTP CANNOT FLUSH %v\n", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("HTTP FLUSHED Deleted: %d\n", count)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(count)
+}
+
+// resizeHandler changes the capacity of the cache to the size of the body,
+// e.g. 128MB, a smaller capacity evicts the entries that no longer fit
+func resizeHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	resizer, ok := gerdu.(cache.ResizeCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		w.WriteHeader(bodyStatus(err))
+		return
+	}
+	capacity, err := bytesize.Parse(strings.TrimSpace(string(body)))
+	if err != nil {
+		log.Printf("HTTP INVALID Capacity: %s %v\n", body, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch err := resizer.Resize(capacity); err {
+	case nil:
+		log.Printf("HTTP RESIZED Capacity: %s\n", capacity)
+		w.WriteHeader(http.StatusOK)
+	case cache.ErrNotSupported:
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		log.Printf("HTTP CANNOT RESIZE Capacity: %s %v\n", capacity, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// defaultAdminKeys is the number of the keys adminKeysHandler writes without
+// a limit
+const defaultAdminKeys = 100
+
+// adminKeysHandler writes up to the limit query parameter of the keys as a
+// JSON array
+func adminKeysHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	scanner, ok := gerdu.(cache.ScanCache)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	limit := defaultAdminKeys
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	keys := []string{}
+	var cursor uint64
+	for {
+		var batch []string
+		batch, cursor = scanner.Scan(cursor, limit-len(keys))
+		keys = append(keys, batch...)
+		if cursor == 0 || len(keys) >= limit {
+			break
+		}
+	}
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}
+
+// applyHandler applies a command a follower forwarded to the leader
+func applyHandler(w http.ResponseWriter, r *http.Request, gerdu cache.UnImplementedCache) {
+	raftCache, ok := gerdu.(*raftproxy.RaftProxy)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	body, err := readBody(w, r)
+	if err != nil {
+		w.WriteHeader(bodyStatus(err))
+		return
+	}
+	response, err := raftCache.ApplyForwarded(body)
+	switch err {
+	case nil:
+	case raftproxy.ErrBadCommand:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
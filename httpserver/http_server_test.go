@@ -1,12 +1,24 @@
 package httpserver
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/arazmj/gerdu/auth"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lfucache"
 	"github.com/arazmj/gerdu/lrucache"
+	"github.com/arazmj/gerdu/namespace"
+	"github.com/arazmj/gerdu/ratelimit"
+	"github.com/arazmj/gerdu/slrucache"
+	"github.com/arazmj/gerdu/tracing"
 	"github.com/gorilla/mux"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestIndexHandler(t *testing.T) {
@@ -98,3 +110,746 @@ func TestIndexHandler(t *testing.T) {
 		})
 	}
 }
+
+type ttlRecorder struct {
+	*lrucache.LRUCache
+	ttl time.Duration
+}
+
+func (c *ttlRecorder) PutWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	c.ttl = ttl
+	return c.Put(key, value)
+}
+
+func TestRouter(t *testing.T) {
+	gerdu := &ttlRecorder{LRUCache: lrucache.NewCache(100)}
+	router := newRouter(gerdu)
+	tests := []struct {
+		name             string
+		r                *http.Request
+		expectedStatus   int
+		expectedResponse string
+		expectedTTL      time.Duration
+	}{
+		{
+			name:           "Miss",
+			r:              httptest.NewRequest(http.MethodGet, "/cache/1", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Create",
+			r:              httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("1")),
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:             "Hit",
+			r:                httptest.NewRequest(http.MethodGet, "/cache/1", nil),
+			expectedStatus:   http.StatusOK,
+			expectedResponse: "1",
+		},
+		{
+			name:           "Overwrite",
+			r:              httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("one")),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "Hit overwritten",
+			r:                httptest.NewRequest(http.MethodGet, "/cache/1", nil),
+			expectedStatus:   http.StatusOK,
+			expectedResponse: "one",
+		},
+		{
+			name:           "Create with TTL query",
+			r:              httptest.NewRequest(http.MethodPut, "/cache/2?ttl=30s", strings.NewReader("2")),
+			expectedStatus: http.StatusCreated,
+			expectedTTL:    30 * time.Second,
+		},
+		{
+			name: "Overwrite with TTL header",
+			r: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPut, "/cache/2", strings.NewReader("two"))
+				r.Header.Set("X-TTL", "1m")
+				return r
+			}(),
+			expectedStatus: http.StatusOK,
+			expectedTTL:    time.Minute,
+		},
+		{
+			name:           "Invalid TTL",
+			r:              httptest.NewRequest(http.MethodPut, "/cache/3?ttl=soon", strings.NewReader("3")),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Delete",
+			r:              httptest.NewRequest(http.MethodDelete, "/cache/1", nil),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Miss deleted",
+			r:              httptest.NewRequest(http.MethodGet, "/cache/1", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Delete missing",
+			r:              httptest.NewRequest(http.MethodDelete, "/cache/1", nil),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		gerdu.ttl = 0
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, test.r)
+		if w.Code != test.expectedStatus {
+			t.Errorf("%s: expected status code %d, got %d", test.name, test.expectedStatus, w.Code)
+		}
+		if body := w.Body.String(); body != test.expectedResponse {
+			t.Errorf("%s: expected response %q, got %q", test.name, test.expectedResponse, body)
+		}
+		if gerdu.ttl != test.expectedTTL {
+			t.Errorf("%s: expected ttl %v, got %v", test.name, test.expectedTTL, gerdu.ttl)
+		}
+	}
+}
+
+func TestRouter_TTLNotSupported(t *testing.T) {
+	router := newRouter(slrucache.NewCache(100))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/cache/1?ttl=1s", strings.NewReader("1")))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+func TestRouter_TruncatedBody(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("1"))
+	r.ContentLength = 10
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRouter_BodyTooLarge(t *testing.T) {
+	defer func(size int64) { MaxBodySize = size }(MaxBodySize)
+	MaxBodySize = 4
+	router := newRouter(lrucache.NewCache(100))
+	r := httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("1"))
+	r.ContentLength = 1e12
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d for a forged length, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	r = httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("12345"))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d for an unknown length, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	r = httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("1234"))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected a body at the limit to be served, got %d", w.Code)
+	}
+}
+
+func TestRouter_OrderedKeys(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	gerdu.Put("1", "1")
+	gerdu.Put("2", "2")
+	router := newRouter(gerdu)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/keys", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `["2","1"]` {
+		t.Errorf("Expected the keys in the eviction order, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRouter_Dump(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	gerdu.Put("1", "1")
+	router := newRouter(gerdu)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dump", nil))
+	entries, err := cache.ReadDump(w.Body)
+	if w.Code != http.StatusOK || err != nil || len(entries) != 1 || entries[0].Value != "1" {
+		t.Errorf("Expected the dump of the entry, got %d %v %v", w.Code, entries, err)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter(slrucache.NewCache(100)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dump", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+// namespacedCache is a cache with a registry of namespaces
+type namespacedCache struct {
+	*lrucache.LRUCache
+	*namespace.Registry
+}
+
+// Close closes the namespaces and the default cache
+func (c namespacedCache) Close() error {
+	err := c.Registry.Close()
+	if e := c.LRUCache.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func TestRouter_Namespaces(t *testing.T) {
+	gerdu := namespacedCache{
+		LRUCache: lrucache.NewCache(100),
+		Registry: namespace.NewRegistry(func(name string, config cache.NamespaceConfig) (cache.UnImplementedCache, error) {
+			return lrucache.NewCache(config.Capacity, cache.WithDefaultTTL(config.TTL)), nil
+		}, cache.NamespaceConfig{Capacity: 100, Policy: "lru"}),
+	}
+	router := newRouter(gerdu)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve(http.MethodPut, "/cache/users/1", "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing namespace to be %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve(http.MethodPut, "/namespaces/users", ""); w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+	if w := serve(http.MethodPut, "/namespaces/users", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected an existing namespace to be %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := serve(http.MethodPut, "/namespaces/a%20b", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid name to be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve(http.MethodPut, "/namespaces/sessions", `{"capacity":"1KB","ttl":"10m"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+	if w := serve(http.MethodPut, "/namespaces/sessions", `{"capacity":"2KB"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected another config to be %d, got %d", http.StatusConflict, w.Code)
+	}
+	if w := serve(http.MethodPut, "/namespaces/orders", `{"ttl":"soon"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid config to be %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := serve(http.MethodGet, "/namespaces/sessions", ""); !strings.Contains(w.Body.String(),
+		`"config":{"capacity":"1.00KB","policy":"lru","ttl":"10m0s"}`) {
+		t.Errorf("Expected the config of the namespace, got %s", w.Body.String())
+	}
+	if w := serve(http.MethodPut, "/cache/users/1", "alice"); w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+	if w := serve(http.MethodGet, "/cache/users/1", ""); w.Code != http.StatusOK || w.Body.String() != "alice" {
+		t.Errorf("Expected alice, got %d %s", w.Code, w.Body.String())
+	}
+	for _, target := range []string{"/cache/sessions/1", "/cache/1"} {
+		if w := serve(http.MethodGet, target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be isolated from the namespace, got %d", target, w.Code)
+		}
+	}
+	if w := serve(http.MethodGet, "/namespaces", ""); strings.TrimSpace(w.Body.String()) != `["sessions","users"]` {
+		t.Errorf("Expected the names of the namespaces, got %s", w.Body.String())
+	}
+	if w := serve(http.MethodGet, "/namespaces/users", ""); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"entries":1`) {
+		t.Errorf("Expected the stats of the namespace, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodDelete, "/cache/users/1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := serve(http.MethodDelete, "/namespaces/users", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := serve(http.MethodGet, "/namespaces/users", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a dropped namespace to be %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w := serve(http.MethodGet, "/cache/users/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+	w := httptest.NewRecorder()
+	newRouter(lrucache.NewCache(100)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/namespaces", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	router.Use(authHandler(auth.Tokens{"reader": auth.ReadOnly, "writer": auth.ReadWrite}))
+	tests := []struct {
+		method string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodPut, "unknown", http.StatusUnauthorized},
+		{http.MethodPut, "reader", http.StatusForbidden},
+		{http.MethodPut, "writer", http.StatusCreated},
+		{http.MethodGet, "reader", http.StatusOK},
+		{http.MethodDelete, "reader", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/cache/1", strings.NewReader("1"))
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("Expected status code %d for %s of %q, got %d", test.status, test.method, test.token, w.Code)
+		}
+		if test.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected the challenge of the bearer scheme")
+		}
+	}
+	for token, status := range map[string]int{"reader": http.StatusForbidden, "writer": http.StatusOK} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("Expected status code %d of the admin stats for %q, got %d", status, token, w.Code)
+		}
+	}
+}
+
+func TestAuthHandler_NodeRoutes(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	router.Use(authHandler(auth.Tokens{"writer": auth.ReadWrite, "node": auth.Node}))
+	for _, path := range []string{"/join", "/leave", "/raft/apply"} {
+		for token, forbidden := range map[string]bool{"writer": true, "node": false} {
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if (w.Code == http.StatusForbidden) != forbidden {
+				t.Errorf("Expected %s of %q to be forbidden %v, got %d", path, token, forbidden, w.Code)
+			}
+		}
+	}
+}
+
+func TestHTTPStartAuth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := l.Addr().String()
+	l.Close()
+	gerdu := lrucache.NewCache(100)
+	gerdu.Put("1", "1")
+	shutdown := HTTPStartAuth(host, nil, nil, nil, gerdu)
+	resp, err := http.Get("http://" + host + "/cache/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("Expected the server to shut down but got %v", err)
+	}
+	if conn, err := net.Dial("tcp", host); err == nil {
+		conn.Close()
+		t.Errorf("Expected the server to stop accepting the connections")
+	}
+}
+
+func TestLimitHandler(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	router.Use(limitHandler(ratelimit.NewGuard(ratelimit.Limits{Rate: 1, Burst: 2})))
+	get := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cache/1", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := get("10.0.0.1:1234"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected the burst to be served, got %d", w.Code)
+		}
+	}
+	if w := get("10.0.0.1:1234"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w := get("10.0.0.2:1234"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected another client not to be limited, got %d", w.Code)
+	}
+}
+
+func TestTokenLimitHandler(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	router.Use(authHandler(auth.Tokens{"reader": auth.ReadOnly}))
+	router.Use(tokenLimitHandler(ratelimit.NewGuard(ratelimit.Limits{TokenRate: 1, TokenBurst: 1})))
+	get := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/cache/1", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	for i := 0; i < 3; i++ {
+		if code := get("unknown"); code != http.StatusUnauthorized {
+			t.Errorf("Expected an unknown token to be unauthenticated before it is limited, got %d", code)
+		}
+	}
+	if code := get("reader"); code != http.StatusNotFound {
+		t.Errorf("Expected the burst of the token to be served, got %d", code)
+	}
+	if code := get("reader"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, code)
+	}
+}
+
+func TestRejectConn(t *testing.T) {
+	guard := ratelimit.NewGuard(ratelimit.Limits{MaxConns: 1})
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := guard.Listener(inner, func(conn net.Conn) {
+		rejectConn(conn, nil)
+	})
+	server := &http.Server{Handler: newRouter(lrucache.NewCache(100))}
+	go server.Serve(listener)
+	defer server.Close()
+
+	held, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	for guard.Conns() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get("http://" + inner.Addr().String() + "/cache/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d over the limit of the connections, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+}
+
+func TestRouter_Batch(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	router := newRouter(gerdu)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/batch", strings.NewReader(`{"1":"a","2":"b"}`)))
+	if w.Code != http.StatusOK || gerdu.Len() != 2 {
+		t.Errorf("Expected 2 entries to be put, got %d %d", w.Code, gerdu.Len())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/batch?key=1&key=3&key=2", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"1":"a","2":"b"}` {
+		t.Errorf("Expected the found entries, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/batch?key=1&key=3", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "1" || gerdu.Len() != 1 {
+		t.Errorf("Expected 1 deleted key, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/batch", strings.NewReader(`["1"]`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRouter_IfMatch(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/cache/1", strings.NewReader("a")))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/1", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected an ETag, got %d %q", w.Code, etag)
+	}
+
+	put := func(key, value, match string) int {
+		r := httptest.NewRequest(http.MethodPut, "/cache/"+key, strings.NewReader(value))
+		r.Header.Set("If-Match", match)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := put("1", "b", etag); code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := put("1", "c", etag); code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d, got %d", http.StatusPreconditionFailed, code)
+	}
+	if code := put("2", "c", etag); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/1", nil))
+	if w.Body.String() != "b" || w.Header().Get("ETag") == etag {
+		t.Errorf("Expected b with a new ETag, got %s %s", w.Body.String(), w.Header().Get("ETag"))
+	}
+}
+
+func TestRouter_Keys(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	gerdu.Put("1", "1")
+	router := newRouter(gerdu)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `["1"]` {
+		t.Errorf("Expected the keys, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys?cursor=0&count=5", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"cursor":0,"keys":["1"]}` {
+		t.Errorf("Expected the scanned keys, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys?cursor=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRouter_Admin(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	for _, key := range []string{"1", "2", "3"} {
+		gerdu.Put(key, key)
+	}
+	gerdu.Get("1")
+	gerdu.Get("4")
+	router := newRouter(gerdu)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	expected := `{"role":"standalone","hits":1,"misses":1,"hit_ratio":0.5,"adds":3,"deletes":0,"evictions":0,"size":3,"entries":3}`
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != expected {
+		t.Errorf("Expected the stats %s, got %d %s", expected, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/keys?limit=2", nil))
+	var keys []string
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil || len(keys) != 2 {
+		t.Errorf("Expected 2 keys, got %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/keys", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil || len(keys) != 3 {
+		t.Errorf("Expected all the keys within the default limit, got %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/keys?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/capacity", strings.NewReader("2B")))
+	if w.Code != http.StatusOK || gerdu.Len() != 2 {
+		t.Errorf("Expected the cache to shrink to 2 entries, got %d %d", w.Code, gerdu.Len())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/capacity", strings.NewReader("lots")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/flush", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "2" || gerdu.Len() != 0 {
+		t.Errorf("Expected 2 flushed entries, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRouter_NotInCluster(t *testing.T) {
+	router := newRouter(lrucache.NewCache(100))
+	for _, test := range []struct{ method, path, body string }{
+		{http.MethodGet, "/members", ""},
+		{http.MethodPost, "/join", `{"id":"node1","addr":"127.0.0.1:12001"}`},
+		{http.MethodPost, "/leave", "node1"},
+		{http.MethodPost, "/raft/apply", `{"op":"put","key":"1","value":"1"}`},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(test.body)))
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusNotImplemented, test.path, w.Code)
+		}
+	}
+}
+
+func TestRouter_DeleteByPattern(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	gerdu.Put("user:1:session", "a")
+	gerdu.Put("user:2:session", "b")
+	gerdu.Put("user:1:profile", "c")
+	router := newRouter(gerdu)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/keys?match=user:1:*", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `["user:1:profile","user:1:session"]` &&
+		strings.TrimSpace(w.Body.String()) != `["user:1:session","user:1:profile"]` {
+		t.Errorf("Expected the keys of user 1, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/keys?match=user:*:session", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "2" || gerdu.Len() != 1 {
+		t.Errorf("Expected 2 deleted keys, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/keys?match=user[", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRouter_TTL(t *testing.T) {
+	gerdu := lrucache.NewCache(100)
+	gerdu.Put("1", "1")
+	router := newRouter(gerdu)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ttl/1", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "-1" {
+		t.Errorf("Expected no expiration, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/ttl/1?ttl=1h", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if ttl, ok := gerdu.TTL("1"); !ok || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected a ttl of an hour, got %v %t", ttl, ok)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/ttl/1", nil))
+	if ttl, ok := gerdu.TTL("1"); w.Code != http.StatusOK || !ok || ttl != 0 {
+		t.Errorf("Expected the expiration to be removed, got %d %v", w.Code, ttl)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/ttl/2?ttl=1h", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/ttl/1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRouter_Tracing(t *testing.T) {
+	tracer := &tracing.MemoryTracer{}
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.Noop)
+	router := newRouter(lrucache.NewCache(10))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/missing", nil))
+
+	var request *tracing.MemorySpan
+	for _, span := range tracer.Spans() {
+		if span.Parent == nil && strings.HasPrefix(span.Name, "HTTP") {
+			request = span
+		}
+	}
+	if request == nil || request.Name != "HTTP GET /cache/{key}" || !request.Ended {
+		t.Fatalf("Expected the span of the request but got %+v", tracer.Spans())
+	}
+	if request.Attributes["http.status_code"] != int64(http.StatusNotFound) ||
+		request.Attributes["http.route"] != "/cache/{key}" {
+		t.Errorf("Expected the route and the status of the request but got %v", request.Attributes)
+	}
+	var get *tracing.MemorySpan
+	for _, span := range tracer.Spans() {
+		if span.Name == "cache.Get" {
+			get = span
+		}
+	}
+	if get == nil || get.Parent != request || !get.Ended || get.Attributes[tracing.HitAttribute] != false {
+		t.Errorf("Expected the span of the get to be a child of the span of the request but got %+v", get)
+	}
+}
+
+func TestRouter_Events(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := cache.WithClock(func() time.Time { return now })
+	testEvents(t, lrucache.NewCache(10, clock), func() { now = now.Add(time.Minute) })
+	testEvents(t, lfucache.NewCache(10, clock), func() { now = now.Add(time.Minute) })
+}
+
+// testEvents streams the events of the cache, advance moves its clock past
+// the TTL of the entries
+func testEvents(t *testing.T, c interface {
+	cache.UnImplementedCache
+	cache.TTLCache
+}, advance func()) {
+	server := httptest.NewServer(newRouter(c))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/events?prefix=user:&prefix=session:")
+	if err != nil {
+		t.Fatalf("Failed to open the event stream %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream but got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	c.Put("user:1", "a")
+	c.Put("other", "b")
+	c.PutWithTTL("session:1", "c", time.Minute)
+	c.Delete("user:1")
+	advance()
+	c.Get("session:1")
+
+	expected := []string{
+		"event: set", `data: {"type":"set","key":"user:1","value":"a"}`, "",
+		"event: set", `data: {"type":"set","key":"session:1","value":"c"}`, "",
+		"event: delete", `data: {"type":"delete","key":"user:1"}`, "",
+		"event: expire", `data: {"type":"expire","key":"session:1"}`, "",
+	}
+	reader := bufio.NewReader(res.Body)
+	for _, line := range expected {
+		got, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read the event stream %v", err)
+		}
+		if got = strings.TrimSuffix(got, "\n"); got != line {
+			t.Errorf("Expected %q but got %q", line, got)
+		}
+	}
+}
+
+func TestRouter_EventsNotImplemented(t *testing.T) {
+	router := newRouter(slrucache.NewCache(10))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected %d but got %d", http.StatusNotImplemented, w.Code)
+	}
+}
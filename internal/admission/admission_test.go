@@ -0,0 +1,53 @@
+package admission
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	s := NewSketch(100)
+	for i := 0; i < 10; i++ {
+		s.Increment("hot")
+	}
+	s.Increment("cold")
+	if hot, cold := s.Estimate("hot"), s.Estimate("cold"); hot < 10 || cold >= hot {
+		t.Errorf("Expected hot to be estimated above cold but got %d and %d", hot, cold)
+	}
+	for i := 0; i < 89; i++ {
+		if s.Increment(strconv.Itoa(i)) != (i == 88) {
+			t.Errorf("Expected the sketch to be reset only after the window")
+		}
+	}
+	if hot := s.Estimate("hot"); hot != 5 {
+		t.Errorf("Expected the counters to be halved but got %d", hot)
+	}
+}
+
+func TestDoorkeeper(t *testing.T) {
+	d := NewDoorkeeper(100, 0.01, 0)
+	if d.Seen("1") || !d.Seen("1") {
+		t.Errorf("Expected a key to be seen only after it is added")
+	}
+	if d.Contains("2") || d.Contains("2") {
+		t.Errorf("Expected contains not to add the key")
+	}
+	d.Miss("2")
+	if d.Admit("2") {
+		t.Errorf("Expected a put after the first miss not to be admitted")
+	}
+	if !d.Admit("2") {
+		t.Errorf("Expected a key seen before to be admitted")
+	}
+	d.Reset()
+	if d.Contains("1") {
+		t.Errorf("Expected the filter to be cleared")
+	}
+
+	d = NewDoorkeeper(100, 0.01, 2)
+	d.Seen("1")
+	d.Seen("2")
+	if d.Contains("1") {
+		t.Errorf("Expected the filter to be cleared after the window")
+	}
+}
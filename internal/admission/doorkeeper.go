@@ -0,0 +1,105 @@
+package admission
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Doorkeeper is a bloom filter of the keys seen since it was last cleared,
+// a new key is only admitted once it is seen a second time so the keys that
+// are accessed once never enter the cache. A missed get and the put of the
+// same key that follows it are a single access, missed keeps whether the
+// missed keys were seen before the miss until they are put
+type Doorkeeper struct {
+	bits      []uint64
+	hashes    int
+	additions int
+	window    int
+	missed    map[string]bool
+}
+
+// NewDoorkeeper returns a filter sized for n keys with the false positive
+// rate fpRate, it is cleared after window keys are added unless window is zero
+func NewDoorkeeper(n int, fpRate float64, window int) *Doorkeeper {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(m / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &Doorkeeper{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: hashes,
+		window: window,
+		missed: map[string]bool{},
+	}
+}
+
+// indexes derives the bit indexes of the key from two halves of its hash
+func (d *Doorkeeper) indexes(key string) []uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	size := uint64(len(d.bits) * 64)
+	indexes := make([]uint64, d.hashes)
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % size
+	}
+	return indexes
+}
+
+// Seen adds the key to the filter and reports whether it was already in it
+func (d *Doorkeeper) Seen(key string) bool {
+	found := true
+	for _, index := range d.indexes(key) {
+		if d.bits[index/64]&(1<<(index%64)) == 0 {
+			found = false
+			d.bits[index/64] |= 1 << (index % 64)
+		}
+	}
+	if !found {
+		d.additions++
+		if d.window > 0 && d.additions >= d.window {
+			d.Reset()
+		}
+	}
+	return found
+}
+
+// Contains reports whether the key is in the filter without adding it
+func (d *Doorkeeper) Contains(key string) bool {
+	for _, index := range d.indexes(key) {
+		if d.bits[index/64]&(1<<(index%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Miss records a missed get of the key
+func (d *Doorkeeper) Miss(key string) {
+	d.missed[key] = d.Seen(key)
+}
+
+// Admit records the put of a new key and reports whether the key was seen
+// before, a put that follows a missed get is admitted if the key was seen
+// before the miss
+func (d *Doorkeeper) Admit(key string) bool {
+	if seen, ok := d.missed[key]; ok {
+		delete(d.missed, key)
+		return seen
+	}
+	return d.Seen(key)
+}
+
+// Reset clears the filter
+func (d *Doorkeeper) Reset() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+	d.additions = 0
+	d.missed = map[string]bool{}
+}
@@ -0,0 +1,83 @@
+// Package admission holds the count-min sketch and the doorkeeper the
+// frequency based caches decide with whether a new key is admitted
+package admission
+
+import "hash/fnv"
+
+const sketchDepth = 4
+
+// Sketch is a count-min sketch that estimates the access frequency of the
+// keys in a fixed amount of memory. After window increments all the counters
+// are halved, so the estimates age and recent accesses weigh more than old ones
+type Sketch struct {
+	counters  [sketchDepth][]uint32
+	mask      uint64
+	additions int
+	window    int
+}
+
+// NewSketch Sketch constructor, a window below 1 is 1
+func NewSketch(window int) *Sketch {
+	if window < 1 {
+		window = 1
+	}
+	width := 1
+	for width < window {
+		width <<= 1
+	}
+	s := &Sketch{
+		mask:   uint64(width - 1),
+		window: window,
+	}
+	for i := range s.counters {
+		s.counters[i] = make([]uint32, width)
+	}
+	return s
+}
+
+// indexes derives one counter index per row from two halves of the key hash
+func (s *Sketch) indexes(key string) (indexes [sketchDepth]uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return indexes
+}
+
+// Increment records an access of the key and reports whether the
+// counters were halved because the window is reached
+func (s *Sketch) Increment(key string) (reset bool) {
+	for i, index := range s.indexes(key) {
+		s.counters[i][index]++
+	}
+	s.additions++
+	if s.additions >= s.window {
+		s.Reset()
+		return true
+	}
+	return false
+}
+
+// Estimate returns the smallest counter of the key, an upper bound of its frequency
+func (s *Sketch) Estimate(key string) uint32 {
+	var min uint32
+	for i, index := range s.indexes(key) {
+		if count := s.counters[i][index]; i == 0 || count < min {
+			min = count
+		}
+	}
+	return min
+}
+
+// Reset halves all the counters
+func (s *Sketch) Reset() {
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] >>= 1
+		}
+	}
+	s.additions >>= 1
+}
@@ -0,0 +1,1149 @@
+// Package cachetest holds the behaviour tests the caches share, each cache
+// runs them against its own constructor
+package cachetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Cache is the API the shared tests exercise
+type Cache interface {
+	cache.UnImplementedCache
+	cache.TTLCache
+	cache.ExpiryCache
+	cache.ExpireCache
+	cache.AbsentCache
+	cache.ReplaceCache
+	cache.Closer
+	cache.Sizer
+	cache.StatsCache
+	cache.PeekCache
+	cache.MetaCache
+	cache.ScanCache
+	cache.PatternCache
+	cache.PinCache
+	cache.BatchCache
+	cache.CounterCache
+	cache.AppendCache
+	cache.VersionCache
+	cache.DumpCache
+	cache.EventCache
+	EvictN(n int) (count int)
+	TrimTo(target bytesize.ByteSize) (count int)
+	Snapshot() (raft.FSMSnapshot, error)
+	Restore(closer io.ReadCloser) error
+}
+
+// Constructor returns a cache of the capacity with the options
+type Constructor func(capacity bytesize.ByteSize, opts ...cache.Option) Cache
+
+var tests = []struct {
+	name string
+	run  func(t *testing.T, newCache Constructor)
+}{
+	{"DefaultTTL", testDefaultTTL},
+	{"OnEvict", testOnEvict},
+	{"EvictionListener", testEvictionListener},
+	{"Incr", testIncr},
+	{"IncrConcurrent", testIncrConcurrent},
+	{"Cas", testCas},
+	{"Append", testAppend},
+	{"Scan", testScan},
+	{"DeleteByPattern", testDeleteByPattern},
+	{"Peek", testPeek},
+	{"Touch", testTouch},
+	{"Expire", testExpire},
+	{"ActiveExpiration", testActiveExpiration},
+	{"Stats", testStats},
+	{"CostFunc", testCostFunc},
+	{"MetricsName", testMetricsName},
+	{"MaxEntries", testMaxEntries},
+	{"Meta", testMeta},
+	{"ZeroCapacity", testZeroCapacity},
+	{"EmptyValue", testEmptyValue},
+	{"ExportImport", testExportImport},
+	{"SnapshotRestore", testSnapshotRestore},
+	{"ImportTTL", testImportTTL},
+	{"EvictN", testEvictN},
+	{"TrimTo", testTrimTo},
+	{"Recorder", testRecorder},
+	{"Latency", testLatency},
+	{"SlidingTTL", testSlidingTTL},
+	{"FixedTTL", testFixedTTL},
+	{"GetWithExpiry", testGetWithExpiry},
+	{"Pin", testPin},
+	{"PutIfAbsent", testPutIfAbsent},
+	{"Replace", testReplace},
+	{"PutIfAbsentRace", testPutIfAbsentRace},
+	{"TTLJitter", testTTLJitter},
+	{"PutWithTTL", testPutWithTTL},
+	{"Subscribe", testSubscribe},
+	{"SubscribeExpired", testSubscribeExpired},
+	{"SubscribeDropped", testSubscribeDropped},
+}
+
+// Run runs the shared tests against the caches newCache returns
+func Run(t *testing.T, newCache Constructor) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newCache)
+		})
+	}
+}
+
+func testDefaultTTL(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	var evicted []string
+	c := newCache(10,
+		cache.WithDefaultTTL(time.Minute),
+		cache.WithClock(func() time.Time { return now }),
+		cache.WithOnEvict(func(key, value string) { evicted = append(evicted, key) }))
+	c.Put("1", "1")
+	now = now.Add(30 * time.Second)
+	c.Put("2", "2")
+	if _, ok := c.Get("1"); !ok {
+		t.Errorf("Expected 1 to live before its TTL")
+	}
+
+	now = now.Add(30 * time.Second)
+	if value, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be expired but got %s", value)
+	}
+	if _, ok := c.Get("2"); !ok {
+		t.Errorf("Expected 2 to live before its TTL")
+	}
+	if c.Len() != 1 || c.Stats().Size != 1 {
+		t.Errorf("Expected the expired entry to be removed")
+	}
+	if len(evicted) != 1 || evicted[0] != "1" {
+		t.Errorf("Expected OnEvict with 1 but got %v", evicted)
+	}
+
+	c.Put("2", "2")
+	now = now.Add(45 * time.Second)
+	if _, ok := c.Get("2"); !ok {
+		t.Errorf("Expected the overwrite to reset the TTL of 2")
+	}
+}
+
+func testOnEvict(t *testing.T, newCache Constructor) {
+	evicted := map[string]string{}
+	c := newCache(2, cache.WithOnEvict(func(key, value string) {
+		evicted[key] = value
+	}))
+	c.Put("1", "a")
+	c.Put("2", "b")
+	c.Put("3", "c")
+	if len(evicted) != 1 || evicted["1"] != "a" {
+		t.Errorf("Expected OnEvict with 1 but got %v", evicted)
+	}
+	c.Delete("2")
+	if len(evicted) != 1 {
+		t.Errorf("Expected no OnEvict for a deleted key but got %v", evicted)
+	}
+}
+
+func testEvictionListener(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	reasons := map[string]cache.EvictionReason{}
+	c := newCache(2,
+		cache.WithClock(func() time.Time { return now }),
+		cache.WithEvictionListener(cache.EvictionListenerFunc(
+			func(key, value string, reason cache.EvictionReason) {
+				if key != value {
+					t.Errorf("Expected the value %s of %s but got %s", key, key, value)
+				}
+				reasons[key] = reason
+			})))
+	c.Put("1", "1")
+	c.PutWithTTL("2", "2", time.Minute)
+	c.Put("3", "3")
+	if reason, ok := reasons["1"]; !ok || reason != cache.EvictionCapacity {
+		t.Errorf("Expected 1 to be evicted for the capacity but got %v", reasons)
+	}
+	now = now.Add(2 * time.Minute)
+	c.Get("2")
+	if reason, ok := reasons["2"]; !ok || reason != cache.EvictionExpired {
+		t.Errorf("Expected 2 to be expired but got %v", reasons)
+	}
+	c.Delete("3")
+	if reason, ok := reasons["3"]; !ok || reason != cache.EvictionDeleted {
+		t.Errorf("Expected 3 to be deleted but got %v", reasons)
+	}
+	if len(reasons) != 3 {
+		t.Errorf("Expected 3 notifications but got %v", reasons)
+	}
+}
+
+func testIncr(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(100, cache.WithClock(func() time.Time { return now }))
+	if value, err := c.Incr("n", 5); err != nil || value != 5 {
+		t.Errorf("Expected 5 but got %d %v", value, err)
+	}
+	if value, err := c.Decr("n", 7); err != nil || value != -2 {
+		t.Errorf("Expected -2 but got %d %v", value, err)
+	}
+	if value, ok := c.Get("n"); !ok || value != "-2" {
+		t.Errorf("Expected the value -2 but got %s %t", value, ok)
+	}
+
+	c.Put("s", "abc")
+	if _, err := c.Incr("s", 1); err != cache.ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger but got %v", err)
+	}
+	c.Put("max", "9223372036854775807")
+	if _, err := c.Incr("max", 1); err != cache.ErrOverflow {
+		t.Errorf("Expected ErrOverflow but got %v", err)
+	}
+
+	c.PutWithTTL("ttl", "1", time.Minute)
+	now = now.Add(30 * time.Second)
+	c.Incr("ttl", 1)
+	now = now.Add(31 * time.Second)
+	if value, ok := c.Get("ttl"); ok {
+		t.Errorf("Expected Incr to keep the expiration but got %s", value)
+	}
+	if value, err := c.Incr("ttl", 1); err != nil || value != 1 {
+		t.Errorf("Expected an expired counter to count from zero but got %d %v", value, err)
+	}
+}
+
+func testIncrConcurrent(t *testing.T, newCache Constructor) {
+	c := newCache(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, _ = c.Incr("n", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := c.Get("n"); value != "8000" {
+		t.Errorf("Expected 8000 but got %s", value)
+	}
+}
+
+func testCas(t *testing.T, newCache Constructor) {
+	c := newCache(100)
+	c.Put("a", "1")
+	value, version, ok := c.Gets("a")
+	if !ok || value != "1" || version == 0 {
+		t.Fatalf("Expected 1 with a version but got %s %d %t", value, version, ok)
+	}
+	c.Put("b", "1")
+	if _, other, _ := c.Gets("b"); other <= version {
+		t.Errorf("Expected the versions to increase but got %d after %d", other, version)
+	}
+
+	if err := c.Cas("a", "2", version); err != nil {
+		t.Errorf("Expected the swap to succeed but got %v", err)
+	}
+	if err := c.Cas("a", "3", version); err != cache.ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch but got %v", err)
+	}
+	if value, _ := c.Get("a"); value != "2" {
+		t.Errorf("Expected 2 but got %s", value)
+	}
+	_, latest, _ := c.Gets("a")
+	if latest <= version {
+		t.Errorf("Expected the swap to change the version but got %d", latest)
+	}
+	c.Put("a", "4")
+	if err := c.Cas("a", "5", latest); err != cache.ErrVersionMismatch {
+		t.Errorf("Expected a put to change the version but got %v", err)
+	}
+	if err := c.Cas("c", "1", 0); err != cache.ErrNotFound {
+		t.Errorf("Expected ErrNotFound but got %v", err)
+	}
+}
+
+func testAppend(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(100, cache.WithClock(func() time.Time { return now }))
+	if c.Append("a", "x") || c.Prepend("a", "x") {
+		t.Errorf("Expected no append to a missing key")
+	}
+	c.PutWithTTL("a", "bc", time.Minute)
+	if !c.Append("a", "de") || !c.Prepend("a", "a") {
+		t.Errorf("Expected the append and the prepend to succeed")
+	}
+	if value, _ := c.Get("a"); value != "abcde" {
+		t.Errorf("Expected abcde but got %s", value)
+	}
+	if c.Stats().Size != 5 {
+		t.Errorf("Expected the size 5 but got %d", c.Stats().Size)
+	}
+	now = now.Add(time.Minute)
+	if value, ok := c.Get("a"); ok {
+		t.Errorf("Expected the append to keep the expiration but got %s", value)
+	}
+}
+
+func testScan(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(1000, cache.WithClock(func() time.Time { return now }))
+	for i := 0; i < 100; i++ {
+		c.Put(strconv.Itoa(i), "v")
+	}
+	c.PutWithTTL("expired", "v", time.Second)
+	c.Delete("0")
+	now = now.Add(time.Second)
+
+	if keys := c.Keys(); len(keys) != 99 {
+		t.Errorf("Expected 99 keys but got %d", len(keys))
+	}
+	seen := map[string]bool{}
+	var cursor uint64
+	for {
+		keys, next := c.Scan(cursor, 10)
+		for _, key := range keys {
+			if seen[key] {
+				t.Errorf("Expected %s to be returned once", key)
+			}
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 99 || seen["0"] || seen["expired"] {
+		t.Errorf("Expected the 99 live keys but got %d", len(seen))
+	}
+}
+
+func testDeleteByPattern(t *testing.T, newCache Constructor) {
+	c := newCache(100)
+	c.Put("user:1:session", "a")
+	c.Put("user:2:session", "b")
+	c.Put("user:1:profile", "c")
+	if count, err := c.DeleteByPattern("user:*:session"); err != nil || count != 2 {
+		t.Errorf("Expected 2 deleted keys but got %d %v", count, err)
+	}
+	if _, ok := c.Get("user:1:profile"); !ok || c.Len() != 1 {
+		t.Errorf("Expected only user:1:profile to be left")
+	}
+	if _, err := c.DeleteByPattern("user:[1"); err != cache.ErrBadPattern {
+		t.Errorf("Expected ErrBadPattern but got %v", err)
+	}
+}
+
+func testPeek(t *testing.T, newCache Constructor) {
+	c := newCache(2)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	if value, ok := c.Peek("1"); !ok || value != "1" {
+		t.Errorf("Expected 1 but got %s %t", value, ok)
+	}
+	if _, ok := c.Peek("3"); ok {
+		t.Errorf("Expected no value for 3")
+	}
+	c.Put("3", "3")
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected Peek not to protect 1 from the eviction")
+	}
+}
+
+func testTouch(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(2, cache.WithClock(func() time.Time { return now }))
+	c.PutWithTTL("1", "1", time.Minute)
+	c.Put("2", "2")
+	now = now.Add(50 * time.Second)
+	if !c.Touch("1") {
+		t.Errorf("Expected 1 to be touched")
+	}
+	if c.Touch("3") {
+		t.Errorf("Expected no touch of a missing key")
+	}
+	c.Put("3", "3")
+	if _, ok := c.Peek("2"); ok {
+		t.Errorf("Expected 2 to be evicted instead of the touched 1")
+	}
+	now = now.Add(50 * time.Second)
+	if _, ok := c.Peek("1"); !ok {
+		t.Errorf("Expected Touch to restart the time to live of 1")
+	}
+}
+
+func testExpire(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(100, cache.WithClock(func() time.Time { return now }))
+	c.Put("1", "1")
+	if ttl, ok := c.TTL("1"); !ok || ttl != 0 {
+		t.Errorf("Expected no expiration but got %v %t", ttl, ok)
+	}
+	if !c.Expire("1", time.Minute) {
+		t.Errorf("Expected the expiration of 1 to be set")
+	}
+	now = now.Add(20 * time.Second)
+	if ttl, ok := c.TTL("1"); !ok || ttl != 40*time.Second {
+		t.Errorf("Expected 40s but got %v %t", ttl, ok)
+	}
+	if !c.Persist("1") {
+		t.Errorf("Expected the expiration of 1 to be removed")
+	}
+	now = now.Add(time.Hour)
+	if _, ok := c.Get("1"); !ok {
+		t.Errorf("Expected 1 not to expire after Persist")
+	}
+	if c.Expire("2", time.Minute) || c.Persist("2") {
+		t.Errorf("Expected no expiration of a missing key")
+	}
+	if _, ok := c.TTL("2"); ok {
+		t.Errorf("Expected no ttl of a missing key")
+	}
+	if !c.Expire("1", 0) {
+		t.Errorf("Expected 1 to expire at once")
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be expired")
+	}
+}
+
+func testActiveExpiration(t *testing.T, newCache Constructor) {
+	now := time.Now().UnixNano()
+	clock := func() time.Time { return time.Unix(0, atomic.LoadInt64(&now)) }
+	c := newCache(1000, cache.WithClock(clock), cache.WithActiveExpiration(time.Millisecond, 2))
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		c.PutWithTTL(strconv.Itoa(i), "v", time.Minute)
+	}
+	c.Put("kept", "v")
+	atomic.AddInt64(&now, int64(2*time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected the expired entries to be reclaimed, %d entries are left", c.Len())
+	}
+	if _, ok := c.Peek("kept"); !ok {
+		t.Errorf("Expected the entry without a ttl to be kept")
+	}
+}
+
+func testStats(t *testing.T, newCache Constructor) {
+	c := newCache(3)
+	other := newCache(3)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	c.Put("3", "3")
+	c.Put("4", "4")
+	c.Get("4")
+	c.Get("1")
+	c.Delete("4")
+	other.Put("1", "1")
+	expected := cache.Stats{Hits: 1, Misses: 1, Adds: 4, Deletes: 2, Evictions: 1, Size: 2, Entries: 2}
+	if stats := c.Stats(); stats != expected {
+		t.Errorf("Expected %+v but got %+v", expected, stats)
+	}
+	if stats := other.Stats(); stats.Adds != 1 || stats.Entries != 1 || stats.Hits != 0 {
+		t.Errorf("Expected the stats of the other cache only but got %+v", stats)
+	}
+}
+
+func testCostFunc(t *testing.T, newCache Constructor) {
+	c := newCache(10, cache.WithCostFunc(func(key, value string) bytesize.ByteSize {
+		return bytesize.ByteSize(len(key) + len(value))
+	}))
+	c.Put("12345", "1")
+	c.Put("abc", "1")
+	if c.Stats().Size != 10 {
+		t.Errorf("Expected size 10 but got %d", c.Stats().Size)
+	}
+	c.Put("x", "1")
+	if _, ok := c.Get("12345"); ok {
+		t.Errorf("Expected 12345 to be evicted by its cost")
+	}
+	if c.Stats().Size != 6 {
+		t.Errorf("Expected size 6 but got %d", c.Stats().Size)
+	}
+}
+
+func testMetricsName(t *testing.T, newCache Constructor) {
+	c := newCache(10, cache.WithMetricsName("cachetest-test"))
+	other := newCache(10, cache.WithMetricsName("cachetest-test"))
+	c.Put("1", "1")
+	c.Get("1")
+	c.Get("2")
+	counter := func(name string) float64 {
+		return metrics.Value(metrics.Prometheus.Counter("cachetest-test", name).(prometheus.Counter))
+	}
+	if counter(metrics.HitsMetric) != 1 || counter(metrics.MissesMetric) != 1 || counter(metrics.AddsMetric) != 1 {
+		t.Errorf("Expected the named counters to be updated")
+	}
+	if stats := other.Stats(); stats.Hits != 0 || stats.Misses != 0 || stats.Adds != 0 {
+		t.Errorf("Expected the stats of another cache of the same name to be left untouched, got %+v", stats)
+	}
+}
+
+func testMaxEntries(t *testing.T, newCache Constructor) {
+	c := newCache(100, cache.WithMaxEntries(2))
+	c.Put("1", "1")
+	c.Put("2", "2")
+	c.Put("3", "3")
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries but got %d", c.Len())
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted")
+	}
+}
+
+func testMeta(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(10, cache.WithClock(func() time.Time { return now }))
+	created := now
+	c.Put("1", "abc")
+	now = now.Add(time.Second)
+	c.Get("1")
+	now = now.Add(time.Second)
+	c.Get("1")
+
+	meta, ok := c.Meta("1")
+	if !ok {
+		t.Fatalf("Expected the metadata of 1")
+	}
+	if meta.AccessCount != 3 || meta.Size != 3 {
+		t.Errorf("Expected 3 accesses of size 3 but got %+v", meta)
+	}
+	if !meta.CreatedAt.Equal(created) || !meta.LastAccess.Equal(now) {
+		t.Errorf("Expected the timestamps to advance but got %+v", meta)
+	}
+
+	now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		c.Meta("1")
+	}
+	if again, _ := c.Meta("1"); again != meta {
+		t.Errorf("Expected Meta to leave the entry unchanged but got %+v", again)
+	}
+
+	c.Put("1", "ab")
+	meta, _ = c.Meta("1")
+	if meta.AccessCount != 4 || meta.Size != 2 || !meta.CreatedAt.Equal(created) || !meta.LastAccess.Equal(now) {
+		t.Errorf("Expected the overwrite to count as an access but got %+v", meta)
+	}
+	if _, ok := c.Meta("2"); ok {
+		t.Errorf("Expected no metadata for 2")
+	}
+}
+
+func testZeroCapacity(t *testing.T, newCache Constructor) {
+	c := newCache(0)
+	if c.Put("1", "1") {
+		t.Errorf("Expected the put to be rejected")
+	}
+	if c.Put("2", "") {
+		t.Errorf("Expected the put of an empty value to be rejected")
+	}
+	c.PutMulti(map[string]string{"3": "3"})
+	if c.Len() != 0 || c.Stats().Size != 0 {
+		t.Errorf("Expected no entry but got %d", c.Len())
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 not to be found")
+	}
+}
+
+func testEmptyValue(t *testing.T, newCache Constructor) {
+	c := newCache(2)
+	if !c.Put("1", "") {
+		t.Errorf("Expected the empty value to be created")
+	}
+	if value, ok := c.Get("1"); !ok || value != "" {
+		t.Errorf("Expected the empty value but got %s %t", value, ok)
+	}
+	c.Put("2", "")
+	c.Put("3", "")
+	if c.Len() != 2 || c.Stats().Size != 2 {
+		t.Errorf("Expected the empty values to be evicted by the capacity but got %d entries", c.Len())
+	}
+
+	c = newCache(100, cache.WithMaxEntries(2))
+	c.Put("1", "")
+	c.Put("2", "")
+	c.Put("3", "")
+	if c.Len() != 2 {
+		t.Errorf("Expected the empty values to be evicted by the entry count but got %d entries", c.Len())
+	}
+}
+
+func testExportImport(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	clock := cache.WithClock(func() time.Time { return now })
+	c := newCache(3, clock)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	c.Put("3", "3")
+	c.Get("1")
+	c.Get("1")
+	c.Get("3")
+
+	var buf bytes.Buffer
+	if err := c.Export(&buf); err != nil {
+		t.Fatalf("Export failed %v", err)
+	}
+	imported := newCache(3, clock)
+	if err := imported.Import(&buf); err != nil {
+		t.Fatalf("Import failed %v", err)
+	}
+	for _, key := range []string{"1", "2", "3"} {
+		meta, ok := c.Meta(key)
+		importedMeta, importedOk := imported.Meta(key)
+		if !ok || !importedOk || meta.AccessCount != importedMeta.AccessCount {
+			t.Errorf("Expected %s with %d accesses but got %+v", key, meta.AccessCount, importedMeta)
+		}
+	}
+
+	// 2 is the first to be evicted in both
+	c.Put("4", "4")
+	imported.Put("4", "4")
+	if _, ok := imported.Meta("2"); ok {
+		t.Errorf("Expected the import to restore the eviction order")
+	}
+	if _, ok := c.Meta("2"); ok {
+		t.Errorf("Expected 2 to be evicted")
+	}
+}
+
+func testSnapshotRestore(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	clock := cache.WithClock(func() time.Time { return now })
+	c := newCache(3, clock)
+	c.Put("1", "1")
+	c.PutWithTTL("2", "2", time.Minute)
+	c.Put("3", "3")
+	c.Get("1")
+	c.Get("1")
+	c.Get("3")
+
+	snapshot, err := c.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed %v", err)
+	}
+	sink := &bufferSink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatalf("Persist failed %v", err)
+	}
+	restored := newCache(3, clock)
+	if err := restored.Restore(ioutil.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	for _, key := range []string{"1", "2", "3"} {
+		meta, ok := c.Meta(key)
+		restoredMeta, restoredOk := restored.Meta(key)
+		if !ok || !restoredOk || meta.AccessCount != restoredMeta.AccessCount {
+			t.Errorf("Expected %s with %d accesses but got %+v", key, meta.AccessCount, restoredMeta)
+		}
+	}
+	if ttl, ok := restored.TTL("2"); !ok || ttl != time.Minute {
+		t.Errorf("Expected 2 to expire in a minute but got %v", ttl)
+	}
+
+	if stats := restored.Stats(); stats.Adds != 3 || stats.Entries != 3 {
+		t.Errorf("Expected 3 adds and entries but got %+v", stats)
+	}
+
+	// 2 is the first to be evicted in both
+	restored.Put("4", "4")
+	if _, ok := restored.Meta("2"); ok {
+		t.Errorf("Expected the snapshot to restore the eviction order")
+	}
+
+	legacy := newCache(3, clock)
+	if err := legacy.Restore(ioutil.NopCloser(strings.NewReader(`{"a":"1"}`))); err != nil {
+		t.Fatalf("Expected the snapshot without metadata to be restored but got %v", err)
+	}
+	if value, ok := legacy.Get("a"); !ok || value != "1" {
+		t.Errorf("Expected a to be 1 but got %s", value)
+	}
+}
+
+func testImportTTL(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	clock := cache.WithClock(func() time.Time { return now })
+	c := newCache(10, clock, cache.WithDefaultTTL(time.Minute))
+	c.Put("1", "1")
+	now = now.Add(30 * time.Second)
+	c.Put("2", "2")
+
+	var buf bytes.Buffer
+	if err := c.Export(&buf); err != nil {
+		t.Fatalf("Export failed %v", err)
+	}
+	now = now.Add(40 * time.Second)
+	imported := newCache(10, clock)
+	if err := imported.Import(&buf); err != nil {
+		t.Fatalf("Import failed %v", err)
+	}
+	if _, ok := imported.Meta("2"); imported.Len() != 1 || !ok {
+		t.Errorf("Expected only 2 to be imported before its expiration")
+	}
+	now = now.Add(20 * time.Second)
+	if _, ok := imported.Meta("2"); ok {
+		t.Errorf("Expected 2 to keep its expiration time")
+	}
+
+	if err := imported.Import(strings.NewReader(`{"version": 2, "entries": []}`)); err == nil {
+		t.Errorf("Expected an unsupported version error")
+	}
+}
+
+func testEvictN(t *testing.T, newCache Constructor) {
+	c := newCache(10)
+	c.Put("1", "1")
+	c.Put("2", "22")
+	c.Put("3", "333")
+	c.Get("1")
+	deletes := c.Stats().Deletes
+
+	if n := c.EvictN(1); n != 1 {
+		t.Errorf("Expected 1 eviction but got %d", n)
+	}
+	if _, ok := c.Meta("2"); ok || c.Len() != 2 || c.Stats().Size != 4 {
+		t.Errorf("Expected 2 to be evicted but got %d entries of size %d", c.Len(), c.Stats().Size)
+	}
+	if n := c.EvictN(5); n != 2 {
+		t.Errorf("Expected 2 evictions but got %d", n)
+	}
+	if c.Len() != 0 || c.Stats().Size != 0 || c.Stats().Deletes != deletes+3 || c.Stats().Evictions != 3 {
+		t.Errorf("Expected an empty cache but got %d entries of size %d", c.Len(), c.Stats().Size)
+	}
+	if n := c.EvictN(1); n != 0 {
+		t.Errorf("Expected no eviction but got %d", n)
+	}
+	c.Put("4", "4")
+	if value, ok := c.Get("4"); !ok || value != "4" {
+		t.Errorf("Expected the cache to be usable after EvictN")
+	}
+}
+
+func testTrimTo(t *testing.T, newCache Constructor) {
+	c := newCache(6)
+	c.Put("1", "1")
+	c.Put("2", "22")
+	c.Put("3", "333")
+	c.Get("2")
+	c.Get("3")
+
+	if n := c.TrimTo(6); n != 0 {
+		t.Errorf("Expected no eviction for a cache within the target but got %d", n)
+	}
+	if n := c.TrimTo(3); n != 2 {
+		t.Errorf("Expected 2 evictions but got %d", n)
+	}
+	if _, ok := c.Meta("3"); !ok || c.Len() != 1 || c.Stats().Size != 3 {
+		t.Errorf("Expected only 3 to survive but got %d entries of size %d", c.Len(), c.Stats().Size)
+	}
+	if n := c.TrimTo(0); n != 1 || c.Stats().Size != 0 {
+		t.Errorf("Expected the cache to be emptied")
+	}
+}
+
+func testRecorder(t *testing.T, newCache Constructor) {
+	r := metrics.NewExpvarRecorder("cachetest-recorder")
+	c := newCache(5, cache.WithMetricsName("a"), cache.WithRecorder(r))
+	c.Put("1", "1")
+	c.Get("1")
+	c.Get("2")
+	c.Put("2", "2222")
+	c.Put("3", "333")
+	value := func(name string) float64 {
+		return r.Counter("a", name).(*expvar.Float).Value()
+	}
+	if value(metrics.HitsMetric) != 1 || value(metrics.MissesMetric) != 1 || value(metrics.AddsMetric) != 3 {
+		t.Errorf("Expected the counters to be reported to the recorder")
+	}
+	if value(metrics.EvictionsMetric) != float64(c.Stats().Evictions) || c.Stats().Evictions == 0 {
+		t.Errorf("Expected the evictions to be reported to the recorder")
+	}
+	if size := value(metrics.SizeMetric); size != float64(c.Stats().Size) {
+		t.Errorf("Expected the size gauge %d but got %f", c.Stats().Size, size)
+	}
+}
+
+func testLatency(t *testing.T, newCache Constructor) {
+	r := metrics.NewExpvarRecorder("cachetest-latency")
+	c := newCache(10, cache.WithMetricsName("a"), cache.WithRecorder(r))
+	c.Put("1", "1")
+	c.PutWithTTL("2", "2", time.Minute)
+	c.Get("1")
+	c.Delete("1")
+	count := func(name string) float64 {
+		var histogram map[string]float64
+		if err := json.Unmarshal([]byte(r.Histogram("a", name).(expvar.Var).String()), &histogram); err != nil {
+			t.Fatal(err)
+		}
+		return histogram["count"]
+	}
+	if count(metrics.PutMetric) != 2 || count(metrics.GetMetric) != 1 || count(metrics.DeleteMetric) != 1 {
+		t.Errorf("Expected 2 puts, 1 get and 1 delete to be timed")
+	}
+	if count(metrics.LockWaitMetric) < 4 {
+		t.Errorf("Expected the lock waits to be timed but got %v", count(metrics.LockWaitMetric))
+	}
+}
+
+func testSlidingTTL(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(10,
+		cache.WithDefaultTTL(time.Minute),
+		cache.WithSlidingTTL(),
+		cache.WithClock(func() time.Time { return now }))
+	c.Put("busy", "1")
+	c.Put("idle", "1")
+	for i := 0; i < 5; i++ {
+		now = now.Add(30 * time.Second)
+		if _, ok := c.Get("busy"); !ok {
+			t.Fatalf("Expected the accessed entry to survive past its original expiry")
+		}
+	}
+	if _, ok := c.Get("idle"); ok {
+		t.Errorf("Expected the idle entry to expire")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("busy"); ok {
+		t.Errorf("Expected the entry to expire once it is idle")
+	}
+}
+
+func testFixedTTL(t *testing.T, newCache Constructor) {
+	now := time.Now()
+	c := newCache(10,
+		cache.WithDefaultTTL(time.Minute),
+		cache.WithClock(func() time.Time { return now }))
+	c.Put("1", "1")
+	now = now.Add(30 * time.Second)
+	c.Get("1")
+	now = now.Add(30 * time.Second)
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected the hit not to extend the fixed expiry")
+	}
+}
+
+func testGetWithExpiry(t *testing.T, newCache Constructor) {
+	now := time.Unix(1000, 0)
+	clock := cache.WithClock(func() time.Time { return now })
+	c := newCache(10, clock)
+	c.Put("1", "1")
+	if value, expiresAt, ok := c.GetWithExpiry("1"); !ok || value != "1" || !expiresAt.IsZero() {
+		t.Errorf("Expected 1 without expiry but got %s %v %t", value, expiresAt, ok)
+	}
+	if meta, _ := c.Meta("1"); meta.AccessCount != 2 {
+		t.Errorf("Expected the hit to be counted but got %d", meta.AccessCount)
+	}
+
+	c = newCache(10, clock, cache.WithDefaultTTL(time.Minute))
+	c.Put("1", "1")
+	now = now.Add(30 * time.Second)
+	if value, expiresAt, ok := c.GetWithExpiry("1"); !ok || value != "1" || !expiresAt.Equal(now.Add(30*time.Second)) {
+		t.Errorf("Expected 1 expiring in 30s but got %s %v %t", value, expiresAt, ok)
+	}
+	now = now.Add(30 * time.Second)
+	if _, expiresAt, ok := c.GetWithExpiry("1"); ok || !expiresAt.IsZero() {
+		t.Errorf("Expected the expired 1 to be a miss")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected the expired 1 to be deleted")
+	}
+	if _, _, ok := c.GetWithExpiry("2"); ok {
+		t.Errorf("Expected 2 to be a miss")
+	}
+}
+
+func testPin(t *testing.T, newCache Constructor) {
+	c := newCache(4)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Put(key, "x")
+	}
+	if !c.Pin("a") || !c.Pin("b") || !c.Pin("c") {
+		t.Fatalf("Expected the pins to fit in the capacity")
+	}
+	if c.Pin("missing") {
+		t.Errorf("Expected a missing key not to be pinned")
+	}
+	c.Put("e", "x")
+	c.Put("f", "x")
+	for _, key := range []string{"a", "b", "c", "f"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+	if c.EvictN(4) != 1 || c.Len() != 3 {
+		t.Errorf("Expected only the unpinned entry to be evicted")
+	}
+
+	c.Put("d", "x")
+	if !c.Pin("d") {
+		t.Errorf("Expected d to fill the capacity with the pins")
+	}
+	c.Put("e", "x")
+	if _, ok := c.Get("e"); ok || c.Len() != 4 {
+		t.Errorf("Expected no room for e besides the pinned entries")
+	}
+	if !c.Unpin("a") || c.Unpin("a") {
+		t.Errorf("Expected a to be unpinned once")
+	}
+	c.Put("e", "x")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected the unpinned a to be evicted")
+	}
+
+	c.Put("b", "xxx")
+	if c.Stats().Size != 5 {
+		t.Errorf("Expected the grown pinned value to overshoot the capacity but got %d", c.Stats().Size)
+	}
+	if !c.Unpin("c") || c.Pin("c") {
+		t.Errorf("Expected a pin that does not fit in the capacity to be rejected")
+	}
+	if !c.Delete("b") || !c.Pin("c") {
+		t.Errorf("Expected a deleted pinned entry to release its pin")
+	}
+}
+
+func testPutIfAbsent(t *testing.T, newCache Constructor) {
+	now := time.Unix(1000, 0)
+	c := newCache(10, cache.WithClock(func() time.Time { return now }))
+	if !c.PutIfAbsent("1", "1") {
+		t.Errorf("Expected the new key 1 to be set")
+	}
+	meta, _ := c.Meta("1")
+	now = now.Add(time.Second)
+	if c.PutIfAbsent("1", "2") {
+		t.Errorf("Expected the existing key 1 not to be set")
+	}
+	if after, _ := c.Meta("1"); after != meta {
+		t.Errorf("Expected the existing entry to be untouched")
+	}
+	if value, _ := c.Get("1"); value != "1" {
+		t.Errorf("Expected 1 to keep its value but got %s", value)
+	}
+
+	if !c.PutIfAbsentWithTTL("2", "2", time.Minute) {
+		t.Errorf("Expected the new key 2 to be set")
+	}
+	if _, expiresAt, _ := c.GetWithExpiry("2"); !expiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected 2 to expire in a minute but got %v", expiresAt)
+	}
+	now = now.Add(time.Minute)
+	if !c.PutIfAbsentWithTTL("2", "3", 0) {
+		t.Errorf("Expected the expired key 2 to be replaced")
+	}
+	if value, expiresAt, _ := c.GetWithExpiry("2"); value != "3" || !expiresAt.IsZero() {
+		t.Errorf("Expected 3 without expiry but got %s %v", value, expiresAt)
+	}
+}
+
+func testReplace(t *testing.T, newCache Constructor) {
+	now := time.Unix(1000, 0)
+	c := newCache(10, cache.WithClock(func() time.Time { return now }))
+	if c.Replace("1", "1") {
+		t.Errorf("Expected the missing key 1 not to be replaced")
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected the missing key 1 to stay missing")
+	}
+	c.PutWithTTL("1", "1", time.Minute)
+	if !c.ReplaceWithTTL("1", "2", time.Hour) {
+		t.Errorf("Expected the key 1 to be replaced")
+	}
+	if value, expiresAt, _ := c.GetWithExpiry("1"); value != "2" || !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected 2 expiring in an hour but got %s %v", value, expiresAt)
+	}
+	if !c.Replace("1", "3") {
+		t.Errorf("Expected the key 1 to be replaced")
+	}
+	if _, expiresAt, _ := c.GetWithExpiry("1"); !expiresAt.IsZero() {
+		t.Errorf("Expected 1 to never expire but got %v", expiresAt)
+	}
+	c.PutWithTTL("2", "2", time.Minute)
+	now = now.Add(time.Minute)
+	if c.Replace("2", "3") {
+		t.Errorf("Expected the expired key 2 not to be replaced")
+	}
+}
+
+func testPutIfAbsentRace(t *testing.T, newCache Constructor) {
+	c := newCache(100)
+	var wg sync.WaitGroup
+	var winners int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if c.PutIfAbsent("lock", strconv.Itoa(i)) {
+				atomic.AddInt32(&winners, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if winners != 1 {
+		t.Errorf("Expected exactly one winner but got %d", winners)
+	}
+}
+
+func testTTLJitter(t *testing.T, newCache Constructor) {
+	now := time.Unix(1000, 0)
+	r := rand.New(rand.NewSource(1))
+	c := newCache(1000, cache.WithClock(func() time.Time { return now }),
+		cache.WithTTLJitter(0.2), cache.WithRand(r.Float64))
+	earliest, latest := time.Time{}, time.Time{}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		c.PutIfAbsentWithTTL(key, "x", 100*time.Second)
+		_, expires, ok := c.GetWithExpiry(key)
+		if !ok {
+			t.Fatalf("Expected %s to be found", key)
+		}
+		if expires.Before(now.Add(80*time.Second)) || expires.After(now.Add(120*time.Second)) {
+			t.Errorf("Expected %s to expire within 20%% of the TTL but got %v", key, expires.Sub(now))
+		}
+		if earliest.IsZero() || expires.Before(earliest) {
+			earliest = expires
+		}
+		if expires.After(latest) {
+			latest = expires
+		}
+	}
+	if latest.Sub(earliest) < 30*time.Second {
+		t.Errorf("Expected the expirations to spread but got %v", latest.Sub(earliest))
+	}
+	c = newCache(1000, cache.WithClock(func() time.Time { return now }))
+	c.PutIfAbsentWithTTL("a", "x", 100*time.Second)
+	if _, expires, _ := c.GetWithExpiry("a"); !expires.Equal(now.Add(100 * time.Second)) {
+		t.Errorf("Expected no jitter by default but got %v", expires.Sub(now))
+	}
+}
+
+func testPutWithTTL(t *testing.T, newCache Constructor) {
+	now := time.Unix(1000, 0)
+	c := newCache(100, cache.WithClock(func() time.Time { return now }))
+	if !c.PutWithTTL("a", "aaa", time.Minute) || c.PutWithTTL("a", "aaaa", time.Second) {
+		t.Errorf("Expected a to be created and then updated")
+	}
+	c.Put("b", "b")
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected a to expire with the TTL of the last put")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Errorf("Expected b without a TTL not to expire")
+	}
+	if c.Len() != 1 || c.Stats().Size != 1 {
+		t.Errorf("Expected the size of a to be reclaimed but got %d entries of size %d", c.Len(), c.Stats().Size)
+	}
+	c.PutWithTTL("b", "b", time.Second)
+	c.Put("b", "bb")
+	now = now.Add(time.Hour)
+	if _, ok := c.Get("b"); !ok {
+		t.Errorf("Expected the put without a TTL to clear the TTL of b")
+	}
+}
+
+func testSubscribe(t *testing.T, newCache Constructor) {
+	c := newCache(2)
+	events := c.Subscribe()
+	c.Put("1", "a")
+	c.Put("1", "b")
+	c.Delete("1")
+	c.Put("2", "2")
+	c.Put("3", "3")
+	c.Put("4", "4")
+
+	expected := []cache.Event{
+		{Type: cache.EventPut, Key: "1", Value: "a"},
+		{Type: cache.EventPut, Key: "1", Value: "b"},
+		{Type: cache.EventDelete, Key: "1"},
+		{Type: cache.EventPut, Key: "2", Value: "2"},
+		{Type: cache.EventPut, Key: "3", Value: "3"},
+		{Type: cache.EventPut, Key: "4", Value: "4"},
+		{Type: cache.EventEvict, Key: "2"},
+	}
+	received := make([]cache.Event, len(expected))
+	for i, e := range expected {
+		select {
+		case received[i] = <-events:
+		default:
+			t.Fatalf("Expected %+v but got no event", e)
+		}
+	}
+	// a cache may evict 2 before or after it inserts 4
+	last := len(received) - 1
+	if received[last-1] == expected[last] {
+		received[last-1], received[last] = received[last], received[last-1]
+	}
+	for i, e := range expected {
+		if received[i] != e {
+			t.Errorf("Expected %+v but got %+v", e, received[i])
+		}
+	}
+
+	c.Unsubscribe(events)
+	if _, ok := <-events; ok {
+		t.Errorf("Expected the channel to be closed")
+	}
+	c.Put("5", "5")
+}
+
+func testSubscribeExpired(t *testing.T, newCache Constructor) {
+	now := time.Unix(1000, 0)
+	c := newCache(10, cache.WithClock(func() time.Time { return now }))
+	c.PutWithTTL("1", "1", time.Minute)
+	events := c.Subscribe()
+	now = now.Add(time.Minute)
+	c.Get("1")
+	if event := <-events; event != (cache.Event{Type: cache.EventExpire, Key: "1"}) {
+		t.Errorf("Expected 1 to expire but got %+v", event)
+	}
+}
+
+func testSubscribeDropped(t *testing.T, newCache Constructor) {
+	c := newCache(1000)
+	slow := c.Subscribe()
+	fast := c.Subscribe()
+	dropped := metrics.Value(metrics.Dropped)
+	received := 0
+	for i := 0; i < 200; i++ {
+		c.Put(strconv.Itoa(i), "x")
+		<-fast
+		received++
+	}
+	if received != 200 {
+		t.Errorf("Expected the fast subscriber to receive every event")
+	}
+	if len(slow) != cap(slow) || metrics.Value(metrics.Dropped) != dropped+float64(200-cap(slow)) {
+		t.Errorf("Expected the events of the slow subscriber to be dropped")
+	}
+}
+
+// bufferSink is a raft snapshot sink in memory
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "buffer" }
+func (s *bufferSink) Close() error  { return nil }
+func (s *bufferSink) Cancel() error { return nil }
@@ -0,0 +1,168 @@
+// Package keyedcache implements an LRU cache keyed by 16-byte binary
+// identifiers, such as UUIDs or hashes, without converting them to strings
+package keyedcache
+
+import (
+	"encoding/binary"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/inhies/go-bytesize"
+	"sync"
+)
+
+// Key is a 16-byte binary identifier, it is a comparable struct so it is
+// used as a map key without an allocation
+type Key struct {
+	Hi uint64
+	Lo uint64
+}
+
+// NewKey returns the key of the first 16 bytes of b, shorter
+// identifiers are padded with zeros
+func NewKey(b []byte) Key {
+	var buf [16]byte
+	copy(buf[:], b)
+	return Key{
+		Hi: binary.BigEndian.Uint64(buf[:8]),
+		Lo: binary.BigEndian.Uint64(buf[8:]),
+	}
+}
+
+// Bytes returns the 16 bytes of the key
+func (k Key) Bytes() [16]byte {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], k.Hi)
+	binary.BigEndian.PutUint64(b[8:], k.Lo)
+	return b
+}
+
+// node is an entry of the linked list, the dlinklist nodes are keyed by
+// strings so the list is duplicated for the binary keys
+type node struct {
+	next  *node
+	prev  *node
+	key   Key
+	value string
+}
+
+// KeyedCache LRU cache keyed by Key, the most recently used entries are
+// at the head of the list and the least recently used at the tail
+type KeyedCache struct {
+	sync.Mutex
+	node     map[Key]*node
+	head     node
+	tail     node
+	capacity bytesize.ByteSize
+	size     bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache KeyedCache constructor
+func NewCache(capacity bytesize.ByteSize) *KeyedCache {
+	c := &KeyedCache{
+		node:     map[Key]*node{},
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+	c.head.next = &c.tail
+	c.tail.prev = &c.head
+	return c
+}
+
+// cost sizes an entry by the length of its value, at least a byte
+func cost(value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+func (c *KeyedCache) addNode(n *node) {
+	n.prev = &c.head
+	n.next = c.head.next
+	c.head.next.prev = n
+	c.head.next = n
+}
+
+func (c *KeyedCache) removeNode(n *node) {
+	n.prev.next = n.next
+	n.next.prev = n.prev
+}
+
+// Get returns the value for the key
+func (c *KeyedCache) Get(key Key) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	n, ok := c.node[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.removeNode(n)
+	c.addNode(n)
+	return n.value, true
+}
+
+// Put updates or inserts the entry and evicts the least
+// recently used entries if the size is over the capacity
+func (c *KeyedCache) Put(key Key, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	if c.capacity == 0 {
+		return false
+	}
+	n, ok := c.node[key]
+	if ok {
+		c.removeNode(n)
+		c.size -= cost(n.value)
+	} else {
+		c.counters.Adds.Inc()
+		n = &node{key: key}
+		c.node[key] = n
+	}
+	c.addNode(n)
+	n.value = value
+	c.size += cost(value)
+	for c.size > c.capacity {
+		tail := c.tail.prev
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		c.remove(tail)
+	}
+	return !ok
+}
+
+// Delete deletes the key from the cache
+func (c *KeyedCache) Delete(key Key) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	n, ok := c.node[key]
+	if !ok {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	c.remove(n)
+	return true
+}
+
+// remove unlinks the node and reclaims its size, the caller must hold the lock
+func (c *KeyedCache) remove(n *node) {
+	c.removeNode(n)
+	c.size -= cost(n.value)
+	delete(c.node, n.key)
+}
+
+// Len returns the number of entries in the cache
+func (c *KeyedCache) Len() int {
+	defer c.Unlock()
+	c.Lock()
+	return len(c.node)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *KeyedCache) Stats() cache.Stats {
+	defer c.Unlock()
+	c.Lock()
+	return cache.NewStats(c.counters, c.size, len(c.node))
+}
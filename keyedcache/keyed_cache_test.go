@@ -0,0 +1,75 @@
+package keyedcache
+
+import (
+	"encoding/binary"
+	"github.com/arazmj/gerdu/lrucache"
+	"testing"
+)
+
+func TestKeyedCache(t *testing.T) {
+	c := NewCache(2)
+	a, b, d := Key{Hi: 1, Lo: 2}, Key{Hi: 1, Lo: 3}, Key{Hi: 2, Lo: 2}
+	if !c.Put(a, "a") || !c.Put(b, "b") {
+		t.Errorf("Expected the new keys to be created")
+	}
+	c.Get(a)
+	c.Put(d, "d")
+	if _, ok := c.Get(b); ok {
+		t.Errorf("Expected the least recently used b to be evicted")
+	}
+	if value, ok := c.Get(a); !ok || value != "a" {
+		t.Errorf("Expected a but got %s", value)
+	}
+	if c.Put(a, "x") {
+		t.Errorf("Expected a to be updated")
+	}
+	if !c.Delete(d) || c.Delete(d) || c.Len() != 1 {
+		t.Errorf("Expected d to be deleted once")
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	id := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	key := NewKey(id)
+	if key.Hi != 0x0001020304050607 || key.Lo != 0x08090a0b0c0d0e0f {
+		t.Errorf("Expected the big endian halves but got %x %x", key.Hi, key.Lo)
+	}
+	if bytes := key.Bytes(); string(bytes[:]) != string(id) {
+		t.Errorf("Expected the bytes back but got %v", bytes)
+	}
+	if NewKey([]byte{1}) != (Key{Hi: 1 << 56}) {
+		t.Errorf("Expected a short identifier to be padded")
+	}
+}
+
+func ids(n int) [][]byte {
+	ids := make([][]byte, n)
+	for i := range ids {
+		ids[i] = make([]byte, 16)
+		binary.BigEndian.PutUint64(ids[i][8:], uint64(i))
+	}
+	return ids
+}
+
+// the benchmarks put more keys than the capacity so every put inserts
+// a new entry, the string keys are copied to the heap to be kept
+
+func BenchmarkKeyedCache_Put(b *testing.B) {
+	c := NewCache(100)
+	keys := ids(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Put(NewKey(keys[i%1000]), "x")
+	}
+}
+
+func BenchmarkLRUCache_PutBytes(b *testing.B) {
+	c := lrucache.NewCache(100)
+	keys := ids(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Put(string(keys[i%1000]), "x")
+	}
+}
@@ -2,36 +2,194 @@
 package lfucache
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"github.com/arazmj/gerdu/cache"
 	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/internal/admission"
 	"github.com/arazmj/gerdu/metrics"
+	"github.com/arazmj/gerdu/tracing"
 	"github.com/hashicorp/raft"
 	"github.com/inhies/go-bytesize"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hitsBuffer is the number of nodes with pending hits that are queued for settle
+	hitsBuffer = 1024
+	// windowFactor is the number of accesses per entry of the capacity in
+	// the default admission window
+	windowFactor = 10
+	// maxWindowEntries bounds the number of entries the default admission
+	// window is sized for
+	maxWindowEntries = 1 << 20
 )
 
 // LFUCache data structure
 type LFUCache struct {
-	sync.RWMutex
+	*cache.RWMutex
 	cache.UnImplementedCache
 	size     bytesize.ByteSize
 	capacity bytesize.ByteSize
 	node     map[string]*dlinklist.Node
 	freq     map[int]*dlinklist.DLinkedList
+	pinned   map[string]*dlinklist.Node
 	minFreq  int
+	sketch   *admission.Sketch
+	keeper   *admission.Doorkeeper
+	hits     chan *dlinklist.Node
+	events   cache.Broadcaster
+	pending  []cache.Event
+	overflow int32
+	options  *cache.Options
+	counters *metrics.Counters
+	gauges   *metrics.Gauges
+	latency  *metrics.Latencies
+	snapshot *cache.Snapshotter
+	wheel    *cache.TimerWheel
+	sweeper  *cache.Sweeper
+	loads    cache.Group
+	version  uint64
+	keys     cache.KeyIndex
+	done     chan struct{}
+	stopped  chan struct{}
+	closed   sync.Once
+	shut     bool
 }
 
+// the cache must satisfy the interfaces used by the servers
+var (
+	_ cache.UnImplementedCache = (*LFUCache)(nil)
+	_ cache.Sizer              = (*LFUCache)(nil)
+	_ cache.MetaCache          = (*LFUCache)(nil)
+	_ cache.ExpiryCache        = (*LFUCache)(nil)
+	_ cache.OrderedCache       = (*LFUCache)(nil)
+	_ cache.PinCache           = (*LFUCache)(nil)
+	_ cache.AbsentCache        = (*LFUCache)(nil)
+	_ cache.Closer             = (*LFUCache)(nil)
+	_ cache.Checker            = (*LFUCache)(nil)
+	_ cache.TTLCache           = (*LFUCache)(nil)
+	_ cache.LoaderCache        = (*LFUCache)(nil)
+	_ cache.DumpCache          = (*LFUCache)(nil)
+	_ cache.BatchCache         = (*LFUCache)(nil)
+	_ cache.CounterCache       = (*LFUCache)(nil)
+	_ cache.VersionCache       = (*LFUCache)(nil)
+	_ cache.AppendCache        = (*LFUCache)(nil)
+	_ cache.ScanCache          = (*LFUCache)(nil)
+	_ cache.PatternCache       = (*LFUCache)(nil)
+	_ cache.PeekCache          = (*LFUCache)(nil)
+	_ cache.ExpireCache        = (*LFUCache)(nil)
+	_ cache.StatsCache         = (*LFUCache)(nil)
+	_ cache.ReplaceCache       = (*LFUCache)(nil)
+	_ cache.ResizeCache        = (*LFUCache)(nil)
+	_ cache.EventCache         = (*LFUCache)(nil)
+	_ cache.ContextCache       = (*LFUCache)(nil)
+)
+
 // NewCache LFUCache constructor
-func NewCache(capacity bytesize.ByteSize) *LFUCache {
-	return &LFUCache{
+func NewCache(capacity bytesize.ByteSize, opts ...cache.Option) *LFUCache {
+	options := cache.NewOptions(opts...)
+	c := &LFUCache{
+		RWMutex:  cache.NewRWMutex(),
 		size:     0,
 		capacity: capacity,
 		node:     map[string]*dlinklist.Node{},
 		freq:     map[int]*dlinklist.DLinkedList{},
+		pinned:   map[string]*dlinklist.Node{},
 		minFreq:  0,
+		options:  options,
+		counters: metrics.NewCounters(options.Recorder, options.MetricsName),
+		gauges:   metrics.NewGauges(options.Recorder, options.MetricsName),
+		latency:  metrics.NewLatencies(options.Recorder, options.MetricsName),
+	}
+	c.gauges.Capacity.Set(float64(capacity))
+	if options.DoorkeeperWindow > 0 && options.DoorkeeperFPRate > 0 && options.DoorkeeperFPRate < 1 {
+		c.keeper = admission.NewDoorkeeper(options.DoorkeeperWindow, options.DoorkeeperFPRate, options.DoorkeeperWindow)
+	}
+	if options.ExpirationTick > 0 {
+		c.wheel = cache.NewTimerWheel(options.ExpirationTick, options.Now())
+	}
+	c.snapshot = cache.StartSnapshots(options, c.Export, c.Import)
+	c.sweeper = cache.StartSweeper(options, c.sweep)
+	if options.DecayInterval > 0 && options.DecayFactor > 0 && options.DecayFactor < 1 {
+		c.done = make(chan struct{})
+		c.stopped = make(chan struct{})
+		go c.decayEvery(options.DecayInterval, options.DecayFactor)
+	}
+	return c
+}
+
+// decayEvery decays the frequencies every interval until the cache is closed
+func (c *LFUCache) decayEvery(interval time.Duration, factor float64) {
+	defer close(c.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.lock()
+			c.settle()
+			c.decay(factor)
+			c.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// NewCacheWithEntries LFUCache constructor that is limited by the number
+// of entries instead of their size, a WithMaxEntries in opts is overridden
+func NewCacheWithEntries(maxEntries int, opts ...cache.Option) *LFUCache {
+	opts = append(opts[:len(opts):len(opts)], cache.WithMaxEntries(maxEntries))
+	return NewCache(cache.Unlimited, opts...)
+}
+
+// NewCacheWithAdmission LFUCache constructor with TinyLFU admission, the
+// accesses are recorded in a frequency sketch and once the cache is full a
+// new key is only admitted if it is estimated to be accessed more often than
+// the entry it would evict. Every window accesses the sketch counters and the
+// frequencies of the entries are halved, so entries that were hot long ago
+// become evictable again. A window that is not positive defaults to 10
+// accesses per entry the cache holds
+func NewCacheWithAdmission(capacity bytesize.ByteSize, window int, opts ...cache.Option) *LFUCache {
+	c := NewCache(capacity, opts...)
+	if window <= 0 {
+		entries := maxWindowEntries
+		if c.options.MaxEntries > 0 && c.options.MaxEntries < entries {
+			entries = c.options.MaxEntries
+		}
+		// an entry takes at least a byte
+		if capacity < bytesize.ByteSize(entries) {
+			entries = int(capacity)
+		}
+		window = windowFactor * entries
+	}
+	c.sketch = admission.NewSketch(window)
+	if c.keeper != nil {
+		// the doorkeeper is cleared with the sketch
+		c.keeper = admission.NewDoorkeeper(window, c.options.DoorkeeperFPRate, 0)
+	}
+	return c
+}
+
+// NewCacheWithConcurrentReads LFUCache constructor whose hits only take the
+// read lock, so the reads of different keys do not serialize. A hit is
+// counted on the node and the node is moved to its new frequency bucket by
+// the next operation that takes the write lock, before it evicts. The
+// admission sketch and the sliding TTL update the cache on every hit so
+// they take the write lock regardless
+func NewCacheWithConcurrentReads(capacity bytesize.ByteSize, opts ...cache.Option) *LFUCache {
+	c := NewCache(capacity, opts...)
+	if !c.options.SlidingTTL {
+		c.hits = make(chan *dlinklist.Node, hitsBuffer)
 	}
+	return c
 }
 
 // This is a helper function that used in the following two cases:
@@ -56,34 +214,298 @@ func NewCache(capacity bytesize.ByteSize) *LFUCache {
 func (c *LFUCache) update(node *dlinklist.Node) {
 	freq := node.Freq
 
-	c.freq[freq].RemoveNode(node)
-	if v, _ := c.freq[freq]; c.minFreq == freq && v.Size() == 0 {
-		delete(c.freq, freq)
+	c.unlink(node)
+	if _, ok := c.freq[freq]; !ok && c.minFreq == freq {
 		c.minFreq++
 	}
 
 	node.Freq++
-	freq = node.Freq
-	if _, ok := c.freq[freq]; !ok {
-		c.freq[freq] = dlinklist.NewLinkedList()
+	c.link(node)
+}
+
+// lock locks the cache and records the time it waited for the lock
+func (c *LFUCache) lock() {
+	start := time.Now()
+	c.Lock()
+	c.latency.LockWait.Since(start)
+}
+
+// lockContext locks the cache like lock unless the context is done before
+// the lock is acquired
+func (c *LFUCache) lockContext(ctx context.Context) error {
+	start := time.Now()
+	if err := c.LockContext(ctx); err != nil {
+		return err
 	}
-	c.freq[freq].AddNode(node)
+	c.latency.LockWait.Since(start)
+	return nil
 }
 
 // Get through checking node[key], we can get the node in O(1) time.
 // Just performs update, then we can return the value of node.
+//
+// A cache created by NewCacheWithConcurrentReads only takes the read lock
+// for a hit, see hit
 func (c *LFUCache) Get(key string) (value string, ok bool) {
+	defer c.latency.Get.Since(time.Now())
+	if c.hits != nil {
+		c.RLock()
+		node, ok := c.node[key]
+		if ok && !node.Expired(c.options.Now()) {
+			value = node.Value
+			c.hit(node)
+			c.RUnlock()
+			c.counters.Hits.Inc()
+			return value, true
+		}
+		c.RUnlock()
+		if !ok && c.keeper == nil {
+			c.counters.Miss.Inc()
+			return "", false
+		}
+	}
+	defer c.publish()
 	defer c.Unlock()
-	c.Lock()
+	c.lock()
+	c.settle()
+	return c.get(key)
+}
+
+// GetContext returns the value for the key like Get in a span of the
+// context, unless the context is done before the lock is acquired
+func (c *LFUCache) GetContext(ctx context.Context, key string) (value string, ok bool, err error) {
+	span := tracing.StartOp(ctx, "cache.Get", key)
+	if err := c.lockContext(ctx); err != nil {
+		span.End()
+		return "", false, err
+	}
+	defer c.publish()
+	defer c.Unlock()
+	evictions := c.counters.Evictions.Count()
+	c.settle()
+	value, ok = c.get(key)
+	tracing.EndOp(span, ok, len(value), c.counters.Evictions.Count()-evictions)
+	return value, ok, nil
+}
+
+// GetWithExpiry returns the value for the key like Get and the time it
+// expires, the zero time if it never expires
+func (c *LFUCache) GetWithExpiry(key string) (value string, expiresAt time.Time, ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	if value, ok = c.get(key); !ok {
+		return "", time.Time{}, false
+	}
+	return value, c.node[key].Expires, true
+}
+
+// Gets returns the value for the key like Get and its version token
+func (c *LFUCache) Gets(key string) (value string, version uint64, ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	if value, ok = c.get(key); !ok {
+		return "", 0, false
+	}
+	return value, c.node[key].Version, true
+}
+
+// Cas replaces the value of the key only if its version token is still
+// version, it returns ErrNotFound if the key is not in the cache and
+// ErrVersionMismatch if the value was updated since
+func (c *LFUCache) Cas(key string, value string, version uint64) error {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return cache.ErrNotFound
+	}
+	if node.Version != version {
+		return cache.ErrVersionMismatch
+	}
+	c.put(key, value)
+	return nil
+}
 
-	if _, ok := c.node[key]; !ok {
-		metrics.Miss.Inc()
+// Peek returns the value for the key without updating its frequency or
+// counting a hit or a miss
+func (c *LFUCache) Peek(key string) (value string, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
 		return "", false
 	}
+	return node.Value, true
+}
+
+// Touch updates the frequency of the key like Get and restarts its time
+// to live, it returns false if the key is not in the cache
+func (c *LFUCache) Touch(key string) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	if _, ok = c.get(key); !ok {
+		return false
+	}
+	if node := c.node[key]; node.TTL > 0 {
+		c.expire(node, node.TTL)
+	}
+	return true
+}
+
+// TTL returns the time left until the key expires, zero if it never expires
+func (c *LFUCache) TTL(key string) (ttl time.Duration, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	now := c.options.Now()
+	node, ok := c.node[key]
+	if !ok || node.Expired(now) {
+		return 0, false
+	}
+	if node.Expires.IsZero() {
+		return 0, true
+	}
+	return node.Expires.Sub(now), true
+}
+
+// Expire sets the key to expire exactly ttl from now without the jitter,
+// a ttl that is not positive expires the key at once
+func (c *LFUCache) Expire(key string, ttl time.Duration) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	now := c.options.Now()
+	node, ok := c.node[key]
+	if ok && node.Expired(now) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	if !ok {
+		return false
+	}
+	if ttl <= 0 {
+		c.evictNode(node, cache.EvictionExpired)
+		return true
+	}
+	node.TTL = ttl
+	node.Expires = now.Add(ttl)
+	c.wheel.Schedule(key, node.Expires)
+	return true
+}
+
+// Persist removes the expiration of the key
+func (c *LFUCache) Persist(key string) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if ok && node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	if !ok {
+		return false
+	}
+	c.expire(node, 0)
+	return true
+}
+
+// hit counts a hit on the node under the read lock, the node is queued for
+// settle on its first pending hit. If the queue is full settle checks all
+// the nodes instead
+func (c *LFUCache) hit(node *dlinklist.Node) {
+	atomic.StoreInt64(&node.Seen, c.options.Now().UnixNano())
+	if atomic.AddInt32(&node.Hits, 1) == 1 {
+		select {
+		case c.hits <- node:
+		default:
+			atomic.StoreInt32(&c.overflow, 1)
+		}
+	}
+}
+
+// settle moves the nodes with pending hits to their frequency buckets,
+// the caller must hold the lock
+func (c *LFUCache) settle() {
+	if c.hits == nil {
+		return
+	}
+	changed := false
+	for drained := false; !drained; {
+		select {
+		case node := <-c.hits:
+			changed = c.apply(node) || changed
+		default:
+			drained = true
+		}
+	}
+	if atomic.SwapInt32(&c.overflow, 0) == 1 {
+		for _, node := range c.node {
+			changed = c.apply(node) || changed
+		}
+	}
+	if changed {
+		c.minFreq = c.nextMinFreq()
+	}
+}
+
+// apply adds the pending hits of the node to its frequency, the caller
+// must hold the lock and update minFreq
+func (c *LFUCache) apply(node *dlinklist.Node) bool {
+	n := atomic.SwapInt32(&node.Hits, 0)
+	if n == 0 || c.node[node.Key] != node {
+		return false
+	}
+	c.unlink(node)
+	node.Freq += int(n)
+	node.Touched = time.Unix(0, atomic.LoadInt64(&node.Seen))
+	c.link(node)
+	return true
+}
 
-	metrics.Hits.Inc()
-	node := c.node[key]
+// GetMulti returns the values of the keys that are found in the cache
+func (c *LFUCache) GetMulti(keys []string) map[string]string {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if value, ok := c.get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// get looks up the key and updates its frequency, the caller must hold the lock
+func (c *LFUCache) get(key string) (value string, ok bool) {
+	c.record(key)
+	node, ok := c.node[key]
+	if ok && node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	if !ok {
+		if c.keeper != nil {
+			c.keeper.Miss(key)
+		}
+		c.counters.Miss.Inc()
+		return "", false
+	}
+
+	c.counters.Hits.Inc()
 	c.update(node)
+	node.Touched = c.options.Now()
+	if c.options.SlidingTTL && node.TTL > 0 {
+		c.expire(node, node.TTL)
+	}
 	return node.Value, true
 }
 
@@ -101,113 +523,1057 @@ func (c *LFUCache) Get(key string) (value string, ok bool) {
 // 3. The tail of the DLinkedList with minFreq is the least
 //recently used one, pop it.
 func (c *LFUCache) Put(key, value string) (created bool) {
+	defer c.latency.Put.Since(time.Now())
+	defer c.publish()
 	defer c.Unlock()
-	c.Lock()
+	c.lock()
+	return c.put(key, value)
+}
+
+// PutContext updates or inserts the entry like Put in a span of the
+// context, unless the context is done before the lock is acquired
+func (c *LFUCache) PutContext(ctx context.Context, key string, value string) (created bool, err error) {
+	span := tracing.StartOp(ctx, "cache.Put", key)
+	if err := c.lockContext(ctx); err != nil {
+		span.End()
+		return false, err
+	}
+	defer c.publish()
+	defer c.Unlock()
+	evictions := c.counters.Evictions.Count()
+	created = c.put(key, value)
+	tracing.EndOp(span, !created, len(value), c.counters.Evictions.Count()-evictions)
+	return created, nil
+}
+
+// PutWithTTL updates or inserts the entry like Put and expires it ttl from
+// now instead of the default TTL, an expired entry is a miss and its size
+// is reclaimed by the eviction or the next access
+func (c *LFUCache) PutWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	defer c.latency.Put.Since(time.Now())
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	created = c.put(key, value)
+	if node, ok := c.node[key]; ok {
+		c.expire(node, ttl)
+	}
+	return created
+}
+
+// put updates or inserts the entry, the caller must hold the lock
+func (c *LFUCache) put(key, value string) (created bool) {
+	c.settle()
+	if c.capacity == 0 || c.shut {
+		return
+	}
+	c.record(key)
+	if node, ok := c.node[key]; ok {
+		c.updateValue(node, value)
+		c.evict(0, 0)
+		return false
+	}
+	return c.add(key, value)
+}
+
+// Incr adds delta to the decimal integer value of the key under the lock,
+// a missing key counts from zero and the expiration of the key is kept
+func (c *LFUCache) Incr(key string, delta int64) (value int64, err error) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if ok && node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	current := "0"
+	if ok {
+		current = node.Value
+	}
+	value, err = cache.AddInt(current, delta)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		c.rewrite(node, strconv.FormatInt(value, 10))
+	} else {
+		c.put(key, strconv.FormatInt(value, 10))
+	}
+	return value, nil
+}
+
+// Decr subtracts delta from the decimal integer value of the key like Incr
+func (c *LFUCache) Decr(key string, delta int64) (value int64, err error) {
+	return c.Incr(key, -delta)
+}
+
+// Append adds value to the end of the value of the key, it returns false
+// if the key is not in the cache
+func (c *LFUCache) Append(key string, value string) (ok bool) {
+	return c.concat(key, value, false)
+}
+
+// Prepend adds value to the start of the value of the key, it returns false
+// if the key is not in the cache
+func (c *LFUCache) Prepend(key string, value string) (ok bool) {
+	return c.concat(key, value, true)
+}
+
+// concat adds value to the end or the start of the value of the key and
+// counts it as a use of the entry like a put
+func (c *LFUCache) concat(key string, value string, prepend bool) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return false
+	}
+	if prepend {
+		value += node.Value
+	} else {
+		value = node.Value + value
+	}
+	c.rewrite(node, value)
+	return true
+}
+
+// rewrite updates the value of the node like put but keeps its
+// expiration, the caller must hold the lock
+func (c *LFUCache) rewrite(node *dlinklist.Node, value string) {
+	expires, ttl := node.Expires, node.TTL
+	c.put(node.Key, value)
+	node.Expires, node.TTL = expires, ttl
+	c.wheel.Schedule(node.Key, expires)
+}
+
+// PutIfAbsent inserts the entry only if the key is not in the cache, an
+// existing entry is left untouched including its frequency
+func (c *LFUCache) PutIfAbsent(key string, value string) (created bool) {
+	return c.PutIfAbsentWithTTL(key, value, c.options.DefaultTTL)
+}
+
+// PutIfAbsentWithTTL inserts the entry like PutIfAbsent and expires it ttl
+// from now, an expired entry of the key is replaced
+func (c *LFUCache) PutIfAbsentWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
 	if c.capacity == 0 {
+		return false
+	}
+	if node, ok := c.node[key]; ok {
+		if !node.Expired(c.options.Now()) {
+			return false
+		}
+		c.evictNode(node, cache.EvictionExpired)
+	}
+	c.record(key)
+	if !c.add(key, value) {
+		return false
+	}
+	c.expire(c.node[key], ttl)
+	return true
+}
+
+// Replace updates the value of the key only if it is in the cache, a
+// missing or expired key is left out
+func (c *LFUCache) Replace(key string, value string) (ok bool) {
+	return c.ReplaceWithTTL(key, value, c.options.DefaultTTL)
+}
+
+// ReplaceWithTTL updates the key like Replace and expires it ttl from now
+func (c *LFUCache) ReplaceWithTTL(key string, value string, ttl time.Duration) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	node, ok := c.node[key]
+	if !ok {
+		return false
+	}
+	if node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		return false
+	}
+	c.put(key, value)
+	if node, ok := c.node[key]; ok {
+		c.expire(node, ttl)
+	}
+	return true
+}
+
+// add inserts a new key and evicts to make room for it, it returns false
+// if the cache is closed or the entry is rejected by the doorkeeper, the
+// admission or the size. The caller must hold the lock
+func (c *LFUCache) add(key, value string) bool {
+	if c.shut {
+		return false
+	}
+	if c.keeper != nil && !c.keeper.Admit(key) {
+		return false
+	}
+	size := c.options.Cost(key, value)
+	if size > c.capacity || !c.admit(key, size) {
+		return false
+	}
+	c.evict(size, 1)
+	if c.overflows(size, 1) && c.victim() == nil {
+		// the pinned entries leave no room for the new entry
+		return false
+	}
+	c.insert(key, value, size)
+	return true
+}
+
+// PutMulti updates or inserts all the entries and evicts the least
+// frequently used entries once all of them are inserted
+func (c *LFUCache) PutMulti(entries map[string]string) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	if c.capacity == 0 || c.shut {
 		return
 	}
-	if _, ok := c.node[key]; ok {
-		metrics.Hits.Inc()
-		node := c.node[key]
-		c.update(node)
-		node.Value = value
-		created = false
+	for key, value := range entries {
+		if node, ok := c.node[key]; ok {
+			c.updateValue(node, value)
+		} else {
+			c.insert(key, value, c.options.Cost(key, value))
+		}
+	}
+	c.evict(0, 0)
+}
+
+// Subscribe returns a channel that receives the puts, deletes, evictions
+// and expirations, the events are dropped if the channel is not drained in time
+func (c *LFUCache) Subscribe() <-chan cache.Event {
+	return c.events.Subscribe()
+}
+
+// Unsubscribe stops sending the events to the channel and closes it
+func (c *LFUCache) Unsubscribe(ch <-chan cache.Event) {
+	c.events.Unsubscribe(ch)
+}
+
+// notify records an event to be published once the lock is released,
+// the caller must hold the lock
+func (c *LFUCache) notify(kind cache.EventType, key, value string) {
+	if c.events.Active() {
+		c.pending = append(c.pending, cache.Event{Type: kind, Key: key, Value: value})
+	}
+}
+
+// publish sends the recorded events to the subscribers,
+// it must be called without holding the lock
+func (c *LFUCache) publish() {
+	c.events.Publish(func() []cache.Event {
+		defer c.Unlock()
+		c.lock()
+		events := c.pending
+		c.pending = nil
+		return events
+	})
+}
+
+// Load warms up the cache at startup, all the entries are inserted and the
+// least frequently used ones are evicted in a single sweep once they are all
+// in. freqs optionally hints the frequency of the entries so the warmed up
+// entries do not all start at frequency 1, an entry without a hint does
+func (c *LFUCache) Load(entries map[string]string, freqs map[string]int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	if c.capacity == 0 || c.shut {
+		return
+	}
+	before := len(c.node)
+	for key, value := range entries {
+		c.load(key, value, freqs[key])
+	}
+	c.loaded(before)
+	c.evict(0, 0)
+}
+
+// load inserts or updates the entry at the frequency hint without evicting,
+// an existing entry keeps its frequency if it is higher. The metrics are not
+// updated, the caller must hold the lock and call loaded once it is done
+func (c *LFUCache) load(key, value string, freq int) *dlinklist.Node {
+	if freq < 1 {
+		freq = 1
+	}
+	size := c.options.Cost(key, value)
+	node, ok := c.node[key]
+	if ok {
+		c.unlink(node)
+		if node.Freq > freq {
+			freq = node.Freq
+		}
+		c.size += size - bytesize.ByteSize(node.Size)
 	} else {
-		c.size += bytesize.ByteSize(len(value))
-		for c.size > c.capacity {
-			minList, ok := c.freq[c.minFreq]
-			metrics.Deletes.Inc()
-			if !ok || minList.Size() == 0 {
-				delete(c.freq, c.minFreq)
-				c.minFreq++
+		node = &dlinklist.Node{Key: key, Created: c.options.Now()}
+		c.node[key] = node
+		c.keys.Add(key)
+		c.size += size
+	}
+	node.Value = value
+	node.Size = int64(size)
+	c.version++
+	node.Version = c.version
+	c.expire(node, c.options.DefaultTTL)
+	node.Freq = freq
+	node.Touched = c.options.Now()
+	c.link(node)
+	return node
+}
+
+// loaded updates minFreq and the metrics for the entries loaded since the
+// cache had before entries, the caller must hold the lock
+func (c *LFUCache) loaded(before int) {
+	added := len(c.node) - before
+	c.counters.Adds.Add(float64(added))
+	c.resize(0, added)
+	c.minFreq = c.nextMinFreq()
+}
+
+// Export writes the entries with their frequencies and expiration times in
+// the portable format of cache.Dump, the least frequently used ones first
+func (c *LFUCache) Export(w io.Writer) error {
+	return cache.WriteDump(w, c.dump())
+}
+
+// Import reads the entries written by Export and loads them like Load,
+// the least frequently used entries are evicted in a single sweep
+func (c *LFUCache) Import(r io.Reader) error {
+	entries, err := cache.ReadDump(r)
+	if err != nil {
+		return err
+	}
+	return c.restore(func(put func(cache.DumpEntry)) error {
+		for _, e := range entries {
+			put(e)
+		}
+		return nil
+	})
+}
+
+// dump returns the entries that are not expired, the least frequently used first
+func (c *LFUCache) dump() []cache.DumpEntry {
+	c.RLock()
+	defer c.RUnlock()
+	freqs := make([]int, 0, len(c.freq))
+	for freq := range c.freq {
+		freqs = append(freqs, freq)
+	}
+	sort.Ints(freqs)
+	now := c.options.Now()
+	entries := make([]cache.DumpEntry, 0, len(c.node))
+	for _, freq := range freqs {
+		c.freq[freq].Walk(func(node *dlinklist.Node) {
+			if !node.Expired(now) {
+				entries = append(entries, dumpEntry(node))
+			}
+		})
+	}
+	return entries
+}
+
+// restore loads the entries read puts in order at their frequencies with
+// their expiration times in a single pass under the lock like Load, the
+// expired entries are skipped and the least frequently used entries are
+// evicted in a single sweep at the end
+func (c *LFUCache) restore(read func(put func(cache.DumpEntry)) error) error {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	before := len(c.node)
+	defer func() {
+		c.loaded(before)
+		c.evict(0, 0)
+	}()
+	now := c.options.Now()
+	return read(func(e cache.DumpEntry) {
+		if c.capacity == 0 || c.shut {
+			return
+		}
+		expires := time.Time{}
+		if e.Expires != 0 {
+			expires = time.Unix(0, e.Expires)
+		}
+		if !expires.IsZero() && !now.Before(expires) {
+			return
+		}
+		c.load(e.Key, e.Value, e.Freq).Expires = expires
+		c.wheel.Schedule(e.Key, expires)
+	})
+}
+
+func dumpEntry(node *dlinklist.Node) cache.DumpEntry {
+	freq := node.Freq + int(atomic.LoadInt32(&node.Hits))
+	e := cache.DumpEntry{Key: node.Key, Value: node.Value, Freq: freq}
+	if !node.Expires.IsZero() {
+		e.Expires = node.Expires.UnixNano()
+	}
+	return e
+}
+
+// link adds the node to the bucket of its frequency, the caller must hold the lock
+func (c *LFUCache) link(node *dlinklist.Node) {
+	if _, ok := c.freq[node.Freq]; !ok {
+		c.freq[node.Freq] = dlinklist.NewLinkedList()
+	}
+	c.freq[node.Freq].AddNode(node)
+}
+
+// unlink removes the node from the bucket of its frequency and drops the
+// bucket if it becomes empty, minFreq is left to the caller
+func (c *LFUCache) unlink(node *dlinklist.Node) {
+	list := c.freq[node.Freq]
+	list.RemoveNode(node)
+	if list.Size() == 0 {
+		delete(c.freq, node.Freq)
+	}
+}
+
+// updateValue replaces the value of an existing node and updates its
+// frequency, the caller must hold the lock
+func (c *LFUCache) updateValue(node *dlinklist.Node, value string) {
+	c.counters.Hits.Inc()
+	c.update(node)
+	size := c.options.Cost(node.Key, value)
+	c.resize(size-bytesize.ByteSize(node.Size), 0)
+	node.Value = value
+	node.Size = int64(size)
+	c.version++
+	node.Version = c.version
+	c.expire(node, c.options.DefaultTTL)
+	node.Touched = c.options.Now()
+	c.notify(cache.EventPut, node.Key, value)
+	c.snapshot.Mutated()
+}
+
+// insert adds a new node with frequency 1 without evicting,
+// the caller must hold the lock
+func (c *LFUCache) insert(key, value string, size bytesize.ByteSize) {
+	c.counters.Adds.Inc()
+	node := &dlinklist.Node{
+		Key:     key,
+		Value:   value,
+		Freq:    1,
+		Size:    int64(size),
+		Created: c.options.Now(),
+		Touched: c.options.Now(),
+	}
+	c.version++
+	node.Version = c.version
+	c.node[key] = node
+	c.keys.Add(key)
+	if _, ok := c.freq[1]; !ok {
+		c.freq[1] = dlinklist.NewLinkedList()
+	}
+
+	c.expire(node, c.options.DefaultTTL)
+	c.freq[1].AddNode(node)
+	c.minFreq = 1
+	c.resize(size, 1)
+	c.notify(cache.EventPut, key, value)
+	c.snapshot.Mutated()
+}
+
+// expire sets the node to expire ttl from now moved by the jitter, a zero
+// ttl never expires
+func (c *LFUCache) expire(node *dlinklist.Node, ttl time.Duration) {
+	node.TTL = ttl
+	node.Expires = time.Time{}
+	if ttl > 0 {
+		node.Expires = c.options.Now().Add(c.options.Jitter(ttl))
+	}
+	c.wheel.Schedule(node.Key, node.Expires)
+}
+
+// sweep expires up to limit of the entries that are due in the timer
+// wheel and returns the number of the due keys it checked
+func (c *LFUCache) sweep(limit int) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	now := c.options.Now()
+	keys := c.wheel.Advance(now, limit)
+	for _, key := range keys {
+		if node, ok := c.node[key]; ok {
+			if node.Expired(now) {
+				c.evictNode(node, cache.EvictionExpired)
 			} else {
-				node := minList.PopTail()
-				freq := node.Freq
-				if v, _ := c.freq[c.minFreq]; c.minFreq == freq && v.Size() == 0 {
-					delete(c.freq, freq)
-					c.minFreq++
-				}
-				c.size -= bytesize.ByteSize(len(node.Value))
-				delete(c.node, node.Key)
+				c.wheel.Schedule(key, node.Expires)
 			}
 		}
-		metrics.Adds.Inc()
-		node := &dlinklist.Node{
-			Key:   key,
-			Value: value,
-			Freq:  1,
+	}
+	return len(keys)
+}
+
+// record counts the access of the key in the admission sketch and ages
+// the entries and clears the doorkeeper when the sketch is reset
+func (c *LFUCache) record(key string) {
+	if c.sketch != nil && c.sketch.Increment(key) {
+		c.age()
+		if c.keeper != nil {
+			c.keeper.Reset()
 		}
-		c.node[key] = node
-		if _, ok := c.freq[1]; !ok {
-			c.freq[1] = dlinklist.NewLinkedList()
+	}
+}
+
+// admit reports whether the new key is estimated to be accessed more
+// often than the least frequently used entry it would evict
+func (c *LFUCache) admit(key string, reserve bytesize.ByteSize) bool {
+	if c.sketch == nil || !c.overflows(reserve, 1) {
+		return true
+	}
+	victim := c.victim()
+	if victim == nil {
+		return true
+	}
+	return c.sketch.Estimate(key) > c.sketch.Estimate(victim.Key)
+}
+
+// age halves the frequency of all the entries
+func (c *LFUCache) age() {
+	c.decay(0.5)
+}
+
+// decay multiplies the frequency of all the entries by factor, at least 1,
+// and moves them to their new frequency buckets. Entries of the higher
+// frequency are added last so they are the most recently used ones within
+// the merged bucket, the caller must hold the lock
+func (c *LFUCache) decay(factor float64) {
+	freqs := make([]int, 0, len(c.freq))
+	for freq := range c.freq {
+		freqs = append(freqs, freq)
+	}
+	sort.Ints(freqs)
+	buckets := map[int]*dlinklist.DLinkedList{}
+	for _, freq := range freqs {
+		list := c.freq[freq]
+		for list.Size() > 0 {
+			node := list.PopTail()
+			node.Freq = int(float64(node.Freq) * factor)
+			if node.Freq < 1 {
+				node.Freq = 1
+			}
+			if _, ok := buckets[node.Freq]; !ok {
+				buckets[node.Freq] = dlinklist.NewLinkedList()
+			}
+			buckets[node.Freq].AddNode(node)
+		}
+	}
+	c.freq = buckets
+	c.minFreq = c.nextMinFreq()
+}
+
+// overflows reports whether reserve more bytes and entries more entries
+// would exceed the capacity or the maximum number of entries
+func (c *LFUCache) overflows(reserve bytesize.ByteSize, entries int) bool {
+	return c.size+reserve > c.capacity ||
+		c.options.MaxEntries > 0 && len(c.node)+entries > c.options.MaxEntries
+}
+
+// evict pops the least frequently used entries until there is room for
+// reserve more bytes and entries more entries or only the minimum entries
+// are left, the caller must hold the lock. The loop can not evict more
+// entries than there are, so it stops and counts an anomaly if it does or
+// if there is nothing left to evict without any pinned entry
+func (c *LFUCache) evict(reserve bytesize.ByteSize, entries int) {
+	for limit := len(c.node); c.overflows(reserve, entries) && len(c.node)+entries > c.options.MinEntries; limit-- {
+		if limit == 0 {
+			metrics.Anomalies.Inc()
+			return
+		}
+		if !c.evictOne() {
+			if len(c.pinned) == 0 {
+				metrics.Anomalies.Inc()
+			}
+			return
 		}
+	}
+}
 
-		c.freq[1].AddNode(node)
-		c.minFreq = 1
-		created = true
+// EvictN evicts up to n least frequently used entries and
+// returns the number of the evicted entries
+func (c *LFUCache) EvictN(n int) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	for count < n && c.evictOne() {
+		count++
 	}
-	return created
+	return count
+}
+
+// TrimTo evicts the least frequently used entries until the size
+// is at most target and returns the number of the evicted entries
+func (c *LFUCache) TrimTo(target bytesize.ByteSize) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	c.settle()
+	for c.size > target && c.evictOne() {
+		count++
+	}
+	return count
+}
+
+// Resize changes the capacity of the cache, a smaller capacity evicts the
+// least frequently used entries in batches of cache.ResizeBatch
+func (c *LFUCache) Resize(capacity bytesize.ByteSize) error {
+	c.lock()
+	c.capacity = capacity
+	c.gauges.Capacity.Set(float64(capacity))
+	c.Unlock()
+	for more := true; more; {
+		c.lock()
+		c.settle()
+		for n := 0; more && n < cache.ResizeBatch; n++ {
+			more = c.overflows(0, 0) && len(c.node) > c.options.MinEntries && c.evictOne()
+		}
+		more = more && c.overflows(0, 0)
+		c.Unlock()
+		c.publish()
+	}
+	return nil
+}
+
+// evictOne evicts the victim entry, it returns false if there is no entry
+// left that is not pinned. The caller must hold the lock and the minFreq
+// bucket is never empty while there is an entry
+func (c *LFUCache) evictOne() bool {
+	victim := c.victim()
+	if victim == nil {
+		return false
+	}
+	c.evictNode(victim, cache.EvictionCapacity)
+	return true
+}
+
+// victim returns the least recently used entry that is not pinned in the
+// lowest frequency bucket that has one, nil if all the entries are pinned.
+// The caller must hold the lock
+func (c *LFUCache) victim() *dlinklist.Node {
+	unpinned := func(node *dlinklist.Node) bool {
+		return c.pinned[node.Key] != node
+	}
+	if list, ok := c.freq[c.minFreq]; ok {
+		if node := list.Find(unpinned); node != nil || len(c.pinned) == 0 {
+			return node
+		}
+	}
+	var victim *dlinklist.Node
+	for freq, list := range c.freq {
+		if victim != nil && freq >= victim.Freq {
+			continue
+		}
+		if node := list.Find(unpinned); node != nil {
+			victim = node
+		}
+	}
+	return victim
 }
 
 //Delete deletes a key from LFU cache
 func (c *LFUCache) Delete(key string) (ok bool) {
+	_, ok = c.Remove(key)
+	return ok
+}
+
+// Remove deletes the key and returns its value
+func (c *LFUCache) Remove(key string) (value string, ok bool) {
+	defer c.latency.Delete.Since(time.Now())
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
 	node, ok := c.node[key]
 	if !ok {
-		return false
+		return "", false
+	}
+	c.removeNode(node)
+	c.notify(cache.EventDelete, key, "")
+	c.evicted(node, cache.EvictionDeleted)
+	return node.Value, true
+}
+
+// DeleteMulti deletes the keys and returns the number of deleted keys
+func (c *LFUCache) DeleteMulti(keys []string) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for _, key := range keys {
+		if node, ok := c.node[key]; ok {
+			c.removeNode(node)
+			c.notify(cache.EventDelete, key, "")
+			c.evicted(node, cache.EvictionDeleted)
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteByPattern deletes all the keys that match the glob pattern and
+// returns the number of deleted keys, see cache.Match for the syntax
+func (c *LFUCache) DeleteByPattern(pattern string) (count int, err error) {
+	if err := cache.ValidPattern(pattern); err != nil {
+		return 0, err
+	}
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for key, node := range c.node {
+		if matched, _ := cache.Match(pattern, key); matched {
+			c.removeNode(node)
+			c.notify(cache.EventDelete, key, "")
+			c.evicted(node, cache.EvictionDeleted)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeletePrefix deletes all the keys starting with prefix and
+// returns the number of deleted keys
+func (c *LFUCache) DeletePrefix(prefix string) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for key, node := range c.node {
+		if strings.HasPrefix(key, prefix) {
+			c.removeNode(node)
+			c.notify(cache.EventDelete, key, "")
+			c.evicted(node, cache.EvictionDeleted)
+			count++
+		}
 	}
+	return count
+}
+
+// removeNode unlinks the node from its frequency bucket and reclaims
+// its size, if the bucket becomes empty it is dropped and minFreq is
+// moved to the next smallest frequency. The caller must hold the lock
+func (c *LFUCache) removeNode(node *dlinklist.Node) {
+	c.counters.Deletes.Inc()
 	freq := node.Freq
-	if v, _ := c.freq[c.minFreq]; c.minFreq == freq && v.Size() == 0 {
-		metrics.Deletes.Inc()
-		delete(c.freq, freq)
-		c.minFreq++
+	c.unlink(node)
+	if _, ok := c.freq[freq]; !ok && c.minFreq == freq {
+		c.minFreq = c.nextMinFreq()
 	}
-	delete(c.node, key)
-	return true
+	c.resize(-bytesize.ByteSize(node.Size), -1)
+	delete(c.node, node.Key)
+	c.keys.Remove(node.Key)
+	c.wheel.Cancel(node.Key)
+	delete(c.pinned, node.Key)
+	c.snapshot.Mutated()
 }
 
-func (c *LFUCache) Snapshot() (raft.FSMSnapshot, error) {
+// resize adds delta to the size and entries to the number of entries and
+// updates the gauges. The size is unsigned, a shrinking delta is the two's
+// complement of the bytes removed and the addition wraps modulo 2^64, so it
+// subtracts them exactly as long as they are part of the size. Removing more
+// bytes than the size wraps it around to a huge size instead of a negative
+// one. The caller must hold the lock
+func (c *LFUCache) resize(delta bytesize.ByteSize, entries int) {
+	c.size += delta
+	c.gauges.Size.Set(float64(c.size))
+	c.gauges.Entries.Add(float64(entries))
+}
+
+// evictNode removes an evicted or expired node and reports it to OnEvict
+// and the eviction listener
+func (c *LFUCache) evictNode(node *dlinklist.Node, reason cache.EvictionReason) {
+	c.removeNode(node)
+	c.counters.Evictions.Inc()
+	if reason == cache.EvictionExpired {
+		c.notify(cache.EventExpire, node.Key, "")
+	} else {
+		c.notify(cache.EventEvict, node.Key, "")
+	}
+	if c.options.OnEvict != nil {
+		c.options.OnEvict(node.Key, node.Value)
+	}
+	c.evicted(node, reason)
+}
+
+// evicted reports the node that left the cache to the eviction listener,
+// the caller must hold the lock
+func (c *LFUCache) evicted(node *dlinklist.Node, reason cache.EvictionReason) {
+	if c.options.EvictionListener != nil {
+		c.options.EvictionListener.OnEvict(node.Key, node.Value, reason)
+	}
+}
+
+// nextMinFreq finds the smallest frequency among the buckets, zero if
+// there is no bucket left
+func (c *LFUCache) nextMinFreq() int {
+	minFreq := 0
+	for freq := range c.freq {
+		if minFreq == 0 || freq < minFreq {
+			minFreq = freq
+		}
+	}
+	return minFreq
+}
+
+// HasKey reports whether the key is in the cache without
+// updating its frequency or the metrics
+func (c *LFUCache) HasKey(key string) bool {
 	c.RLock()
 	defer c.RUnlock()
+	node, ok := c.node[key]
+	return ok && !node.Expired(c.options.Now())
+}
 
-	o := make(map[string]string)
+// Keys returns the keys that are not expired in no particular order
+func (c *LFUCache) Keys() []string {
+	c.RLock()
+	defer c.RUnlock()
+	now := c.options.Now()
+	keys := make([]string, 0, len(c.node))
+	for key, node := range c.node {
+		if !node.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
 
-	for k, v := range c.node {
-		o[k] = v.Value
+// Scan returns a batch of about count keys that are not expired from cursor
+// on and the cursor of the next batch, zero once all the keys are scanned
+func (c *LFUCache) Scan(cursor uint64, count int) (keys []string, next uint64) {
+	c.RLock()
+	defer c.RUnlock()
+	keys, next = c.keys.Scan(cursor, count)
+	now := c.options.Now()
+	live := keys[:0]
+	for _, key := range keys {
+		if !c.node[key].Expired(now) {
+			live = append(live, key)
+		}
 	}
+	return live, next
+}
 
-	return &fsmSnapshot{store: o}, nil
+// Meta returns the access metadata of the key without updating its
+// frequency or the metrics, the access count is the frequency of the key
+// including the pending hits
+func (c *LFUCache) Meta(key string) (meta cache.EntryMeta, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return meta, false
+	}
+	meta = cache.EntryMeta{
+		CreatedAt:   node.Created,
+		LastAccess:  node.Touched,
+		AccessCount: node.Freq,
+		Size:        bytesize.ByteSize(node.Size),
+	}
+	if hits := atomic.LoadInt32(&node.Hits); hits > 0 {
+		meta.AccessCount += int(hits)
+		meta.LastAccess = time.Unix(0, atomic.LoadInt64(&node.Seen))
+	}
+	return meta, true
+}
 
+// Pin exempts the entry from the eviction until it is unpinned, it still
+// expires and can be deleted. A pinned entry keeps its size, so the pin is
+// rejected if the pinned entries would not fit in the capacity, and the
+// cache overshoots the capacity if the pinned values grow. Pin returns false
+// if the key is not found or the pin is rejected
+func (c *LFUCache) Pin(key string) bool {
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return false
+	}
+	size := bytesize.ByteSize(node.Size)
+	for k, pinned := range c.pinned {
+		if k != key {
+			size += bytesize.ByteSize(pinned.Size)
+		}
+	}
+	if size > c.capacity {
+		return false
+	}
+	c.pinned[key] = node
+	return true
 }
 
-func (c *LFUCache) Restore(closer io.ReadCloser) error {
-	o := make(map[string]string)
-	if err := json.NewDecoder(closer).Decode(&o); err != nil {
-		return err
+// Unpin makes the pinned entry evictable again, it returns false if the
+// key is not pinned
+func (c *LFUCache) Unpin(key string) bool {
+	defer c.Unlock()
+	c.lock()
+	if _, ok := c.pinned[key]; !ok {
+		return false
+	}
+	delete(c.pinned, key)
+	return true
+}
+
+// OrderedKeys returns the keys from the last to be evicted to the next,
+// from the highest frequency to the lowest and from the most recently used
+// to the least within a frequency. The hits that are not settled yet are
+// not reflected in the order
+func (c *LFUCache) OrderedKeys() []string {
+	c.RLock()
+	defer c.RUnlock()
+	freqs := make([]int, 0, len(c.freq))
+	for freq := range c.freq {
+		freqs = append(freqs, freq)
 	}
+	sort.Sort(sort.Reverse(sort.IntSlice(freqs)))
+	keys := make([]string, 0, len(c.node))
+	for _, freq := range freqs {
+		start := len(keys)
+		c.freq[freq].Walk(func(node *dlinklist.Node) {
+			keys = append(keys, node.Key)
+		})
+		for i, j := start, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	return keys
+}
 
-	// Set the state from the snapshot, no lock required according to
-	// Hashicorp docs.
-	for k, v := range o {
-		c.Put(k, v)
+// Close stops the frequency decay, the expiration sweeps and the periodic
+// snapshots, writes a last snapshot and drops the entries. A closed cache
+// misses every Get and every Put returns false without storing the entry
+func (c *LFUCache) Close() error {
+	c.sweeper.Stop()
+	c.closed.Do(func() {
+		if c.done != nil {
+			close(c.done)
+			<-c.stopped
+		}
+	})
+	err := c.snapshot.Stop()
+	defer c.Unlock()
+	c.lock()
+	if !c.shut {
+		c.settle()
+		c.shut = true
+		c.resize(-c.size, -len(c.node))
+		c.node = map[string]*dlinklist.Node{}
+		c.freq = map[int]*dlinklist.DLinkedList{}
+		c.pinned = map[string]*dlinklist.Node{}
+		c.minFreq = 0
+		c.keys = cache.KeyIndex{}
 	}
+	return err
+}
 
+// CheckInvariants verifies that every entry is in the bucket of its
+// frequency exactly once, that the buckets are not empty and have no other
+// node, that minFreq is the smallest frequency, that the pinned entries are
+// in the cache and that the size is the sum of the entry sizes. It returns
+// an error for the first violation
+func (c *LFUCache) CheckInvariants() error {
+	c.RLock()
+	defer c.RUnlock()
+	seen := make(map[string]bool, len(c.node))
+	size := bytesize.ByteSize(0)
+	var err error
+	for freq, list := range c.freq {
+		if list.Size() == 0 {
+			return fmt.Errorf("bucket %d is empty", freq)
+		}
+		if freq < c.minFreq {
+			return fmt.Errorf("minFreq is %d but bucket %d is not empty", c.minFreq, freq)
+		}
+		list.Walk(func(node *dlinklist.Node) {
+			switch {
+			case err != nil:
+			case seen[node.Key]:
+				err = fmt.Errorf("key %s is linked more than once", node.Key)
+			case c.node[node.Key] != node:
+				err = fmt.Errorf("linked key %s is not in the cache", node.Key)
+			case node.Freq != freq:
+				err = fmt.Errorf("key %s with frequency %d is in bucket %d", node.Key, node.Freq, freq)
+			default:
+				seen[node.Key] = true
+				size += bytesize.ByteSize(node.Size)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(seen) != len(c.node) {
+		return fmt.Errorf("%d entries but %d linked nodes", len(c.node), len(seen))
+	}
+	if _, ok := c.freq[c.minFreq]; !ok && len(c.node) > 0 {
+		return fmt.Errorf("minFreq %d has no bucket", c.minFreq)
+	}
+	for key, node := range c.pinned {
+		if c.node[key] != node {
+			return fmt.Errorf("pinned key %s is not in the cache", key)
+		}
+	}
+	if size != c.size {
+		return fmt.Errorf("size is %d but the entries take %d", c.size, size)
+	}
 	return nil
 }
 
+// GetOrLoad returns the value for the key, a missing key is loaded with
+// loader and put in the cache. The concurrent loads of a key are made once
+func (c *LFUCache) GetOrLoad(key string, loader cache.Loader) (value string, err error) {
+	return c.loads.GetOrLoad(c, key, loader)
+}
+
+// Len returns the number of entries in the cache
+func (c *LFUCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.node)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *LFUCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.size, len(c.node))
+}
+
+// Snapshot returns the snapshot of the entries with their frequencies and
+// expiration times, the least frequently used first
+func (c *LFUCache) Snapshot() (raft.FSMSnapshot, error) {
+	return &fsmSnapshot{entries: c.dump()}, nil
+}
+
+// Restore loads the entries of the snapshot written by Persist at their
+// frequencies like Import. The snapshot is decoded one entry at a time and
+// the entries are loaded in a single pass under the lock, so a snapshot
+// over the capacity is evicted once at the end instead of on every entry
+func (c *LFUCache) Restore(closer io.ReadCloser) error {
+	// no lock is required according to the Hashicorp docs, it is taken
+	// once so the entries are not locked and evicted one by one
+	return c.restore(func(put func(cache.DumpEntry)) error {
+		return cache.ReadSnapshotEntries(closer, put)
+	})
+}
+
 type fsmSnapshot struct {
-	store map[string]string
+	entries []cache.DumpEntry
 }
 
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
-		// Encode data.
-		b, err := json.Marshal(f.store)
-		if err != nil {
-			return err
-		}
-
-		// Write data to sink.
-		if _, err := sink.Write(b); err != nil {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshotEntries(sink, f.entries); err != nil {
 			return err
 		}
 
@@ -1,11 +1,24 @@
 package lfucache
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/internal/cachetest"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/arazmj/gerdu/tracing"
 	"github.com/inhies/go-bytesize"
+	"io/ioutil"
 	"math/rand"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -43,6 +56,7 @@ func TestThreadSafety(t *testing.T) {
 
 	wg.Wait()
 }
+
 func TestNewLFUCache(t *testing.T) {
 	c := 100
 	size := bytesize.ByteSize(10 + 2*10*9)
@@ -177,3 +191,903 @@ func TestLfuCache_Delete(t *testing.T) {
 		t.Fatal("Expected the ket to be deleted")
 	}
 }
+
+func TestLFUCache_Behaviour(t *testing.T) {
+	cachetest.Run(t, func(capacity bytesize.ByteSize, opts ...cache.Option) cachetest.Cache {
+		return NewCache(capacity, opts...)
+	})
+}
+
+func TestLFUCache_DeletePrefix(t *testing.T) {
+	cache := NewCache(100)
+	cache.Put("user:1:profile", "a")
+	cache.Put("user:1:session", "b")
+	cache.Put("user:12:profile", "c")
+	cache.Put("user:2:profile", "d")
+	cache.Put("order:1", "e")
+	cache.Get("user:1:profile")
+	cache.Get("order:1")
+	cache.Get("order:1")
+
+	if count := cache.DeletePrefix("user:1:"); count != 2 {
+		t.Errorf("Expected 2 keys to be deleted but got %d", count)
+	}
+	for _, key := range []string{"user:12:profile", "user:2:profile"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+
+	if count := cache.DeletePrefix("user:"); count != 2 {
+		t.Errorf("Expected 2 keys to be deleted but got %d", count)
+	}
+	if cache.size != 1 || len(cache.node) != 1 {
+		t.Errorf("Expected size 1 and one node but got %d %d", cache.size, len(cache.node))
+	}
+	if cache.minFreq != 3 || len(cache.freq) != 1 {
+		t.Errorf("Expected only frequency bucket 3 but got minFreq %d", cache.minFreq)
+	}
+	if value, ok := cache.Get("order:1"); !ok || value != "e" {
+		t.Errorf("Expected order:1 to survive")
+	}
+}
+
+func TestLFUCache_DeleteMulti(t *testing.T) {
+	cache := NewCache(10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	if count := cache.DeleteMulti([]string{"1", "3", "4"}); count != 2 {
+		t.Errorf("Expected 2 deleted keys but got %d", count)
+	}
+	if len(cache.node) != 1 || cache.size != 1 {
+		t.Errorf("Expected 1 entry but got %d of size %d", len(cache.node), cache.size)
+	}
+	if _, ok := cache.Get("2"); !ok {
+		t.Errorf("Expected 2 to survive DeleteMulti")
+	}
+}
+
+func TestLFUCache_GetMulti(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	values := cache.GetMulti([]string{"1", "3", "4"})
+	if len(values) != 2 || values["1"] != "1" || values["3"] != "3" {
+		t.Errorf("Expected only the keys 1 and 3 but got %v", values)
+	}
+	if cache.node["1"].Freq != 2 || cache.node["3"].Freq != 2 {
+		t.Errorf("Expected GetMulti to update the frequencies")
+	}
+
+	cache.Put("4", "4")
+	if _, ok := cache.Get("2"); ok {
+		t.Errorf("Expected 2 to be evicted as the least frequently used")
+	}
+}
+
+func TestLFUCache_PutMulti(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.Get("1")
+	cache.PutMulti(map[string]string{"2": "2", "3": "3", "4": "4", "5": "5"})
+
+	if len(cache.node) != 3 || cache.size != 3 {
+		t.Errorf("Expected 3 entries but got %d of size %d", len(cache.node), cache.size)
+	}
+	if _, ok := cache.Get("1"); !ok {
+		t.Errorf("Expected the frequently used 1 to survive")
+	}
+}
+
+// hitRatio replays the accesses reading through the cache,
+// a missed key is put into the cache
+func hitRatio(cache *LFUCache, accesses []string) float64 {
+	hits := 0
+	for _, key := range accesses {
+		if _, ok := cache.Get(key); ok {
+			hits++
+		} else {
+			cache.Put(key, "v")
+		}
+	}
+	return float64(hits) / float64(len(accesses))
+}
+
+// shiftingWorkload accesses a hot set of keys mixed with one-hit keys,
+// after phase accesses the hot set moves to a new set of keys
+func shiftingWorkload(phases, phase, hot int) [][]string {
+	r := rand.New(rand.NewSource(42))
+	workload := make([][]string, phases)
+	cold := 0
+	for p := range workload {
+		for i := 0; i < phase; i++ {
+			var key string
+			if r.Intn(4) == 0 {
+				cold++
+				key = "cold" + strconv.Itoa(cold)
+			} else {
+				key = strconv.Itoa(p*hot + r.Intn(hot))
+			}
+			workload[p] = append(workload[p], key)
+		}
+	}
+	return workload
+}
+
+func TestLFUCache_Admission(t *testing.T) {
+	workload := shiftingWorkload(3, 5000, 10)
+	lfu := NewCache(20)
+	tinyLFU := NewCacheWithAdmission(20, 200)
+	for p, accesses := range workload {
+		lfuRatio := hitRatio(lfu, accesses)
+		tinyRatio := hitRatio(tinyLFU, accesses)
+		t.Logf("phase %d LFU hit ratio %.3f TinyLFU hit ratio %.3f", p, lfuRatio, tinyRatio)
+		if p > 0 && tinyRatio <= lfuRatio {
+			t.Errorf("Expected TinyLFU to adapt to the shifted hot set, LFU %.3f TinyLFU %.3f",
+				lfuRatio, tinyRatio)
+		}
+	}
+}
+
+func TestLFUCache_AdmissionDefaultWindow(t *testing.T) {
+	for _, window := range []int{0, -1} {
+		c := NewCacheWithAdmission(20, window)
+		if c.sketch == nil {
+			t.Fatalf("Expected a sketch for the window %d", window)
+		}
+		workload := shiftingWorkload(2, 5000, 10)
+		for p, accesses := range workload {
+			if ratio := hitRatio(c, accesses); p > 0 && ratio < 0.5 {
+				t.Errorf("Expected the default window to adapt to the shifted hot set but got %.3f", ratio)
+			}
+		}
+	}
+}
+
+func TestLFUCache_AdmissionRejects(t *testing.T) {
+	cache := NewCacheWithAdmission(2, 100)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Get("1")
+	cache.Get("2")
+	if cache.Put("3", "3") {
+		t.Errorf("Expected the rarely seen key 3 to be rejected")
+	}
+	cache.Get("3")
+	cache.Get("3")
+	if !cache.Put("3", "3") {
+		t.Errorf("Expected the frequently seen key 3 to be admitted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries but got %d", cache.Len())
+	}
+}
+
+func TestLFUCache_Age(t *testing.T) {
+	cache := NewCacheWithAdmission(10, 8)
+	cache.Put("1", "1")
+	for i := 0; i < 7; i++ {
+		cache.Get("1")
+	}
+	// the eighth access halves the frequency 7 before counting itself
+	if cache.node["1"].Freq != 4 {
+		t.Errorf("Expected frequency 4 after aging but got %d", cache.node["1"].Freq)
+	}
+	if cache.minFreq != 4 || cache.freq[4].Size() != 1 || len(cache.freq) != 1 {
+		t.Errorf("Expected the entry to be moved to bucket 4")
+	}
+}
+
+func TestLFUCache_NewCacheWithEntries(t *testing.T) {
+	c := NewCacheWithEntries(2, cache.WithMaxEntries(10))
+	c.Put("1", strings.Repeat("a", 1<<20))
+	c.Put("2", strings.Repeat("b", 1<<20))
+	c.Put("3", "c")
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries but got %d", c.Len())
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted by the number of entries")
+	}
+	for _, key := range []string{"2", "3"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to be kept regardless of its size", key)
+		}
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLFUCache_HasKey(t *testing.T) {
+	c := NewCache(2)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	c.Get("2")
+	misses, hits := c.Stats().Misses, c.Stats().Hits
+	for i := 0; i < 10; i++ {
+		if !c.HasKey("1") {
+			t.Errorf("Expected 1 to be in the cache")
+		}
+	}
+	if c.HasKey("3") {
+		t.Errorf("Expected 3 not to be in the cache")
+	}
+	if c.node["1"].Freq != 1 {
+		t.Errorf("Expected HasKey to leave the frequency of 1 unchanged")
+	}
+	if c.Stats().Misses != misses || c.Stats().Hits != hits {
+		t.Errorf("Expected HasKey to leave the metrics unchanged")
+	}
+
+	c.Put("3", "3")
+	if c.HasKey("1") {
+		t.Errorf("Expected 1 to be evicted as the least frequently used")
+	}
+	if !c.HasKey("2") || !c.HasKey("3") {
+		t.Errorf("Expected 2 and 3 to be in the cache")
+	}
+}
+
+func TestLFUCache_Load(t *testing.T) {
+	c := NewCache(3)
+	c.Load(map[string]string{"a": "a", "b": "b", "c": "c", "d": "d"},
+		map[string]int{"a": 5, "c": 3, "d": 2})
+	if c.Len() != 3 || c.HasKey("b") {
+		t.Errorf("Expected b without a hint to be evicted by the warmup")
+	}
+	if c.node["a"].Freq != 5 || c.node["c"].Freq != 3 || c.minFreq != 2 {
+		t.Errorf("Expected the warmed up entries to start at their hinted frequency")
+	}
+
+	c.Put("e", "e")
+	if c.HasKey("d") {
+		t.Errorf("Expected d to be evicted as the least frequently used")
+	}
+	c.Put("f", "f")
+	if c.HasKey("e") {
+		t.Errorf("Expected e to be evicted as the least frequently used")
+	}
+	for _, key := range []string{"a", "c", "f"} {
+		if !c.HasKey(key) {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+
+	c.Load(map[string]string{"a": "A", "f": "F"}, map[string]int{"a": 1, "f": 4})
+	if c.node["a"].Freq != 5 || c.node["f"].Freq != 4 || c.node["a"].Value != "A" {
+		t.Errorf("Expected the reloaded entries to keep the higher frequency")
+	}
+	if c.size != 3 {
+		t.Errorf("Expected size 3 but got %d", c.size)
+	}
+}
+
+func TestLFUCache_Resize(t *testing.T) {
+	c := NewCache(1000)
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	for i := 0; i < 10; i++ {
+		c.Get(strconv.Itoa(i))
+	}
+	if err := c.Resize(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 || c.size != 10 {
+		t.Errorf("Expected 10 entries of size 10 but got %d entries of size %d", c.Len(), c.size)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Meta(strconv.Itoa(i)); !ok {
+			t.Errorf("Expected the frequently used %d to survive the shrink", i)
+		}
+	}
+	if err := c.Resize(1000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if c.Len() != 510 {
+		t.Errorf("Expected the grown cache to keep all the entries but got %d", c.Len())
+	}
+}
+
+func TestLFUCache_Gauges(t *testing.T) {
+	c := NewCache(5, cache.WithMetricsName("lfucache-gauges"))
+	c.Put("1", "1")
+	c.Put("2", "22")
+	c.Put("2", "2")
+	c.Put("3", "333")
+	c.Delete("3")
+	c.Put("4", "4444")
+
+	if size := c.gauges.Size.Value(); size != float64(c.size) || size != 5 {
+		t.Errorf("Expected size 5 but got %f", size)
+	}
+	if entries := c.gauges.Entries.Value(); entries != float64(c.Len()) || entries != 2 {
+		t.Errorf("Expected 2 entries but got %f", entries)
+	}
+	if capacity := c.gauges.Capacity.Value(); capacity != 5 {
+		t.Errorf("Expected capacity 5 but got %f", capacity)
+	}
+}
+
+func TestLFUCache_Tracing(t *testing.T) {
+	tracer := &tracing.MemoryTracer{}
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.Noop)
+	ctx, request := tracing.Start(context.Background(), "request")
+	c := NewCache(4)
+	c.PutContext(ctx, "1", "11")
+	c.PutContext(ctx, "2", "22")
+	c.GetContext(ctx, "1")
+	c.GetContext(ctx, "3")
+	c.Get("1")
+	c.Delete("1")
+	expected := []struct {
+		name      string
+		hit       bool
+		size      int64
+		evictions int64
+	}{
+		{"cache.Put", false, 2, 0},
+		{"cache.Put", false, 2, 0},
+		{"cache.Get", true, 2, 0},
+		{"cache.Get", false, 0, 0},
+	}
+	spans := tracer.Spans()[1:]
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected %d spans but got %d", len(expected), len(spans))
+	}
+	for i, e := range expected {
+		s := spans[i]
+		if s.Name != e.name || !s.Ended || s.Attributes[tracing.HitAttribute] != e.hit ||
+			s.Attributes[tracing.ValueSizeAttribute] != e.size || s.Attributes[tracing.EvictionsAttribute] != e.evictions {
+			t.Errorf("Expected span %d to be %+v but got %s %v", i, e, s.Name, s.Attributes)
+		}
+		if s.Parent != request {
+			t.Errorf("Expected span %d to be a child of the span of the context", i)
+		}
+	}
+	c.PutContext(ctx, "3", "333")
+	if s := tracer.Spans()[len(expected)+1]; s.Attributes[tracing.EvictionsAttribute] != int64(1) ||
+		s.Attributes[tracing.KeyHashAttribute] != tracing.KeyHash("3") {
+		t.Errorf("Expected the put to evict 1 entry but got %v", s.Attributes)
+	}
+}
+
+func TestLFUCache_Remove(t *testing.T) {
+	c := NewCache(10)
+	c.Put("1", "one")
+	c.Put("2", "two")
+	c.Get("2")
+
+	if value, ok := c.Remove("2"); !ok || value != "two" {
+		t.Errorf("Expected two but got %s %t", value, ok)
+	}
+	if c.Len() != 1 || c.size != 3 {
+		t.Errorf("Expected 1 entry of size 3 but got %d of size %d", c.Len(), c.size)
+	}
+	if value, ok := c.Remove("2"); ok || value != "" {
+		t.Errorf("Expected 2 to be already removed but got %s %t", value, ok)
+	}
+	if value, ok := c.Remove("1"); !ok || value != "one" || c.Len() != 0 || c.size != 0 {
+		t.Errorf("Expected one and an empty cache but got %s %t", value, ok)
+	}
+	c.Put("3", "three")
+	if value, ok := c.Get("3"); !ok || value != "three" {
+		t.Errorf("Expected the cache to be usable after Remove")
+	}
+}
+
+// checkMinFreq verifies that there is no empty bucket and that
+// minFreq is the smallest frequency of a non-empty bucket
+func checkMinFreq(t *testing.T, c *LFUCache) {
+	t.Helper()
+	if len(c.node) == 0 {
+		return
+	}
+	if list, ok := c.freq[c.minFreq]; !ok || list.Size() == 0 {
+		t.Fatalf("Expected the minFreq %d bucket to be non-empty", c.minFreq)
+	}
+	entries := 0
+	for freq, list := range c.freq {
+		if list.Size() == 0 {
+			t.Fatalf("Expected no empty bucket but got %d", freq)
+		}
+		if freq < c.minFreq {
+			t.Fatalf("Expected minFreq %d to be the smallest but got %d", c.minFreq, freq)
+		}
+		entries += list.Size()
+	}
+	if entries != len(c.node) {
+		t.Fatalf("Expected %d entries in the buckets but got %d", len(c.node), entries)
+	}
+}
+
+func TestLFUCache_MinFreqStress(t *testing.T) {
+	c := NewCache(50)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(r.Intn(100))
+		switch r.Intn(6) {
+		case 0:
+			c.Delete(key)
+		case 1:
+			c.Put(key, strings.Repeat("x", r.Intn(5)))
+		default:
+			// skew the accesses so the keys spread over many frequencies
+			c.Get(strconv.Itoa(r.Intn(1 + r.Intn(100))))
+		}
+		checkMinFreq(t, c)
+		if err := c.CheckInvariants(); err != nil {
+			t.Fatalf("Expected the invariants to hold but got %v", err)
+		}
+		if c.size > c.capacity {
+			t.Fatalf("Expected the size %d to fit in the capacity", c.size)
+		}
+	}
+}
+
+func TestLFUCache_Oversized(t *testing.T) {
+	c := NewCache(3)
+	c.Put("1", "1")
+	if c.Put("2", "2222") {
+		t.Errorf("Expected the value larger than the capacity to be rejected")
+	}
+	if _, ok := c.Get("1"); !ok || c.size != 1 {
+		t.Errorf("Expected 1 to survive the rejected put")
+	}
+	c.Put("1", "1111")
+	if c.Len() != 0 || c.size != 0 {
+		t.Errorf("Expected the oversized update to be evicted")
+	}
+	checkMinFreq(t, c)
+}
+
+func TestLFUCache_ConcurrentReadsOrder(t *testing.T) {
+	exact := NewCache(20)
+	concurrent := NewCacheWithConcurrentReads(20)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(r.Intn(40))
+		for _, c := range []*LFUCache{exact, concurrent} {
+			if _, ok := c.Get(key); !ok {
+				c.Put(key, "x")
+			}
+		}
+	}
+	concurrent.Lock()
+	concurrent.settle()
+	concurrent.Unlock()
+	for key, node := range exact.node {
+		other, ok := concurrent.node[key]
+		if !ok || other.Freq != node.Freq {
+			t.Fatalf("Expected %s with frequency %d in both caches", key, node.Freq)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		exact.EvictN(1)
+		concurrent.EvictN(1)
+		if exact.Len() != concurrent.Len() {
+			t.Fatalf("Expected the same number of entries")
+		}
+		for key := range exact.node {
+			if _, ok := concurrent.node[key]; !ok {
+				t.Fatalf("Expected the same eviction order but %s is evicted", key)
+			}
+		}
+	}
+}
+
+func TestLFUCache_ConcurrentReadsOverflow(t *testing.T) {
+	c := NewCacheWithConcurrentReads(bytesize.ByteSize(2 * hitsBuffer))
+	for i := 0; i < 2*hitsBuffer; i++ {
+		c.Put(strconv.Itoa(i), "x")
+	}
+	for i := 0; i < 2*hitsBuffer; i++ {
+		c.Get(strconv.Itoa(i))
+	}
+	c.Get("0")
+	if meta, _ := c.Meta("0"); meta.AccessCount != 3 {
+		t.Errorf("Expected the pending hits in the access count but got %d", meta.AccessCount)
+	}
+	c.Put("new", "x")
+	if !c.HasKey("0") || !c.HasKey("new") {
+		t.Errorf("Expected the pending hits to be settled before the eviction")
+	}
+	checkMinFreq(t, c)
+}
+
+func TestLFUCache_ConcurrentReadsThreadSafety(t *testing.T) {
+	c := NewCacheWithConcurrentReads(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				key := strconv.Itoa((i * j) % 150)
+				if _, ok := c.Get(key); !ok {
+					c.Put(key, key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.Lock()
+	c.settle()
+	c.Unlock()
+	checkMinFreq(t, c)
+}
+
+func benchmarkParallelReads(b *testing.B, c *LFUCache, get func(key string)) {
+	for i := 0; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "x")
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			get(strconv.Itoa(i % 1000))
+			i++
+		}
+	})
+}
+
+func BenchmarkLFUCache_ReadsLocked(b *testing.B) {
+	c := NewCache(10000)
+	benchmarkParallelReads(b, c, func(key string) { c.Get(key) })
+}
+
+func BenchmarkLFUCache_ReadsConcurrent(b *testing.B) {
+	c := NewCacheWithConcurrentReads(10000)
+	benchmarkParallelReads(b, c, func(key string) { c.Get(key) })
+}
+
+func TestLFUCache_MinEntries(t *testing.T) {
+	c := NewCache(10, cache.WithMinEntries(3))
+	for i := 0; i < 5; i++ {
+		c.Put(strconv.Itoa(i), "xx")
+	}
+	c.Get("0")
+	c.Put("big", strings.Repeat("x", 8))
+	if c.Len() != 3 {
+		t.Fatalf("Expected 3 entries to survive but got %d", c.Len())
+	}
+	for _, key := range []string{"big", "0", "4"} {
+		if !c.HasKey(key) {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+	if c.size != 12 {
+		t.Errorf("Expected the size to overshoot the capacity but got %d", c.size)
+	}
+	checkMinFreq(t, c)
+}
+
+func TestLFUCache_OrderedKeys(t *testing.T) {
+	c := NewCache(10)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	c.Put("d", "d")
+	c.Get("b")
+	c.Get("b")
+	c.Get("c")
+	c.Get("a")
+	if keys := strings.Join(c.OrderedKeys(), ","); keys != "b,a,c,d" {
+		t.Errorf("Expected b,a,c,d but got %s", keys)
+	}
+	c.EvictN(2)
+	if keys := strings.Join(c.OrderedKeys(), ","); keys != "b,a" {
+		t.Errorf("Expected d and c to be evicted first but got %s", keys)
+	}
+}
+
+func TestLFUCache_EvictInconsistent(t *testing.T) {
+	c := NewCache(10)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	// a size that is not reclaimed by evicting all the entries
+	c.size += 100
+	anomalies := metrics.Value(metrics.Anomalies)
+	if c.Put("3", "3") || c.Len() != 0 {
+		t.Errorf("Expected no room for 3")
+	}
+	if metrics.Value(metrics.Anomalies) != anomalies+1 {
+		t.Errorf("Expected the eviction to stop on an anomaly once the entries are evicted")
+	}
+}
+
+func TestLFUCache_EvictMissingBuckets(t *testing.T) {
+	c := NewCache(2)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	// entries that are in no frequency bucket
+	c.freq = map[int]*dlinklist.DLinkedList{}
+	anomalies := metrics.Value(metrics.Anomalies)
+	if c.Put("3", "3") {
+		t.Errorf("Expected no room for 3")
+	}
+	if metrics.Value(metrics.Anomalies) != anomalies+1 {
+		t.Errorf("Expected the eviction to stop on an anomaly")
+	}
+}
+
+func TestLFUCache_Closed(t *testing.T) {
+	c := NewCache(100, cache.WithFrequencyDecay(time.Hour, 0.5))
+	c.Put("1", "one")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected a closed cache to miss")
+	}
+	if c.Put("2", "two") || c.PutIfAbsent("3", "three") {
+		t.Errorf("Expected a closed cache to drop the puts")
+	}
+	c.PutMulti(map[string]string{"4": "four"})
+	if c.Len() != 0 || c.size != 0 {
+		t.Errorf("Expected a closed cache to be empty but got %d entries of %d bytes", c.Len(), c.size)
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLFUCache_FrequencyDecay(t *testing.T) {
+	c := NewCache(3, cache.WithFrequencyDecay(10*time.Millisecond, 0.5))
+	c.Put("hot", "x")
+	for i := 0; i < 100; i++ {
+		c.Get("hot")
+	}
+	c.Put("a", "x")
+	c.Put("b", "x")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if meta, _ := c.Meta("hot"); meta.AccessCount == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the frequency of hot to decay")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// stop the decay, a closed cache would drop the entries
+	close(c.done)
+	<-c.stopped
+	checkMinFreq(t, c)
+
+	c.Get("a")
+	c.Get("b")
+	c.Put("c", "x")
+	if c.HasKey("hot") {
+		t.Errorf("Expected the cold hot to be evicted after the decay")
+	}
+}
+
+func TestLFUCache_Doorkeeper(t *testing.T) {
+	c := NewCache(100, cache.WithDoorkeeper(1000, 0.01))
+	if c.Put("1", "1") || c.HasKey("1") {
+		t.Errorf("Expected the first put of 1 to be rejected")
+	}
+	if !c.Put("1", "1") || !c.HasKey("1") {
+		t.Errorf("Expected the second put of 1 to be admitted")
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := c.Get("2"); ok {
+			t.Errorf("Expected 2 to be missed")
+		}
+		if c.Put("2", "2") != (i == 1) {
+			t.Errorf("Expected 2 to be admitted only on the second access")
+		}
+	}
+
+	rejected := 0
+	for i := 0; i < 50; i++ {
+		key := "scan" + strconv.Itoa(i)
+		c.Get(key)
+		if !c.Put(key, "x") {
+			rejected++
+		}
+	}
+	if rejected < 45 {
+		t.Errorf("Expected the one-hit keys to be rejected but only %d are", rejected)
+	}
+}
+
+func TestLFUCache_DoorkeeperWindow(t *testing.T) {
+	c := NewCache(100, cache.WithDoorkeeper(4, 0.01))
+	c.Put("1", "1")
+	for i := 0; i < 3; i++ {
+		c.Put("scan"+strconv.Itoa(i), "x")
+	}
+	if c.Put("1", "1") {
+		t.Errorf("Expected the doorkeeper to be cleared after the window")
+	}
+
+	c = NewCacheWithAdmission(100, 20, cache.WithDoorkeeper(1000, 0.01))
+	c.Put("1", "1")
+	for i := 0; i < 20; i++ {
+		c.Get("other")
+	}
+	if c.Put("1", "1") {
+		t.Errorf("Expected the doorkeeper to be cleared with the sketch")
+	}
+}
+
+// scanHitRatio runs a hot set of keys interleaved with a scan of keys that
+// are accessed once and returns the hit ratio of the hot keys
+func scanHitRatio(c *LFUCache) float64 {
+	r := rand.New(rand.NewSource(1))
+	hits, gets := 0, 0
+	for i := 0; i < 20000; i++ {
+		key := "scan" + strconv.Itoa(i)
+		if i%2 == 0 {
+			key = "hot" + strconv.Itoa(r.Intn(60))
+			gets++
+		}
+		if _, ok := c.Get(key); ok {
+			if i%2 == 0 {
+				hits++
+			}
+		} else {
+			c.Put(key, "x")
+		}
+	}
+	return float64(hits) / float64(gets)
+}
+
+func TestLFUCache_DoorkeeperScan(t *testing.T) {
+	without := scanHitRatio(NewCache(50))
+	with := scanHitRatio(NewCache(50, cache.WithDoorkeeper(1000, 0.01)))
+	if with <= without {
+		t.Errorf("Expected the doorkeeper to improve the hit ratio but got %.3f and %.3f", with, without)
+	}
+	t.Logf("hit ratio %.3f with the doorkeeper and %.3f without", with, without)
+}
+
+func TestLFUCache_CloseGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		c := NewCache(100,
+			cache.WithFrequencyDecay(time.Millisecond, 0.5),
+			cache.WithPeriodicSnapshot(filepath.Join(dir, strconv.Itoa(i)), time.Millisecond),
+			cache.WithSnapshotMutations(10))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Put(strconv.Itoa(j), "x")
+				c.Get(strconv.Itoa(j / 2))
+			}
+		}()
+		time.Sleep(2 * time.Millisecond)
+		if err := c.Close(); err != nil {
+			t.Fatalf("Close failed %v", err)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatalf("Expected Close to be idempotent but got %v", err)
+		}
+	}
+	wg.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected no goroutine leak but got %d goroutines, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLFUCache_CheckInvariants(t *testing.T) {
+	corruptions := map[string]func(c *LFUCache){
+		"size": func(c *LFUCache) { c.size++ },
+		"minFreq": func(c *LFUCache) {
+			c.minFreq = 7
+		},
+		"orphan": func(c *LFUCache) {
+			c.node["d"] = &dlinklist.Node{Key: "d", Freq: 1}
+		},
+		"unlinked": func(c *LFUCache) {
+			c.freq[1].AddNode(&dlinklist.Node{Key: "d", Freq: 1})
+		},
+		"frequency": func(c *LFUCache) { c.node["a"].Freq = 5 },
+		"empty": func(c *LFUCache) {
+			c.freq[9] = dlinklist.NewLinkedList()
+		},
+		"pinned": func(c *LFUCache) {
+			c.pinned["d"] = &dlinklist.Node{Key: "d"}
+		},
+	}
+	for name, corrupt := range corruptions {
+		c := NewCache(10)
+		c.Put("a", "a")
+		c.Put("b", "bb")
+		c.Put("c", "c")
+		c.Get("b")
+		if err := c.CheckInvariants(); err != nil {
+			t.Fatalf("Expected the invariants to hold but got %v", err)
+		}
+		corrupt(c)
+		if err := c.CheckInvariants(); err == nil {
+			t.Errorf("Expected the %s corruption to be caught", name)
+		}
+	}
+}
+
+// snapshotOf returns the snapshot of n entries as Persist writes it
+func snapshotOf(n int) []byte {
+	store := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		store[strconv.Itoa(i)] = "x"
+	}
+	b, _ := json.Marshal(store)
+	return b
+}
+
+func TestLFUCache_Restore(t *testing.T) {
+	c := NewCache(100)
+	c.Put("a", "a")
+	c.Get("a")
+	if err := c.Restore(ioutil.NopCloser(bytes.NewReader(snapshotOf(1000)))); err != nil {
+		t.Fatalf("Expected the snapshot to be restored but got %v", err)
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Fatalf("Expected the invariants to hold but got %v", err)
+	}
+	if c.Len() != 100 || c.size > c.capacity {
+		t.Errorf("Expected 100 entries in the capacity but got %d of size %d", c.Len(), c.size)
+	}
+	if !c.HasKey("a") {
+		t.Errorf("Expected the more frequent a to survive the eviction")
+	}
+
+	c = NewCache(10)
+	if err := c.Restore(ioutil.NopCloser(strings.NewReader(`{"a":"a","b":"bb"}`))); err != nil {
+		t.Fatalf("Expected the snapshot to be restored but got %v", err)
+	}
+	if value, ok := c.Get("b"); !ok || value != "bb" || c.size != 3 {
+		t.Errorf("Expected b of size 3 but got %s of %d", value, c.size)
+	}
+	for _, snapshot := range []string{`["a"]`, `{"a":1}`, `{"a":"a"`} {
+		if err := c.Restore(ioutil.NopCloser(strings.NewReader(snapshot))); err == nil {
+			t.Errorf("Expected %s to be rejected", snapshot)
+		}
+		if err := c.CheckInvariants(); err != nil {
+			t.Errorf("Expected the invariants to hold but got %v", err)
+		}
+	}
+}
+
+// restoreByPut restores the snapshot like Restore did before the bulk
+// load, one Put per entry
+func restoreByPut(c *LFUCache, b []byte) {
+	o := make(map[string]string)
+	_ = json.Unmarshal(b, &o)
+	for k, v := range o {
+		c.Put(k, v)
+	}
+}
+
+func BenchmarkLFUCache_RestoreByPut(b *testing.B) {
+	snapshot := snapshotOf(1000000)
+	for i := 0; i < b.N; i++ {
+		restoreByPut(NewCache(2000000), snapshot)
+	}
+}
+
+func BenchmarkLFUCache_Restore(b *testing.B) {
+	snapshot := snapshotOf(1000000)
+	for i := 0; i < b.N; i++ {
+		_ = NewCache(2000000).Restore(ioutil.NopCloser(bytes.NewReader(snapshot)))
+	}
+}
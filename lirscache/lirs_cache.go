@@ -0,0 +1,270 @@
+// Package lirscache implements LIRS (Low Inter-reference Recency Set) cache
+package lirscache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/inhies/go-bytesize"
+	"sync"
+)
+
+// defaultHIRRatio is the default share of the capacity for the resident HIR entries
+const defaultHIRRatio = 0.01
+
+type status int
+
+const (
+	lir status = iota
+	hirResident
+	hirNonResident
+)
+
+type entry struct {
+	value  string
+	status status
+	// s is the node of the entry in the stack, nil if it is pruned
+	s *dlinklist.Node
+	// q is the node of the entry in the queue of the resident HIR
+	// entries, or in the history of the non-resident ones
+	q *dlinklist.Node
+}
+
+// LIRSCache data structure, the entries with a low inter-reference recency
+// (LIR) are always resident, the rest (HIR) share a small part of the
+// capacity. The stack orders the entries by recency and its bottom is always
+// a LIR entry, a HIR entry that is accessed again while it is still in the
+// stack has a lower inter-reference recency than the bottom LIR entry so it
+// takes its place. The queue orders the resident HIR entries for eviction,
+// evicted entries are remembered as non-resident while they are in the stack.
+type LIRSCache struct {
+	sync.Mutex
+	cache.UnImplementedCache
+	entries  map[string]*entry
+	stack    *dlinklist.DLinkedList
+	queue    *dlinklist.DLinkedList
+	history  *dlinklist.DLinkedList
+	lirCap   int
+	hirCap   int
+	lirCount int
+	counters *metrics.Counters
+}
+
+// NewCache LIRSCache constructor, capacity is the maximum number of entries
+func NewCache(capacity int) *LIRSCache {
+	return NewCacheWithRatio(capacity, defaultHIRRatio)
+}
+
+// NewCacheWithRatio LIRSCache constructor, hirRatio is the share of the
+// capacity for the resident HIR entries, at least one entry
+func NewCacheWithRatio(capacity int, hirRatio float64) *LIRSCache {
+	hirCap := int(float64(capacity) * hirRatio)
+	if hirCap < 1 {
+		hirCap = 1
+	}
+	if hirCap > capacity {
+		hirCap = capacity
+	}
+	return &LIRSCache{
+		entries:  map[string]*entry{},
+		stack:    dlinklist.NewLinkedList(),
+		queue:    dlinklist.NewLinkedList(),
+		history:  dlinklist.NewLinkedList(),
+		lirCap:   capacity - hirCap,
+		hirCap:   hirCap,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+}
+
+// Get returns the value for the key
+func (c *LIRSCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok || e.status == hirNonResident {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.access(key, e)
+	return e.value, true
+}
+
+// Put updates or inserts a new entry, a new entry is LIR until the LIR
+// part of the capacity is full and HIR after that
+func (c *LIRSCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	if c.lirCap+c.hirCap == 0 {
+		return false
+	}
+	e, ok := c.entries[key]
+	if ok && e.status != hirNonResident {
+		e.value = value
+		c.access(key, e)
+		return false
+	}
+
+	c.counters.Adds.Inc()
+	if c.lirCount < c.lirCap {
+		if ok {
+			c.history.RemoveNode(e.q)
+			c.stack.RemoveNode(e.s)
+		}
+		e = &entry{value: value, status: lir, s: &dlinklist.Node{Key: key}}
+		c.entries[key] = e
+		c.stack.AddNode(e.s)
+		c.lirCount++
+		return true
+	}
+
+	if c.queue.Size() >= c.hirCap {
+		// the eviction may forget the non-resident entry of the key
+		c.evict()
+		e, ok = c.entries[key]
+	}
+	if ok {
+		// a non-resident entry accessed again while in the stack
+		c.history.RemoveNode(e.q)
+		c.stack.RemoveNode(e.s)
+		c.stack.AddNode(e.s)
+		e.value = value
+		c.promote(e)
+		return true
+	}
+	e = &entry{value: value, status: hirResident,
+		s: &dlinklist.Node{Key: key}, q: &dlinklist.Node{Key: key}}
+	c.entries[key] = e
+	c.stack.AddNode(e.s)
+	c.queue.AddNode(e.q)
+	return true
+}
+
+// access moves an accessed resident entry to the top of the stack,
+// the caller must hold the lock
+func (c *LIRSCache) access(key string, e *entry) {
+	switch {
+	case e.status == lir:
+		c.stack.RemoveNode(e.s)
+		c.stack.AddNode(e.s)
+		c.prune()
+	case e.s != nil:
+		c.stack.RemoveNode(e.s)
+		c.stack.AddNode(e.s)
+		c.queue.RemoveNode(e.q)
+		c.promote(e)
+	default:
+		e.s = &dlinklist.Node{Key: key}
+		c.stack.AddNode(e.s)
+		c.queue.RemoveNode(e.q)
+		c.queue.AddNode(e.q)
+	}
+}
+
+// promote turns a HIR entry at the top of the stack to LIR and demotes
+// the bottom LIR entries to the queue, the caller must hold the lock
+func (c *LIRSCache) promote(e *entry) {
+	e.status = lir
+	e.q = nil
+	c.lirCount++
+	for c.lirCount > c.lirCap {
+		bottom := c.stack.PopTail()
+		demoted := c.entries[bottom.Key]
+		demoted.status = hirResident
+		demoted.s = nil
+		demoted.q = &dlinklist.Node{Key: bottom.Key}
+		c.queue.AddNode(demoted.q)
+		c.lirCount--
+		c.prune()
+	}
+	for c.queue.Size() > c.hirCap {
+		c.evict()
+	}
+}
+
+// prune removes the HIR entries from the bottom of the stack so
+// its bottom is a LIR entry, the caller must hold the lock
+func (c *LIRSCache) prune() {
+	for bottom := c.stack.Tail(); bottom != nil; bottom = c.stack.Tail() {
+		e := c.entries[bottom.Key]
+		if e.status == lir {
+			return
+		}
+		c.stack.RemoveNode(bottom)
+		e.s = nil
+		if e.status == hirNonResident {
+			c.history.RemoveNode(e.q)
+			delete(c.entries, bottom.Key)
+		}
+	}
+}
+
+// evict evicts the oldest resident HIR entry, it is remembered as
+// non-resident while it is in the stack. The non-resident entries are
+// limited to the capacity, the caller must hold the lock
+func (c *LIRSCache) evict() {
+	c.counters.Deletes.Inc()
+	c.counters.Evictions.Inc()
+	q := c.queue.PopTail()
+	e := c.entries[q.Key]
+	if e.s == nil {
+		delete(c.entries, q.Key)
+		return
+	}
+	e.status = hirNonResident
+	e.value = ""
+	c.history.AddNode(e.q)
+	if c.history.Size() > c.lirCap+c.hirCap {
+		old := c.history.PopTail()
+		c.stack.RemoveNode(c.entries[old.Key].s)
+		delete(c.entries, old.Key)
+	}
+}
+
+// Delete deletes the key from the cache
+func (c *LIRSCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if e.s != nil {
+		c.stack.RemoveNode(e.s)
+	}
+	switch e.status {
+	case lir:
+		c.lirCount--
+	case hirResident:
+		c.queue.RemoveNode(e.q)
+	case hirNonResident:
+		c.history.RemoveNode(e.q)
+	}
+	delete(c.entries, key)
+	c.prune()
+	if e.status == hirNonResident {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *LIRSCache) Close() error {
+	return nil
+}
+
+// Len returns the number of resident entries in the cache
+func (c *LIRSCache) Len() int {
+	defer c.Unlock()
+	c.Lock()
+	return c.lirCount + c.queue.Size()
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *LIRSCache) Stats() cache.Stats {
+	defer c.Unlock()
+	c.Lock()
+	return cache.NewStats(c.counters, bytesize.ByteSize(c.lirCount+c.queue.Size()), c.lirCount+c.queue.Size())
+}
@@ -0,0 +1,168 @@
+package lirscache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// check verifies the invariants of the stack and the queue
+func check(t *testing.T, c *LIRSCache) {
+	t.Helper()
+	if bottom := c.stack.Tail(); bottom != nil && c.entries[bottom.Key].status != lir {
+		t.Fatalf("Expected a LIR entry at the bottom of the stack")
+	}
+	lirs, residents, nonResidents := 0, 0, 0
+	for key, e := range c.entries {
+		switch e.status {
+		case lir:
+			lirs++
+			if e.s == nil {
+				t.Fatalf("Expected LIR entry %s to be in the stack", key)
+			}
+		case hirResident:
+			residents++
+		case hirNonResident:
+			nonResidents++
+			if e.s == nil {
+				t.Fatalf("Expected non-resident entry %s to be in the stack", key)
+			}
+		}
+	}
+	if lirs != c.lirCount || lirs > c.lirCap {
+		t.Fatalf("Expected %d LIR entries but got %d", c.lirCount, lirs)
+	}
+	if residents != c.queue.Size() || residents > c.hirCap {
+		t.Fatalf("Expected %d resident HIR entries but got %d", c.queue.Size(), residents)
+	}
+	if nonResidents != c.history.Size() {
+		t.Fatalf("Expected %d non-resident entries but got %d", c.history.Size(), nonResidents)
+	}
+}
+
+func TestLIRSCache(t *testing.T) {
+	c := NewCache(3)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	c.Put("d", "d")
+	check(t, c)
+	if _, ok := c.Get("c"); ok {
+		t.Errorf("Expected the HIR entry c to be evicted")
+	}
+	if c.entries["c"].status != hirNonResident {
+		t.Errorf("Expected c to be remembered as non-resident")
+	}
+
+	// c is accessed again while in the stack, so it becomes LIR
+	c.Put("c", "c")
+	check(t, c)
+	if c.entries["c"].status != lir || c.entries["a"].status != hirResident {
+		t.Errorf("Expected c to be promoted and a to be demoted")
+	}
+	if _, ok := c.Get("d"); ok {
+		t.Errorf("Expected d to be evicted")
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if value, ok := c.Get(key); !ok || value != key {
+			t.Errorf("Expected %s but got %s %t", key, value, ok)
+		}
+	}
+	if c.Len() != 3 {
+		t.Errorf("Expected 3 entries but got %d", c.Len())
+	}
+}
+
+func TestLIRSCache_Scan(t *testing.T) {
+	c := NewCacheWithRatio(10, 0.2)
+	for i := 0; i < 8; i++ {
+		c.Put(strconv.Itoa(i), "x")
+	}
+	for i := 100; i < 200; i++ {
+		c.Put(strconv.Itoa(i), "x")
+		check(t, c)
+	}
+	for i := 0; i < 8; i++ {
+		if _, ok := c.Get(strconv.Itoa(i)); !ok {
+			t.Errorf("Expected the LIR entry %d to survive the scan", i)
+		}
+	}
+	if c.history.Size() > 10 {
+		t.Errorf("Expected at most 10 non-resident entries but got %d", c.history.Size())
+	}
+}
+
+func TestLIRSCache_Delete(t *testing.T) {
+	c := NewCache(3)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	c.Put("d", "d")
+	if !c.Delete("a") || c.Delete("a") {
+		t.Errorf("Expected a to be deleted once")
+	}
+	if c.Delete("c") {
+		t.Errorf("Expected the non-resident c not to be deleted")
+	}
+	check(t, c)
+	c.Put("e", "e")
+	check(t, c)
+	if c.entries["e"].status != lir || c.Len() != 3 {
+		t.Errorf("Expected e to take the LIR place of a")
+	}
+}
+
+func TestLIRSCache_Random(t *testing.T) {
+	c := NewCacheWithRatio(20, 0.1)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(r.Intn(60))
+		switch r.Intn(4) {
+		case 0:
+			c.Delete(key)
+		case 1:
+			c.Get(key)
+		default:
+			c.Put(key, key)
+		}
+		check(t, c)
+		if c.Len() > 20 {
+			t.Fatalf("Expected at most 20 entries but got %d", c.Len())
+		}
+	}
+}
+
+func TestLIRSCache_ZeroCapacity(t *testing.T) {
+	c := NewCache(0)
+	if c.Put("1", "1") {
+		t.Errorf("Expected the put to be rejected")
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 not to be found")
+	}
+}
+
+// benchmarkCyclic accesses the keys in a loop larger than the capacity,
+// LRU always evicts the next key of the loop while LIRS keeps most of them
+func benchmarkCyclic(b *testing.B, c cache.UnImplementedCache) {
+	hits := 0
+	for i := 0; i < b.N; i++ {
+		key := strconv.Itoa(i % 150)
+		if _, ok := c.Get(key); ok {
+			hits++
+		} else {
+			c.Put(key, "x")
+		}
+	}
+	b.ReportMetric(float64(hits)/float64(b.N), "hits/op")
+}
+
+func BenchmarkLIRSCache_Cyclic(b *testing.B) {
+	benchmarkCyclic(b, NewCache(100))
+}
+
+func BenchmarkLRUCache_Cyclic(b *testing.B) {
+	benchmarkCyclic(b, lrucache.NewCache(100))
+}
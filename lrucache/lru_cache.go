@@ -2,135 +2,1203 @@
 package lrucache
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"github.com/arazmj/gerdu/cache"
 	"github.com/arazmj/gerdu/dlinklist"
 	"github.com/arazmj/gerdu/metrics"
+	"github.com/arazmj/gerdu/tracing"
 	"github.com/hashicorp/raft"
 	"github.com/inhies/go-bytesize"
 	"io"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //LRUCache data structure
 type LRUCache struct {
-	sync.RWMutex
+	*cache.RWMutex
 	cache.UnImplementedCache
 	node     map[string]*dlinklist.Node
+	negative map[string]*dlinklist.Node
+	pinned   map[string]*dlinklist.Node
 	linklist *dlinklist.DLinkedList
 	capacity bytesize.ByteSize
 	size     bytesize.ByteSize
+	options  *cache.Options
+	counters *metrics.Counters
+	gauges   *metrics.Gauges
+	latency  *metrics.Latencies
+	snapshot *cache.Snapshotter
+	wheel    *cache.TimerWheel
+	sweeper  *cache.Sweeper
+	loads    cache.Group
+	version  uint64
+	keys     cache.KeyIndex
+	events   cache.Broadcaster
+	pending  []cache.Event
+	closed   bool
 }
 
+// the cache must satisfy the interfaces used by the servers
+var (
+	_ cache.UnImplementedCache = (*LRUCache)(nil)
+	_ cache.Sizer              = (*LRUCache)(nil)
+	_ cache.NegativeCache      = (*LRUCache)(nil)
+	_ cache.ContextCache       = (*LRUCache)(nil)
+	_ cache.MetaCache          = (*LRUCache)(nil)
+	_ cache.ExpiryCache        = (*LRUCache)(nil)
+	_ cache.OrderedCache       = (*LRUCache)(nil)
+	_ cache.PinCache           = (*LRUCache)(nil)
+	_ cache.AbsentCache        = (*LRUCache)(nil)
+	_ cache.Closer             = (*LRUCache)(nil)
+	_ cache.Checker            = (*LRUCache)(nil)
+	_ cache.TTLCache           = (*LRUCache)(nil)
+	_ cache.LoaderCache        = (*LRUCache)(nil)
+	_ cache.DumpCache          = (*LRUCache)(nil)
+	_ cache.BatchCache         = (*LRUCache)(nil)
+	_ cache.CounterCache       = (*LRUCache)(nil)
+	_ cache.VersionCache       = (*LRUCache)(nil)
+	_ cache.AppendCache        = (*LRUCache)(nil)
+	_ cache.ScanCache          = (*LRUCache)(nil)
+	_ cache.PatternCache       = (*LRUCache)(nil)
+	_ cache.PeekCache          = (*LRUCache)(nil)
+	_ cache.ExpireCache        = (*LRUCache)(nil)
+	_ cache.StatsCache         = (*LRUCache)(nil)
+	_ cache.ReplaceCache       = (*LRUCache)(nil)
+	_ cache.EventCache         = (*LRUCache)(nil)
+	_ cache.ResizeCache        = (*LRUCache)(nil)
+)
+
 // NewCache LRUCache constructor
-func NewCache(capacity bytesize.ByteSize) *LRUCache {
+func NewCache(capacity bytesize.ByteSize, opts ...cache.Option) *LRUCache {
+	options := cache.NewOptions(opts...)
 	l := &LRUCache{
-		RWMutex:  sync.RWMutex{},
+		RWMutex:  cache.NewRWMutex(),
 		node:     map[string]*dlinklist.Node{},
+		negative: map[string]*dlinklist.Node{},
+		pinned:   map[string]*dlinklist.Node{},
 		linklist: dlinklist.NewLinkedList(),
 		capacity: capacity,
 		size:     0,
+		options:  options,
+		counters: metrics.NewCounters(options.Recorder, options.MetricsName),
+		gauges:   metrics.NewGauges(options.Recorder, options.MetricsName),
+		latency:  metrics.NewLatencies(options.Recorder, options.MetricsName),
+	}
+	l.gauges.Capacity.Set(float64(capacity))
+	if options.ExpirationTick > 0 {
+		l.wheel = cache.NewTimerWheel(options.ExpirationTick, options.Now())
 	}
+	l.snapshot = cache.StartSnapshots(options, l.Export, l.Import)
+	l.sweeper = cache.StartSweeper(options, l.sweep)
 	return l
 }
 
+// NewCacheWithEntries LRUCache constructor that is limited by the number
+// of entries instead of their size, a WithMaxEntries in opts is overridden
+func NewCacheWithEntries(maxEntries int, opts ...cache.Option) *LRUCache {
+	opts = append(opts[:len(opts):len(opts)], cache.WithMaxEntries(maxEntries))
+	return NewCache(cache.Unlimited, opts...)
+}
+
+// lock locks the cache and records the time it waited for the lock
+func (c *LRUCache) lock() {
+	start := time.Now()
+	c.Lock()
+	c.latency.LockWait.Since(start)
+}
+
+// lockContext locks the cache like lock unless the context is done before
+// the lock is acquired
+func (c *LRUCache) lockContext(ctx context.Context) error {
+	start := time.Now()
+	if err := c.LockContext(ctx); err != nil {
+		return err
+	}
+	c.latency.LockWait.Since(start)
+	return nil
+}
+
 // Get returns the value for the key
 func (c *LRUCache) Get(key string) (value string, ok bool) {
+	defer c.latency.Get.Since(time.Now())
+	defer c.publish()
 	defer c.Unlock()
-	c.Lock()
-	if value, ok := c.node[key]; ok {
-		metrics.Hits.Inc()
-		node := value
+	c.lock()
+	return c.get(key)
+}
+
+// GetWithExpiry returns the value for the key like Get and the time it
+// expires, the zero time if it never expires
+func (c *LRUCache) GetWithExpiry(key string) (value string, expiresAt time.Time, ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if value, ok = c.get(key); !ok {
+		return "", time.Time{}, false
+	}
+	return value, c.node[key].Expires, true
+}
+
+// Gets returns the value for the key like Get and its version token
+func (c *LRUCache) Gets(key string) (value string, version uint64, ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if value, ok = c.get(key); !ok {
+		return "", 0, false
+	}
+	return value, c.node[key].Version, true
+}
+
+// Cas replaces the value of the key only if its version token is still
+// version, it returns ErrNotFound if the key is not in the cache and
+// ErrVersionMismatch if the value was updated since
+func (c *LRUCache) Cas(key string, value string, version uint64) error {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return cache.ErrNotFound
+	}
+	if node.Version != version {
+		return cache.ErrVersionMismatch
+	}
+	c.put(key, value)
+	c.evict()
+	return nil
+}
+
+// GetContext returns the value for the key like Get in a span of the
+// context, unless the context is done before the lock is acquired
+func (c *LRUCache) GetContext(ctx context.Context, key string) (value string, ok bool, err error) {
+	span := tracing.StartOp(ctx, "cache.Get", key)
+	if err := c.lockContext(ctx); err != nil {
+		span.End()
+		return "", false, err
+	}
+	defer c.publish()
+	defer c.Unlock()
+	evictions := c.counters.Evictions.Count()
+	value, ok = c.get(key)
+	tracing.EndOp(span, ok, len(value), c.counters.Evictions.Count()-evictions)
+	return value, ok, nil
+}
+
+// GetMulti returns the values of the keys that are found in the cache
+func (c *LRUCache) GetMulti(keys []string) map[string]string {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if value, ok := c.get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// get looks up the key and moves it to the head of the linked list,
+// the caller must hold the lock
+func (c *LRUCache) get(key string) (value string, ok bool) {
+	node, ok := c.node[key]
+	if ok && node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.linklist.RemoveNode(node)
+	c.linklist.AddNode(node)
+	node.Freq++
+	node.Touched = c.options.Now()
+	if c.options.SlidingTTL && node.TTL > 0 {
+		c.expire(node, node.TTL)
+	}
+	return node.Value, true
+}
+
+// Peek returns the value for the key without moving it in the linked list
+// or counting a hit or a miss
+func (c *LRUCache) Peek(key string) (value string, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return "", false
+	}
+	return node.Value, true
+}
+
+// Touch moves the key to the head of the linked list like Get and restarts
+// its time to live, it returns false if the key is not in the cache
+func (c *LRUCache) Touch(key string) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if _, ok = c.get(key); !ok {
+		return false
+	}
+	if node := c.node[key]; node.TTL > 0 {
+		c.expire(node, node.TTL)
+	}
+	return true
+}
+
+// TTL returns the time left until the key expires, zero if it never expires
+func (c *LRUCache) TTL(key string) (ttl time.Duration, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	now := c.options.Now()
+	node, ok := c.node[key]
+	if !ok || node.Expired(now) {
+		return 0, false
+	}
+	if node.Expires.IsZero() {
+		return 0, true
+	}
+	return node.Expires.Sub(now), true
+}
+
+// Expire sets the key to expire exactly ttl from now without the jitter,
+// a ttl that is not positive expires the key at once
+func (c *LRUCache) Expire(key string, ttl time.Duration) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	now := c.options.Now()
+	node, ok := c.node[key]
+	if ok && node.Expired(now) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	if !ok {
+		return false
+	}
+	if ttl <= 0 {
+		c.evictNode(node, cache.EvictionExpired)
+		return true
+	}
+	node.TTL = ttl
+	node.Expires = now.Add(ttl)
+	c.wheel.Schedule(key, node.Expires)
+	return true
+}
+
+// Persist removes the expiration of the key
+func (c *LRUCache) Persist(key string) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if ok && node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		ok = false
+	}
+	if !ok {
+		return false
+	}
+	c.expire(node, 0)
+	return true
+}
+
+// Lookup returns the value for the key like Get, and reports whether the
+// key is known to be absent by a tombstone put with PutNegative. A
+// tombstone is neither a hit nor a miss
+func (c *LRUCache) Lookup(key string) (value string, ok bool, absent bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if node, ok := c.negative[key]; ok {
+		if !node.Expired(c.options.Now()) {
+			return "", false, true
+		}
+		c.removeNegative(node)
+	}
+	value, ok = c.get(key)
+	return value, ok, false
+}
+
+// PutNegative records a tombstone for a key that is known to be absent
+// until the ttl passes, the value of the key is deleted if there is any.
+// Tombstones take the size of their key and are evicted like the entries
+func (c *LRUCache) PutNegative(key string, ttl time.Duration) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if c.capacity == 0 || c.closed {
+		return
+	}
+	if node, ok := c.node[key]; ok {
+		c.removeNode(node)
+		c.notify(cache.EventDelete, key, "")
+		c.evicted(node, cache.EvictionDeleted)
+	}
+	node, ok := c.negative[key]
+	if ok {
 		c.linklist.RemoveNode(node)
-		c.linklist.AddNode(node)
-		return node.Value, true
+	} else {
+		node = &dlinklist.Node{Key: key, Size: int64(len(key))}
+		c.negative[key] = node
+		c.resize(bytesize.ByteSize(node.Size), 0)
 	}
-	metrics.Miss.Inc()
-	return "", false
+	c.linklist.AddNode(node)
+	node.Expires = c.options.Now().Add(ttl)
+	c.wheel.Schedule(key, node.Expires)
+	c.evict()
 }
 
-// applyPut updates or insert a new entry, evicts the old entry
+// Put updates or insert a new entry, evicts the old entry
 // if node size is larger than capacity
 func (c *LRUCache) Put(key string, value string) (created bool) {
+	defer c.latency.Put.Since(time.Now())
+	defer c.publish()
 	defer c.Unlock()
-	c.Lock()
-	if v, ok := c.node[key]; ok {
-		node := v
+	c.lock()
+	created = c.put(key, value)
+	c.evict()
+	return created
+}
+
+// PutWithTTL updates or inserts the entry like Put and expires it ttl from
+// now instead of the default TTL, an expired entry is a miss and its size
+// is reclaimed by the eviction or the next access
+func (c *LRUCache) PutWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	defer c.latency.Put.Since(time.Now())
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	created = c.put(key, value)
+	if node, ok := c.node[key]; ok {
+		c.expire(node, ttl)
+	}
+	c.evict()
+	return created
+}
+
+// PutIfAbsent inserts the entry only if the key is not in the cache, an
+// existing entry is left untouched including its recency
+func (c *LRUCache) PutIfAbsent(key string, value string) (created bool) {
+	return c.PutIfAbsentWithTTL(key, value, c.options.DefaultTTL)
+}
+
+// PutIfAbsentWithTTL inserts the entry like PutIfAbsent and expires it ttl
+// from now, an expired entry of the key is replaced
+func (c *LRUCache) PutIfAbsentWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if node, ok := c.node[key]; ok {
+		if !node.Expired(c.options.Now()) {
+			return false
+		}
+		c.evictNode(node, cache.EvictionExpired)
+	}
+	if !c.put(key, value) {
+		return false
+	}
+	c.expire(c.node[key], ttl)
+	c.evict()
+	return true
+}
+
+// Replace updates the value of the key only if it is in the cache, a
+// missing or expired key is left out
+func (c *LRUCache) Replace(key string, value string) (ok bool) {
+	return c.ReplaceWithTTL(key, value, c.options.DefaultTTL)
+}
+
+// ReplaceWithTTL updates the key like Replace and expires it ttl from now
+func (c *LRUCache) ReplaceWithTTL(key string, value string, ttl time.Duration) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok {
+		return false
+	}
+	if node.Expired(c.options.Now()) {
+		c.evictNode(node, cache.EvictionExpired)
+		return false
+	}
+	c.put(key, value)
+	if node, ok := c.node[key]; ok {
+		c.expire(node, ttl)
+	}
+	c.evict()
+	return true
+}
+
+// PutContext updates or inserts the entry like Put in a span of the
+// context, unless the context is done before the lock is acquired
+func (c *LRUCache) PutContext(ctx context.Context, key string, value string) (created bool, err error) {
+	span := tracing.StartOp(ctx, "cache.Put", key)
+	if err := c.lockContext(ctx); err != nil {
+		span.End()
+		return false, err
+	}
+	defer c.publish()
+	defer c.Unlock()
+	evictions := c.counters.Evictions.Count()
+	created = c.put(key, value)
+	c.evict()
+	tracing.EndOp(span, !created, len(value), c.counters.Evictions.Count()-evictions)
+	return created, nil
+}
+
+// PutMulti updates or inserts all the entries and evicts the old
+// entries once all of them are inserted
+func (c *LRUCache) PutMulti(entries map[string]string) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for key, value := range entries {
+		c.put(key, value)
+	}
+	c.evict()
+}
+
+// Subscribe returns a channel that receives the puts, deletes, evictions
+// and expirations, the events are dropped if the channel is not drained in time
+func (c *LRUCache) Subscribe() <-chan cache.Event {
+	return c.events.Subscribe()
+}
+
+// Unsubscribe stops sending the events to the channel and closes it
+func (c *LRUCache) Unsubscribe(ch <-chan cache.Event) {
+	c.events.Unsubscribe(ch)
+}
+
+// notify records an event to be published once the lock is released,
+// the caller must hold the lock
+func (c *LRUCache) notify(kind cache.EventType, key, value string) {
+	if c.events.Active() {
+		c.pending = append(c.pending, cache.Event{Type: kind, Key: key, Value: value})
+	}
+}
+
+// publish sends the recorded events to the subscribers,
+// it must be called without holding the lock
+func (c *LRUCache) publish() {
+	c.events.Publish(func() []cache.Event {
+		defer c.Unlock()
+		c.lock()
+		events := c.pending
+		c.pending = nil
+		return events
+	})
+}
+
+// Load warms up the cache at startup, all the entries are inserted and
+// the old entries are evicted in a single sweep once they are all in
+func (c *LRUCache) Load(entries map[string]string) {
+	c.PutMulti(entries)
+}
+
+// Export writes the entries with their access counts and expiration times
+// in the portable format of cache.Dump, the least recently used ones first
+func (c *LRUCache) Export(w io.Writer) error {
+	return cache.WriteDump(w, c.dump())
+}
+
+// Import reads the entries written by Export, the entries are inserted in
+// order and the old entries are evicted in a single sweep once they are all in
+func (c *LRUCache) Import(r io.Reader) error {
+	entries, err := cache.ReadDump(r)
+	if err != nil {
+		return err
+	}
+	return c.restore(func(put func(cache.DumpEntry)) error {
+		for _, e := range entries {
+			put(e)
+		}
+		return nil
+	})
+}
+
+// dump returns the entries that are not expired, the least recently used first
+func (c *LRUCache) dump() []cache.DumpEntry {
+	c.RLock()
+	defer c.RUnlock()
+	now := c.options.Now()
+	entries := make([]cache.DumpEntry, 0, len(c.node))
+	c.linklist.Walk(func(node *dlinklist.Node) {
+		if c.node[node.Key] == node && !node.Expired(now) {
+			entries = append(entries, dumpEntry(node))
+		}
+	})
+	return entries
+}
+
+// restore inserts the entries read puts in order with their access counts
+// and expiration times under the lock, the expired entries are skipped and
+// the old entries are evicted in a single sweep once they are all in. The
+// metrics are updated once for all the entries and no events are sent for
+// them
+func (c *LRUCache) restore(read func(put func(cache.DumpEntry)) error) error {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	now := c.options.Now()
+	before := len(c.node)
+	err := read(func(e cache.DumpEntry) {
+		if c.capacity == 0 || c.closed {
+			return
+		}
+		expires := time.Time{}
+		if e.Expires != 0 {
+			expires = time.Unix(0, e.Expires)
+		}
+		if !expires.IsZero() && !now.Before(expires) {
+			return
+		}
+		c.load(e, expires, now)
+	})
+	added := len(c.node) - before
+	c.counters.Adds.Add(float64(added))
+	c.resize(0, added)
+	c.evict()
+	return err
+}
+
+// load inserts or updates the restored entry at the head of the linked list
+// like put, without evicting, notifying or updating the metrics. The caller
+// must hold the lock and update the metrics
+func (c *LRUCache) load(e cache.DumpEntry, expires time.Time, now time.Time) {
+	if node, ok := c.negative[e.Key]; ok {
+		c.removeNegative(node)
+	}
+	size := c.options.Cost(e.Key, e.Value)
+	node, ok := c.node[e.Key]
+	if ok {
+		c.linklist.RemoveNode(node)
+		c.size += size - bytesize.ByteSize(node.Size)
+	} else {
+		node = &dlinklist.Node{Key: e.Key, Created: now}
+		c.node[e.Key] = node
+		c.keys.Add(e.Key)
+		c.size += size
+	}
+	c.linklist.AddNode(node)
+	node.Value = e.Value
+	node.Size = int64(size)
+	c.version++
+	node.Version = c.version
+	node.TTL = c.options.DefaultTTL
+	node.Expires = expires
+	c.wheel.Schedule(e.Key, expires)
+	node.Freq++
+	if e.Freq > 0 {
+		node.Freq = e.Freq
+	}
+	node.Touched = now
+}
+
+func dumpEntry(node *dlinklist.Node) cache.DumpEntry {
+	e := cache.DumpEntry{Key: node.Key, Value: node.Value, Freq: node.Freq}
+	if !node.Expires.IsZero() {
+		e.Expires = node.Expires.UnixNano()
+	}
+	return e
+}
+
+// put updates or inserts the entry at the head of the linked list
+// without evicting, a cache of zero capacity or a closed cache rejects all
+// the entries. The caller must hold the lock
+func (c *LRUCache) put(key string, value string) (created bool) {
+	if c.capacity == 0 || c.closed {
+		return false
+	}
+	if node, ok := c.negative[key]; ok {
+		c.removeNegative(node)
+	}
+	size := c.options.Cost(key, value)
+	node, ok := c.node[key]
+	if ok {
 		c.linklist.RemoveNode(node)
 		c.linklist.AddNode(node)
-		node.Value = value
-		created = false
+		c.resize(size-bytesize.ByteSize(node.Size), 0)
 	} else {
-		node := &dlinklist.Node{Key: key, Value: value}
+		node = &dlinklist.Node{Key: key, Created: c.options.Now()}
 		c.linklist.AddNode(node)
 		c.node[key] = node
-		metrics.Adds.Inc()
-		c.size += bytesize.ByteSize(len(value))
-		for c.size > c.capacity {
-			metrics.Deletes.Inc()
-			tail := c.linklist.PopTail()
-			c.size -= bytesize.ByteSize(len(tail.Value))
-			delete(c.node, tail.Key)
-		}
-		created = true
+		c.keys.Add(key)
+		c.counters.Adds.Inc()
+		c.resize(size, 1)
 	}
-	return created
+	node.Value = value
+	node.Size = int64(size)
+	c.version++
+	node.Version = c.version
+	c.expire(node, c.options.DefaultTTL)
+	node.Freq++
+	node.Touched = c.options.Now()
+	c.notify(cache.EventPut, key, value)
+	c.snapshot.Mutated()
+	return !ok
 }
 
-//applyDelete the key from the node
-func (c *LRUCache) Delete(key string) (ok bool) {
+// Incr adds delta to the decimal integer value of the key under the lock,
+// a missing key counts from zero and the expiration of the key is kept
+func (c *LRUCache) Incr(key string, delta int64) (value int64, err error) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	current, ok := c.get(key)
+	if !ok {
+		current = "0"
+	}
+	value, err = cache.AddInt(current, delta)
+	if err != nil {
+		return 0, err
+	}
 	if node, ok := c.node[key]; ok {
-		metrics.Deletes.Inc()
-		c.linklist.RemoveNode(node)
-		delete(c.node, key)
+		c.rewrite(node, strconv.FormatInt(value, 10))
 	} else {
+		c.put(key, strconv.FormatInt(value, 10))
+	}
+	c.evict()
+	return value, nil
+}
+
+// Decr subtracts delta from the decimal integer value of the key like Incr
+func (c *LRUCache) Decr(key string, delta int64) (value int64, err error) {
+	return c.Incr(key, -delta)
+}
+
+// Append adds value to the end of the value of the key, it returns false
+// if the key is not in the cache
+func (c *LRUCache) Append(key string, value string) (ok bool) {
+	return c.concat(key, value, false)
+}
+
+// Prepend adds value to the start of the value of the key, it returns false
+// if the key is not in the cache
+func (c *LRUCache) Prepend(key string, value string) (ok bool) {
+	return c.concat(key, value, true)
+}
+
+// concat adds value to the end or the start of the value of the key and
+// moves it to the head of the linked list like a put
+func (c *LRUCache) concat(key string, value string, prepend bool) (ok bool) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
 		return false
 	}
+	if prepend {
+		value += node.Value
+	} else {
+		value = node.Value + value
+	}
+	c.rewrite(node, value)
+	c.evict()
 	return true
 }
 
-func (c *LRUCache) Snapshot() (raft.FSMSnapshot, error) {
+// rewrite updates the value of the node like put but keeps its
+// expiration, the caller must hold the lock
+func (c *LRUCache) rewrite(node *dlinklist.Node, value string) {
+	expires, ttl := node.Expires, node.TTL
+	c.put(node.Key, value)
+	node.Expires, node.TTL = expires, ttl
+	c.wheel.Schedule(node.Key, expires)
+}
+
+// expire sets the node to expire ttl from now moved by the jitter, a zero
+// ttl never expires
+func (c *LRUCache) expire(node *dlinklist.Node, ttl time.Duration) {
+	node.TTL = ttl
+	node.Expires = time.Time{}
+	if ttl > 0 {
+		node.Expires = c.options.Now().Add(c.options.Jitter(ttl))
+	}
+	c.wheel.Schedule(node.Key, node.Expires)
+}
+
+// sweep expires up to limit of the entries and tombstones that are due in
+// the timer wheel and returns the number of the due keys it checked
+func (c *LRUCache) sweep(limit int) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	now := c.options.Now()
+	keys := c.wheel.Advance(now, limit)
+	for _, key := range keys {
+		if node, ok := c.node[key]; ok {
+			if node.Expired(now) {
+				c.evictNode(node, cache.EvictionExpired)
+			} else {
+				c.wheel.Schedule(key, node.Expires)
+			}
+		} else if node, ok := c.negative[key]; ok {
+			if node.Expired(now) {
+				c.removeNegative(node)
+			} else {
+				c.wheel.Schedule(key, node.Expires)
+			}
+		}
+	}
+	return len(keys)
+}
+
+// evict pops the least recently used entries that are not pinned until the
+// size fits in the capacity and the entries in the limit or only the minimum
+// entries are left. It stops and counts an anomaly if there is nothing left
+// to evict without any pinned entry, the caller must hold the lock
+func (c *LRUCache) evict() {
+	c.evictUpTo(-1)
+}
+
+// evictUpTo is evict that stops after n entries and tombstones, a negative
+// n means no limit. It returns true if it stopped at n before the size
+// fits, the caller must hold the lock
+func (c *LRUCache) evictUpTo(n int) (more bool) {
+	for ; c.size > c.capacity || c.options.MaxEntries > 0 && len(c.node) > c.options.MaxEntries; n-- {
+		if n == 0 {
+			return true
+		}
+		victim := c.linklist.Find(c.evictable)
+		if victim == nil {
+			if len(c.pinned) == 0 {
+				// the size is over the capacity without any entry
+				metrics.Anomalies.Inc()
+			}
+			return false
+		}
+		if c.negative[victim.Key] == victim {
+			c.removeNegative(victim)
+		} else if len(c.node) > c.options.MinEntries {
+			c.evictNode(victim, cache.EvictionCapacity)
+		} else {
+			return false
+		}
+	}
+	return false
+}
+
+// Resize changes the capacity of the cache, a smaller capacity evicts the
+// least recently used entries in batches of cache.ResizeBatch
+func (c *LRUCache) Resize(capacity bytesize.ByteSize) error {
+	c.lock()
+	c.capacity = capacity
+	c.gauges.Capacity.Set(float64(capacity))
+	c.Unlock()
+	for more := true; more; {
+		c.lock()
+		more = c.evictUpTo(cache.ResizeBatch)
+		c.Unlock()
+		c.publish()
+	}
+	return nil
+}
+
+// evictable reports whether the node is a tombstone or an entry that is
+// not pinned, the caller must hold the lock
+func (c *LRUCache) evictable(node *dlinklist.Node) bool {
+	return c.pinned[node.Key] != node
+}
+
+// EvictN evicts up to n least recently used entries and
+// returns the number of the evicted entries
+func (c *LRUCache) EvictN(n int) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for count < n && c.evictTail() {
+		count++
+	}
+	return count
+}
+
+// TrimTo evicts the least recently used entries until the size is
+// at most target and returns the number of the evicted entries
+func (c *LRUCache) TrimTo(target bytesize.ByteSize) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for c.size > target && c.evictTail() {
+		count++
+	}
+	return count
+}
+
+// evictTail evicts the least recently used entry that is not pinned, the
+// tombstones on the way are dropped. It returns false if there is no such
+// entry left, the caller must hold the lock
+func (c *LRUCache) evictTail() bool {
+	for victim := c.linklist.Find(c.evictable); victim != nil; victim = c.linklist.Find(c.evictable) {
+		if c.negative[victim.Key] != victim {
+			c.evictNode(victim, cache.EvictionCapacity)
+			return true
+		}
+		c.removeNegative(victim)
+	}
+	return false
+}
+
+// evictNode removes an evicted or expired node and reports it to OnEvict
+// and the eviction listener
+func (c *LRUCache) evictNode(node *dlinklist.Node, reason cache.EvictionReason) {
+	c.removeNode(node)
+	c.counters.Evictions.Inc()
+	if reason == cache.EvictionExpired {
+		c.notify(cache.EventExpire, node.Key, "")
+	} else {
+		c.notify(cache.EventEvict, node.Key, "")
+	}
+	if c.options.OnEvict != nil {
+		c.options.OnEvict(node.Key, node.Value)
+	}
+	c.evicted(node, reason)
+}
+
+// evicted reports the node that left the cache to the eviction listener,
+// the caller must hold the lock
+func (c *LRUCache) evicted(node *dlinklist.Node, reason cache.EvictionReason) {
+	if c.options.EvictionListener != nil {
+		c.options.EvictionListener.OnEvict(node.Key, node.Value, reason)
+	}
+}
+
+//Delete the key from the node
+func (c *LRUCache) Delete(key string) (ok bool) {
+	_, ok = c.Remove(key)
+	return ok
+}
+
+// Remove deletes the key and returns its value
+func (c *LRUCache) Remove(key string) (value string, ok bool) {
+	defer c.latency.Delete.Since(time.Now())
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	if node, ok := c.negative[key]; ok {
+		c.removeNegative(node)
+	}
+	node, ok := c.node[key]
+	if !ok {
+		return "", false
+	}
+	c.removeNode(node)
+	c.notify(cache.EventDelete, key, "")
+	c.evicted(node, cache.EvictionDeleted)
+	return node.Value, true
+}
+
+// DeleteMulti deletes the keys and returns the number of deleted keys
+func (c *LRUCache) DeleteMulti(keys []string) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for _, key := range keys {
+		if node, ok := c.negative[key]; ok {
+			c.removeNegative(node)
+		}
+		if node, ok := c.node[key]; ok {
+			c.removeNode(node)
+			c.notify(cache.EventDelete, key, "")
+			c.evicted(node, cache.EvictionDeleted)
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteByPattern deletes all the keys that match the glob pattern and
+// returns the number of deleted keys, see cache.Match for the syntax
+func (c *LRUCache) DeleteByPattern(pattern string) (count int, err error) {
+	if err := cache.ValidPattern(pattern); err != nil {
+		return 0, err
+	}
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for key, node := range c.node {
+		if matched, _ := cache.Match(pattern, key); matched {
+			c.removeNode(node)
+			c.notify(cache.EventDelete, key, "")
+			c.evicted(node, cache.EvictionDeleted)
+			count++
+		}
+	}
+	for key, node := range c.negative {
+		if matched, _ := cache.Match(pattern, key); matched {
+			c.removeNegative(node)
+		}
+	}
+	return count, nil
+}
+
+// DeletePrefix deletes all the keys starting with prefix and
+// returns the number of deleted keys
+func (c *LRUCache) DeletePrefix(prefix string) (count int) {
+	defer c.publish()
+	defer c.Unlock()
+	c.lock()
+	for key, node := range c.node {
+		if strings.HasPrefix(key, prefix) {
+			c.removeNode(node)
+			c.notify(cache.EventDelete, key, "")
+			c.evicted(node, cache.EvictionDeleted)
+			count++
+		}
+	}
+	for key, node := range c.negative {
+		if strings.HasPrefix(key, prefix) {
+			c.removeNegative(node)
+		}
+	}
+	return count
+}
+
+// removeNode unlinks the node from the linked list and reclaims its size,
+// the caller must hold the lock
+func (c *LRUCache) removeNode(node *dlinklist.Node) {
+	c.counters.Deletes.Inc()
+	c.linklist.RemoveNode(node)
+	c.resize(-bytesize.ByteSize(node.Size), -1)
+	delete(c.node, node.Key)
+	c.keys.Remove(node.Key)
+	c.wheel.Cancel(node.Key)
+	delete(c.pinned, node.Key)
+	c.snapshot.Mutated()
+}
+
+// removeNegative unlinks the tombstone from the linked list and reclaims
+// its size, the caller must hold the lock
+func (c *LRUCache) removeNegative(node *dlinklist.Node) {
+	c.linklist.RemoveNode(node)
+	c.resize(-bytesize.ByteSize(node.Size), 0)
+	delete(c.negative, node.Key)
+	c.wheel.Cancel(node.Key)
+}
+
+// resize adds delta to the size and entries to the number of entries and
+// updates the gauges. The size is unsigned, a shrinking delta is the two's
+// complement of the bytes removed and the addition wraps modulo 2^64, so it
+// subtracts them exactly as long as they are part of the size. Removing more
+// bytes than the size wraps it around to a huge size instead of a negative
+// one. The caller must hold the lock
+func (c *LRUCache) resize(delta bytesize.ByteSize, entries int) {
+	c.size += delta
+	c.gauges.Size.Set(float64(c.size))
+	c.gauges.Entries.Add(float64(entries))
+}
+
+// Keys returns the keys that are not expired in no particular order
+func (c *LRUCache) Keys() []string {
 	c.RLock()
 	defer c.RUnlock()
+	now := c.options.Now()
+	keys := make([]string, 0, len(c.node))
+	for key, node := range c.node {
+		if !node.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
 
-	o := make(map[string]string)
+// Scan returns a batch of about count keys that are not expired from cursor
+// on and the cursor of the next batch, zero once all the keys are scanned
+func (c *LRUCache) Scan(cursor uint64, count int) (keys []string, next uint64) {
+	c.RLock()
+	defer c.RUnlock()
+	keys, next = c.keys.Scan(cursor, count)
+	now := c.options.Now()
+	live := keys[:0]
+	for _, key := range keys {
+		if !c.node[key].Expired(now) {
+			live = append(live, key)
+		}
+	}
+	return live, next
+}
 
-	for k, v := range c.node {
-		o[k] = v.Value
+// Meta returns the access metadata of the key without
+// updating its recency or the metrics
+func (c *LRUCache) Meta(key string) (meta cache.EntryMeta, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return meta, false
 	}
+	return cache.EntryMeta{
+		CreatedAt:   node.Created,
+		LastAccess:  node.Touched,
+		AccessCount: node.Freq,
+		Size:        bytesize.ByteSize(node.Size),
+	}, true
+}
 
-	return &fsmSnapshot{store: o}, nil
+// Pin exempts the entry from the eviction until it is unpinned, it still
+// expires and can be deleted. A pinned entry keeps its size, so the pin is
+// rejected if the pinned entries would not fit in the capacity, and the
+// cache overshoots the capacity if the pinned values grow. Pin returns false
+// if the key is not found or the pin is rejected
+func (c *LRUCache) Pin(key string) bool {
+	defer c.Unlock()
+	c.lock()
+	node, ok := c.node[key]
+	if !ok || node.Expired(c.options.Now()) {
+		return false
+	}
+	size := bytesize.ByteSize(node.Size)
+	for k, pinned := range c.pinned {
+		if k != key {
+			size += bytesize.ByteSize(pinned.Size)
+		}
+	}
+	if size > c.capacity {
+		return false
+	}
+	c.pinned[key] = node
+	return true
 }
 
-func (c *LRUCache) Restore(closer io.ReadCloser) error {
-	o := make(map[string]string)
-	if err := json.NewDecoder(closer).Decode(&o); err != nil {
-		return err
+// Unpin makes the pinned entry evictable again, it returns false if the
+// key is not pinned
+func (c *LRUCache) Unpin(key string) bool {
+	defer c.Unlock()
+	c.lock()
+	if _, ok := c.pinned[key]; !ok {
+		return false
 	}
+	delete(c.pinned, key)
+	return true
+}
 
-	// Set the state from the snapshot, no lock required according to
-	// Hashicorp docs.
-	for k, v := range o {
-		c.Put(k, v)
+// OrderedKeys returns the keys from the most recently used, which is the
+// last to be evicted, to the least recently used, which is the next
+func (c *LRUCache) OrderedKeys() []string {
+	c.RLock()
+	defer c.RUnlock()
+	keys := make([]string, 0, len(c.node))
+	c.linklist.Walk(func(node *dlinklist.Node) {
+		if c.node[node.Key] == node {
+			keys = append(keys, node.Key)
+		}
+	})
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
 	}
+	return keys
+}
 
+// Close stops the expiration sweeps and the periodic snapshots, writes a
+// last snapshot and drops the entries. A closed cache misses every Get and
+// every Put returns false without storing the entry
+func (c *LRUCache) Close() error {
+	c.sweeper.Stop()
+	err := c.snapshot.Stop()
+	defer c.Unlock()
+	c.lock()
+	if !c.closed {
+		c.closed = true
+		c.resize(-c.size, -len(c.node))
+		c.node = map[string]*dlinklist.Node{}
+		c.negative = map[string]*dlinklist.Node{}
+		c.pinned = map[string]*dlinklist.Node{}
+		c.linklist = dlinklist.NewLinkedList()
+		c.keys = cache.KeyIndex{}
+	}
+	return err
+}
+
+// CheckInvariants verifies that every node of the linked list is an entry
+// or a tombstone of the maps exactly once, that the maps have no other node,
+// that the pinned entries are in the cache and that the size is the sum of
+// the node sizes. It returns an error for the first violation
+func (c *LRUCache) CheckInvariants() error {
+	c.RLock()
+	defer c.RUnlock()
+	seen := make(map[string]bool, c.linklist.Size())
+	size := bytesize.ByteSize(0)
+	var err error
+	c.linklist.Walk(func(node *dlinklist.Node) {
+		switch {
+		case err != nil:
+		case seen[node.Key]:
+			err = fmt.Errorf("key %s is linked more than once", node.Key)
+		case c.node[node.Key] != node && c.negative[node.Key] != node:
+			err = fmt.Errorf("linked key %s is not in the cache", node.Key)
+		default:
+			seen[node.Key] = true
+			size += bytesize.ByteSize(node.Size)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if n := len(c.node) + len(c.negative); n != c.linklist.Size() || n != len(seen) {
+		return fmt.Errorf("%d entries and tombstones but %d linked nodes", n, c.linklist.Size())
+	}
+	for key, node := range c.pinned {
+		if c.node[key] != node {
+			return fmt.Errorf("pinned key %s is not in the cache", key)
+		}
+	}
+	if size != c.size {
+		return fmt.Errorf("size is %d but the nodes take %d", c.size, size)
+	}
 	return nil
 }
 
+// GetOrLoad returns the value for the key, a missing key is loaded with
+// loader and put in the cache. The concurrent loads of a key are made once
+func (c *LRUCache) GetOrLoad(key string, loader cache.Loader) (value string, err error) {
+	return c.loads.GetOrLoad(c, key, loader)
+}
+
+// Len returns the number of entries in the cache
+func (c *LRUCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.node)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *LRUCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.size, len(c.node))
+}
+
+// Snapshot returns the snapshot of the entries with their access counts and
+// expiration times, the least recently used first
+func (c *LRUCache) Snapshot() (raft.FSMSnapshot, error) {
+	return &fsmSnapshot{entries: c.dump()}, nil
+}
+
+// Restore inserts the entries of the snapshot in order like Import, so the
+// restored cache evicts them in the same order
+func (c *LRUCache) Restore(closer io.ReadCloser) error {
+	// no lock is required according to the Hashicorp docs, it is taken
+	// once so the entries are not locked and evicted one by one
+	return c.restore(func(put func(cache.DumpEntry)) error {
+		return cache.ReadSnapshotEntries(closer, put)
+	})
+}
+
 type fsmSnapshot struct {
-	store map[string]string
+	entries []cache.DumpEntry
 }
 
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
-		// Encode data.
-		b, err := json.Marshal(f.store)
-		if err != nil {
-			return err
-		}
-
-		// Write data to sink.
-		if _, err := sink.Write(b); err != nil {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshotEntries(sink, f.entries); err != nil {
 			return err
 		}
 
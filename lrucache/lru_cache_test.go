@@ -1,11 +1,23 @@
 package lrucache
 
 import (
+	"context"
+	"expvar"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/internal/cachetest"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/arazmj/gerdu/tracing"
 	"github.com/inhies/go-bytesize"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestLRUCache(t *testing.T) {
@@ -72,3 +84,619 @@ func TestLruCache_Delete(t *testing.T) {
 		t.Fatal("Expected the ket to be deleted")
 	}
 }
+
+func TestLRUCache_Behaviour(t *testing.T) {
+	cachetest.Run(t, func(capacity bytesize.ByteSize, opts ...cache.Option) cachetest.Cache {
+		return NewCache(capacity, opts...)
+	})
+}
+
+func TestLRUCache_DeletePrefix(t *testing.T) {
+	cache := NewCache(100)
+	cache.Put("user:1:profile", "a")
+	cache.Put("user:1:session", "b")
+	cache.Put("user:12:profile", "c")
+	cache.Put("user:2:profile", "d")
+	cache.Put("order:1", "e")
+
+	if count := cache.DeletePrefix("user:1:"); count != 2 {
+		t.Errorf("Expected 2 keys to be deleted but got %d", count)
+	}
+	for _, key := range []string{"user:12:profile", "user:2:profile", "order:1"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+
+	if count := cache.DeletePrefix("user:"); count != 2 {
+		t.Errorf("Expected 2 keys to be deleted but got %d", count)
+	}
+	if _, ok := cache.Get("order:1"); !ok {
+		t.Errorf("Expected order:1 to survive")
+	}
+	if cache.size != 1 || cache.linklist.Size() != 1 {
+		t.Errorf("Expected size 1 and one node but got %d %d", cache.size, cache.linklist.Size())
+	}
+	if count := cache.DeletePrefix("user:"); count != 0 {
+		t.Errorf("Expected no keys to be deleted but got %d", count)
+	}
+}
+
+func TestLRUCache_GetMulti(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	values := cache.GetMulti([]string{"1", "4"})
+	if len(values) != 1 || values["1"] != "1" {
+		t.Errorf("Expected only the key 1 but got %v", values)
+	}
+
+	cache.Put("4", "4")
+	if _, ok := cache.Get("2"); ok {
+		t.Errorf("Expected 2 to be evicted as the least recently used")
+	}
+	if _, ok := cache.Get("1"); !ok {
+		t.Errorf("Expected 1 to survive after GetMulti")
+	}
+}
+
+func TestLRUCache_DeleteMulti(t *testing.T) {
+	cache := NewCache(10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	if count := cache.DeleteMulti([]string{"1", "3", "4"}); count != 2 {
+		t.Errorf("Expected 2 deleted keys but got %d", count)
+	}
+	if len(cache.node) != 1 || cache.size != 1 {
+		t.Errorf("Expected 1 entry but got %d of size %d", len(cache.node), cache.size)
+	}
+	if _, ok := cache.Get("2"); !ok {
+		t.Errorf("Expected 2 to survive DeleteMulti")
+	}
+}
+
+func TestLRUCache_PutMulti(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.PutMulti(map[string]string{"2": "2", "3": "3", "4": "4"})
+
+	if _, ok := cache.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted")
+	}
+	for _, key := range []string{"2", "3", "4"} {
+		if value, ok := cache.Get(key); !ok || value != key {
+			t.Errorf("Expected %s but got %s %t", key, value, ok)
+		}
+	}
+
+	cache.PutMulti(map[string]string{"5": "5", "6": "6", "7": "7", "8": "8"})
+	if len(cache.node) != 3 || cache.size != 3 {
+		t.Errorf("Expected 3 entries but got %d of size %d", len(cache.node), cache.size)
+	}
+}
+
+func TestLRUCache_NewCacheWithEntries(t *testing.T) {
+	c := NewCacheWithEntries(2, cache.WithMaxEntries(10))
+	c.Put("1", strings.Repeat("a", 1<<20))
+	c.Put("2", strings.Repeat("b", 1<<20))
+	c.Put("3", "c")
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries but got %d", c.Len())
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted by the number of entries")
+	}
+	for _, key := range []string{"2", "3"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to be kept regardless of its size", key)
+		}
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRUCache_PutNegative(t *testing.T) {
+	now := time.Now()
+	c := NewCache(10, cache.WithClock(func() time.Time { return now }))
+	c.Put("present", "1")
+	c.PutNegative("absent", time.Minute)
+	hits, misses := c.Stats().Hits, c.Stats().Misses
+
+	if value, ok, absent := c.Lookup("present"); !ok || absent || value != "1" {
+		t.Errorf("Expected the present key but got %s %t %t", value, ok, absent)
+	}
+	if _, ok, absent := c.Lookup("absent"); ok || !absent {
+		t.Errorf("Expected the cached-absent key but got %t %t", ok, absent)
+	}
+	if _, ok, absent := c.Lookup("unknown"); ok || absent {
+		t.Errorf("Expected the unknown key but got %t %t", ok, absent)
+	}
+	if c.Stats().Hits != hits+1 || c.Stats().Misses != misses+1 {
+		t.Errorf("Expected the tombstone to count neither as a hit nor a miss")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected the tombstone not to count as an entry but got %d", c.Len())
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, absent := c.Lookup("absent"); ok || absent {
+		t.Errorf("Expected the tombstone to expire")
+	}
+	if c.size != 1 {
+		t.Errorf("Expected the size of the expired tombstone to be reclaimed but got %d", c.size)
+	}
+
+	c.PutNegative("present", time.Minute)
+	if _, ok, absent := c.Lookup("present"); ok || !absent {
+		t.Errorf("Expected the tombstone to replace the value")
+	}
+	c.Put("present", "2")
+	if value, ok, absent := c.Lookup("present"); !ok || absent || value != "2" {
+		t.Errorf("Expected the value to replace the tombstone but got %s %t %t", value, ok, absent)
+	}
+}
+
+func TestLRUCache_PutNegativeEvict(t *testing.T) {
+	c := NewCache(4)
+	c.PutNegative("a", time.Minute)
+	c.PutNegative("b", time.Minute)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	c.Put("3", "3")
+	if _, _, absent := c.Lookup("a"); absent {
+		t.Errorf("Expected the tombstone a to be evicted")
+	}
+	if _, _, absent := c.Lookup("b"); !absent {
+		t.Errorf("Expected the tombstone b to survive")
+	}
+	if c.Len() != 3 || c.size != 4 {
+		t.Errorf("Expected 3 entries and a tombstone but got %d of size %d", c.Len(), c.size)
+	}
+}
+
+func TestLRUCache_Load(t *testing.T) {
+	c := NewCache(3)
+	c.Put("1", "1")
+	c.Load(map[string]string{"2": "2", "3": "3", "4": "4"})
+	if c.Len() != 3 {
+		t.Errorf("Expected 3 entries but got %d", c.Len())
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted by the warmup")
+	}
+}
+
+func TestLRUCache_ContextCancelled(t *testing.T) {
+	c := NewCache(10)
+	c.Put("1", "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.PutContext(ctx, "2", "2"); err != context.Canceled {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+	if _, _, err := c.GetContext(ctx, "1"); err != context.Canceled {
+		t.Errorf("Expected context.Canceled but got %v", err)
+	}
+	if _, ok := c.Get("2"); ok {
+		t.Errorf("Expected 2 not to be put")
+	}
+
+	if created, err := c.PutContext(context.Background(), "2", "2"); err != nil || !created {
+		t.Errorf("Expected 2 to be created but got %t %v", created, err)
+	}
+	if value, ok, err := c.GetContext(context.Background(), "2"); err != nil || !ok || value != "2" {
+		t.Errorf("Expected 2 but got %s %t %v", value, ok, err)
+	}
+}
+
+func TestLRUCache_ContextWhileLocked(t *testing.T) {
+	c := NewCache(10)
+	c.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.GetContext(ctx, "1"); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded but got %v", err)
+	}
+	c.Unlock()
+
+	// the abandoned lock attempt must release the lock
+	done := make(chan bool)
+	go func() {
+		done <- c.Put("1", "1")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the lock to be released")
+	}
+}
+
+func TestLRUCache_ContextLockWait(t *testing.T) {
+	c := NewCache(10, cache.WithRecorder(metrics.NewExpvarRecorder("lrucache-lockwait")), cache.WithMetricsName("lockwait"))
+	count := func() int64 {
+		h := expvar.Get("lrucache-lockwait").(*expvar.Map).Get("lockwait.lock_wait_seconds")
+		if h == nil {
+			return 0
+		}
+		return h.(interface{ Get(string) expvar.Var }).Get("count").(*expvar.Int).Value()
+	}
+	c.Lock()
+	time.AfterFunc(10*time.Millisecond, c.Unlock)
+	if _, err := c.PutContext(context.Background(), "1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.GetContext(context.Background(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("Expected the lock wait of the context operations to be recorded but got %d", n)
+	}
+}
+
+func TestLRUCache_Resize(t *testing.T) {
+	c := NewCache(1000)
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	c.Get("0")
+	if err := c.Resize(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 || c.size != 10 {
+		t.Errorf("Expected 10 entries of size 10 but got %d entries of size %d", c.Len(), c.size)
+	}
+	for _, key := range []string{"0", "499", "491"} {
+		if _, ok := c.Peek(key); !ok {
+			t.Errorf("Expected the recently used %s to survive the shrink", key)
+		}
+	}
+	if err := c.Resize(1000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if c.Len() != 510 || c.capacity != 1000 {
+		t.Errorf("Expected the grown cache to keep all the entries but got %d", c.Len())
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRUCache_Gauges(t *testing.T) {
+	c := NewCache(5, cache.WithMetricsName("lrucache-gauges"))
+	c.Put("1", "1")
+	c.Put("2", "22")
+	c.Put("2", "2")
+	c.Put("3", "333")
+	c.Delete("3")
+	c.Put("4", "4444")
+
+	if size := c.gauges.Size.Value(); size != float64(c.size) || size != 5 {
+		t.Errorf("Expected size 5 but got %f", size)
+	}
+	if entries := c.gauges.Entries.Value(); entries != float64(c.Len()) || entries != 2 {
+		t.Errorf("Expected 2 entries but got %f", entries)
+	}
+	if capacity := c.gauges.Capacity.Value(); capacity != 5 {
+		t.Errorf("Expected capacity 5 but got %f", capacity)
+	}
+}
+
+func TestLRUCache_Tracing(t *testing.T) {
+	tracer := &tracing.MemoryTracer{}
+	tracing.SetTracer(tracer)
+	defer tracing.SetTracer(tracing.Noop)
+	ctx, request := tracing.Start(context.Background(), "request")
+	c := NewCache(4)
+	c.PutContext(ctx, "1", "11")
+	c.PutContext(ctx, "2", "22")
+	c.GetContext(ctx, "1")
+	c.GetContext(ctx, "3")
+	c.Get("1")
+	c.Delete("1")
+	expected := []struct {
+		name      string
+		hit       bool
+		size      int64
+		evictions int64
+	}{
+		{"cache.Put", false, 2, 0},
+		{"cache.Put", false, 2, 0},
+		{"cache.Get", true, 2, 0},
+		{"cache.Get", false, 0, 0},
+	}
+	spans := tracer.Spans()[1:]
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected %d spans but got %d", len(expected), len(spans))
+	}
+	for i, e := range expected {
+		s := spans[i]
+		if s.Name != e.name || !s.Ended || s.Attributes[tracing.HitAttribute] != e.hit ||
+			s.Attributes[tracing.ValueSizeAttribute] != e.size || s.Attributes[tracing.EvictionsAttribute] != e.evictions {
+			t.Errorf("Expected span %d to be %+v but got %s %v", i, e, s.Name, s.Attributes)
+		}
+		if s.Parent != request {
+			t.Errorf("Expected span %d to be a child of the span of the context", i)
+		}
+	}
+	c.PutContext(ctx, "3", "333")
+	if s := tracer.Spans()[len(expected)+1]; s.Attributes[tracing.EvictionsAttribute] != int64(1) ||
+		s.Attributes[tracing.KeyHashAttribute] != tracing.KeyHash("3") {
+		t.Errorf("Expected the put to evict 1 entry but got %v", s.Attributes)
+	}
+}
+
+func TestLRUCache_Remove(t *testing.T) {
+	c := NewCache(10)
+	c.Put("1", "one")
+	c.Put("2", "two")
+	c.Get("2")
+
+	if value, ok := c.Remove("2"); !ok || value != "two" {
+		t.Errorf("Expected two but got %s %t", value, ok)
+	}
+	if c.Len() != 1 || c.size != 3 {
+		t.Errorf("Expected 1 entry of size 3 but got %d of size %d", c.Len(), c.size)
+	}
+	if value, ok := c.Remove("2"); ok || value != "" {
+		t.Errorf("Expected 2 to be already removed but got %s %t", value, ok)
+	}
+	if value, ok := c.Remove("1"); !ok || value != "one" || c.Len() != 0 || c.size != 0 {
+		t.Errorf("Expected one and an empty cache but got %s %t", value, ok)
+	}
+	c.Put("3", "three")
+	if value, ok := c.Get("3"); !ok || value != "three" {
+		t.Errorf("Expected the cache to be usable after Remove")
+	}
+}
+
+func TestLRUCache_PeriodicSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	c := NewCache(100, cache.WithPeriodicSnapshot(path, 10*time.Millisecond))
+	c.Put("1", "one")
+	c.Put("2", "two")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a snapshot to be written")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	restored := NewCache(100, cache.WithPeriodicSnapshot(path, time.Hour))
+	defer restored.Close()
+	for key, want := range map[string]string{"1": "one", "2": "two"} {
+		if value, ok := restored.Get(key); !ok || value != want {
+			t.Errorf("Expected %s for %s but got %s", want, key, value)
+		}
+	}
+
+	c.Put("3", "three")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Expected the last snapshot to be written but got %v", err)
+	}
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(matches) != 0 {
+		t.Errorf("Expected no temporary files but got %v", matches)
+	}
+	if value, ok := NewCache(100, cache.WithPeriodicSnapshot(path, 0)).Get("3"); !ok || value != "three" {
+		t.Errorf("Expected the last snapshot to be loaded")
+	}
+}
+
+func TestLRUCache_Closed(t *testing.T) {
+	c := NewCache(100)
+	c.Put("1", "one")
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected a closed cache to miss")
+	}
+	if c.Put("2", "two") || c.PutIfAbsent("3", "three") {
+		t.Errorf("Expected a closed cache to drop the puts")
+	}
+	c.PutNegative("4", time.Hour)
+	if _, _, absent := c.Lookup("4"); absent {
+		t.Errorf("Expected a closed cache to drop the tombstones")
+	}
+	if c.Len() != 0 || c.size != 0 {
+		t.Errorf("Expected a closed cache to be empty but got %d entries of %d bytes", c.Len(), c.size)
+	}
+	if err := c.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRUCache_SnapshotMutations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	c := NewCache(100, cache.WithPeriodicSnapshot(path, 0), cache.WithSnapshotMutations(3))
+	defer c.Close()
+	c.Put("1", "one")
+	c.Put("2", "two")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no snapshot before the third mutation but got %v", err)
+	}
+	c.Delete("1")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the third mutation to write a snapshot")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	restored := NewCache(100, cache.WithPeriodicSnapshot(path, 0))
+	defer restored.Close()
+	if _, ok := restored.Get("1"); ok {
+		t.Errorf("Expected the deleted 1 not to be loaded")
+	}
+	if value, ok := restored.Get("2"); !ok || value != "two" {
+		t.Errorf("Expected two for 2 but got %s", value)
+	}
+}
+
+func TestLRUCache_CompressedSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json.gz")
+	c := NewCache(1<<20, cache.WithPeriodicSnapshot(path, 0), cache.WithSnapshotCompression(cache.Gzip))
+	c.Put("1", strings.Repeat("one", 100))
+	if err := c.Close(); err != nil {
+		t.Fatalf("Expected the snapshot to be written but got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 200 {
+		t.Errorf("Expected a compressed snapshot but got %v %v", info, err)
+	}
+	// the snapshot is loaded by its header without the option
+	if value, ok := NewCache(1<<20, cache.WithPeriodicSnapshot(path, 0)).Get("1"); !ok || value != strings.Repeat("one", 100) {
+		t.Errorf("Expected the compressed snapshot to be loaded")
+	}
+}
+
+func TestLRUCache_MinEntries(t *testing.T) {
+	c := NewCache(10, cache.WithMinEntries(3))
+	for i := 0; i < 5; i++ {
+		c.Put(strconv.Itoa(i), "xx")
+	}
+	c.Put("huge", strings.Repeat("x", 20))
+	if c.Len() != 3 {
+		t.Fatalf("Expected 3 entries to survive but got %d", c.Len())
+	}
+	for _, key := range []string{"huge", "4", "3"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to survive", key)
+		}
+	}
+	if c.size != 24 {
+		t.Errorf("Expected the size to overshoot the capacity but got %d", c.size)
+	}
+	c.Put("huge", "x")
+	c.Put("5", "xx")
+	if c.size > 10 {
+		t.Errorf("Expected the size back within the capacity but got %d", c.size)
+	}
+}
+
+func TestLRUCache_OrderedKeys(t *testing.T) {
+	c := NewCache(10)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	c.Get("a")
+	c.PutNegative("d", time.Minute)
+	if keys := strings.Join(c.OrderedKeys(), ","); keys != "a,c,b" {
+		t.Errorf("Expected a,c,b but got %s", keys)
+	}
+	c.EvictN(1)
+	if keys := strings.Join(c.OrderedKeys(), ","); keys != "a,c" {
+		t.Errorf("Expected b to be evicted first but got %s", keys)
+	}
+}
+
+func TestLRUCache_EvictInconsistent(t *testing.T) {
+	c := NewCache(10)
+	c.Put("1", "1")
+	c.Put("2", "2")
+	// a size that is not reclaimed by evicting all the entries
+	c.size += 100
+	anomalies := metrics.Value(metrics.Anomalies)
+	c.Put("1", "11")
+	if metrics.Value(metrics.Anomalies) != anomalies+1 || c.Len() != 0 {
+		t.Errorf("Expected the eviction to stop on an anomaly once the entries are evicted")
+	}
+}
+
+func TestLRUCache_CheckInvariants(t *testing.T) {
+	c := NewCache(50)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		key := strconv.Itoa(r.Intn(100))
+		switch r.Intn(5) {
+		case 0:
+			c.Delete(key)
+		case 1:
+			c.PutNegative(key, time.Minute)
+		case 2:
+			c.Get(key)
+		default:
+			c.Put(key, strings.Repeat("x", r.Intn(5)))
+		}
+		if err := c.CheckInvariants(); err != nil {
+			t.Fatalf("Expected the invariants to hold but got %v", err)
+		}
+	}
+
+	corruptions := map[string]func(c *LRUCache){
+		"size": func(c *LRUCache) { c.size-- },
+		"orphan": func(c *LRUCache) {
+			c.node["d"] = &dlinklist.Node{Key: "d"}
+		},
+		"unlinked": func(c *LRUCache) {
+			c.linklist.AddNode(&dlinklist.Node{Key: "d"})
+		},
+		"twice": func(c *LRUCache) {
+			c.linklist.AddNode(&dlinklist.Node{Key: "a"})
+		},
+		"pinned": func(c *LRUCache) {
+			c.pinned["d"] = &dlinklist.Node{Key: "d"}
+		},
+	}
+	for name, corrupt := range corruptions {
+		c := NewCache(10)
+		c.Put("a", "a")
+		c.Put("b", "bb")
+		c.PutNegative("c", time.Minute)
+		corrupt(c)
+		if err := c.CheckInvariants(); err == nil {
+			t.Errorf("Expected the %s corruption to be caught", name)
+		}
+	}
+}
+
+func TestLRUCache_GetOrLoad(t *testing.T) {
+	c := NewCache(100)
+	var loads int32
+	release := make(chan struct{})
+	loader := func(key string) (string, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "loaded", nil
+	}
+	var wg sync.WaitGroup
+	values := make([]string, 50)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = c.GetOrLoad("a", loader)
+		}(i)
+	}
+	// let the goroutines pile up on the missing key before the load ends
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("Expected a single load but got %d", n)
+	}
+	for i, value := range values {
+		if value != "loaded" {
+			t.Errorf("Expected caller %d to get the loaded value but got %s", i, value)
+		}
+	}
+	if value, ok := c.Get("a"); !ok || value != "loaded" {
+		t.Errorf("Expected the loaded value to be put")
+	}
+}
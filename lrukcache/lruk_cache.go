@@ -0,0 +1,276 @@
+// Package lrukcache implements LRU-K cache
+package lrukcache
+
+import (
+	"container/heap"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+// entry is a cached entry with the times of its last K references, the
+// most recent first, and its position in the heap
+type entry struct {
+	key      string
+	value    string
+	size     bytesize.ByteSize
+	history  []uint64
+	position int
+}
+
+// full reports whether the entry has K references
+func (e *entry) full(k int) bool {
+	return len(e.history) == k
+}
+
+// LRUKCache data structure, the victim is the entry whose K-th most recent
+// reference is the oldest, so an entry is only kept for its recency once it
+// is referenced K times. The entries with fewer than K references are
+// evicted first, the least recently used of them first, so a burst of one
+// time keys does not push out the entries that are referenced regularly.
+// The references are counted on a logical clock that ticks on every access
+type LRUKCache struct {
+	sync.Mutex
+	cache.UnImplementedCache
+	entries  map[string]*entry
+	victims  victimHeap
+	k        int
+	clock    uint64
+	capacity bytesize.ByteSize
+	size     bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache LRUKCache constructor, k is the number of references that are
+// tracked per entry, at least 1 which makes it LRU
+func NewCache(capacity bytesize.ByteSize, k int) *LRUKCache {
+	if k < 1 {
+		k = 1
+	}
+	c := &LRUKCache{
+		entries:  map[string]*entry{},
+		k:        k,
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+	c.victims.k = k
+	return c
+}
+
+// cost sizes an entry by the length of its value, at least a byte
+func cost(value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+// reference records an access of the entry on the clock
+func (c *LRUKCache) reference(e *entry) {
+	c.clock++
+	if !e.full(c.k) {
+		e.history = append(e.history, 0)
+	}
+	copy(e.history[1:], e.history)
+	e.history[0] = c.clock
+}
+
+// Get returns the value for the key and records the reference
+func (c *LRUKCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.reference(e)
+	heap.Fix(&c.victims, e.position)
+	return e.value, true
+}
+
+// Put updates or inserts a new entry, an update counts as a reference.
+// An entry larger than the capacity is rejected
+func (c *LRUKCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	size := cost(value)
+	if size > c.capacity {
+		return false
+	}
+	e, ok := c.entries[key]
+	if ok {
+		heap.Remove(&c.victims, e.position)
+		c.size += size - e.size
+		e.value = value
+		e.size = size
+	} else {
+		c.counters.Adds.Inc()
+		e = &entry{key: key, value: value, size: size}
+		c.entries[key] = e
+		c.size += size
+	}
+	c.reference(e)
+	// the victims are chosen among the other entries, so the entry that is
+	// put is never evicted right away for having fewer than K references
+	c.evict()
+	heap.Push(&c.victims, e)
+	return !ok
+}
+
+// evict removes the victims until the size fits in the capacity
+func (c *LRUKCache) evict() {
+	for c.size > c.capacity && c.victims.Len() > 0 {
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		c.remove(c.victims.entries[0])
+	}
+}
+
+// Resize changes the capacity of the cache, a smaller capacity evicts the
+// victims in batches of cache.ResizeBatch
+func (c *LRUKCache) Resize(capacity bytesize.ByteSize) error {
+	c.Lock()
+	c.capacity = capacity
+	c.Unlock()
+	for more := true; more; {
+		c.Lock()
+		for n := 0; n < cache.ResizeBatch && c.size > c.capacity && c.victims.Len() > 0; n++ {
+			c.counters.Deletes.Inc()
+			c.counters.Evictions.Inc()
+			c.remove(c.victims.entries[0])
+		}
+		more = c.size > c.capacity && c.victims.Len() > 0
+		c.Unlock()
+	}
+	return nil
+}
+
+// remove removes the entry from the heap and the map
+func (c *LRUKCache) remove(e *entry) {
+	heap.Remove(&c.victims, e.position)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// Delete deletes the key from the cache
+func (c *LRUKCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	c.remove(e)
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *LRUKCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *LRUKCache) Len() int {
+	defer c.Unlock()
+	c.Lock()
+	return len(c.entries)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *LRUKCache) Stats() cache.Stats {
+	defer c.Unlock()
+	c.Lock()
+	return cache.NewStats(c.counters, c.size, len(c.entries))
+}
+
+// victimHeap is a min-heap of the entries by their eviction order
+type victimHeap struct {
+	entries []*entry
+	k       int
+}
+
+func (h victimHeap) Len() int { return len(h.entries) }
+
+// Less orders the entries with fewer than K references first by their last
+// reference, then the others by their K-th most recent reference
+func (h victimHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if a.full(h.k) != b.full(h.k) {
+		return !a.full(h.k)
+	}
+	if !a.full(h.k) {
+		return a.history[0] < b.history[0]
+	}
+	return a.history[h.k-1] < b.history[h.k-1]
+}
+
+func (h victimHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].position = i
+	h.entries[j].position = j
+}
+
+func (h *victimHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.position = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *victimHeap) Pop() interface{} {
+	old := h.entries
+	e := old[len(old)-1]
+	h.entries = old[:len(old)-1]
+	return e
+}
+
+func (c *LRUKCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	o := make(map[string]string)
+
+	for k, e := range c.entries {
+		o[k] = e.value
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+func (c *LRUKCache) Restore(closer io.ReadCloser) error {
+	// Set the state from the snapshot as it is decoded, no lock required
+	// according to Hashicorp docs.
+	return cache.ReadSnapshot(closer, func(k, v string) {
+		c.Put(k, v)
+	})
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
@@ -0,0 +1,146 @@
+package lrukcache
+
+import (
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// check verifies the heap positions and the size
+func check(t *testing.T, c *LRUKCache) {
+	t.Helper()
+	if c.victims.Len() != len(c.entries) {
+		t.Fatalf("Expected %d entries in the heap but got %d", len(c.entries), c.victims.Len())
+	}
+	size := bytesize.ByteSize(0)
+	for i, e := range c.victims.entries {
+		if e.position != i || c.entries[e.key] != e {
+			t.Fatalf("Expected %s at position %d", e.key, i)
+		}
+		if i > 0 && c.victims.Less(i, (i-1)/2) {
+			t.Fatalf("Expected %s not to come before its parent", e.key)
+		}
+		size += e.size
+	}
+	if size != c.size || c.size > c.capacity {
+		t.Fatalf("Expected the size %d to be %d within the capacity", c.size, size)
+	}
+}
+
+func TestLRUKCache(t *testing.T) {
+	c := NewCache(3, 2)
+	c.Put("a", "a")
+	c.Get("a")
+	c.Put("b", "b")
+	c.Get("b")
+	c.Put("c", "c")
+	// c has a single reference so it is evicted before the older a and b
+	c.Put("d", "d")
+	check(t, c)
+	if _, ok := c.Get("c"); ok {
+		t.Errorf("Expected c with one reference to be evicted")
+	}
+	// d is put last and is the next victim, not a or b
+	c.Put("e", "e")
+	if _, ok := c.Get("d"); ok {
+		t.Errorf("Expected d with one reference to be evicted")
+	}
+	// now a has the oldest second reference
+	c.Get("e")
+	c.Put("f", "f")
+	c.Get("f")
+	check(t, c)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected a with the oldest second reference to be evicted")
+	}
+	for _, key := range []string{"b", "e", "f"} {
+		if value, ok := c.Get(key); !ok || value != key {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+}
+
+func TestLRUKCache_K1(t *testing.T) {
+	c := NewCache(2, 0)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Get("a")
+	c.Put("c", "c")
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Expected LRU-1 to evict the least recently used b")
+	}
+}
+
+func TestLRUKCache_Burst(t *testing.T) {
+	c := NewCache(10, 2)
+	lru := lrucache.NewCache(10)
+	hot := []string{"a", "b", "c"}
+	for _, key := range hot {
+		c.Put(key, key)
+		c.Get(key)
+		lru.Put(key, key)
+		lru.Get(key)
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		c.Put(key, "x")
+		lru.Put(key, "x")
+		check(t, c)
+	}
+	for _, key := range hot {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected the hot key %s to survive the burst", key)
+		}
+		if _, ok := lru.Get(key); ok {
+			t.Errorf("Expected the hot key %s to be evicted from LRU by the burst", key)
+		}
+	}
+}
+
+func TestLRUKCache_Random(t *testing.T) {
+	c := NewCache(50, 3)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(r.Intn(1 + r.Intn(200)))
+		switch r.Intn(6) {
+		case 0:
+			c.Delete(key)
+		case 1, 2:
+			c.Put(key, strings.Repeat("x", r.Intn(8)))
+		default:
+			c.Get(key)
+		}
+		check(t, c)
+	}
+	if c.Put("big", strings.Repeat("x", 51)) {
+		t.Errorf("Expected the oversized entry to be rejected")
+	}
+}
+
+func TestLRUKCache_Resize(t *testing.T) {
+	c := NewCache(1000, 2)
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if err := c.Resize(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 || c.size != 10 {
+		t.Errorf("Expected 10 entries of size 10 but got %d entries of size %d", c.Len(), c.size)
+	}
+	if _, ok := c.Get("499"); !ok {
+		t.Errorf("Expected the newest entry to survive the shrink")
+	}
+	if err := c.Resize(1000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if c.Len() != 510 {
+		t.Errorf("Expected the grown cache to keep all the entries but got %d", c.Len())
+	}
+}
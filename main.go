@@ -1,26 +1,75 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"github.com/arazmj/gerdu/aof"
+	"github.com/arazmj/gerdu/arccache"
+	"github.com/arazmj/gerdu/auth"
 	cache "github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/discovery"
+	"github.com/arazmj/gerdu/discovery/gossip"
+	"github.com/arazmj/gerdu/diskcache"
+	"github.com/arazmj/gerdu/fifocache"
 	"github.com/arazmj/gerdu/grpcserver"
 	"github.com/arazmj/gerdu/httpserver"
 	"github.com/arazmj/gerdu/lfucache"
 	"github.com/arazmj/gerdu/lrucache"
+	"github.com/arazmj/gerdu/lrukcache"
 	"github.com/arazmj/gerdu/memcached"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/arazmj/gerdu/mrucache"
+	"github.com/arazmj/gerdu/namespace"
 	"github.com/arazmj/gerdu/raftproxy"
+	"github.com/arazmj/gerdu/randomcache"
+	"github.com/arazmj/gerdu/ratelimit"
 	"github.com/arazmj/gerdu/redis"
+	"github.com/arazmj/gerdu/slrucache"
+	"github.com/arazmj/gerdu/tieredcache"
+	"github.com/arazmj/gerdu/tinylfucache"
+	"github.com/arazmj/gerdu/tracing"
+	"github.com/arazmj/gerdu/tracing/oteltracing"
+	"github.com/arazmj/gerdu/twoqcache"
+	"github.com/arazmj/gerdu/warmup"
 	"github.com/arazmj/gerdu/weakcache"
+	"github.com/arazmj/gerdu/writebehind"
+	"github.com/arazmj/gerdu/writethrough"
+	"github.com/hashicorp/memberlist"
 	"github.com/inhies/go-bytesize"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var gerdu raftproxy.RaftCache
 
+// members is the gossip membership of the node, nil without -gossip
+var members *gossip.Gossip
+
+// flushTraces exports the spans that are left on shutdown
+var flushTraces = func(ctx context.Context) error { return nil }
+
+// leaderTimeout is the time the node that bootstraps the cluster waits
+// to lead it before the warm-up
+const leaderTimeout = 30 * time.Second
+
+// gossipSync is the time between the syncs of the gossip members with the
+// raft cluster, in case the leader missed a join
+const gossipSync = 10 * time.Second
+
 var (
 	loglevel = flag.String("log", "info",
 		"log level can be any of values of 'panic', 'fatal', 'error', 'warn', 'info', 'debug', 'trace'")
@@ -35,7 +84,14 @@ var (
 	grpcPort  = flag.Int("grpcport", 8081, "grpc server port number")
 	mcdPort   = flag.Int("mcdport", 11211, "memcached server port number")
 	redisPort = flag.Int("redisport", 6379, "redis server port number")
-	kind      = flag.String("type", "lru", "type of cache, lru or lfu, weak")
+	kind      = flag.String("type", "lru", "type of cache, lru or lfu, slru, arc, 2q, tinylfu, lruk, mru, fifo, tiered, random, weak")
+	protected = flag.Float64("protected", 0.8, "share of the capacity for the protected segment of slru")
+	k         = flag.Int("k", 2, "number of references lruk tracks per entry")
+	entries   = flag.Int("entries", 0, "maximum number of entries of lru and lfu in addition to the capacity, 0 means no limit")
+	sweep     = flag.Duration("sweep", time.Second, "time between the sweeps of the expired entries of lru and lfu, 0 means they only expire when accessed")
+	batch     = flag.Int("sweepbatch", 128, "maximum number of the expired entries a sweep reclaims under a single lock")
+	recorder  = flag.String("metrics", "prometheus", "metrics backend of lru and lfu, prometheus, statsd or expvar")
+	statsd    = flag.String("statsd", "127.0.0.1:8125", "statsd server address of the statsd metrics backend")
 	protocols = flag.String("protocols", "",
 		"protocol 'grpc', 'redis' or 'mcd' (memcached), multiple comma-separated values, http is not optional")
 	tlsKey   = flag.String("key", "", "SSL certificate private key")
@@ -45,13 +101,54 @@ var (
 	joinAddr = flag.String("join", "", "Set join address, if any")
 	nodeID   = flag.String("id", "master", "Node ID")
 	storage  = flag.String("storage", "", "Path to store log files and snapshot, will store in memory if not set")
+	gossipAt = flag.String("gossip", "", "gossip bind address host:port the nodes find each other and their failures at, the leader adds the nodes it finds to the raft cluster, empty means no gossip")
+	seeds    = flag.String("seeds", "", "comma separated gossip addresses of the nodes of the cluster the node joins, needs -gossip, empty means the node bootstraps a new cluster")
+	nonvoter = flag.Bool("nonvoter", false, "Join as a non-voting read replica that serves the reads from its local copy")
+	compress = flag.String("compression", "none", "compression of the raft snapshots and the dumps, none or gzip")
+	aofPath  = flag.String("aof", "", "append-only file of the puts and the deletes the cache is recovered from on startup, empty means none")
+	fsync    = flag.String("appendfsync", "everysec", "when the append-only file is synced, always, everysec or no")
+	rewrite  = flag.String("aofrewrite", "64MB", "size the append-only file is rewritten at once it doubles, 0 means it is never rewritten")
+	dumpPath = flag.String("dump", "", "file lru and lfu dump their entries to and load them from on startup, empty means none")
+	dumpTime = flag.Duration("dumpinterval", 5*time.Minute, "time between the dumps, 0 means they are not written periodically")
+	dumpMuts = flag.Int("dumpmutations", 0, "number of the puts and the deletes after which a dump is written, 0 means they do not trigger one")
+	spillLog = flag.String("spill", "", "log file lru and lfu spill their evicted entries to and read them back from on a miss, empty means none")
+	spillCap = flag.String("spillcapacity", "1GB", "size of the entries the spill file holds, 0 means no limit")
+	seedFile = flag.String("seed", "", "seed file in the export format the cache is warmed up from before serving, empty means none")
+	spaces   = flag.String("namespaces", "", "comma separated namespaces created on startup as name[:policy[:capacity[:ttl]]], e.g. users:lfu:128MB:10m, each one a cache of its own served under /cache/{ns}/{key}")
+	spaceCap = flag.String("nscapacity", "64MB", "default capacity of the cache of a namespace")
+	seedPeer = flag.String("seedpeer", "", "HTTP API of a peer, e.g. http://127.0.0.1:8080, the cache is warmed up from before serving, empty means none")
 
-	secure = len(*tlsCert) > 0 && len(*tlsKey) > 0
+	tokensPath   = flag.String("tokens", "", "file of the bearer tokens of the HTTP and gRPC clients, a token and its role readonly, readwrite or node per line, empty means no authentication")
+	clientCA     = flag.String("clientca", "", "CA certificate the HTTP and gRPC clients must present a certificate signed by, needs -cert and -key, empty means no mutual TLS")
+	nodeToken    = flag.String("nodetoken", "", "node bearer token the node sends with the join request and the writes it forwards to the leader")
+	caFile       = flag.String("ca", "", "CA certificate of the cluster the nodes verify each other against, empty means the roots of the system")
+	raftTLS      = flag.Bool("rafttls", false, "secure the raft transport with -cert and -key, with -ca the nodes must present a certificate it signed")
+	rateLimit    = flag.Float64("ratelimit", 0, "requests per second of each client IP over HTTP, gRPC and redis, 0 means no limit")
+	rateBurst    = flag.Int("rateburst", 0, "requests a client IP makes at once above its rate, 0 means the rate")
+	tokenRate    = flag.Float64("tokenratelimit", 0, "requests per second of each authenticated bearer token over HTTP and gRPC, needs -tokens, 0 means no limit")
+	tokenBurst   = flag.Int("tokenburst", 0, "requests a token makes at once above its rate, 0 means the rate")
+	maxConns     = flag.Int("maxconns", 0, "connections the HTTP, gRPC and redis servers hold at once in total, 0 means no limit")
+	certReload   = flag.Duration("certreload", time.Minute, "time between the checks of the certificate, key and CA files for a rotation, 0 means they are only loaded on startup")
+	stopTime     = flag.Duration("shutdowntimeout", 30*time.Second, "time the node has to shut down on SIGINT or SIGTERM, half of it for the requests in flight to finish")
+	maxBody      = flag.String("maxbody", "64MB", "largest request body the HTTP server reads, a larger one gets 413")
+	traceTo      = flag.String("trace", "", "exporter of the OpenTelemetry spans of the cache operations and the server requests, stdout writes them to the standard output, empty means no tracing")
+	decommission = flag.Bool("decommission", false, "leave the cluster on SIGINT or SIGTERM instead of keeping the membership to rejoin on restart")
+	storeKind    = flag.String("store", "", "wrapper that writes the puts and the deletes of the cache to the -storefile and loads its misses from it, writethrough or writebehind, empty means none")
+	storeFile    = flag.String("storefile", "", "log file on the local disk the -store wrapper writes the entries to")
+	storeFlush   = flag.Duration("storeflush", time.Second, "time between the flushes of the writebehind store wrapper")
+
+	secure bool
+	// serverCerts are the certificates of the listeners and nodeCerts the
+	// ones of the connections between the nodes
+	serverCerts, nodeCerts *auth.Reloader
 )
 
 func main() {
 	flag.Parse()
+	secure = len(*tlsCert) > 0 && len(*tlsKey) > 0
 	setLogLevel()
+	setTracer()
+	setTLS()
 	setCache()
 	serve()
 }
@@ -59,53 +156,100 @@ func main() {
 func serve() {
 	*protocols = strings.ToLower(*protocols)
 
-	go func() {
-		httpHost := *host + ":" + strconv.Itoa(*httpPort)
-		if secure {
-			httpserver.HTTPServeTLS(httpHost, *tlsCert, *tlsKey, gerdu)
-		} else {
-			httpserver.HTTPServe(httpHost, gerdu)
-		}
-	}()
+	tlsConfig, tokens := serverAuth()
+	maxBodySize, err := bytesize.Parse(*maxBody)
+	if err != nil {
+		log.Fatal("Invalid value for maxbody", err.Error())
+	}
+	httpserver.MaxBodySize = int64(maxBodySize)
+	if *tokenRate > 0 && tokens == nil {
+		log.Warnf("The token rate limit needs -tokens, the requests are not limited by token")
+	}
+	var guard *ratelimit.Guard
+	if *rateLimit > 0 || *tokenRate > 0 || *maxConns > 0 {
+		guard = ratelimit.NewGuard(ratelimit.Limits{
+			Rate:       *rateLimit,
+			Burst:      *rateBurst,
+			TokenRate:  *tokenRate,
+			TokenBurst: *tokenBurst,
+			MaxConns:   *maxConns,
+		})
+	}
+	httpHost := *host + ":" + strconv.Itoa(*httpPort)
+	servers := []func(ctx context.Context) error{
+		httpserver.HTTPStartAuth(httpHost, tlsConfig, tokens, guard, gerdu),
+	}
 
 	if strings.Contains(*protocols, "grpc") {
-		go func() {
-			grpcHost := *host + ":" + strconv.Itoa(*grpcPort)
-			if secure {
-				grpcserver.GrpcServeTLS(grpcHost, *tlsCert, *tlsKey, gerdu)
-			} else {
-				grpcserver.GrpcServe(grpcHost, gerdu)
-			}
-		}()
+		grpcHost := *host + ":" + strconv.Itoa(*grpcPort)
+		servers = append(servers, grpcserver.GrpcStartAuth(grpcHost, tlsConfig, tokens, guard, gerdu))
+	}
+	if tokens != nil && (strings.Contains(*protocols, "mcd") || strings.Contains(*protocols, "redis")) {
+		log.Warnln("The memcached and redis protocols do not authenticate their clients")
+	}
+	if guard != nil && strings.Contains(*protocols, "mcd") {
+		log.Warnln("The memcached protocol does not limit its clients")
 	}
 	if strings.Contains(*protocols, "mcd") {
-		go func() {
-			mcdHost := *host + ":" + strconv.Itoa(*mcdPort)
-			if secure {
-				log.Fatalln("Memcached protocol does not support TLS")
-				os.Exit(1)
-			}
-			memcached.Serve(mcdHost, gerdu)
-		}()
+		mcdHost := *host + ":" + strconv.Itoa(*mcdPort)
+		if secure {
+			log.Fatalln("Memcached protocol does not support TLS")
+			os.Exit(1)
+		}
+		servers = append(servers, memcached.Start(mcdHost, gerdu))
 	}
 
 	if strings.Contains(*protocols, "redis") {
-		go func() {
-			redisHost := *host + ":" + strconv.Itoa(*redisPort)
-			if secure {
-				redis.ServeTLS(redisHost, *tlsCert, *tlsKey, gerdu)
-			} else {
-				redis.Serve(redisHost, gerdu)
-			}
-		}()
+		redisHost := *host + ":" + strconv.Itoa(*redisPort)
+		servers = append(servers, redis.StartLimited(redisHost, tlsConfig, guard, gerdu))
 	}
 
 	terminate := make(chan os.Signal, 1)
-	signal.Notify(terminate, os.Interrupt)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
 	<-terminate
-	err := gerdu.(*raftproxy.RaftProxy).Leave(*nodeID)
+	shutdown(servers)
+}
+
+// shutdown stops the servers and gives the requests in flight half of the
+// shutdown timeout to finish, then the node hands its leadership over,
+// leaves its cluster if it is decommissioned and persists the cache. It exits once the timeout is
+// over even if the node is not done
+func shutdown(servers []func(ctx context.Context) error) {
+	log.Println("Gerdu shutting down")
+	deadline := time.AfterFunc(*stopTime, func() {
+		log.Errorf("Cannot shut down within %s", *stopTime)
+		os.Exit(1)
+	})
+	defer deadline.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), *stopTime/2)
+	defer cancel()
+	var drained sync.WaitGroup
+	for _, server := range servers {
+		drained.Add(1)
+		go func(shutdown func(ctx context.Context) error) {
+			defer drained.Done()
+			if err := shutdown(ctx); err != nil {
+				log.Warnf("Cannot drain the requests in flight %v", err)
+			}
+		}(server)
+	}
+	drained.Wait()
+	if err := flushTraces(ctx); err != nil {
+		log.Warnf("Cannot export the spans %v", err)
+	}
+	if members != nil && *decommission {
+		if err := members.Leave(*stopTime / 4); err != nil {
+			log.Warnf("Cannot leave the gossip %v", err)
+		}
+	}
+	err := gerdu.(*raftproxy.RaftProxy).Shutdown(*decommission)
+	if backing != nil {
+		if e := backing.Close(); err == nil {
+			err = e
+		}
+	}
 	if err != nil {
-		log.Errorf("Cannot leave the cluster gracefully %v", err)
+		log.Errorf("Cannot shut down the node %v", err)
 	} else {
 		log.Println("Gerdu exiting")
 	}
@@ -117,23 +261,416 @@ func setCache() {
 		log.Fatal("Invalid value for capacity", err.Error())
 	}
 
+	checkPolicyFlags(strings.ToLower(*kind))
 	var c cache.UnImplementedCache
+	metricsRecorder := newRecorder()
+	opts := []cache.Option{
+		cache.WithMaxEntries(*entries),
+		cache.WithCostFunc(cache.MemoryCost),
+		cache.WithActiveExpiration(*sweep, *batch),
+		cache.WithRecorder(metricsRecorder),
+	}
+	compression, err := cache.ParseCompression(*compress)
+	if err != nil {
+		log.Fatalf("Invalid value for compression: %s", err)
+	}
+	if *dumpPath != "" {
+		opts = append(opts,
+			cache.WithPeriodicSnapshot(*dumpPath, *dumpTime),
+			cache.WithSnapshotMutations(*dumpMuts),
+			cache.WithSnapshotCompression(compression))
+	}
 	if strings.ToLower(*kind) == "lru" {
-		c = lrucache.NewCache(capacity)
+		c = spillOver(func(spill ...cache.Option) cache.UnImplementedCache {
+			return lrucache.NewCache(capacity, append(opts, spill...)...)
+		})
 	} else if strings.ToLower(*kind) == "lfu" {
-		c = lfucache.NewCache(capacity)
+		c = spillOver(func(spill ...cache.Option) cache.UnImplementedCache {
+			return lfucache.NewCache(capacity, append(opts, spill...)...)
+		})
+	} else if strings.ToLower(*kind) == "slru" {
+		c = slrucache.NewCacheWithRatio(capacity, *protected)
+	} else if strings.ToLower(*kind) == "arc" {
+		c = arccache.NewCache(capacity)
+	} else if strings.ToLower(*kind) == "2q" {
+		c = twoqcache.NewCache(capacity)
+	} else if strings.ToLower(*kind) == "tinylfu" {
+		c = tinylfucache.NewCache(capacity)
+	} else if strings.ToLower(*kind) == "lruk" {
+		c = lrukcache.NewCache(capacity, *k)
+	} else if strings.ToLower(*kind) == "mru" {
+		c = mrucache.NewCache(capacity)
+	} else if strings.ToLower(*kind) == "fifo" {
+		c = fifocache.NewCache(capacity)
+	} else if strings.ToLower(*kind) == "tiered" {
+		c = tieredcache.NewCache(capacity/10, capacity)
+	} else if strings.ToLower(*kind) == "random" {
+		c = randomcache.NewCache(capacity)
 	} else if strings.ToLower(*kind) == "weak" {
 		c = weakcache.NewWeakCache()
 	} else {
 		log.Fatalf("Invalid value for type")
 		os.Exit(1)
 	}
-	gerdu = raftproxy.NewRaftProxy(c, *raftAddr, *joinAddr, *nodeID)
+	c = storeBehind(c)
+	if *aofPath != "" {
+		c = appendOnly(c)
+	}
+	proxy := raftproxy.NewRaftProxy(c, *raftAddr, *joinAddr, *nodeID)
+	proxy.APIURL = apiURL()
+	proxy.Nonvoter = *nonvoter
+	proxy.WaitJoin = *seeds != ""
+	proxy.Compression = compression
+	proxy.Registry = namespaces(metricsRecorder)
+	proxy.Token = *nodeToken
+	if secure {
+		proxy.Client = nodeClient(30 * time.Second)
+	}
+	if *raftTLS {
+		proxy.TransportTLS = &raftproxy.TransportTLS{Server: nodeCerts.ServerConfig(*caFile != ""), Dial: nodeCerts.DialContext}
+	}
+	gerdu = proxy
 	err = gerdu.OpenRaft(*storage)
 	if err != nil {
 		log.Fatalf("Cannot open raft peer connection: %s", err)
 	}
+	if *gossipAt != "" {
+		members = startGossip(proxy)
+	} else if *seeds != "" {
+		log.Fatalf("The seeds need -gossip")
+	}
+	if *seedFile != "" || *seedPeer != "" {
+		warmUpCluster(proxy)
+	}
+
+}
+
+// setTracer installs the OpenTelemetry tracer of the exporter of the flags,
+// the HTTP and gRPC requests continue the W3C trace context of the callers
+func setTracer() {
+	switch strings.ToLower(*traceTo) {
+	case "":
+		return
+	case "stdout":
+		exporter, err := stdouttrace.New()
+		if err != nil {
+			log.Fatalf("Cannot create the span exporter: %s", err)
+		}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		tracing.SetTracer(oteltracing.NewTracer(provider.Tracer("gerdu")))
+		flushTraces = provider.Shutdown
+	default:
+		log.Fatalf("Invalid value for trace: %s", *traceTo)
+	}
+}
+
+// setTLS loads the certificates of the listeners and of the connections
+// between the nodes, they are loaded again as their files rotate
+func setTLS() {
+	if !secure {
+		if *clientCA != "" || *raftTLS {
+			log.Fatalf("TLS needs the certificate and the key of the node")
+		}
+		return
+	}
+	var err error
+	if serverCerts, err = auth.NewReloader(*tlsCert, *tlsKey, *clientCA, *certReload); err != nil {
+		log.Fatalf("Failed to setup TLS: %s", err)
+	}
+	if nodeCerts, err = auth.NewReloader(*tlsCert, *tlsKey, *caFile, *certReload); err != nil {
+		log.Fatalf("Failed to setup TLS: %s", err)
+	}
+}
+
+// nodeClient returns the HTTP client of the node to the other nodes, it
+// presents the certificate of the node and verifies theirs against the CA
+func nodeClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &http.Transport{DialTLSContext: nodeCerts.DialContext}}
+}
+
+// serverAuth returns the TLS config of the HTTP and gRPC servers, nil
+// without a certificate, and the tokens of their clients, nil without a
+// tokens file
+func serverAuth() (*tls.Config, auth.Tokens) {
+	var config *tls.Config
+	if secure {
+		config = serverCerts.ServerConfig(*clientCA != "")
+	}
+	var tokens auth.Tokens
+	if *tokensPath != "" {
+		var err error
+		tokens, err = auth.LoadTokens(*tokensPath)
+		if err != nil {
+			log.Fatalf("Cannot load the tokens: %s", err)
+		}
+	}
+	return config, tokens
+}
+
+// namespaces returns the registry of the namespaces with the ones of the
+// flags created, their policy is the type if lru or lfu and lru otherwise
+// unless their spec says another one
+func namespaces(metricsRecorder metrics.Recorder) *namespace.Registry {
+	capacity, err := bytesize.Parse(*spaceCap)
+	if err != nil {
+		log.Fatalf("Invalid value for nscapacity: %s", err)
+	}
+	defaults := cache.NamespaceConfig{Capacity: capacity, Policy: "lru"}
+	if strings.ToLower(*kind) == "lfu" {
+		defaults.Policy = "lfu"
+	}
+	registry := namespace.NewRegistry(func(name string, config cache.NamespaceConfig) (cache.UnImplementedCache, error) {
+		opts := []cache.Option{
+			cache.WithCostFunc(cache.MemoryCost),
+			cache.WithRecorder(metricsRecorder),
+			cache.WithMetricsName(name),
+			cache.WithDefaultTTL(config.TTL),
+		}
+		if config.TTL != 0 && config.Policy != "lru" && config.Policy != "lfu" {
+			return nil, fmt.Errorf("the ttl of the namespace %s needs the lru or lfu policy", name)
+		}
+		switch config.Policy {
+		case "lru":
+			return lrucache.NewCache(config.Capacity, opts...), nil
+		case "lfu":
+			return lfucache.NewCache(config.Capacity, opts...), nil
+		case "slru":
+			return slrucache.NewCacheWithRatio(config.Capacity, *protected), nil
+		case "arc":
+			return arccache.NewCache(config.Capacity), nil
+		case "2q":
+			return twoqcache.NewCache(config.Capacity), nil
+		case "tinylfu":
+			return tinylfucache.NewCache(config.Capacity), nil
+		case "lruk":
+			return lrukcache.NewCache(config.Capacity, *k), nil
+		case "mru":
+			return mrucache.NewCache(config.Capacity), nil
+		case "fifo":
+			return fifocache.NewCache(config.Capacity), nil
+		case "random":
+			return randomcache.NewCache(config.Capacity), nil
+		}
+		return nil, fmt.Errorf("invalid policy of the namespace %s: %s", name, config.Policy)
+	}, defaults)
+	for _, spec := range strings.Split(*spaces, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		name, config, err := namespace.ParseSpec(spec)
+		if err != nil {
+			log.Fatalf("Invalid value for namespaces: %s", err)
+		}
+		if _, err := registry.CreateNamespace(name, config); err != nil {
+			log.Fatalf("Cannot create the namespace %s: %s", name, err)
+		}
+	}
+	return registry
+}
+
+// startGossip joins the gossip of the seeds of the flags, or starts a new
+// one without seeds, and adds the nodes it finds to the raft cluster while
+// the node leads
+func startGossip(proxy *raftproxy.RaftProxy) *gossip.Gossip {
+	host, port, err := net.SplitHostPort(*gossipAt)
+	if err != nil {
+		log.Fatalf("Invalid value for gossip: %s", err)
+	}
+	config := memberlist.DefaultLANConfig()
+	config.BindAddr = host
+	if config.BindPort, err = strconv.Atoi(port); err != nil {
+		log.Fatalf("Invalid value for gossip: %s", err)
+	}
+	config.AdvertisePort = config.BindPort
+	var addrs []string
+	for _, seed := range strings.Split(*seeds, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			addrs = append(addrs, seed)
+		}
+	}
+	self := discovery.Member{Name: *nodeID, Raft: *raftAddr, API: proxy.APIURL, Nonvoter: *nonvoter}
+	g, err := gossip.Start(config, self, &discovery.Raft{Cluster: proxy}, gossipSync, addrs...)
+	if err != nil {
+		log.Fatalf("Cannot join the gossip: %s", err)
+	}
+	return g
+}
+
+// warmUpCluster warms the cache up through raft so that every node of the
+// cluster gets the entries. Only the node that bootstraps the cluster warms
+// it up once it leads, the nodes that join get the entries replicated
+func warmUpCluster(proxy *raftproxy.RaftProxy) {
+	if *joinAddr != "" || *seeds != "" {
+		log.Infof("Skipping the warm-up, the entries are replicated from the cluster the node joins")
+		return
+	}
+	if err := proxy.WaitLeader(leaderTimeout); err != nil {
+		log.Errorf("Cannot warm up without leading the cluster: %v", err)
+		return
+	}
+	warmUp(proxy)
+}
 
+// warmUp loads the seed file or the dump of the peer of the flags into the
+// cache and logs the progress until it is done, a failed warm-up
+// leaves the entries it loaded and the node serves anyway
+func warmUp(c cache.UnImplementedCache) {
+	source := warmup.File(*seedFile)
+	if *seedPeer != "" {
+		var client *http.Client
+		if secure {
+			client = nodeClient(0)
+		}
+		source = warmup.Peer(client, *seedPeer)
+	}
+	w := warmup.Start(c, source, 1024)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.Ready():
+			if err := w.Wait(); err != nil {
+				log.Errorf("Warm-up failed after %d entries: %v", w.Loaded(), err)
+			} else {
+				log.Infof("Warmed up %d entries", w.Loaded())
+			}
+			return
+		case <-ticker.C:
+			log.Infof("Warming up, %d entries loaded", w.Loaded())
+		}
+	}
+}
+
+// spillOver creates the cache with newCache and spills its evicted entries
+// to the spill file of the flags if there is one
+func spillOver(newCache func(spill ...cache.Option) cache.UnImplementedCache) cache.UnImplementedCache {
+	if *spillLog == "" {
+		return newCache()
+	}
+	size, err := bytesize.Parse(*spillCap)
+	if err != nil {
+		log.Fatalf("Invalid value for spillcapacity: %s", err)
+	}
+	disk, err := diskcache.NewCache(*spillLog, size)
+	if err != nil {
+		log.Fatalf("Cannot open the spill file %s: %s", *spillLog, err)
+	}
+	return diskcache.NewSpillCache(func(spill cache.Option) cache.UnImplementedCache {
+		return newCache(spill)
+	}, disk)
+}
+
+// policyFlags are the flags that only the policies they list take, the
+// other policies have no such option
+var policyFlags = map[string][]string{
+	"entries":       {"lru", "lfu"},
+	"sweep":         {"lru", "lfu"},
+	"sweepbatch":    {"lru", "lfu"},
+	"metrics":       {"lru", "lfu"},
+	"dump":          {"lru", "lfu"},
+	"dumpinterval":  {"lru", "lfu"},
+	"dumpmutations": {"lru", "lfu"},
+	"spill":         {"lru", "lfu"},
+	"spillcapacity": {"lru", "lfu"},
+	"protected":     {"slru"},
+	"k":             {"lruk"},
+}
+
+// namespaceFlags are the policy flags the namespaces take whatever the
+// policy of the cache is
+var namespaceFlags = map[string]bool{"metrics": true, "protected": true, "k": true}
+
+// checkPolicyFlags exits if a flag that is set does not apply to the policy,
+// so an option is not silently ignored
+func checkPolicyFlags(policy string) {
+	flag.Visit(func(f *flag.Flag) {
+		policies, ok := policyFlags[f.Name]
+		if !ok || namespaceFlags[f.Name] && *spaces != "" {
+			return
+		}
+		for _, p := range policies {
+			if p == policy {
+				return
+			}
+		}
+		log.Fatalf("-%s does not apply to the %s cache, only to %s", f.Name, policy, strings.Join(policies, ", "))
+	})
+}
+
+// backing is the store of the -store wrapper, nil without one
+var backing *diskcache.Store
+
+// storeBehind wraps the cache in the write-through or the write-behind
+// cache of the store file of the flags if there is one
+func storeBehind(c cache.UnImplementedCache) cache.UnImplementedCache {
+	if *storeKind == "" {
+		if *storeFile != "" {
+			log.Fatalf("The store file needs -store")
+		}
+		return c
+	}
+	if *storeFile == "" {
+		log.Fatalf("The store needs -storefile")
+	}
+	store, err := diskcache.NewStore(*storeFile, 0)
+	if err != nil {
+		log.Fatalf("Cannot open the store file %s: %s", *storeFile, err)
+	}
+	backing = store
+	switch strings.ToLower(*storeKind) {
+	case "writethrough":
+		return writethrough.NewCache(c, store)
+	case "writebehind":
+		return writebehind.NewStoreCache(c, store, 0, *storeFlush)
+	default:
+		log.Fatalf("Invalid value for store: %s", *storeKind)
+		return nil
+	}
+}
+
+// appendOnly wraps the cache in the append-only file of the flags
+func appendOnly(c cache.UnImplementedCache) cache.UnImplementedCache {
+	policy, err := aof.ParseFsyncPolicy(*fsync)
+	if err != nil {
+		log.Fatalf("Invalid value for appendfsync: %s", err)
+	}
+	size, err := bytesize.Parse(*rewrite)
+	if err != nil {
+		log.Fatalf("Invalid value for aofrewrite: %s", err)
+	}
+	a, err := aof.NewCache(c, *aofPath, policy, int64(size))
+	if err != nil {
+		log.Fatalf("Cannot open the append-only file %s: %s", *aofPath, err)
+	}
+	return a
+}
+
+// apiURL returns the base URL of the HTTP API of the node
+func apiURL() string {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return scheme + "://" + *host + ":" + strconv.Itoa(*httpPort)
+}
+
+func newRecorder() metrics.Recorder {
+	switch strings.ToLower(*recorder) {
+	case "prometheus":
+		return metrics.Prometheus
+	case "statsd":
+		r, err := metrics.NewStatsdRecorder(*statsd, "gerdu")
+		if err != nil {
+			log.Fatalf("Cannot connect to statsd: %s", err)
+		}
+		return r
+	case "expvar":
+		return metrics.NewExpvarRecorder("gerdu")
+	}
+	log.Fatalf("Invalid value for metrics")
+	return nil
 }
 
 func setLogLevel() {
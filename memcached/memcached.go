@@ -3,42 +3,82 @@ package memcached
 import (
 	"context"
 	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/tracing"
 	mc "github.com/arazmj/gomemcached"
 	log "github.com/sirupsen/logrus"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// handler serves a memcached command with the cache
+type handler func(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error
+
 //Serve start memcached server
 func Serve(host string, gerdu cache.UnImplementedCache) {
+	newServer(host, gerdu).Start()
+}
+
+// Start starts the memcached server like Serve, it returns the shutdown of
+// the server that stops accepting the connections and closes them, the
+// commands they are running get a moment to finish
+func Start(host string, gerdu cache.UnImplementedCache) (shutdown func(ctx context.Context) error) {
+	server := newServer(host, gerdu)
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
+	return func(ctx context.Context) error {
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- server.Stop()
+		}()
+		select {
+		case err := <-stopped:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// newServer returns the memcached server of the commands of the cache
+func newServer(host string, gerdu cache.UnImplementedCache) *mc.Server {
 	server := mc.NewServer(host)
-	server.RegisterFunc("get", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return getHandler(ctx, req, res, gerdu)
-	})
-	server.RegisterFunc("gets", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return getHandler(ctx, req, res, gerdu)
-	})
-	server.RegisterFunc("set", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return setHandler(ctx, req, res, gerdu)
-	})
-	server.RegisterFunc("delete", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return deleteHandler(ctx, req, res, gerdu)
+	handlers := map[string]handler{
+		"get":       getHandler,
+		"gets":      getsHandler,
+		"cas":       casHandler,
+		"set":       setHandler,
+		"add":       addHandler,
+		"replace":   replaceHandler,
+		"touch":     touchHandler,
+		"append":    concatHandler,
+		"prepend":   concatHandler,
+		"delete":    deleteHandler,
+		"incr":      incrHandler,
+		"decr":      decrHandler,
+		"flush_all": flushAllHandler,
+		"version":   versionHandler,
+	}
+	for command, h := range handlers {
+		server.RegisterFunc(command, traced(command, h, gerdu))
+	}
+	return server
+}
 
-	})
-	server.RegisterFunc("incr", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return incrHandler(ctx, req, res, gerdu)
-	})
-	server.RegisterFunc("flush_all", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return flushAllHandler(ctx, req, res, gerdu)
-	})
-	server.RegisterFunc("version", func(ctx context.Context, req *mc.Request, res *mc.Response) error {
-		return versionHandler(ctx, req, res, gerdu)
-	})
-	server.Start()
+// traced serves the command with h in a span named by the command
+func traced(command string, h handler, gerdu cache.UnImplementedCache) mc.HandlerFunc {
+	return func(ctx context.Context, req *mc.Request, res *mc.Response) error {
+		ctx, span := tracing.Start(ctx, "memcached "+command)
+		defer span.End()
+		return h(ctx, req, res, gerdu)
+	}
 }
 
 func getHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	values := cache.GetMulti(gerdu, req.Keys)
 	for _, key := range req.Keys {
-		value, ok := gerdu.Get(key)
+		value, ok := values[key]
 		if ok {
 
 			log.Printf("Memcached RETRIEVED Key: %s Value: %s\n", key, value)
@@ -50,10 +90,97 @@ func getHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu ca
 	return nil
 }
 
+func getsHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	versions, ok := gerdu.(cache.VersionCache)
+	if !ok {
+		return getHandler(ctx, req, res, gerdu)
+	}
+	for _, key := range req.Keys {
+		value, version, ok := versions.Gets(key)
+		if ok {
+			log.Printf("Memcached RETRIEVED Key: %s Value: %s Version: %d\n", key, value, version)
+			res.Values = append(res.Values, mc.Value{Key: key, Flags: "0", Data: []byte(value),
+				Cas: strconv.FormatUint(version, 10)})
+		}
+	}
+
+	res.Response = mc.RespEnd
+	return nil
+}
+
+func casHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	versions, ok := gerdu.(cache.VersionCache)
+	if !ok {
+		return cache.ErrNotSupported
+	}
+	version, err := strconv.ParseUint(req.Cas, 10, 64)
+	if err != nil {
+		log.Printf("Memcached CAS Key %v has invalid version %s\n", req.Key, req.Cas)
+		return err
+	}
+	switch err := versions.Cas(req.Key, string(req.Data), version); err {
+	case nil:
+		log.Printf("Memcached CAS Key: %s Value: %s\n", req.Key, req.Data)
+		res.Response = mc.RespStored
+	case cache.ErrVersionMismatch:
+		res.Response = mc.RespExists
+	case cache.ErrNotFound:
+		res.Response = mc.RespNotFound
+	default:
+		return err
+	}
+	return nil
+}
+
+// expiration returns the time to live of the exptime of the request, zero
+// if it never expires and negative if it is already expired. The library
+// turns a relative exptime of up to 30 days into now.Unix()/1e9 plus the
+// delta, so that offset is taken off again here
+func expiration(req *mc.Request, now time.Time) time.Duration {
+	if req.Exptime == 0 {
+		return 0
+	}
+	if req.Exptime < 0 {
+		return -1
+	}
+	offset := now.Unix() / 1e9
+	if req.Exptime <= mc.RealtimeMaxDelta+offset {
+		return time.Duration(req.Exptime-offset) * time.Second
+	}
+	ttl := time.Unix(req.Exptime, 0).Sub(now)
+	if ttl <= 0 {
+		return -1
+	}
+	return ttl
+}
+
+// store stores the entry with put and the ttl of the request, an entry
+// whose exptime is already past is stored and then deleted at once
+func store(req *mc.Request, gerdu cache.UnImplementedCache, put func(ttl time.Duration) bool) bool {
+	ttl := expiration(req, time.Now())
+	if ttl >= 0 {
+		return put(ttl)
+	}
+	if !put(0) {
+		return false
+	}
+	gerdu.Delete(req.Key)
+	return true
+}
+
 func setHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
 	key := req.Key
 	value := req.Data
-	created := gerdu.Put(key, string(value))
+	ttlCache, ok := gerdu.(cache.TTLCache)
+	if !ok && req.Exptime > 0 {
+		return cache.ErrNotSupported
+	}
+	created := store(req, gerdu, func(ttl time.Duration) bool {
+		if ttl > 0 {
+			return ttlCache.PutWithTTL(key, string(value), ttl)
+		}
+		return gerdu.Put(key, string(value))
+	})
 	if !created {
 		log.Printf("Memcached UPDATE Key: %s Value: %s\n", key, value)
 	} else {
@@ -63,19 +190,82 @@ func setHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu ca
 	return nil
 }
 
-func deleteHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
-	count := 0
-	for _, key := range req.Keys {
-		if _, exists := gerdu.Get(key); exists {
-			ok := gerdu.Delete(key)
-			if ok {
-				log.Printf("Memcached DELETE Key: %s\n", key)
-			}
-			count++
-		} else {
-			log.Printf("Memcached DELETE Key not found: %s\n", key)
+func addHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	absent, ok := gerdu.(cache.AbsentCache)
+	if !ok {
+		return cache.ErrNotSupported
+	}
+	created := store(req, gerdu, func(ttl time.Duration) bool {
+		return absent.PutIfAbsentWithTTL(req.Key, string(req.Data), ttl)
+	})
+	if created {
+		log.Printf("Memcached ADD Key: %s Value: %s\n", req.Key, req.Data)
+		res.Response = mc.RespStored
+	} else {
+		res.Response = mc.RespNotStored
+	}
+	return nil
+}
+
+func replaceHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	replacer, ok := gerdu.(cache.ReplaceCache)
+	if !ok {
+		return cache.ErrNotSupported
+	}
+	replaced := store(req, gerdu, func(ttl time.Duration) bool {
+		return replacer.ReplaceWithTTL(req.Key, string(req.Data), ttl)
+	})
+	if replaced {
+		log.Printf("Memcached REPLACE Key: %s Value: %s\n", req.Key, req.Data)
+		res.Response = mc.RespStored
+	} else {
+		res.Response = mc.RespNotStored
+	}
+	return nil
+}
+
+func touchHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	expirer, ok := gerdu.(cache.ExpireCache)
+	if !ok {
+		return cache.ErrNotSupported
+	}
+	touched := store(req, gerdu, func(ttl time.Duration) bool {
+		if ttl > 0 {
+			return expirer.Expire(req.Key, ttl)
 		}
+		return expirer.Persist(req.Key)
+	})
+	if touched {
+		log.Printf("Memcached TOUCH Key: %s Exptime: %d\n", req.Key, req.Exptime)
+		res.Response = mc.RespTouched
+	} else {
+		res.Response = mc.RespNotFound
+	}
+	return nil
+}
+
+func concatHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	appender, ok := gerdu.(cache.AppendCache)
+	if !ok {
+		return cache.ErrNotSupported
+	}
+	if req.Command == "append" {
+		ok = appender.Append(req.Key, string(req.Data))
+	} else {
+		ok = appender.Prepend(req.Key, string(req.Data))
+	}
+	if ok {
+		log.Printf("Memcached %s Key: %s Value: %s\n", strings.ToUpper(req.Command), req.Key, req.Data)
+		res.Response = mc.RespStored
+	} else {
+		res.Response = mc.RespNotStored
 	}
+	return nil
+}
+
+func deleteHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	count := cache.DeleteMulti(gerdu, req.Keys)
+	log.Printf("Memcached DELETE Keys: %v Deleted: %d\n", req.Keys, count)
 	if count > 0 {
 		res.Response = mc.RespDeleted
 	} else {
@@ -86,23 +276,27 @@ func deleteHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu
 
 func incrHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
 	key := req.Key
-	increment := req.Value
-	var base int64
-	if value, exists := gerdu.Get(key); exists {
-		var err error
-		base, err = strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			log.Printf("Memcached INCREMENT Key %v is not valid \n", key)
-			return err
-		}
+	value, err := cache.Incr(gerdu, key, req.Value)
+	if err != nil {
+		log.Printf("Memcached INCREMENT Key %v is not valid \n", key)
+		return err
 	}
+	log.Printf("Memcached INCREMENTED Key %s by %d to value %d\n", key, req.Value, value)
 
-	value := strconv.FormatInt(base+increment, 10)
-	log.Printf("Memcached INCREMENTED Key %s value %d to value %s\n", key, req.Value, value)
+	res.Response = strconv.FormatInt(value, 10)
+	return nil
+}
 
-	gerdu.Put(key, value)
+func decrHandler(ctx context.Context, req *mc.Request, res *mc.Response, gerdu cache.UnImplementedCache) error {
+	key := req.Key
+	value, err := cache.Decr(gerdu, key, req.Value)
+	if err != nil {
+		log.Printf("Memcached DECREMENT Key %v is not valid \n", key)
+		return err
+	}
+	log.Printf("Memcached DECREMENTED Key %s by %d to value %d\n", key, req.Value, value)
 
-	res.Response = value
+	res.Response = strconv.FormatInt(value, 10)
 	return nil
 }
 
@@ -0,0 +1,88 @@
+package memcached
+
+import (
+	"context"
+	"github.com/arazmj/gerdu/lrucache"
+	mc "github.com/arazmj/gomemcached"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, h handler, c *lrucache.LRUCache, req *mc.Request) string {
+	t.Helper()
+	res := &mc.Response{}
+	if err := h(context.Background(), req, res, c); err != nil {
+		t.Fatalf("%s %s failed %v", req.Command, req.Key, err)
+	}
+	return res.Response
+}
+
+func TestExpiration(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	offset := now.Unix() / 1e9
+	tests := []struct {
+		exptime  int64
+		expected time.Duration
+	}{
+		{0, 0},
+		{-1, -1},
+		{offset + 60, time.Minute},
+		{offset + mc.RealtimeMaxDelta, mc.RealtimeMaxDelta * time.Second},
+		{now.Unix() + 3600, time.Hour},
+		{now.Unix() - 3600, -1},
+	}
+	for _, test := range tests {
+		ttl := expiration(&mc.Request{Exptime: test.exptime}, now)
+		if ttl != test.expected {
+			t.Errorf("expiration(%d) expected %v but got %v", test.exptime, test.expected, ttl)
+		}
+	}
+}
+
+func TestAddReplace(t *testing.T) {
+	c := lrucache.NewCache(10)
+	if res := serve(t, replaceHandler, c, &mc.Request{Command: "replace", Key: "1", Data: []byte("1")}); res != mc.RespNotStored {
+		t.Errorf("Expected replace of a missing key to be NOT_STORED but got %s", res)
+	}
+	if res := serve(t, addHandler, c, &mc.Request{Command: "add", Key: "1", Data: []byte("1")}); res != mc.RespStored {
+		t.Errorf("Expected add of a missing key to be STORED but got %s", res)
+	}
+	if res := serve(t, addHandler, c, &mc.Request{Command: "add", Key: "1", Data: []byte("2")}); res != mc.RespNotStored {
+		t.Errorf("Expected add of an existing key to be NOT_STORED but got %s", res)
+	}
+	if res := serve(t, replaceHandler, c, &mc.Request{Command: "replace", Key: "1", Data: []byte("3")}); res != mc.RespStored {
+		t.Errorf("Expected replace of an existing key to be STORED but got %s", res)
+	}
+	if value, _ := c.Get("1"); value != "3" {
+		t.Errorf("Expected 3 but got %s", value)
+	}
+}
+
+func TestExptime(t *testing.T) {
+	c := lrucache.NewCache(10)
+	exptime := time.Now().Unix() + 3600
+	serve(t, setHandler, c, &mc.Request{Command: "set", Key: "1", Data: []byte("1"), Exptime: exptime})
+	if ttl, ok := c.TTL("1"); !ok || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected 1 to expire within an hour but got %v", ttl)
+	}
+	serve(t, setHandler, c, &mc.Request{Command: "set", Key: "2", Data: []byte("2"), Exptime: -1})
+	if _, ok := c.Get("2"); ok {
+		t.Errorf("Expected 2 to be expired at once")
+	}
+
+	if res := serve(t, touchHandler, c, &mc.Request{Command: "touch", Key: "1"}); res != mc.RespTouched {
+		t.Errorf("Expected touch of an existing key to be TOUCHED but got %s", res)
+	}
+	if ttl, ok := c.TTL("1"); !ok || ttl != 0 {
+		t.Errorf("Expected 1 to never expire but got %v", ttl)
+	}
+	if res := serve(t, touchHandler, c, &mc.Request{Command: "touch", Key: "2", Exptime: exptime}); res != mc.RespNotFound {
+		t.Errorf("Expected touch of a missing key to be NOT_FOUND but got %s", res)
+	}
+	if res := serve(t, touchHandler, c, &mc.Request{Command: "touch", Key: "1", Exptime: -1}); res != mc.RespTouched {
+		t.Errorf("Expected touch of an existing key to be TOUCHED but got %s", res)
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be expired at once")
+	}
+}
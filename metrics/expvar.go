@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// ExpvarRecorder publishes the metrics as the cache.name floats of an expvar
+// map, they are served as JSON on /debug/vars
+type ExpvarRecorder struct {
+	vars *expvar.Map
+	mu   sync.Mutex
+}
+
+// NewExpvarRecorder returns a recorder of the expvar map name, the map is
+// published once and shared by the recorders of the same name
+func NewExpvarRecorder(name string) *ExpvarRecorder {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	vars, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		vars = expvar.NewMap(name)
+	}
+	return &ExpvarRecorder{vars: vars}
+}
+
+// expvarMu guards publishing the maps, expvar panics on a name that is
+// published twice
+var expvarMu sync.Mutex
+
+// Counter returns the float of the counter of the cache
+func (r *ExpvarRecorder) Counter(cache, name string) CounterRecorder {
+	return r.float(cache + "." + name)
+}
+
+// Gauge returns the float of the gauge of the cache
+func (r *ExpvarRecorder) Gauge(cache, name string) GaugeRecorder {
+	return r.float(cache + "." + name)
+}
+
+// Histogram returns the histogram of the latency of the cache, a map of
+// the count and sum of the observations and the cumulative counts of
+// LatencyBuckets by their upper bound
+func (r *ExpvarRecorder) Histogram(cache, name string) HistogramRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := cache + "." + name
+	if h, ok := r.vars.Get(key).(*expvarHistogram); ok {
+		return h
+	}
+	h := &expvarHistogram{}
+	h.Init()
+	r.vars.Set(key, h)
+	return h
+}
+
+type expvarHistogram struct {
+	expvar.Map
+}
+
+// Observe adds v to the count, the sum and the buckets it falls in
+func (h *expvarHistogram) Observe(v float64) {
+	h.Add("count", 1)
+	h.AddFloat("sum", v)
+	for _, bound := range LatencyBuckets {
+		if v <= bound {
+			h.Add("le_"+strconv.FormatFloat(bound, 'g', -1, 64), 1)
+		}
+	}
+}
+
+// float returns the float of the key, the caches of the same name share it
+func (r *ExpvarRecorder) float(key string) *expvar.Float {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.vars.Get(key).(*expvar.Float); ok {
+		return f
+	}
+	f := new(expvar.Float)
+	r.vars.Set(key, f)
+	return f
+}
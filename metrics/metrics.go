@@ -1,33 +1,306 @@
-// Package metrics this package contains Prometheus counters
+// Package metrics this package contains the counters of the caches and the
+// backends they are reported to
 package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultName is the cache label of the counters of the caches that are not named
+const DefaultName = "default"
+
 var (
-	// Miss cache misses
-	Miss = promauto.NewCounter(prometheus.CounterOpts{
+	misses = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gerdu_misses_total",
 		Help: "The total number of missed cache hits",
-	})
+	}, []string{"cache"})
 
-	// Hits cache hits
-	Hits = promauto.NewCounter(prometheus.CounterOpts{
+	hits = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gerdu_hits_total",
 		Help: "The total number of cache hits",
-	})
+	}, []string{"cache"})
 
-	// Adds number of adds operations
-	Adds = promauto.NewCounter(prometheus.CounterOpts{
+	adds = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gerdu_adds_total",
 		Help: "The total number of new added nodes",
-	})
+	}, []string{"cache"})
 
-	// Deletes number of delete operations
-	Deletes = promauto.NewCounter(prometheus.CounterOpts{
+	deletes = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gerdu_deletes_total",
 		Help: "The total number of deletes nodes",
+	}, []string{"cache"})
+
+	evictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gerdu_evictions_total",
+		Help: "The total number of nodes evicted by the capacity or expired",
+	}, []string{"cache"})
+
+	size = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gerdu_size_bytes",
+		Help: "The current size of the cache in bytes",
+	}, []string{"cache"})
+
+	entries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gerdu_entries",
+		Help: "The current number of entries in the cache",
+	}, []string{"cache"})
+
+	capacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gerdu_capacity_bytes",
+		Help: "The configured capacity of the cache in bytes",
+	}, []string{"cache"})
+
+	// Dropped number of events dropped for the slow subscribers
+	Dropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gerdu_events_dropped_total",
+		Help: "The total number of events dropped for the slow subscribers",
 	})
+
+	// Anomalies number of eviction loops that stopped on an inconsistent
+	// cache state instead of spinning or panicking
+	Anomalies = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gerdu_eviction_anomalies_total",
+		Help: "The total number of eviction loops stopped on an inconsistent cache state",
+	})
+)
+
+// Counter counts an event of a single cache and reports the increments to
+// a recorder, so two caches of the same name in one process still have
+// their own counts
+type Counter struct {
+	count    uint64
+	recorder CounterRecorder
+}
+
+// Inc increments the counter
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.count, 1)
+	c.recorder.Add(1)
+}
+
+// Add adds v to the recorder and its integer part to the count
+func (c *Counter) Add(v float64) {
+	atomic.AddUint64(&c.count, uint64(v))
+	c.recorder.Add(v)
+}
+
+// Count returns the increments of the cache
+func (c *Counter) Count() uint64 {
+	return atomic.LoadUint64(&c.count)
+}
+
+// Counters are the counters of a cache, each cache has its own counters
+// even if it shares the name with another one
+type Counters struct {
+	Miss      *Counter
+	Hits      *Counter
+	Adds      *Counter
+	Deletes   *Counter
+	Evictions *Counter
+}
+
+// NewCounters returns the counters of the cache name that report to r
+func NewCounters(r Recorder, name string) *Counters {
+	counter := func(metric string) *Counter {
+		return &Counter{recorder: r.Counter(name, metric)}
+	}
+	return &Counters{
+		Miss:      counter(MissesMetric),
+		Hits:      counter(HitsMetric),
+		Adds:      counter(AddsMetric),
+		Deletes:   counter(DeletesMetric),
+		Evictions: counter(EvictionsMetric),
+	}
+}
+
+// Gauge is a value of a single cache that is reported to a recorder on
+// every change
+type Gauge struct {
+	bits     uint64
+	recorder GaugeRecorder
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+	g.recorder.Set(v)
+}
+
+// Add adds v to the gauge
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		value := math.Float64frombits(old) + v
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(value)) {
+			g.recorder.Set(value)
+			return
+		}
+	}
+}
+
+// Value returns the value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Gauges are the utilization gauges of a cache
+type Gauges struct {
+	Size     *Gauge
+	Entries  *Gauge
+	Capacity *Gauge
+}
+
+// NewGauges returns the gauges of the cache name that report to r
+func NewGauges(r Recorder, name string) *Gauges {
+	gauge := func(metric string) *Gauge {
+		return &Gauge{recorder: r.Gauge(name, metric)}
+	}
+	return &Gauges{
+		Size:     gauge(SizeMetric),
+		Entries:  gauge(EntriesMetric),
+		Capacity: gauge(CapacityMetric),
+	}
+}
+
+// Histogram records the latencies of an operation of a cache
+type Histogram struct {
+	recorder HistogramRecorder
+}
+
+// Observe records the latency d
+func (h *Histogram) Observe(d time.Duration) {
+	h.recorder.Observe(d.Seconds())
+}
+
+// Since records the latency since start, it is meant to be deferred
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// Latencies are the latency histograms of the operations of a cache and of
+// the waits for its lock
+type Latencies struct {
+	Get      *Histogram
+	Put      *Histogram
+	Delete   *Histogram
+	LockWait *Histogram
+}
+
+// NewLatencies returns the latency histograms of the cache name that report
+// to r
+func NewLatencies(r Recorder, name string) *Latencies {
+	histogram := func(metric string) *Histogram {
+		return &Histogram{recorder: r.Histogram(name, metric)}
+	}
+	return &Latencies{
+		Get:      histogram(GetMetric),
+		Put:      histogram(PutMetric),
+		Delete:   histogram(DeleteMetric),
+		LockWait: histogram(LockWaitMetric),
+	}
+}
+
+// Value returns the current value of the counter
+func Value(counter prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := counter.Write(m); err != nil {
+		return 0
+	}
+	return m.GetCounter().GetValue()
+}
+
+// GaugeValue returns the current value of the gauge
+func GaugeValue(gauge prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	if err := gauge.Write(m); err != nil {
+		return 0
+	}
+	return m.GetGauge().GetValue()
+}
+
+// Stats are the values of the counters of a cache
+type Stats struct {
+	Miss    float64
+	Hits    float64
+	Adds    float64
+	Deletes float64
+}
+
+func (s Stats) sub(b Stats) Stats {
+	return Stats{
+		Miss:    s.Miss - b.Miss,
+		Hits:    s.Hits - b.Hits,
+		Adds:    s.Adds - b.Adds,
+		Deletes: s.Deletes - b.Deletes,
+	}
+}
+
+var (
+	// baseline is the values of the counters at the last Reset by cache name
+	baseline   = map[string]Stats{}
+	baselineMu sync.Mutex
 )
+
+// Snapshot returns the values of the counters by cache name since the last
+// Reset, the counters exported to Prometheus are not affected by Reset
+func Snapshot() map[string]Stats {
+	defer baselineMu.Unlock()
+	baselineMu.Lock()
+	stats := collect()
+	for name, s := range stats {
+		stats[name] = s.sub(baseline[name])
+	}
+	return stats
+}
+
+// Reset zeroes the counters of Snapshot and returns their values before
+// the reset. Each counter is read once, so an increment that is concurrent
+// with Reset is either in the returned values or in the next Snapshot
+func Reset() map[string]Stats {
+	defer baselineMu.Unlock()
+	baselineMu.Lock()
+	stats := collect()
+	for name, s := range stats {
+		stats[name] = s.sub(baseline[name])
+		baseline[name] = s
+	}
+	return stats
+}
+
+// collect returns the current values of the counters by cache name
+func collect() map[string]Stats {
+	stats := map[string]Stats{}
+	for _, vec := range []*prometheus.CounterVec{misses, hits, adds, deletes} {
+		ch := make(chan prometheus.Metric, 16)
+		go func() {
+			vec.Collect(ch)
+			close(ch)
+		}()
+		for metric := range ch {
+			m := &dto.Metric{}
+			if err := metric.Write(m); err != nil {
+				continue
+			}
+			name := m.GetLabel()[0].GetValue()
+			s := stats[name]
+			switch vec {
+			case misses:
+				s.Miss = m.GetCounter().GetValue()
+			case hits:
+				s.Hits = m.GetCounter().GetValue()
+			case adds:
+				s.Adds = m.GetCounter().GetValue()
+			case deletes:
+				s.Deletes = m.GetCounter().GetValue()
+			}
+			stats[name] = s
+		}
+	}
+	return stats
+}
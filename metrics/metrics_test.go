@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	c := NewCounters(Prometheus, "reset")
+	Reset()
+	c.Hits.Inc()
+	c.Hits.Inc()
+	c.Miss.Inc()
+	if s := Snapshot()["reset"]; s.Hits != 2 || s.Miss != 1 || s.Adds != 0 {
+		t.Errorf("Expected 2 hits and 1 miss but got %+v", s)
+	}
+	if s := Reset()["reset"]; s.Hits != 2 || s.Miss != 1 {
+		t.Errorf("Expected Reset to return 2 hits and 1 miss but got %+v", s)
+	}
+	if s := Snapshot()["reset"]; s != (Stats{}) {
+		t.Errorf("Expected zero counters after Reset but got %+v", s)
+	}
+	c.Adds.Inc()
+	c.Deletes.Inc()
+	if s := Snapshot()["reset"]; s.Hits != 0 || s.Adds != 1 || s.Deletes != 1 {
+		t.Errorf("Expected only the increments after Reset but got %+v", s)
+	}
+	if Value(hits.WithLabelValues("reset")) != 2 {
+		t.Errorf("Expected the exported counter to be unaffected but got %v", Value(hits.WithLabelValues("reset")))
+	}
+}
+
+func TestReset_Concurrent(t *testing.T) {
+	c := NewCounters(Prometheus, "concurrent")
+	Reset()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Hits.Inc()
+			}
+		}()
+	}
+	total := 0.0
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		total += Reset()["concurrent"].Hits
+	}
+	total += Snapshot()["concurrent"].Hits
+	if total != 4000 {
+		t.Errorf("Expected the deltas to add up to 4000 hits but got %v", total)
+	}
+}
+
+func TestCounters_Instance(t *testing.T) {
+	a := NewCounters(Prometheus, "instance")
+	b := NewCounters(Prometheus, "instance")
+	before := Value(hits.WithLabelValues("instance"))
+	a.Hits.Inc()
+	a.Hits.Inc()
+	b.Hits.Inc()
+	b.Evictions.Add(3)
+	if a.Hits.Count() != 2 || b.Hits.Count() != 1 || a.Evictions.Count() != 0 || b.Evictions.Count() != 3 {
+		t.Errorf("Expected the counts of each instance but got %d %d %d %d",
+			a.Hits.Count(), b.Hits.Count(), a.Evictions.Count(), b.Evictions.Count())
+	}
+	if Value(hits.WithLabelValues("instance")) != before+3 {
+		t.Errorf("Expected the exported counter to add up the instances but got %v", Value(hits.WithLabelValues("instance")))
+	}
+}
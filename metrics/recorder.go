@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The names of the metrics of a cache that are reported to a Recorder
+const (
+	HitsMetric      = "hits"
+	MissesMetric    = "misses"
+	AddsMetric      = "adds"
+	DeletesMetric   = "deletes"
+	EvictionsMetric = "evictions"
+	SizeMetric      = "size_bytes"
+	EntriesMetric   = "entries"
+	CapacityMetric  = "capacity_bytes"
+	GetMetric       = "get_seconds"
+	PutMetric       = "put_seconds"
+	DeleteMetric    = "delete_seconds"
+	LockWaitMetric  = "lock_wait_seconds"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the latency histograms,
+// from half a microsecond to about two seconds
+var LatencyBuckets = prometheus.ExponentialBuckets(0.0000005, 4, 12)
+
+var (
+	latency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gerdu_operation_duration_seconds",
+		Help:    "The latency of the get, put and delete operations of the cache",
+		Buckets: LatencyBuckets,
+	}, []string{"cache", "op"})
+
+	lockWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gerdu_lock_wait_seconds",
+		Help:    "The time the operations of the cache waited for its lock",
+		Buckets: LatencyBuckets,
+	}, []string{"cache"})
+)
+
+// CounterRecorder receives the increments of a counter
+type CounterRecorder interface {
+	Add(v float64)
+}
+
+// GaugeRecorder receives the values of a gauge
+type GaugeRecorder interface {
+	Set(v float64)
+}
+
+// HistogramRecorder receives the observations of a histogram
+type HistogramRecorder interface {
+	Observe(v float64)
+}
+
+// Recorder is the backend the caches report their metrics to. It returns
+// the recorder of the metric name of the cache, it is called once per
+// metric when the cache is created
+type Recorder interface {
+	Counter(cache, name string) CounterRecorder
+	Gauge(cache, name string) GaugeRecorder
+	Histogram(cache, name string) HistogramRecorder
+}
+
+// Prometheus reports the metrics to the gerdu_* vectors of the default
+// Prometheus registry labeled by the cache name
+var Prometheus Recorder = prometheusRecorder{}
+
+type prometheusRecorder struct{}
+
+// Counter returns the Prometheus counter of the metric name
+func (prometheusRecorder) Counter(cache, name string) CounterRecorder {
+	switch name {
+	case HitsMetric:
+		return hits.WithLabelValues(cache)
+	case MissesMetric:
+		return misses.WithLabelValues(cache)
+	case AddsMetric:
+		return adds.WithLabelValues(cache)
+	case DeletesMetric:
+		return deletes.WithLabelValues(cache)
+	case EvictionsMetric:
+		return evictions.WithLabelValues(cache)
+	}
+	return Discard.Counter(cache, name)
+}
+
+// Gauge returns the Prometheus gauge of the metric name
+func (prometheusRecorder) Gauge(cache, name string) GaugeRecorder {
+	switch name {
+	case SizeMetric:
+		return size.WithLabelValues(cache)
+	case EntriesMetric:
+		return entries.WithLabelValues(cache)
+	case CapacityMetric:
+		return capacity.WithLabelValues(cache)
+	}
+	return Discard.Gauge(cache, name)
+}
+
+// Histogram returns the Prometheus histogram of the metric name, the
+// operations share a histogram labeled by the operation
+func (prometheusRecorder) Histogram(cache, name string) HistogramRecorder {
+	switch name {
+	case GetMetric:
+		return latency.WithLabelValues(cache, "get")
+	case PutMetric:
+		return latency.WithLabelValues(cache, "put")
+	case DeleteMetric:
+		return latency.WithLabelValues(cache, "delete")
+	case LockWaitMetric:
+		return lockWait.WithLabelValues(cache)
+	}
+	return Discard.Histogram(cache, name)
+}
+
+// Discard drops the metrics, the counts of the caches are still kept
+var Discard Recorder = discardRecorder{}
+
+type discardRecorder struct{}
+
+func (discardRecorder) Counter(string, string) CounterRecorder { return discard{} }
+
+func (discardRecorder) Gauge(string, string) GaugeRecorder { return discard{} }
+
+func (discardRecorder) Histogram(string, string) HistogramRecorder { return discard{} }
+
+type discard struct{}
+
+func (discard) Add(float64) {}
+
+func (discard) Set(float64) {}
+
+func (discard) Observe(float64) {}
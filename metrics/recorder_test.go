@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"expvar"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGauge(t *testing.T) {
+	g := NewGauges(Discard, "gauge")
+	g.Size.Set(10)
+	g.Size.Add(-4)
+	g.Entries.Add(2)
+	if g.Size.Value() != 6 || g.Entries.Value() != 2 || g.Capacity.Value() != 0 {
+		t.Errorf("Expected 6 2 0 but got %v %v %v", g.Size.Value(), g.Entries.Value(), g.Capacity.Value())
+	}
+}
+
+func TestExpvarRecorder(t *testing.T) {
+	r := NewExpvarRecorder("expvar-test")
+	c := NewCounters(r, "a")
+	g := NewGauges(r, "a")
+	c.Hits.Inc()
+	c.Hits.Add(2)
+	g.Size.Set(42)
+	vars := expvar.Get("expvar-test").(*expvar.Map)
+	if hits := vars.Get("a.hits").(*expvar.Float).Value(); hits != 3 {
+		t.Errorf("Expected 3 hits but got %v", hits)
+	}
+	if size := vars.Get("a.size_bytes").(*expvar.Float).Value(); size != 42 {
+		t.Errorf("Expected size 42 but got %v", size)
+	}
+	if NewExpvarRecorder("expvar-test").vars != vars {
+		t.Errorf("Expected the recorders of the same name to share the map")
+	}
+}
+
+func TestStatsdRecorder(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r, err := NewStatsdRecorder(conn.LocalAddr().String(), "gerdu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	c := NewCounters(r, "a")
+	g := NewGauges(r, "a")
+	c.Hits.Inc()
+	c.Evictions.Add(3)
+	g.Entries.Set(1.5)
+	NewLatencies(r, "a").Put.Observe(1500 * time.Microsecond)
+
+	var lines []string
+	buf := make([]byte, 512)
+	for len(lines) < 4 {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Expected 4 datagrams but got %v: %v", lines, err)
+		}
+		lines = append(lines, string(buf[:n]))
+	}
+	sort.Strings(lines)
+	expected := []string{"gerdu.a.entries:1.5|g", "gerdu.a.evictions:3|c", "gerdu.a.hits:1|c", "gerdu.a.put_seconds:1.5|ms"}
+	if strings.Join(lines, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v but got %v", expected, lines)
+	}
+	if c.Hits.Count() != 1 || c.Evictions.Count() != 3 {
+		t.Errorf("Expected the counts to be kept")
+	}
+}
+
+func TestExpvarRecorder_Histogram(t *testing.T) {
+	r := NewExpvarRecorder("expvar-histogram")
+	l := NewLatencies(r, "a")
+	l.Get.Observe(time.Microsecond)
+	l.Get.Observe(time.Second)
+	h := expvar.Get("expvar-histogram").(*expvar.Map).Get("a.get_seconds").(*expvarHistogram)
+	if count := h.Get("count").(*expvar.Int).Value(); count != 2 {
+		t.Errorf("Expected 2 observations but got %d", count)
+	}
+	if sum := h.Get("sum").(*expvar.Float).Value(); sum != 1.000001 {
+		t.Errorf("Expected the sum of 1.000001s but got %v", sum)
+	}
+	if le := h.Get("le_2e-06").(*expvar.Int).Value(); le != 1 {
+		t.Errorf("Expected 1 observation up to 2us but got %d", le)
+	}
+	if le := h.Get("le_2.097152").(*expvar.Int).Value(); le != 2 {
+		t.Errorf("Expected 2 observations up to 2.097152s but got %d", le)
+	}
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"net"
+	"strconv"
+)
+
+// StatsdRecorder sends the metrics to a statsd server over UDP as
+// prefix.cache.name:value|c for the increments of the counters and
+// prefix.cache.name:value|g for the values of the gauges and
+// prefix.cache.name:value|ms for the latencies in milliseconds. A metric is sent
+// on every change and a failed send is dropped like statsd does
+type StatsdRecorder struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsdRecorder returns a recorder that sends to the statsd server at
+// addr, the metric names start with prefix if it is not empty
+func NewStatsdRecorder(addr, prefix string) (*StatsdRecorder, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix += "."
+	}
+	return &StatsdRecorder{conn: conn, prefix: prefix}, nil
+}
+
+// Counter returns the recorder of the counter of the cache
+func (r *StatsdRecorder) Counter(cache, name string) CounterRecorder {
+	return statsdMetric{r: r, name: r.prefix + cache + "." + name, kind: "|c"}
+}
+
+// Gauge returns the recorder of the gauge of the cache
+func (r *StatsdRecorder) Gauge(cache, name string) GaugeRecorder {
+	return statsdMetric{r: r, name: r.prefix + cache + "." + name, kind: "|g"}
+}
+
+// Histogram returns the timer of the latency of the cache, the seconds are
+// sent as milliseconds
+func (r *StatsdRecorder) Histogram(cache, name string) HistogramRecorder {
+	return statsdMetric{r: r, name: r.prefix + cache + "." + name, kind: "|ms"}
+}
+
+// Close closes the connection to the server
+func (r *StatsdRecorder) Close() error {
+	return r.conn.Close()
+}
+
+// send writes a single metric line, a UDP write is a single datagram so the
+// lines of concurrent sends are not interleaved
+func (r *StatsdRecorder) send(name string, v float64, kind string) {
+	line := name + ":" + strconv.FormatFloat(v, 'f', -1, 64) + kind
+	_, _ = r.conn.Write([]byte(line))
+}
+
+type statsdMetric struct {
+	r    *StatsdRecorder
+	name string
+	kind string
+}
+
+// Add sends the increment of the counter
+func (m statsdMetric) Add(v float64) {
+	m.r.send(m.name, v, m.kind)
+}
+
+// Set sends the value of the gauge
+func (m statsdMetric) Set(v float64) {
+	m.r.send(m.name, v, m.kind)
+}
+
+// Observe sends the latency in milliseconds
+func (m statsdMetric) Observe(v float64) {
+	m.r.send(m.name, v*1000, m.kind)
+}
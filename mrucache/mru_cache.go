@@ -0,0 +1,213 @@
+// Package mrucache implements MRU (Most Recently Used) cache
+package mrucache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+// MRUCache data structure, it evicts the most recently used entries which
+// suits the repeated scans where the most recent entry is the last to be
+// used again
+type MRUCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	node     map[string]*dlinklist.Node
+	linklist *dlinklist.DLinkedList
+	capacity bytesize.ByteSize
+	size     bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache MRUCache constructor
+func NewCache(capacity bytesize.ByteSize) *MRUCache {
+	return &MRUCache{
+		node:     map[string]*dlinklist.Node{},
+		linklist: dlinklist.NewLinkedList(),
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+}
+
+// Get returns the value for the key and moves it to the head
+func (c *MRUCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	node, ok := c.node[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.linklist.RemoveNode(node)
+	c.linklist.AddNode(node)
+	return node.Value, true
+}
+
+// Put updates or inserts a new entry, the most recently used entries are
+// evicted to make room for it before it is added to the head
+func (c *MRUCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	node, ok := c.node[key]
+	if ok {
+		c.linklist.RemoveNode(node)
+		c.size -= bytesize.ByteSize(len(node.Value))
+	} else {
+		node = &dlinklist.Node{Key: key}
+	}
+	size := bytesize.ByteSize(len(value))
+	for c.size+size > c.capacity && c.linklist.Size() > 0 {
+		c.counters.Evictions.Inc()
+		c.removeNode(c.linklist.Head())
+	}
+	if size > c.capacity {
+		if ok {
+			c.counters.Deletes.Inc()
+			c.counters.Evictions.Inc()
+			delete(c.node, key)
+		}
+		return false
+	}
+	if !ok {
+		c.counters.Adds.Inc()
+		c.node[key] = node
+	}
+	node.Value = value
+	c.linklist.AddNode(node)
+	c.size += size
+	return !ok
+}
+
+// Delete deletes the key from the cache
+func (c *MRUCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	node, ok := c.node[key]
+	if !ok {
+		return false
+	}
+	c.removeNode(node)
+	return true
+}
+
+// Resize changes the capacity of the cache, a smaller capacity evicts the
+// most recently used entries in batches of cache.ResizeBatch
+func (c *MRUCache) Resize(capacity bytesize.ByteSize) error {
+	c.Lock()
+	c.capacity = capacity
+	c.Unlock()
+	for more := true; more; {
+		c.Lock()
+		for n := 0; n < cache.ResizeBatch && c.size > c.capacity; n++ {
+			c.counters.Evictions.Inc()
+			c.removeNode(c.linklist.Head())
+		}
+		more = c.size > c.capacity
+		c.Unlock()
+	}
+	return nil
+}
+
+// removeNode unlinks the node from the linked list and reclaims its size,
+// the caller must hold the lock
+func (c *MRUCache) removeNode(node *dlinklist.Node) {
+	c.counters.Deletes.Inc()
+	c.linklist.RemoveNode(node)
+	c.size -= bytesize.ByteSize(len(node.Value))
+	delete(c.node, node.Key)
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *MRUCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *MRUCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.node)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *MRUCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.size, len(c.node))
+}
+
+func (c *MRUCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := make(map[string]string)
+
+	for k, v := range c.node {
+		o[k] = v.Value
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+// Restore inserts the entries of the snapshot in a single pass under the
+// lock. Nothing is evicted, the entries that do not fit are dropped as Put
+// would evict them again as the most recently used ones
+func (c *MRUCache) Restore(closer io.ReadCloser) error {
+	// no lock is required according to the Hashicorp docs, it is taken
+	// once so the entries are not locked one by one
+	defer c.Unlock()
+	c.Lock()
+	before := len(c.node)
+	err := cache.ReadSnapshot(closer, func(k, v string) {
+		size := bytesize.ByteSize(len(v))
+		node, ok := c.node[k]
+		if ok {
+			size -= bytesize.ByteSize(len(node.Value))
+		}
+		if c.size+size > c.capacity {
+			return
+		}
+		if ok {
+			c.linklist.RemoveNode(node)
+		} else {
+			node = &dlinklist.Node{Key: k}
+			c.node[k] = node
+		}
+		node.Value = v
+		c.linklist.AddNode(node)
+		c.size += size
+	})
+	c.counters.Adds.Add(float64(len(c.node) - before))
+	return err
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
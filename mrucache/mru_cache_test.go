@@ -0,0 +1,167 @@
+package mrucache
+
+import (
+	"bytes"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/hashicorp/raft"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMRUCache(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	cache.Get("1")
+	cache.Put("4", "4")
+	if _, ok := cache.Get("1"); ok {
+		t.Errorf("Expected the most recently used 1 to be evicted")
+	}
+	for _, key := range []string{"2", "3", "4"} {
+		if value, ok := cache.Get(key); !ok || value != key {
+			t.Errorf("Expected %s but got %s %t", key, value, ok)
+		}
+	}
+	if cache.Len() != 3 || cache.size != 3 {
+		t.Errorf("Expected 3 entries but got %d", cache.Len())
+	}
+}
+
+func TestMRUCache_Update(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	if cache.Put("1", "11") {
+		t.Errorf("Expected 1 to be updated")
+	}
+	if value, _ := cache.Get("1"); value != "11" || cache.size != 3 {
+		t.Errorf("Expected 11 of size 3 but got %s of size %d", value, cache.size)
+	}
+	cache.Put("2", "222")
+	if _, ok := cache.Get("1"); ok || cache.size != 3 {
+		t.Errorf("Expected the most recently used 1 to make room for 2")
+	}
+	cache.Put("2", "2222")
+	if _, ok := cache.Get("2"); ok || cache.Len() != 0 || cache.size != 0 {
+		t.Errorf("Expected the value larger than the capacity to be dropped")
+	}
+}
+
+func TestMRUCache_Delete(t *testing.T) {
+	cache := NewCache(3)
+	cache.Put("1", "1")
+	if !cache.Delete("1") || cache.Delete("1") {
+		t.Errorf("Expected 1 to be deleted once")
+	}
+	if cache.Len() != 0 || cache.size != 0 {
+		t.Errorf("Expected an empty cache")
+	}
+}
+
+func TestMRUCache_Scan(t *testing.T) {
+	mru := NewCache(10)
+	lru := lrucache.NewCache(10)
+	mruHits, lruHits := 0, 0
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 15; j++ {
+			key := strconv.Itoa(j)
+			if _, ok := mru.Get(key); ok {
+				mruHits++
+			} else {
+				mru.Put(key, "x")
+			}
+			if _, ok := lru.Get(key); ok {
+				lruHits++
+			} else {
+				lru.Put(key, "x")
+			}
+		}
+		if i == 0 {
+			if _, ok := mru.node["0"]; !ok {
+				t.Errorf("Expected MRU to retain the oldest entry of the scan")
+			}
+			if _, ok := lru.Meta("0"); ok {
+				t.Errorf("Expected LRU to discard the oldest entry of the scan")
+			}
+		}
+	}
+	if lruHits != 0 || mruHits <= lruHits {
+		t.Errorf("Expected MRU to outperform LRU on the scan but got %d and %d hits", mruHits, lruHits)
+	}
+}
+
+type sink struct {
+	bytes.Buffer
+}
+
+func (s *sink) ID() string    { return "" }
+func (s *sink) Cancel() error { return nil }
+func (s *sink) Close() error  { return nil }
+
+var _ raft.SnapshotSink = (*sink)(nil)
+
+func TestMRUCache_Snapshot(t *testing.T) {
+	cache := NewCache(10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	snapshot, err := cache.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed %v", err)
+	}
+	s := &sink{}
+	if err := snapshot.Persist(s); err != nil {
+		t.Fatalf("Persist failed %v", err)
+	}
+	restored := NewCache(10)
+	if err := restored.Restore(ioutil.NopCloser(&s.Buffer)); err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	if value, ok := restored.Get("2"); !ok || value != "2" || restored.Len() != 2 {
+		t.Errorf("Expected the restored entries")
+	}
+}
+
+func TestMRUCache_RestoreOverCapacity(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("1", "1")
+	if err := cache.Restore(ioutil.NopCloser(strings.NewReader(`{"1":"x","2":"2","3":"3"}`))); err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	if cache.Len() != 2 || cache.size != 2 || cache.linklist.Size() != 2 {
+		t.Errorf("Expected 2 entries in the capacity but got %d of size %d", cache.Len(), cache.size)
+	}
+	if value, ok := cache.Get("1"); !ok || value != "x" {
+		t.Errorf("Expected 1 to be updated but got %s", value)
+	}
+	if stats := cache.Stats(); stats.Adds != 2 || stats.Evictions != 0 {
+		t.Errorf("Expected 2 adds without evictions but got %+v", stats)
+	}
+}
+
+func TestMRUCache_Resize(t *testing.T) {
+	c := NewCache(1000)
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if err := c.Resize(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 || c.size != 10 {
+		t.Errorf("Expected 10 entries of size 10 but got %d entries of size %d", c.Len(), c.size)
+	}
+	if _, ok := c.Get("0"); !ok {
+		t.Errorf("Expected the oldest entry to survive the shrink")
+	}
+	if err := c.Resize(1000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if c.Len() != 510 {
+		t.Errorf("Expected the grown cache to keep all the entries but got %d", c.Len())
+	}
+}
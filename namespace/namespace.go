@@ -0,0 +1,174 @@
+// Package namespace implements a registry of independent caches addressed
+// by the name of their namespace, so a server hosts several datasets
+package namespace
+
+import (
+	"errors"
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/inhies/go-bytesize"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidName is returned when a namespace is created with a name that
+// is not 1 to 64 letters, digits, dots, dashes or underscores
+var ErrInvalidName = errors.New("namespace: invalid name")
+
+// ErrClosed is returned when a namespace is created after the registry is closed
+var ErrClosed = errors.New("namespace: the registry is closed")
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Factory creates the cache of a namespace with its config, the zero
+// fields of the config are filled with the defaults of the registry
+type Factory func(name string, config cache.NamespaceConfig) (cache.UnImplementedCache, error)
+
+// namespace is the cache of a namespace and the config it was created with
+type namespace struct {
+	cache  cache.UnImplementedCache
+	config cache.NamespaceConfig
+}
+
+// Registry holds the caches of the namespaces, each one is created by the
+// factory and has its own config, entries, stats and lifecycle
+type Registry struct {
+	sync.RWMutex
+	factory  Factory
+	defaults cache.NamespaceConfig
+	spaces   map[string]namespace
+	closed   bool
+}
+
+// the registry must satisfy the interface used by the servers
+var _ cache.NamespaceCache = (*Registry)(nil)
+
+// NewRegistry Registry constructor, defaults fills the zero fields of the
+// configs of the namespaces
+func NewRegistry(factory Factory, defaults cache.NamespaceConfig) *Registry {
+	return &Registry{factory: factory, defaults: defaults, spaces: map[string]namespace{}}
+}
+
+// Namespace returns the cache of the namespace
+func (r *Registry) Namespace(name string) (cache.UnImplementedCache, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	ns, ok := r.spaces[name]
+	return ns.cache, ok
+}
+
+// NamespaceConfig returns the config the namespace was created with,
+// defaults included
+func (r *Registry) NamespaceConfig(name string) (cache.NamespaceConfig, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	ns, ok := r.spaces[name]
+	return ns.config, ok
+}
+
+// CreateNamespace creates the cache of the namespace unless it exists, it
+// returns false for an existing one with the same config and
+// cache.ErrNamespaceConflict for one with another config
+func (r *Registry) CreateNamespace(name string, config cache.NamespaceConfig) (created bool, err error) {
+	if !validName.MatchString(name) {
+		return false, ErrInvalidName
+	}
+	if config.TTL < 0 {
+		return false, fmt.Errorf("namespace: negative ttl %v", config.TTL)
+	}
+	if config.Capacity == 0 {
+		config.Capacity = r.defaults.Capacity
+	}
+	if config.Policy == "" {
+		config.Policy = r.defaults.Policy
+	}
+	config.Policy = strings.ToLower(config.Policy)
+	if config.TTL == 0 {
+		config.TTL = r.defaults.TTL
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return false, ErrClosed
+	}
+	if ns, ok := r.spaces[name]; ok {
+		if ns.config != config {
+			return false, cache.ErrNamespaceConflict
+		}
+		return false, nil
+	}
+	c, err := r.factory(name, config)
+	if err != nil {
+		return false, err
+	}
+	r.spaces[name] = namespace{cache: c, config: config}
+	return true, nil
+}
+
+// DropNamespace removes the namespace and closes its cache, it returns
+// false if there is no such namespace
+func (r *Registry) DropNamespace(name string) (ok bool, err error) {
+	r.Lock()
+	ns, ok := r.spaces[name]
+	delete(r.spaces, name)
+	r.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, cache.Close(ns.cache)
+}
+
+// Namespaces returns the names of the namespaces in order
+func (r *Registry) Namespaces() []string {
+	r.RLock()
+	defer r.RUnlock()
+	names := make([]string, 0, len(r.spaces))
+	for name := range r.spaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the caches of all the namespaces and returns the first
+// error, no namespace can be created afterwards
+func (r *Registry) Close() error {
+	r.Lock()
+	spaces := r.spaces
+	r.spaces = map[string]namespace{}
+	r.closed = true
+	r.Unlock()
+	var err error
+	for _, ns := range spaces {
+		if e := cache.Close(ns.cache); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ParseSpec parses the spec of a namespace in the form
+// name[:policy[:capacity[:ttl]]], e.g. users:lfu:128MB:10m or sessions::32MB,
+// the empty fields are left to the defaults of the registry
+func ParseSpec(spec string) (name string, config cache.NamespaceConfig, err error) {
+	fields := strings.Split(strings.TrimSpace(spec), ":")
+	if len(fields) > 4 {
+		return "", config, fmt.Errorf("namespace: invalid spec %q", spec)
+	}
+	fields = append(fields, "", "", "")
+	name, config.Policy = fields[0], fields[1]
+	if fields[2] != "" {
+		if config.Capacity, err = bytesize.Parse(fields[2]); err != nil {
+			return "", config, fmt.Errorf("namespace: invalid capacity of %s: %v", name, err)
+		}
+	}
+	if fields[3] != "" {
+		if config.TTL, err = time.ParseDuration(fields[3]); err != nil {
+			return "", config, fmt.Errorf("namespace: invalid ttl of %s: %v", name, err)
+		}
+	}
+	return name, config, nil
+}
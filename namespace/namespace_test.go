@@ -0,0 +1,148 @@
+package namespace
+
+import (
+	"errors"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lfucache"
+	"github.com/arazmj/gerdu/lrucache"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	var created []string
+	r := NewRegistry(func(name string, config cache.NamespaceConfig) (cache.UnImplementedCache, error) {
+		created = append(created, name)
+		return lrucache.NewCache(config.Capacity, cache.WithMetricsName(name)), nil
+	}, cache.NamespaceConfig{Capacity: 100, Policy: "lru"})
+
+	for _, name := range []string{"users", "sessions"} {
+		if ok, err := r.CreateNamespace(name, cache.NamespaceConfig{}); !ok || err != nil {
+			t.Errorf("Expected %s to be created but got %v %v", name, ok, err)
+		}
+	}
+	if ok, err := r.CreateNamespace("users", cache.NamespaceConfig{}); ok || err != nil {
+		t.Errorf("Expected the existing namespace to be kept but got %v %v", ok, err)
+	}
+	if len(created) != 2 {
+		t.Errorf("Expected 2 caches to be created but got %v", created)
+	}
+	for _, name := range []string{"", "a/b", "a b", string(make([]byte, 65))} {
+		if _, err := r.CreateNamespace(name, cache.NamespaceConfig{}); err != ErrInvalidName {
+			t.Errorf("Expected ErrInvalidName for %q but got %v", name, err)
+		}
+	}
+	if names := r.Namespaces(); !reflect.DeepEqual(names, []string{"sessions", "users"}) {
+		t.Errorf("Expected the sorted names but got %v", names)
+	}
+
+	users, _ := r.Namespace("users")
+	sessions, _ := r.Namespace("sessions")
+	users.Put("1", "alice")
+	if _, ok := sessions.Get("1"); ok {
+		t.Errorf("Expected the namespaces to be isolated")
+	}
+	if value, ok := users.Get("1"); !ok || value != "alice" {
+		t.Errorf("Expected alice but got %s", value)
+	}
+
+	if ok, err := r.DropNamespace("users"); !ok || err != nil {
+		t.Errorf("Expected users to be dropped but got %v %v", ok, err)
+	}
+	if _, ok := r.Namespace("users"); ok {
+		t.Errorf("Expected the dropped namespace to be gone")
+	}
+	if ok, _ := r.DropNamespace("users"); ok {
+		t.Errorf("Expected a missing namespace not to be dropped")
+	}
+	r.CreateNamespace("users", cache.NamespaceConfig{})
+	users, _ = r.Namespace("users")
+	if _, ok := users.Get("1"); ok {
+		t.Errorf("Expected a recreated namespace to be empty")
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CreateNamespace("orders", cache.NamespaceConfig{}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed but got %v", err)
+	}
+	if len(r.Namespaces()) != 0 {
+		t.Errorf("Expected no namespace after close")
+	}
+}
+
+func TestRegistry_Config(t *testing.T) {
+	unknown := errors.New("unknown policy")
+	r := NewRegistry(func(name string, config cache.NamespaceConfig) (cache.UnImplementedCache, error) {
+		switch config.Policy {
+		case "lru":
+			return lrucache.NewCache(config.Capacity, cache.WithDefaultTTL(config.TTL)), nil
+		case "lfu":
+			return lfucache.NewCache(config.Capacity, cache.WithDefaultTTL(config.TTL)), nil
+		}
+		return nil, unknown
+	}, cache.NamespaceConfig{Capacity: 100, Policy: "lru"})
+
+	config := cache.NamespaceConfig{Capacity: 10, Policy: "LFU", TTL: time.Minute}
+	if ok, err := r.CreateNamespace("sessions", config); !ok || err != nil {
+		t.Fatalf("Expected sessions to be created but got %v %v", ok, err)
+	}
+	want := cache.NamespaceConfig{Capacity: 10, Policy: "lfu", TTL: time.Minute}
+	if got, ok := r.NamespaceConfig("sessions"); !ok || got != want {
+		t.Errorf("Expected %v but got %v", want, got)
+	}
+	sessions, _ := r.Namespace("sessions")
+	if _, ok := sessions.(*lfucache.LFUCache); !ok {
+		t.Errorf("Expected an lfu cache but got %T", sessions)
+	}
+	sessions.Put("1", "one")
+	if ttl, ok := sessions.(cache.ExpireCache).TTL("1"); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected the default ttl of the namespace but got %v", ttl)
+	}
+	if ok, err := r.CreateNamespace("sessions", want); ok || err != nil {
+		t.Errorf("Expected the same config to keep the namespace but got %v %v", ok, err)
+	}
+	if _, err := r.CreateNamespace("sessions", cache.NamespaceConfig{}); err != cache.ErrNamespaceConflict {
+		t.Errorf("Expected ErrNamespaceConflict but got %v", err)
+	}
+
+	r.CreateNamespace("users", cache.NamespaceConfig{})
+	if got, _ := r.NamespaceConfig("users"); got != (cache.NamespaceConfig{Capacity: 100, Policy: "lru"}) {
+		t.Errorf("Expected the defaults but got %v", got)
+	}
+	if _, err := r.CreateNamespace("orders", cache.NamespaceConfig{Policy: "mru"}); err != unknown {
+		t.Errorf("Expected the error of the factory but got %v", err)
+	}
+	if _, err := r.CreateNamespace("orders", cache.NamespaceConfig{TTL: -time.Second}); err == nil {
+		t.Errorf("Expected a negative ttl to be an error")
+	}
+	if _, ok := r.Namespace("orders"); ok {
+		t.Errorf("Expected the failed namespace not to be created")
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		name   string
+		config cache.NamespaceConfig
+	}{
+		{"users", "users", cache.NamespaceConfig{}},
+		{" users:lfu:128MB:10m ", "users", cache.NamespaceConfig{Capacity: 128 * 1024 * 1024, Policy: "lfu", TTL: 10 * time.Minute}},
+		{"sessions::32KB", "sessions", cache.NamespaceConfig{Capacity: 32 * 1024}},
+		{"tokens:::1h", "tokens", cache.NamespaceConfig{TTL: time.Hour}},
+	}
+	for _, test := range tests {
+		name, config, err := ParseSpec(test.spec)
+		if err != nil || name != test.name || config != test.config {
+			t.Errorf("Expected %s %v for %q but got %s %v %v", test.name, test.config, test.spec, name, config, err)
+		}
+	}
+	for _, spec := range []string{"users:lru:lots", "users:lru:1MB:soon", "a:b:c:d:e"} {
+		if _, _, err := ParseSpec(spec); err == nil {
+			t.Errorf("Expected %q to be invalid", spec)
+		}
+	}
+}
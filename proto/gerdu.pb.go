@@ -180,6 +180,7 @@ type GetResponse struct {
 	unknownFields protoimpl.UnknownFields
 
 	Value []byte `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Found bool   `protobuf:"varint,2,opt,name=found,proto3" json:"found,omitempty"`
 }
 
 func (x *GetResponse) Reset() {
@@ -221,6 +222,13 @@ func (x *GetResponse) GetValue() []byte {
 	return nil
 }
 
+func (x *GetResponse) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
 type DeleteRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -315,6 +323,506 @@ func (x *DeleteResponse) GetDeleted() bool {
 	return false
 }
 
+type StatsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{6}
+}
+
+type StatsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hits      uint64 `protobuf:"varint,1,opt,name=hits,proto3" json:"hits,omitempty"`
+	Misses    uint64 `protobuf:"varint,2,opt,name=misses,proto3" json:"misses,omitempty"`
+	Adds      uint64 `protobuf:"varint,3,opt,name=adds,proto3" json:"adds,omitempty"`
+	Deletes   uint64 `protobuf:"varint,4,opt,name=deletes,proto3" json:"deletes,omitempty"`
+	Evictions uint64 `protobuf:"varint,5,opt,name=evictions,proto3" json:"evictions,omitempty"`
+	Size      uint64 `protobuf:"varint,6,opt,name=size,proto3" json:"size,omitempty"`
+	Entries   uint64 `protobuf:"varint,7,opt,name=entries,proto3" json:"entries,omitempty"`
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StatsResponse) GetHits() uint64 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetMisses() uint64 {
+	if x != nil {
+		return x.Misses
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetAdds() uint64 {
+	if x != nil {
+		return x.Adds
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetDeletes() uint64 {
+	if x != nil {
+		return x.Deletes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetEvictions() uint64 {
+	if x != nil {
+		return x.Evictions
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetEntries() uint64 {
+	if x != nil {
+		return x.Entries
+	}
+	return 0
+}
+
+type MGetRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Keys []string `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+}
+
+func (x *MGetRequest) Reset() {
+	*x = MGetRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MGetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MGetRequest) ProtoMessage() {}
+
+func (x *MGetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MGetRequest.ProtoReflect.Descriptor instead.
+func (*MGetRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MGetRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type MGetResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Values map[string][]byte `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *MGetResponse) Reset() {
+	*x = MGetResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MGetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MGetResponse) ProtoMessage() {}
+
+func (x *MGetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MGetResponse.ProtoReflect.Descriptor instead.
+func (*MGetResponse) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *MGetResponse) GetValues() map[string][]byte {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type MPutRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Entries map[string][]byte `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *MPutRequest) Reset() {
+	*x = MPutRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MPutRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MPutRequest) ProtoMessage() {}
+
+func (x *MPutRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MPutRequest.ProtoReflect.Descriptor instead.
+func (*MPutRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *MPutRequest) GetEntries() map[string][]byte {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type MPutResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *MPutResponse) Reset() {
+	*x = MPutResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MPutResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MPutResponse) ProtoMessage() {}
+
+func (x *MPutResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MPutResponse.ProtoReflect.Descriptor instead.
+func (*MPutResponse) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{11}
+}
+
+type MDeleteRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Keys []string `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+}
+
+func (x *MDeleteRequest) Reset() {
+	*x = MDeleteRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MDeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MDeleteRequest) ProtoMessage() {}
+
+func (x *MDeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MDeleteRequest.ProtoReflect.Descriptor instead.
+func (*MDeleteRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *MDeleteRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type MDeleteResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Deleted uint64 `protobuf:"varint,1,opt,name=deleted,proto3" json:"deleted,omitempty"`
+}
+
+func (x *MDeleteResponse) Reset() {
+	*x = MDeleteResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MDeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MDeleteResponse) ProtoMessage() {}
+
+func (x *MDeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MDeleteResponse.ProtoReflect.Descriptor instead.
+func (*MDeleteResponse) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MDeleteResponse) GetDeleted() uint64 {
+	if x != nil {
+		return x.Deleted
+	}
+	return 0
+}
+
+type BulkLoadRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Entries map[string][]byte `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *BulkLoadRequest) Reset() {
+	*x = BulkLoadRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[14]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BulkLoadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkLoadRequest) ProtoMessage() {}
+
+func (x *BulkLoadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkLoadRequest.ProtoReflect.Descriptor instead.
+func (*BulkLoadRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *BulkLoadRequest) GetEntries() map[string][]byte {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+type BulkLoadResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Loaded uint64 `protobuf:"varint,1,opt,name=loaded,proto3" json:"loaded,omitempty"`
+}
+
+func (x *BulkLoadResponse) Reset() {
+	*x = BulkLoadResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_gerdu_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BulkLoadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkLoadResponse) ProtoMessage() {}
+
+func (x *BulkLoadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gerdu_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkLoadResponse.ProtoReflect.Descriptor instead.
+func (*BulkLoadResponse) Descriptor() ([]byte, []int) {
+	return file_proto_gerdu_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *BulkLoadResponse) GetLoaded() uint64 {
+	if x != nil {
+		return x.Loaded
+	}
+	return 0
+}
+
 var File_proto_gerdu_proto protoreflect.FileDescriptor
 
 var file_proto_gerdu_proto_rawDesc = []byte{
@@ -327,27 +835,95 @@ var file_proto_gerdu_proto_rawDesc = []byte{
 	0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01,
 	0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x1e, 0x0a, 0x0a, 0x47, 0x65, 0x74,
 	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x23, 0x0a, 0x0b, 0x47, 0x65, 0x74,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x39, 0x0a, 0x0b, 0x47, 0x65, 0x74,
 	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x21,
-	0x0a, 0x0d, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
-	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
-	0x79, 0x22, 0x2a, 0x0a, 0x0e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x66, 0x6f, 0x75, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x66,
+	0x6f, 0x75, 0x6e, 0x64, 0x22, 0x21, 0x0a, 0x0d, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x22, 0x2a, 0x0a, 0x0e, 0x44, 0x65, 0x6c, 0x65, 0x74,
+	0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65, 0x6c,
+	0x65, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x64, 0x65, 0x6c, 0x65,
+	0x74, 0x65, 0x64, 0x22, 0x0e, 0x0a, 0x0c, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x22, 0xb5, 0x01, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x69, 0x74, 0x73, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x04, 0x68, 0x69, 0x74, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x6d, 0x69, 0x73,
+	0x73, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x6d, 0x69, 0x73, 0x73, 0x65,
+	0x73, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x64, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x04, 0x61, 0x64, 0x64, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x73,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x73, 0x12,
+	0x1c, 0x0a, 0x09, 0x65, 0x76, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x09, 0x65, 0x76, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x12, 0x0a,
+	0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x73, 0x69, 0x7a,
+	0x65, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x22, 0x21, 0x0a, 0x0b, 0x4d,
+	0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x65,
+	0x79, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x22, 0x82,
+	0x01, 0x0a, 0x0c, 0x4d, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x37, 0x0a, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x1f, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x2e, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x1a, 0x39, 0x0a, 0x0b, 0x56, 0x61, 0x6c, 0x75,
+	0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
+	0x02, 0x38, 0x01, 0x22, 0x84, 0x01, 0x0a, 0x0b, 0x4d, 0x50, 0x75, 0x74, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x39, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d, 0x50, 0x75,
+	0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x1a, 0x3a,
+	0x0a, 0x0c, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x0e, 0x0a, 0x0c, 0x4d, 0x50,
+	0x75, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x24, 0x0a, 0x0e, 0x4d, 0x44,
+	0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04,
+	0x6b, 0x65, 0x79, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x04, 0x6b, 0x65, 0x79, 0x73,
+	0x22, 0x2b, 0x0a, 0x0f, 0x4d, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f,
 	0x6e, 0x73, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x32, 0x9a, 0x01,
-	0x0a, 0x05, 0x47, 0x65, 0x72, 0x64, 0x75, 0x12, 0x2c, 0x0a, 0x03, 0x50, 0x75, 0x74, 0x12, 0x11,
-	0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x50, 0x75, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x1a, 0x12, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x50, 0x75, 0x74, 0x52, 0x65, 0x73,
-	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2c, 0x0a, 0x03, 0x47, 0x65, 0x74, 0x12, 0x11, 0x2e, 0x67,
-	0x65, 0x72, 0x64, 0x75, 0x2e, 0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
-	0x12, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f,
-	0x6e, 0x73, 0x65, 0x12, 0x35, 0x0a, 0x06, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x12, 0x14, 0x2e,
-	0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x44, 0x65, 0x6c, 0x65,
-	0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2b, 0x0a, 0x1a, 0x63, 0x6f,
-	0x6d, 0x2e, 0x61, 0x6d, 0x69, 0x72, 0x72, 0x61, 0x7a, 0x6d, 0x6a, 0x6f, 0x75, 0x2e, 0x67, 0x65,
-	0x72, 0x64, 0x75, 0x2e, 0x6a, 0x61, 0x76, 0x61, 0x50, 0x01, 0x5a, 0x0b, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x3b, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x22, 0x8c, 0x01,
+	0x0a, 0x0f, 0x42, 0x75, 0x6c, 0x6b, 0x4c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x3d, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x23, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x42, 0x75, 0x6c, 0x6b, 0x4c,
+	0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x45, 0x6e, 0x74, 0x72, 0x69,
+	0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73,
+	0x1a, 0x3a, 0x0a, 0x0c, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x2a, 0x0a, 0x10,
+	0x42, 0x75, 0x6c, 0x6b, 0x4c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x16, 0x0a, 0x06, 0x6c, 0x6f, 0x61, 0x64, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x06, 0x6c, 0x6f, 0x61, 0x64, 0x65, 0x64, 0x32, 0xa9, 0x03, 0x0a, 0x05, 0x47, 0x65, 0x72,
+	0x64, 0x75, 0x12, 0x2c, 0x0a, 0x03, 0x50, 0x75, 0x74, 0x12, 0x11, 0x2e, 0x67, 0x65, 0x72, 0x64,
+	0x75, 0x2e, 0x50, 0x75, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x67,
+	0x65, 0x72, 0x64, 0x75, 0x2e, 0x50, 0x75, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x2c, 0x0a, 0x03, 0x47, 0x65, 0x74, 0x12, 0x11, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e,
+	0x47, 0x65, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x67, 0x65, 0x72,
+	0x64, 0x75, 0x2e, 0x47, 0x65, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x35,
+	0x0a, 0x06, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x12, 0x14, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75,
+	0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15,
+	0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x32, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x13,
+	0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x53, 0x74, 0x61, 0x74,
+	0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2f, 0x0a, 0x04, 0x4d, 0x47, 0x65,
+	0x74, 0x12, 0x12, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d, 0x47, 0x65, 0x74, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d, 0x47,
+	0x65, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2f, 0x0a, 0x04, 0x4d, 0x50,
+	0x75, 0x74, 0x12, 0x12, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d, 0x50, 0x75, 0x74, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x13, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d,
+	0x50, 0x75, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x38, 0x0a, 0x07, 0x4d,
+	0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x12, 0x15, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d,
+	0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16, 0x2e,
+	0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x4d, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3d, 0x0a, 0x08, 0x42, 0x75, 0x6c, 0x6b, 0x4c, 0x6f, 0x61,
+	0x64, 0x12, 0x16, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x42, 0x75, 0x6c, 0x6b, 0x4c, 0x6f,
+	0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x67, 0x65, 0x72, 0x64,
+	0x75, 0x2e, 0x42, 0x75, 0x6c, 0x6b, 0x4c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x28, 0x01, 0x42, 0x2b, 0x0a, 0x1a, 0x63, 0x6f, 0x6d, 0x2e, 0x61, 0x6d, 0x69, 0x72,
+	0x72, 0x61, 0x7a, 0x6d, 0x6a, 0x6f, 0x75, 0x2e, 0x67, 0x65, 0x72, 0x64, 0x75, 0x2e, 0x6a, 0x61,
+	0x76, 0x61, 0x50, 0x01, 0x5a, 0x0b, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x3b, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -362,27 +938,53 @@ func file_proto_gerdu_proto_rawDescGZIP() []byte {
 	return file_proto_gerdu_proto_rawDescData
 }
 
-var file_proto_gerdu_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_gerdu_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
 var file_proto_gerdu_proto_goTypes = []interface{}{
-	(*PutResponse)(nil),    // 0: gerdu.PutResponse
-	(*PutRequest)(nil),     // 1: gerdu.PutRequest
-	(*GetRequest)(nil),     // 2: gerdu.GetRequest
-	(*GetResponse)(nil),    // 3: gerdu.GetResponse
-	(*DeleteRequest)(nil),  // 4: gerdu.DeleteRequest
-	(*DeleteResponse)(nil), // 5: gerdu.DeleteResponse
+	(*PutResponse)(nil),      // 0: gerdu.PutResponse
+	(*PutRequest)(nil),       // 1: gerdu.PutRequest
+	(*GetRequest)(nil),       // 2: gerdu.GetRequest
+	(*GetResponse)(nil),      // 3: gerdu.GetResponse
+	(*DeleteRequest)(nil),    // 4: gerdu.DeleteRequest
+	(*DeleteResponse)(nil),   // 5: gerdu.DeleteResponse
+	(*StatsRequest)(nil),     // 6: gerdu.StatsRequest
+	(*StatsResponse)(nil),    // 7: gerdu.StatsResponse
+	(*MGetRequest)(nil),      // 8: gerdu.MGetRequest
+	(*MGetResponse)(nil),     // 9: gerdu.MGetResponse
+	(*MPutRequest)(nil),      // 10: gerdu.MPutRequest
+	(*MPutResponse)(nil),     // 11: gerdu.MPutResponse
+	(*MDeleteRequest)(nil),   // 12: gerdu.MDeleteRequest
+	(*MDeleteResponse)(nil),  // 13: gerdu.MDeleteResponse
+	(*BulkLoadRequest)(nil),  // 14: gerdu.BulkLoadRequest
+	(*BulkLoadResponse)(nil), // 15: gerdu.BulkLoadResponse
+	nil,                      // 16: gerdu.MGetResponse.ValuesEntry
+	nil,                      // 17: gerdu.MPutRequest.EntriesEntry
+	nil,                      // 18: gerdu.BulkLoadRequest.EntriesEntry
 }
 var file_proto_gerdu_proto_depIdxs = []int32{
-	1, // 0: gerdu.Gerdu.Put:input_type -> gerdu.PutRequest
-	2, // 1: gerdu.Gerdu.Get:input_type -> gerdu.GetRequest
-	4, // 2: gerdu.Gerdu.Delete:input_type -> gerdu.DeleteRequest
-	0, // 3: gerdu.Gerdu.Put:output_type -> gerdu.PutResponse
-	3, // 4: gerdu.Gerdu.Get:output_type -> gerdu.GetResponse
-	5, // 5: gerdu.Gerdu.Delete:output_type -> gerdu.DeleteResponse
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	16, // 0: gerdu.MGetResponse.values:type_name -> gerdu.MGetResponse.ValuesEntry
+	17, // 1: gerdu.MPutRequest.entries:type_name -> gerdu.MPutRequest.EntriesEntry
+	18, // 2: gerdu.BulkLoadRequest.entries:type_name -> gerdu.BulkLoadRequest.EntriesEntry
+	1,  // 3: gerdu.Gerdu.Put:input_type -> gerdu.PutRequest
+	2,  // 4: gerdu.Gerdu.Get:input_type -> gerdu.GetRequest
+	4,  // 5: gerdu.Gerdu.Delete:input_type -> gerdu.DeleteRequest
+	6,  // 6: gerdu.Gerdu.Stats:input_type -> gerdu.StatsRequest
+	8,  // 7: gerdu.Gerdu.MGet:input_type -> gerdu.MGetRequest
+	10, // 8: gerdu.Gerdu.MPut:input_type -> gerdu.MPutRequest
+	12, // 9: gerdu.Gerdu.MDelete:input_type -> gerdu.MDeleteRequest
+	14, // 10: gerdu.Gerdu.BulkLoad:input_type -> gerdu.BulkLoadRequest
+	0,  // 11: gerdu.Gerdu.Put:output_type -> gerdu.PutResponse
+	3,  // 12: gerdu.Gerdu.Get:output_type -> gerdu.GetResponse
+	5,  // 13: gerdu.Gerdu.Delete:output_type -> gerdu.DeleteResponse
+	7,  // 14: gerdu.Gerdu.Stats:output_type -> gerdu.StatsResponse
+	9,  // 15: gerdu.Gerdu.MGet:output_type -> gerdu.MGetResponse
+	11, // 16: gerdu.Gerdu.MPut:output_type -> gerdu.MPutResponse
+	13, // 17: gerdu.Gerdu.MDelete:output_type -> gerdu.MDeleteResponse
+	15, // 18: gerdu.Gerdu.BulkLoad:output_type -> gerdu.BulkLoadResponse
+	11, // [11:19] is the sub-list for method output_type
+	3,  // [3:11] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_gerdu_proto_init() }
@@ -463,6 +1065,126 @@ func file_proto_gerdu_proto_init() {
 				return nil
 			}
 		}
+		file_proto_gerdu_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MGetRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MGetResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MPutRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MPutResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MDeleteRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MDeleteResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BulkLoadRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_gerdu_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BulkLoadResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -470,7 +1192,7 @@ func file_proto_gerdu_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_gerdu_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   19,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -20,6 +20,11 @@ type GerduClient interface {
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	MGet(ctx context.Context, in *MGetRequest, opts ...grpc.CallOption) (*MGetResponse, error)
+	MPut(ctx context.Context, in *MPutRequest, opts ...grpc.CallOption) (*MPutResponse, error)
+	MDelete(ctx context.Context, in *MDeleteRequest, opts ...grpc.CallOption) (*MDeleteResponse, error)
+	BulkLoad(ctx context.Context, opts ...grpc.CallOption) (Gerdu_BulkLoadClient, error)
 }
 
 type gerduClient struct {
@@ -57,6 +62,76 @@ func (c *gerduClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grp
 	return out, nil
 }
 
+func (c *gerduClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, "/gerdu.Gerdu/Stats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gerduClient) MGet(ctx context.Context, in *MGetRequest, opts ...grpc.CallOption) (*MGetResponse, error) {
+	out := new(MGetResponse)
+	err := c.cc.Invoke(ctx, "/gerdu.Gerdu/MGet", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gerduClient) MPut(ctx context.Context, in *MPutRequest, opts ...grpc.CallOption) (*MPutResponse, error) {
+	out := new(MPutResponse)
+	err := c.cc.Invoke(ctx, "/gerdu.Gerdu/MPut", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gerduClient) MDelete(ctx context.Context, in *MDeleteRequest, opts ...grpc.CallOption) (*MDeleteResponse, error) {
+	out := new(MDeleteResponse)
+	err := c.cc.Invoke(ctx, "/gerdu.Gerdu/MDelete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gerduClient) BulkLoad(ctx context.Context, opts ...grpc.CallOption) (Gerdu_BulkLoadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Gerdu_serviceDesc.Streams[0], "/gerdu.Gerdu/BulkLoad", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gerduBulkLoadClient{stream}
+	return x, nil
+}
+
+type Gerdu_BulkLoadClient interface {
+	Send(*BulkLoadRequest) error
+	CloseAndRecv() (*BulkLoadResponse, error)
+	grpc.ClientStream
+}
+
+type gerduBulkLoadClient struct {
+	grpc.ClientStream
+}
+
+func (x *gerduBulkLoadClient) Send(m *BulkLoadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *gerduBulkLoadClient) CloseAndRecv() (*BulkLoadResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BulkLoadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // GerduServer is the server API for Gerdu service.
 // All implementations must embed UnimplementedGerduServer
 // for forward compatibility
@@ -64,6 +139,11 @@ type GerduServer interface {
 	Put(context.Context, *PutRequest) (*PutResponse, error)
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	MGet(context.Context, *MGetRequest) (*MGetResponse, error)
+	MPut(context.Context, *MPutRequest) (*MPutResponse, error)
+	MDelete(context.Context, *MDeleteRequest) (*MDeleteResponse, error)
+	BulkLoad(Gerdu_BulkLoadServer) error
 	mustEmbedUnimplementedGerduServer()
 }
 
@@ -80,6 +160,21 @@ func (*UnimplementedGerduServer) Get(context.Context, *GetRequest) (*GetResponse
 func (*UnimplementedGerduServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (*UnimplementedGerduServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (*UnimplementedGerduServer) MGet(context.Context, *MGetRequest) (*MGetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MGet not implemented")
+}
+func (*UnimplementedGerduServer) MPut(context.Context, *MPutRequest) (*MPutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MPut not implemented")
+}
+func (*UnimplementedGerduServer) MDelete(context.Context, *MDeleteRequest) (*MDeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MDelete not implemented")
+}
+func (*UnimplementedGerduServer) BulkLoad(Gerdu_BulkLoadServer) error {
+	return status.Errorf(codes.Unimplemented, "method BulkLoad not implemented")
+}
 func (*UnimplementedGerduServer) mustEmbedUnimplementedGerduServer() {}
 
 func RegisterGerduServer(s *grpc.Server, srv GerduServer) {
@@ -140,6 +235,104 @@ func _Gerdu_Delete_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Gerdu_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GerduServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gerdu.Gerdu/Stats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GerduServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gerdu_MGet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GerduServer).MGet(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gerdu.Gerdu/MGet",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GerduServer).MGet(ctx, req.(*MGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gerdu_MPut_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MPutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GerduServer).MPut(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gerdu.Gerdu/MPut",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GerduServer).MPut(ctx, req.(*MPutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gerdu_MDelete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MDeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GerduServer).MDelete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gerdu.Gerdu/MDelete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GerduServer).MDelete(ctx, req.(*MDeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gerdu_BulkLoad_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GerduServer).BulkLoad(&gerduBulkLoadServer{stream})
+}
+
+type Gerdu_BulkLoadServer interface {
+	SendAndClose(*BulkLoadResponse) error
+	Recv() (*BulkLoadRequest, error)
+	grpc.ServerStream
+}
+
+type gerduBulkLoadServer struct {
+	grpc.ServerStream
+}
+
+func (x *gerduBulkLoadServer) SendAndClose(m *BulkLoadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *gerduBulkLoadServer) Recv() (*BulkLoadRequest, error) {
+	m := new(BulkLoadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Gerdu_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "gerdu.Gerdu",
 	HandlerType: (*GerduServer)(nil),
@@ -156,7 +349,29 @@ var _Gerdu_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _Gerdu_Delete_Handler,
 		},
+		{
+			MethodName: "Stats",
+			Handler:    _Gerdu_Stats_Handler,
+		},
+		{
+			MethodName: "MGet",
+			Handler:    _Gerdu_MGet_Handler,
+		},
+		{
+			MethodName: "MPut",
+			Handler:    _Gerdu_MPut_Handler,
+		},
+		{
+			MethodName: "MDelete",
+			Handler:    _Gerdu_MDelete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BulkLoad",
+			Handler:       _Gerdu_BulkLoad_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/gerdu.proto",
 }
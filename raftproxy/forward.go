@@ -0,0 +1,386 @@
+package raftproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/hashicorp/raft"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoLeader is returned when a follower cannot find the leader or the
+	// URL of its HTTP API to forward to
+	ErrNoLeader = errors.New("raftproxy: the leader is not known")
+	// ErrNotLeader is returned when a forwarded command reaches a node
+	// that is not the leader
+	ErrNotLeader = errors.New("raftproxy: the node is not the leader")
+	// ErrBadCommand is returned when a forwarded command is malformed or
+	// has an unknown op
+	ErrBadCommand = errors.New("raftproxy: malformed command")
+	// ErrMemberExists is returned when a node joins with the ID of a member
+	// at another address or API, the member must leave first
+	ErrMemberExists = errors.New("raftproxy: the node is a member at another address")
+)
+
+// forwardClient sends the forwarded requests to the leader
+var forwardClient = &http.Client{Timeout: raftTimeout + tcpTimeout}
+
+// response kinds of the command ops
+const (
+	noResponse = iota
+	boolResponse
+	intResponse
+	valueResponse
+	entriesResponse
+	errorResponse
+	counterResponse
+)
+
+// responseKinds maps the ops to the kinds of their responses, a forwarded
+// command with an op that is not in it is refused. The member and forget
+// commands are not in it, the membership only changes by a join or a leave
+var responseKinds = map[string]int{
+	"get":            valueResponse,
+	"gets":           valueResponse,
+	"put":            boolResponse,
+	"putttl":         boolResponse,
+	"putifabsent":    boolResponse,
+	"putifabsentttl": boolResponse,
+	"replace":        boolResponse,
+	"replacettl":     boolResponse,
+	"delete":         boolResponse,
+	"append":         boolResponse,
+	"prepend":        boolResponse,
+	"touch":          boolResponse,
+	"expire":         boolResponse,
+	"persist":        boolResponse,
+	"mget":           entriesResponse,
+	"mput":           noResponse,
+	"mdelete":        intResponse,
+	"deletepattern":  intResponse,
+	"cas":            errorResponse,
+	"resize":         errorResponse,
+	"incr":           counterResponse,
+}
+
+// forwardErrors are the errors of the responses that are restored as
+// themselves, so the callers can compare them
+var forwardErrors = []error{
+	cache.ErrNotFound,
+	cache.ErrVersionMismatch,
+	cache.ErrNotSupported,
+	cache.ErrNotInteger,
+	cache.ErrOverflow,
+}
+
+// response is the JSON of the response of a forwarded command
+type response struct {
+	Ok      bool              `json:"ok,omitempty"`
+	Value   string            `json:"value,omitempty"`
+	Version uint64            `json:"version,omitempty"`
+	Count   int64             `json:"count,omitempty"`
+	Entries map[string]string `json:"entries,omitempty"`
+	Err     string            `json:"err,omitempty"`
+}
+
+// encodeResponse converts the response of an applied command to JSON
+func encodeResponse(r interface{}) response {
+	switch r := r.(type) {
+	case bool:
+		return response{Ok: r}
+	case int:
+		return response{Count: int64(r)}
+	case getResponse:
+		return response{Ok: r.ok, Value: r.value, Version: r.version}
+	case incrResponse:
+		return response{Count: r.value, Err: errString(r.err)}
+	case map[string]string:
+		return response{Entries: r}
+	case error:
+		return response{Err: r.Error()}
+	}
+	return response{}
+}
+
+// decode converts the JSON back to the response the fsm returns for the op
+func (r response) decode(op string) interface{} {
+	switch responseKinds[op] {
+	case boolResponse:
+		return r.Ok
+	case intResponse:
+		return int(r.Count)
+	case valueResponse:
+		return getResponse{value: r.Value, version: r.Version, ok: r.Ok}
+	case entriesResponse:
+		if r.Entries == nil {
+			return map[string]string{}
+		}
+		return r.Entries
+	case errorResponse:
+		return stringError(r.Err)
+	case counterResponse:
+		return incrResponse{value: r.Count, err: stringError(r.Err)}
+	}
+	return nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// stringError returns the error of the message, one of forwardErrors if it
+// has the same message
+func stringError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, err := range forwardErrors {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
+
+// doneFuture is the future of a command that is applied outside of the
+// log of this node, by the leader for a follower or locally by a non-voter
+type doneFuture struct {
+	response interface{}
+}
+
+func (f *doneFuture) Error() error          { return nil }
+func (f *doneFuture) Index() uint64         { return 0 }
+func (f *doneFuture) Response() interface{} { return f.response }
+
+// Member is a server of the raft cluster
+type Member struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	API      string `json:"api,omitempty"`
+	Suffrage string `json:"suffrage"`
+	Leader   bool   `json:"leader"`
+}
+
+// Members returns the servers of the cluster as this node knows them
+func (c *RaftProxy) Members() ([]Member, error) {
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	leader := c.raft.Leader()
+	servers := future.Configuration().Servers
+	members := make([]Member, 0, len(servers))
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, srv := range servers {
+		members = append(members, Member{
+			ID:       string(srv.ID),
+			Address:  string(srv.Address),
+			API:      c.members[string(srv.ID)],
+			Suffrage: srv.Suffrage.String(),
+			Leader:   srv.Address == leader,
+		})
+	}
+	return members, nil
+}
+
+// ApplyForwarded applies the JSON command a follower forwarded and returns
+// the JSON of its response
+func (c *RaftProxy) ApplyForwarded(data []byte) ([]byte, error) {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, ErrBadCommand
+	}
+	if _, ok := responseKinds[cmd.Op]; !ok {
+		return nil, ErrBadCommand
+	}
+	if c.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	future, err := c.applyCommand(&cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(encodeResponse(future.Response()))
+}
+
+// forward sends the command to the leader and returns its response
+func (c *RaftProxy) forward(cmd *command) (raft.ApplyFuture, error) {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.postLeader("/raft/apply", b)
+	if err != nil {
+		return nil, err
+	}
+	var r response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, err
+	}
+	return &doneFuture{response: r.decode(cmd.Op)}, nil
+}
+
+// forwardJoin sends the join request to the leader
+func (c *RaftProxy) forwardJoin(nodeID, addr, api string, nonvoter bool) error {
+	b, err := json.Marshal(joinRequest(nodeID, addr, api, nonvoter))
+	if err != nil {
+		return err
+	}
+	_, err = c.postLeader("/join", b)
+	return err
+}
+
+// joinRequest returns the body of the join request of the node
+func joinRequest(nodeID, addr, api string, nonvoter bool) map[string]string {
+	m := map[string]string{"addr": addr, "id": nodeID, "api": api}
+	if nonvoter {
+		m["suffrage"] = "nonvoter"
+	}
+	return m
+}
+
+// forwardLeave sends the leave request to the leader
+func (c *RaftProxy) forwardLeave(nodeID string) error {
+	_, err := c.postLeader("/leave", []byte(nodeID))
+	return err
+}
+
+// postLeader posts the body to the path of the HTTP API of the leader and
+// returns the body of its response
+func (c *RaftProxy) postLeader(path string, b []byte) ([]byte, error) {
+	api, err := c.leaderAPI()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.post(api+path, b)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("leader %s: %s %s", api, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// post posts the JSON body to the url with the client and the token of the
+// node
+func (c *RaftProxy) post(url string, b []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.Client
+	if client == nil {
+		client = forwardClient
+	}
+	return client.Do(req)
+}
+
+// leaderAPI returns the URL of the HTTP API of the leader
+func (c *RaftProxy) leaderAPI() (string, error) {
+	leader := c.raft.Leader()
+	if leader == "" {
+		return "", ErrNoLeader
+	}
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return "", err
+	}
+	for _, srv := range future.Configuration().Servers {
+		if srv.Address != leader {
+			continue
+		}
+		c.mu.RLock()
+		api := c.members[string(srv.ID)]
+		c.mu.RUnlock()
+		if api == "" {
+			return "", ErrNoLeader
+		}
+		return api, nil
+	}
+	return "", ErrNoLeader
+}
+
+// IsLeader reports whether the node is the leader of the cluster
+func (c *RaftProxy) IsLeader() bool {
+	return c.raft.State() == raft.Leader
+}
+
+// WaitLeader waits up to timeout for the node to become the leader of the
+// cluster, it returns ErrNotLeader if it does not
+func (c *RaftProxy) WaitLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !c.IsLeader() {
+		if time.Now().After(deadline) {
+			return ErrNotLeader
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// Role returns the raft state of the node, leader, follower, candidate or
+// shutdown
+func (c *RaftProxy) Role() string {
+	return strings.ToLower(c.raft.State().String())
+}
+
+// remember records the URL of the HTTP API of the node in the cluster, if
+// it is not already recorded, a node is never rebound to another URL
+func (c *RaftProxy) remember(nodeID, api string) error {
+	c.mu.RLock()
+	known, ok := c.members[nodeID]
+	c.mu.RUnlock()
+	if api == "" || known == api {
+		return nil
+	}
+	if ok {
+		return ErrMemberExists
+	}
+	return c.membership(&command{Op: "member", Key: nodeID, Value: api})
+}
+
+// membership applies a member or forget command on the leader
+func (c *RaftProxy) membership(cmd *command) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	return c.raft.Apply(b, raftTimeout).Error()
+}
+
+// announce records the URL of the HTTP API of the node every time it
+// becomes the leader, so the followers can forward to it
+func (c *RaftProxy) announce() {
+	for leader := range c.raft.LeaderCh() {
+		if !leader {
+			continue
+		}
+		if err := c.remember(c.localId, c.APIURL); err != nil {
+			log.Errorf("failed to announce the API of the leader %s: %v", c.localId, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package raftproxy
+
+import (
+	"encoding/json"
+	"github.com/arazmj/gerdu/cache"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResponse_RoundTrip(t *testing.T) {
+	tests := []struct {
+		op       string
+		response interface{}
+	}{
+		{"put", true},
+		{"delete", false},
+		{"get", getResponse{value: "a", ok: true}},
+		{"gets", getResponse{value: "a", version: 7, ok: true}},
+		{"get", getResponse{}},
+		{"mget", map[string]string{"1": "a"}},
+		{"mget", map[string]string{}},
+		{"mput", nil},
+		{"mdelete", 2},
+		{"cas", nil},
+		{"cas", cache.ErrVersionMismatch},
+		{"incr", incrResponse{value: 3}},
+		{"incr", incrResponse{err: cache.ErrNotInteger}},
+	}
+	for _, test := range tests {
+		b, err := json.Marshal(encodeResponse(test.response))
+		if err != nil {
+			t.Fatalf("Failed to encode %v %v", test.response, err)
+		}
+		var r response
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Fatalf("Failed to decode %s %v", b, err)
+		}
+		if decoded := r.decode(test.op); !reflect.DeepEqual(decoded, test.response) {
+			t.Errorf("Expected %s to decode to %#v but got %#v", test.op, test.response, decoded)
+		}
+	}
+}
+
+func TestApplyForwarded_BadCommand(t *testing.T) {
+	c := NewRaftProxy(nil, "", "", "")
+	for _, data := range []string{"{", `{"op":"drop"}`, `{"op":"member","key":"master","value":"http://evil"}`,
+		`{"op":"forget","key":"master"}`} {
+		if _, err := c.ApplyForwarded([]byte(data)); err != ErrBadCommand {
+			t.Errorf("Expected ErrBadCommand for %s but got %v", data, err)
+		}
+	}
+}
+
+func TestRemember_Rebind(t *testing.T) {
+	c := NewRaftProxy(nil, "", "", "")
+	c.members = map[string]string{"node1": "http://127.0.0.1:8081"}
+	if err := c.remember("node1", "http://127.0.0.1:8081"); err != nil {
+		t.Errorf("Expected the known API to be accepted but got %v", err)
+	}
+	if err := c.remember("node1", "http://127.0.0.1:9999"); err != ErrMemberExists {
+		t.Errorf("Expected %v but got %v", ErrMemberExists, err)
+	}
+}
+
+func TestJoinRequest(t *testing.T) {
+	m := joinRequest("node1", "127.0.0.1:12001", "http://127.0.0.1:8081", true)
+	if m["suffrage"] != "nonvoter" || m["id"] != "node1" || m["addr"] != "127.0.0.1:12001" {
+		t.Errorf("Expected a non-voter join request but got %v", m)
+	}
+	if m := joinRequest("node1", "127.0.0.1:12001", "", false); len(m) != 3 {
+		t.Errorf("Expected a voter join request but got %v", m)
+	}
+}
+
+func TestPost_Token(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Header.Get("Authorization") + " " + string(body)))
+	}))
+	defer server.Close()
+	c := NewRaftProxy(nil, "", "", "")
+	c.Token = "secret"
+	c.Client = server.Client()
+	resp, err := c.post(server.URL+"/join", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "Bearer secret {}" {
+		t.Errorf("Expected the token of the node to be sent but got %s", body)
+	}
+}
+
+func TestOpenRaft_NonvoterBootstrap(t *testing.T) {
+	c := NewRaftProxy(nil, "127.0.0.1:0", "", "replica")
+	c.Nonvoter = true
+	if err := c.OpenRaft(t.TempDir()); err == nil {
+		t.Errorf("Expected a non-voter not to bootstrap a cluster")
+	}
+}
@@ -1,18 +1,22 @@
 package raftproxy
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/arazmj/gerdu/cache"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/inhies/go-bytesize"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -34,13 +38,48 @@ type RaftProxy struct {
 	raftAddr string
 	joinAddr string
 	localId  string
+	// APIURL is the base URL of the HTTP API of the node, the followers
+	// forward the commands and the membership changes to the one of the
+	// leader
+	APIURL string
+	// WaitJoin makes a node without a join address wait for the leader to
+	// add it, as the discovery does, instead of bootstrapping a cluster of
+	// its own
+	WaitJoin bool
+	// Nonvoter makes the node join as a non-voter that gets the replicated
+	// state without taking part in the elections and the write quorum. It
+	// serves the reads from its local copy, which may lag the leader
+	Nonvoter bool
+	// Compression compresses the raft snapshots of the node, the snapshots
+	// are restored by the compression of their header whatever it is
+	Compression cache.Compression
+	// Registry hosts the caches of the namespaces next to the replicated
+	// cache, they are local to the node and not replicated
+	Registry cache.NamespaceCache
+	// Token is the bearer token the node sends with the join request and
+	// the requests it forwards to the leader, empty means none
+	Token string
+	// TransportTLS secures the raft transport between the nodes, nil means
+	// plain TCP
+	TransportTLS *TransportTLS
+	// Client sends the join request and the forwarded requests, nil is a
+	// client with the timeout of the forwards. A client certificate for
+	// mutual TLS goes in its transport
+	Client *http.Client
+	// members maps the IDs of the nodes to the URLs of their HTTP API
+	members map[string]string
+	mu      sync.RWMutex
 	RaftCache
 }
 
 type command struct {
-	Op    string `json:"op,omitempty"`
-	Key   string `json:"key,omitempty"`
-	Value string `json:"value,omitempty"`
+	Op      string            `json:"op,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Value   string            `json:"value,omitempty"`
+	Keys    []string          `json:"keys,omitempty"`
+	Entries map[string]string `json:"entries,omitempty"`
+	Delta   int64             `json:"delta,omitempty"`
+	Version uint64            `json:"version,omitempty"`
 }
 
 func NewRaftProxy(imp cache.UnImplementedCache, raftAddr, joinAddr, localId string) *RaftProxy {
@@ -76,6 +115,56 @@ func (c *RaftProxy) Put(key string, value string) (created bool) {
 	return future.Response().(bool)
 }
 
+// PutWithTTL updates or inserts the entry like Put and expires it ttl from
+// now, the time is counted on each node from when it applies the command
+func (c *RaftProxy) PutWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	return c.putIf("putttl", key, value, ttl)
+}
+
+// PutIfAbsent inserts the entry only if the key is not in the cache
+func (c *RaftProxy) PutIfAbsent(key string, value string) (created bool) {
+	return c.putIf("putifabsent", key, value, 0)
+}
+
+// PutIfAbsentWithTTL inserts the entry like PutIfAbsent and expires it ttl
+// from now
+func (c *RaftProxy) PutIfAbsentWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	return c.putIf("putifabsentttl", key, value, ttl)
+}
+
+// Replace updates the value of the key only if it is in the cache
+func (c *RaftProxy) Replace(key string, value string) (ok bool) {
+	return c.putIf("replace", key, value, 0)
+}
+
+// ReplaceWithTTL updates the key like Replace and expires it ttl from now
+func (c *RaftProxy) ReplaceWithTTL(key string, value string, ttl time.Duration) (ok bool) {
+	return c.putIf("replacettl", key, value, ttl)
+}
+
+func (c *RaftProxy) putIf(op string, key string, value string, ttl time.Duration) (ok bool) {
+	cmd := &command{
+		Op:    op,
+		Key:   key,
+		Value: value,
+		Delta: int64(ttl),
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return false
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return false
+	}
+
+	return future.Response().(bool)
+}
+
 func (c *RaftProxy) Delete(key string) (ok bool) {
 	cmd := &command{
 		Op:  "delete",
@@ -119,22 +208,472 @@ func (c *RaftProxy) Get(key string) (value string, ok bool) {
 	return response.value, response.ok
 }
 
-func (c *RaftProxy) applyCommand(cmd *command) (raft.ApplyFuture, error) {
-	if c.raft.State() != raft.Leader {
-		return nil, errors.New(fmt.Sprintf("not a leader but a %v %p", c.raft.State(), c.raft))
+// GetMulti returns the values of the keys that are found in the cache,
+// all the keys are looked up by a single command
+func (c *RaftProxy) GetMulti(keys []string) map[string]string {
+	cmd := &command{
+		Op:   "mget",
+		Keys: keys,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return map[string]string{}
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return map[string]string{}
+	}
+
+	return future.Response().(map[string]string)
+}
+
+// PutMulti updates or inserts all the entries by a single command
+func (c *RaftProxy) PutMulti(entries map[string]string) {
+	cmd := &command{
+		Op:      "mput",
+		Entries: entries,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+	}
+}
+
+// DeleteMulti deletes the keys by a single command and returns the
+// number of the deleted keys
+func (c *RaftProxy) DeleteMulti(keys []string) (count int) {
+	cmd := &command{
+		Op:   "mdelete",
+		Keys: keys,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return 0
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return 0
+	}
+
+	return future.Response().(int)
+}
+
+// Incr adds delta to the counter of the key, the update is replicated
+// as a single command so it is applied atomically on every node
+func (c *RaftProxy) Incr(key string, delta int64) (value int64, err error) {
+	cmd := &command{
+		Op:    "incr",
+		Key:   key,
+		Delta: delta,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return 0, err
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return 0, future.Error()
+	}
+
+	response := future.Response().(incrResponse)
+	return response.value, response.err
+}
+
+// Decr subtracts delta from the counter of the key like Incr
+func (c *RaftProxy) Decr(key string, delta int64) (value int64, err error) {
+	return c.Incr(key, -delta)
+}
+
+// Gets returns the value for the key and its version token, the version is
+// zero if the cache does not support the versions
+func (c *RaftProxy) Gets(key string) (value string, version uint64, ok bool) {
+	cmd := &command{
+		Op:  "gets",
+		Key: key,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return "", 0, false
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return "", 0, false
+	}
+
+	response := future.Response().(getResponse)
+	return response.value, response.version, response.ok
+}
+
+// Cas replaces the value of the key only if its version token is still
+// version, the check and the update are replicated as a single command
+func (c *RaftProxy) Cas(key string, value string, version uint64) error {
+	cmd := &command{
+		Op:      "cas",
+		Key:     key,
+		Value:   value,
+		Version: version,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return err
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return future.Error()
+	}
+
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Append adds value to the end of the value of the key, it returns false
+// if the key is not in the cache or the cache does not support it
+func (c *RaftProxy) Append(key string, value string) (ok bool) {
+	return c.concat("append", key, value)
+}
+
+// Prepend adds value to the start of the value of the key like Append
+func (c *RaftProxy) Prepend(key string, value string) (ok bool) {
+	return c.concat("prepend", key, value)
+}
+
+func (c *RaftProxy) concat(op string, key string, value string) (ok bool) {
+	cmd := &command{
+		Op:    op,
+		Key:   key,
+		Value: value,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return false
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return false
+	}
+
+	return future.Response().(bool)
+}
+
+// DeleteByPattern deletes the keys that match the glob pattern by a single
+// command and returns the number of the deleted keys
+func (c *RaftProxy) DeleteByPattern(pattern string) (count int, err error) {
+	if err := cache.ValidPattern(pattern); err != nil {
+		return 0, err
+	}
+	cmd := &command{
+		Op:  "deletepattern",
+		Key: pattern,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return 0, err
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return 0, future.Error()
+	}
+
+	return future.Response().(int), nil
+}
+
+// Resize changes the capacity of the caches of all the nodes by a single
+// command, so they keep evicting the same entries
+func (c *RaftProxy) Resize(capacity bytesize.ByteSize) error {
+	if _, ok := c.Imp.(cache.ResizeCache); !ok {
+		return cache.ErrNotSupported
+	}
+	cmd := &command{
+		Op: "resize",
+		// the capacity is a decimal string, cache.Unlimited does not fit in Delta
+		Value: strconv.FormatUint(uint64(capacity), 10),
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return err
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return future.Error()
+	}
+
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of entries of the local cache, the count is
+// read without going through the raft log
+func (c *RaftProxy) Len() int {
+	if sizer, ok := c.Imp.(cache.Sizer); ok {
+		return sizer.Len()
+	}
+	return 0
+}
+
+// Stats returns the stats of the local cache, all zero if it does not keep
+// its own stats
+func (c *RaftProxy) Stats() cache.Stats {
+	if statser, ok := c.Imp.(cache.StatsCache); ok {
+		return statser.Stats()
+	}
+	return cache.Stats{}
+}
+
+// OrderedKeys returns the keys of the local cache in the eviction order,
+// nil if the cache does not support it
+func (c *RaftProxy) OrderedKeys() []string {
+	if ordered, ok := c.Imp.(cache.OrderedCache); ok {
+		return ordered.OrderedKeys()
+	}
+	return nil
+}
+
+// Peek returns the value for the key from the local cache without
+// counting it as a use, the read does not go through the raft log
+func (c *RaftProxy) Peek(key string) (value string, ok bool) {
+	if peeker, ok := c.Imp.(cache.PeekCache); ok {
+		return peeker.Peek(key)
+	}
+	return "", false
+}
+
+// Touch promotes the key and restarts its time to live, the touch is
+// replicated so the eviction order is the same on every node
+func (c *RaftProxy) Touch(key string) (ok bool) {
+	cmd := &command{
+		Op:  "touch",
+		Key: key,
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return false
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return false
 	}
 
+	return future.Response().(bool)
+}
+
+// TTL returns the time left until the key expires in the local cache, the
+// read does not go through the raft log
+func (c *RaftProxy) TTL(key string) (ttl time.Duration, ok bool) {
+	if expirer, ok := c.Imp.(cache.ExpireCache); ok {
+		return expirer.TTL(key)
+	}
+	return 0, false
+}
+
+// Expire sets the key to expire ttl from now, a ttl that is not positive
+// expires it at once
+func (c *RaftProxy) Expire(key string, ttl time.Duration) (ok bool) {
+	return c.expire("expire", key, ttl)
+}
+
+// Persist removes the expiration of the key
+func (c *RaftProxy) Persist(key string) (ok bool) {
+	return c.expire("persist", key, 0)
+}
+
+func (c *RaftProxy) expire(op string, key string, ttl time.Duration) (ok bool) {
+	cmd := &command{
+		Op:    op,
+		Key:   key,
+		Delta: int64(ttl),
+	}
+
+	future, err := c.applyCommand(cmd)
+
+	if err != nil {
+		log.Errorf("Error applyCommand %v", err)
+		return false
+	}
+
+	if future.Error() != nil {
+		log.Errorf("Error in raft apply future %v", future.Error())
+		return false
+	}
+
+	return future.Response().(bool)
+}
+
+// Keys returns the keys of the local cache, nil if the cache does not
+// support it
+func (c *RaftProxy) Keys() []string {
+	if scanner, ok := c.Imp.(cache.ScanCache); ok {
+		return scanner.Keys()
+	}
+	return nil
+}
+
+// Scan returns a batch of the keys of the local cache and the next cursor,
+// no keys and the cursor zero if the cache does not support it
+func (c *RaftProxy) Scan(cursor uint64, count int) (keys []string, next uint64) {
+	if scanner, ok := c.Imp.(cache.ScanCache); ok {
+		return scanner.Scan(cursor, count)
+	}
+	return nil, 0
+}
+
+// Export writes the entries of the local cache in the portable format of
+// cache.Dump, it returns cache.ErrNotSupported if the cache cannot export
+func (c *RaftProxy) Export(w io.Writer) error {
+	if exporter, ok := c.Imp.(cache.ExportCache); ok {
+		return exporter.Export(w)
+	}
+	return cache.ErrNotSupported
+}
+
+// Subscribe returns a channel that receives the events of the local cache,
+// every node applies the same commands so it sees the same puts and deletes.
+// It returns nil if the cache does not support it
+func (c *RaftProxy) Subscribe() <-chan cache.Event {
+	if events, ok := c.Imp.(cache.EventCache); ok {
+		return events.Subscribe()
+	}
+	return nil
+}
+
+// Unsubscribe stops sending the events of the local cache to the channel
+func (c *RaftProxy) Unsubscribe(ch <-chan cache.Event) {
+	if events, ok := c.Imp.(cache.EventCache); ok {
+		events.Unsubscribe(ch)
+	}
+}
+
+// Namespace returns the cache of the namespace from the registry
+func (c *RaftProxy) Namespace(name string) (cache.UnImplementedCache, bool) {
+	if c.Registry == nil {
+		return nil, false
+	}
+	return c.Registry.Namespace(name)
+}
+
+// NamespaceConfig returns the config of the namespace from the registry
+func (c *RaftProxy) NamespaceConfig(name string) (cache.NamespaceConfig, bool) {
+	if c.Registry == nil {
+		return cache.NamespaceConfig{}, false
+	}
+	return c.Registry.NamespaceConfig(name)
+}
+
+// CreateNamespace creates the namespace in the registry, it returns
+// cache.ErrNotSupported without a registry
+func (c *RaftProxy) CreateNamespace(name string, config cache.NamespaceConfig) (created bool, err error) {
+	if c.Registry == nil {
+		return false, cache.ErrNotSupported
+	}
+	return c.Registry.CreateNamespace(name, config)
+}
+
+// DropNamespace drops the namespace from the registry
+func (c *RaftProxy) DropNamespace(name string) (ok bool, err error) {
+	if c.Registry == nil {
+		return false, nil
+	}
+	return c.Registry.DropNamespace(name)
+}
+
+// Namespaces returns the names of the namespaces of the registry
+func (c *RaftProxy) Namespaces() []string {
+	if c.Registry == nil {
+		return nil
+	}
+	return c.Registry.Namespaces()
+}
+
+// Close closes the local cache and the namespaces of the registry
+func (c *RaftProxy) Close() error {
+	err := cache.Close(c.Imp)
+	if closer, ok := c.Registry.(cache.Closer); ok {
+		if e := closer.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *RaftProxy) applyCommand(cmd *command) (raft.ApplyFuture, error) {
 	b, err := json.Marshal(cmd)
 	if err != nil {
 		return nil, err
 	}
 
+	if c.Nonvoter && readOps[cmd.Op] {
+		return &doneFuture{response: (*fsm)(c).Apply(&raft.Log{Data: b})}, nil
+	}
+
+	if c.raft.State() != raft.Leader {
+		return c.forward(cmd)
+	}
+
 	return c.raft.Apply(b, raftTimeout), nil
 }
 
+// readOps are the ops a non-voter applies to its local copy
+var readOps = map[string]bool{
+	"get":  true,
+	"gets": true,
+	"mget": true,
+}
+
 type getResponse struct {
-	value string
-	ok    bool
+	value   string
+	version uint64
+	ok      bool
+}
+
+type incrResponse struct {
+	value int64
+	err   error
 }
 
 type fsm RaftProxy
@@ -156,8 +695,120 @@ func (f *fsm) Apply(l *raft.Log) interface{} {
 		return response
 	case "put":
 		return f.Imp.Put(cmd.Key, cmd.Value)
+	case "putttl":
+		ttlCache, ok := f.Imp.(cache.TTLCache)
+		if !ok {
+			return false
+		}
+		return ttlCache.PutWithTTL(cmd.Key, cmd.Value, time.Duration(cmd.Delta))
+	case "putifabsent", "putifabsentttl":
+		absent, ok := f.Imp.(cache.AbsentCache)
+		if !ok {
+			return false
+		}
+		if cmd.Op == "putifabsent" {
+			return absent.PutIfAbsent(cmd.Key, cmd.Value)
+		}
+		return absent.PutIfAbsentWithTTL(cmd.Key, cmd.Value, time.Duration(cmd.Delta))
+	case "replace", "replacettl":
+		replacer, ok := f.Imp.(cache.ReplaceCache)
+		if !ok {
+			return false
+		}
+		if cmd.Op == "replace" {
+			return replacer.Replace(cmd.Key, cmd.Value)
+		}
+		return replacer.ReplaceWithTTL(cmd.Key, cmd.Value, time.Duration(cmd.Delta))
 	case "delete":
 		return f.Imp.Delete(cmd.Key)
+	case "mget":
+		return cache.GetMulti(f.Imp, cmd.Keys)
+	case "mput":
+		cache.PutMulti(f.Imp, cmd.Entries)
+		return nil
+	case "mdelete":
+		return cache.DeleteMulti(f.Imp, cmd.Keys)
+	case "gets":
+		versions, ok := f.Imp.(cache.VersionCache)
+		if !ok {
+			value, ok := f.Imp.Get(cmd.Key)
+			return getResponse{
+				value: value,
+				ok:    ok,
+			}
+		}
+		value, version, ok := versions.Gets(cmd.Key)
+		return getResponse{
+			value:   value,
+			version: version,
+			ok:      ok,
+		}
+	case "cas":
+		versions, ok := f.Imp.(cache.VersionCache)
+		if !ok {
+			return cache.ErrNotSupported
+		}
+		return versions.Cas(cmd.Key, cmd.Value, cmd.Version)
+	case "append", "prepend":
+		appender, ok := f.Imp.(cache.AppendCache)
+		if !ok {
+			return false
+		}
+		if cmd.Op == "append" {
+			return appender.Append(cmd.Key, cmd.Value)
+		}
+		return appender.Prepend(cmd.Key, cmd.Value)
+	case "resize":
+		resizer, ok := f.Imp.(cache.ResizeCache)
+		if !ok {
+			return cache.ErrNotSupported
+		}
+		capacity, err := strconv.ParseUint(cmd.Value, 10, 64)
+		if err != nil {
+			return err
+		}
+		return resizer.Resize(bytesize.ByteSize(capacity))
+	case "deletepattern":
+		deleter, ok := f.Imp.(cache.PatternCache)
+		if !ok {
+			return 0
+		}
+		count, _ := deleter.DeleteByPattern(cmd.Key)
+		return count
+	case "touch":
+		peeker, ok := f.Imp.(cache.PeekCache)
+		if !ok {
+			return false
+		}
+		return peeker.Touch(cmd.Key)
+	case "expire", "persist":
+		expirer, ok := f.Imp.(cache.ExpireCache)
+		if !ok {
+			return false
+		}
+		if cmd.Op == "expire" {
+			return expirer.Expire(cmd.Key, time.Duration(cmd.Delta))
+		}
+		return expirer.Persist(cmd.Key)
+	case "incr":
+		value, err := cache.Incr(f.Imp, cmd.Key, cmd.Delta)
+		return incrResponse{
+			value: value,
+			err:   err,
+		}
+	case "member":
+		f.mu.Lock()
+		if f.members == nil {
+			f.members = map[string]string{}
+		}
+		f.members[cmd.Key] = cmd.Value
+		f.mu.Unlock()
+		return true
+	case "forget":
+		f.mu.Lock()
+		delete(f.members, cmd.Key)
+		f.mu.Unlock()
+		return true
 	default:
 		log.Fatalf("unrecognized command op: %s", cmd.Op)
 	}
@@ -165,6 +816,10 @@ func (f *fsm) Apply(l *raft.Log) interface{} {
 }
 
 func (c *RaftProxy) OpenRaft(storage string) error {
+	if c.Nonvoter && c.joinAddr == "" && !c.WaitJoin {
+		return errors.New("a non-voter must join an existing cluster")
+	}
+
 	// Setup Raft configuration.
 	config := raft.DefaultConfig()
 	config.LocalID = raft.ServerID(c.localId)
@@ -174,7 +829,12 @@ func (c *RaftProxy) OpenRaft(storage string) error {
 	if err != nil {
 		return err
 	}
-	transport, err := raft.NewTCPTransport(c.raftAddr, addr, 3, tcpTimeout, os.Stderr)
+	var transport *raft.NetworkTransport
+	if c.TransportTLS != nil {
+		transport, err = newTLSTransport(c.raftAddr, addr, c.TransportTLS, 3, tcpTimeout, os.Stderr)
+	} else {
+		transport, err = raft.NewTCPTransport(c.raftAddr, addr, 3, tcpTimeout, os.Stderr)
+	}
 	if err != nil {
 		return err
 	}
@@ -207,7 +867,11 @@ func (c *RaftProxy) OpenRaft(storage string) error {
 	}
 
 	c.raft = ra
+	go c.announce()
 
+	if c.joinAddr == "" && c.WaitJoin {
+		return nil
+	}
 	if c.joinAddr == "" {
 		configuration := raft.Configuration{
 			Servers: []raft.Server{
@@ -219,15 +883,22 @@ func (c *RaftProxy) OpenRaft(storage string) error {
 		}
 		ra.BootstrapCluster(configuration)
 	} else {
-		b, err := json.Marshal(map[string]string{"addr": c.raftAddr, "id": c.localId})
+		b, err := json.Marshal(joinRequest(c.localId, c.raftAddr, c.APIURL, c.Nonvoter))
 		if err != nil {
 			return err
 		}
-		resp, err := http.Post(fmt.Sprintf("http://%s/join", c.joinAddr), "", bytes.NewReader(b))
+		scheme := "http"
+		if strings.HasPrefix(c.APIURL, "https://") {
+			scheme = "https"
+		}
+		resp, err := c.post(fmt.Sprintf("%s://%s/join", scheme, c.joinAddr), b)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("join %s: %s", c.joinAddr, resp.Status)
+		}
 
 		return nil
 
@@ -238,8 +909,15 @@ func (c *RaftProxy) OpenRaft(storage string) error {
 
 // Join joins a node, identified by nodeID and located at addr, to this store.
 // The node must be ready to respond to Raft communications at that address.
-func (c *RaftProxy) Join(nodeID, addr string) error {
+// api is the URL of the HTTP API of the node, if it is set the node can be
+// the target of the forwarded commands once it is the leader. A non-voter
+// gets the log but does not vote, joining a non-voter again as a voter
+// promotes it. A follower forwards the join to the leader
+func (c *RaftProxy) Join(nodeID, addr, api string, nonvoter bool) error {
 	log.Infof("received join request for remote node %s at %s", nodeID, addr)
+	if c.raft.State() != raft.Leader {
+		return c.forwardJoin(nodeID, addr, api, nonvoter)
+	}
 
 	configFuture := c.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
@@ -248,14 +926,24 @@ func (c *RaftProxy) Join(nodeID, addr string) error {
 	}
 
 	for _, srv := range configFuture.Configuration().Servers {
-		// If a node already exists with either the joining node's ID or address,
-		// that node may need to be removed from the config first.
+		// A member is never rebound to another address, it must leave first
+		if srv.ID == raft.ServerID(nodeID) && srv.Address != raft.ServerAddress(addr) {
+			return ErrMemberExists
+		}
+		// If a node already exists with the joining node's address, that node
+		// needs to be removed from the config first.
 		if srv.ID == raft.ServerID(nodeID) || srv.Address == raft.ServerAddress(addr) {
 			// However if *both* the ID and the address are the same, then nothing -- not even
 			// a join operation -- is needed.
 			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(nodeID) {
-				log.Warnf("node %s at %s already member of cluster, ignoring join request", nodeID, addr)
-				return nil
+				if (srv.Suffrage == raft.Nonvoter) == nonvoter {
+					log.Warnf("node %s at %s already member of cluster, ignoring join request", nodeID, addr)
+					return c.remember(nodeID, api)
+				}
+				if nonvoter {
+					return fmt.Errorf("node %s at %s is a voter, it cannot be demoted by a join", nodeID, addr)
+				}
+				continue
 			}
 
 			future := c.raft.RemoveServer(srv.ID, 0, 0)
@@ -265,15 +953,25 @@ func (c *RaftProxy) Join(nodeID, addr string) error {
 		}
 	}
 
-	f := c.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
+	var f raft.IndexFuture
+	if nonvoter {
+		f = c.raft.AddNonvoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
+	} else {
+		f = c.raft.AddVoter(raft.ServerID(nodeID), raft.ServerAddress(addr), 0, 0)
+	}
 	if f.Error() != nil {
 		return f.Error()
 	}
 	log.Infof("node %s at %s joined successfully", nodeID, addr)
-	return nil
+	return c.remember(nodeID, api)
 }
 
+// Leave removes the node from the cluster, a follower forwards it to the
+// leader
 func (c *RaftProxy) Leave(nodeID string) error {
+	if c.raft.State() != raft.Leader {
+		return c.forwardLeave(nodeID)
+	}
 	configFuture := c.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
 		log.Errorf("failed to get raft configuration: %v", err)
@@ -285,5 +983,48 @@ func (c *RaftProxy) Leave(nodeID string) error {
 		error := fmt.Sprintf("error removing existing node %s: %s", nodeID, err.Error())
 		return errors.New(error)
 	}
+	if err := c.membership(&command{Op: "forget", Key: nodeID}); err != nil {
+		log.Warnf("failed to forget the API of node %s: %v", nodeID, err)
+	}
 	return nil
 }
+
+// Shutdown stops the node. A leader hands the leadership over to another
+// voter, then the node takes a last raft snapshot, stops raft and closes the
+// cache, which writes its last dump and syncs its append-only file. The node
+// keeps its membership to rejoin once it restarts, unless it is decommissioned
+// and leaves a cluster of several servers
+func (c *RaftProxy) Shutdown(decommission bool) error {
+	if c.raft == nil {
+		return c.Close()
+	}
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		log.Warnf("Cannot get the raft configuration: %v", err)
+	} else if servers := future.Configuration().Servers; len(servers) > 1 {
+		voters := 0
+		for _, server := range servers {
+			if server.Suffrage == raft.Voter && server.ID != raft.ServerID(c.localId) {
+				voters++
+			}
+		}
+		if c.IsLeader() && voters > 0 {
+			if err := c.raft.LeadershipTransfer().Error(); err != nil {
+				log.Warnf("Cannot transfer the leadership: %v", err)
+			}
+		}
+		if decommission {
+			if err := c.Leave(c.localId); err != nil {
+				log.Warnf("Cannot leave the cluster gracefully: %v", err)
+			}
+		}
+	}
+	if err := c.raft.Snapshot().Error(); err != nil && err != raft.ErrNothingNewToSnapshot {
+		log.Warnf("Cannot take the last raft snapshot: %v", err)
+	}
+	err := c.raft.Shutdown().Error()
+	if e := c.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package raftproxy
+
+import (
+	"encoding/json"
+	"github.com/arazmj/gerdu/arccache"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/hashicorp/raft"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump.json")
+	local := lrucache.NewCache(100, cache.WithPeriodicSnapshot(dump, 0))
+	c := NewRaftProxy(local, "127.0.0.1:0", "", "node")
+	if err := c.OpenRaft(dir); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Put("1", "1")
+
+	if err := c.Shutdown(false); err != nil {
+		t.Fatalf("Expected the node to shut down but got %v", err)
+	}
+	if state := c.raft.State(); state != raft.Shutdown {
+		t.Errorf("Expected raft to be shut down but got %s", state)
+	}
+	if _, err := os.Stat(dump); err != nil {
+		t.Errorf("Expected the last dump of the cache but got %v", err)
+	}
+	snapshots, _ := filepath.Glob(filepath.Join(dir, "snapshots", "*", "state.bin"))
+	if len(snapshots) != 1 {
+		t.Errorf("Expected the last raft snapshot but got %v", snapshots)
+	}
+}
+
+func TestWaitLeader(t *testing.T) {
+	dir := t.TempDir()
+	c := NewRaftProxy(lrucache.NewCache(100), "127.0.0.1:0", "", "node")
+	if err := c.OpenRaft(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown(false)
+	if err := c.WaitLeader(5 * time.Second); err != nil {
+		t.Fatalf("Expected the single node to lead but got %v", err)
+	}
+	if !c.PutWithTTL("1", "1", time.Minute) {
+		t.Errorf("Expected the warm-up entry to be applied through raft")
+	}
+	c.PutMulti(map[string]string{"2": "2"})
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries but got %d", c.Len())
+	}
+}
+
+func TestOpenRaft_WaitJoin(t *testing.T) {
+	c := NewRaftProxy(lrucache.NewCache(100), "127.0.0.1:0", "", "node")
+	c.WaitJoin = true
+	c.Nonvoter = true
+	if err := c.OpenRaft(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown(false)
+	if err := c.WaitLeader(time.Second); err != ErrNotLeader {
+		t.Errorf("Expected the node to wait to be added instead of bootstrapping but got %v", err)
+	}
+}
+
+func TestFSM_Resize(t *testing.T) {
+	local := lrucache.NewCache(10)
+	for _, key := range []string{"1", "2", "3"} {
+		local.Put(key, key)
+	}
+	c := NewRaftProxy(local, "127.0.0.1:0", "", "node")
+	b, _ := json.Marshal(&command{Op: "resize", Value: "2"})
+	if err := (*fsm)(c).Apply(&raft.Log{Data: b}); err != nil {
+		t.Errorf("Expected the resize to apply but got %v", err)
+	}
+	if local.Len() != 2 {
+		t.Errorf("Expected 2 entries to fit in the new capacity but got %d", local.Len())
+	}
+	b, _ = json.Marshal(&command{Op: "resize", Value: strconv.FormatUint(uint64(cache.Unlimited), 10)})
+	if err := (*fsm)(c).Apply(&raft.Log{Data: b}); err != nil {
+		t.Errorf("Expected the resize to apply but got %v", err)
+	}
+	for _, key := range []string{"4", "5", "6"} {
+		local.Put(key, key)
+	}
+	if local.Len() != 5 {
+		t.Errorf("Expected an unlimited capacity to keep all the entries but got %d", local.Len())
+	}
+
+	c = NewRaftProxy(arccache.NewCache(10), "127.0.0.1:0", "", "node")
+	if err := c.Resize(2); err != cache.ErrNotSupported {
+		t.Errorf("Expected %v but got %v", cache.ErrNotSupported, err)
+	}
+	if err := (*fsm)(c).Apply(&raft.Log{Data: b}); err != cache.ErrNotSupported {
+		t.Errorf("Expected %v but got %v", cache.ErrNotSupported, err)
+	}
+}
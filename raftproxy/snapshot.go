@@ -0,0 +1,103 @@
+package raftproxy
+
+import (
+	"encoding/json"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/hashicorp/raft"
+	"io"
+	"io/ioutil"
+)
+
+// snapshotter is implemented by the caches that raft can snapshot
+type snapshotter interface {
+	Snapshot() (raft.FSMSnapshot, error)
+	Restore(closer io.ReadCloser) error
+}
+
+// fsmSnapshot is the snapshot of the members followed by the one of the
+// cache, compressed by compression
+type fsmSnapshot struct {
+	members     map[string]string
+	cache       raft.FSMSnapshot
+	compression cache.Compression
+}
+
+// compressSink compresses what the snapshot of the cache writes to the sink
+type compressSink struct {
+	raft.SnapshotSink
+	w io.WriteCloser
+}
+
+func (s *compressSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// Close flushes the compressed snapshot and closes the sink
+func (s *compressSink) Close() error {
+	if err := s.w.Close(); err != nil {
+		_ = s.SnapshotSink.Cancel()
+		return err
+	}
+	return s.SnapshotSink.Close()
+}
+
+// Snapshot returns the snapshot of the members and of the cache, the cache
+// is snapshotted by itself and its entries are streamed by Persist
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	s, ok := f.Imp.(snapshotter)
+	if !ok {
+		return nil, cache.ErrNotSupported
+	}
+	f.mu.RLock()
+	members := make(map[string]string, len(f.members))
+	for id, api := range f.members {
+		members[id] = api
+	}
+	f.mu.RUnlock()
+	snapshot, err := s.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{members: members, cache: snapshot, compression: f.Compression}, nil
+}
+
+// Restore restores the members and then the cache from the snapshot, it is
+// decompressed by the compression of its header
+func (f *fsm) Restore(closer io.ReadCloser) error {
+	s, ok := f.Imp.(snapshotter)
+	if !ok {
+		return cache.ErrNotSupported
+	}
+	r, err := cache.NewDecompressReader(closer)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	var members map[string]string
+	if err := dec.Decode(&members); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.members = members
+	f.mu.Unlock()
+	return s.Restore(ioutil.NopCloser(io.MultiReader(dec.Buffered(), r)))
+}
+
+// Persist writes the members as a JSON line and then lets the snapshot of
+// the cache stream its entries and close the sink
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	w, err := cache.NewCompressWriter(sink, f.compression)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(f.members); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return f.cache.Persist(&compressSink{SnapshotSink: sink, w: w})
+}
+
+func (f *fsmSnapshot) Release() {
+	f.cache.Release()
+}
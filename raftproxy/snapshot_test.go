@@ -0,0 +1,86 @@
+package raftproxy
+
+import (
+	"bytes"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// bufferSink is a snapshot sink in memory
+type bufferSink struct {
+	bytes.Buffer
+	closed, cancelled bool
+}
+
+func (s *bufferSink) ID() string    { return "buffer" }
+func (s *bufferSink) Close() error  { s.closed = true; return nil }
+func (s *bufferSink) Cancel() error { s.cancelled = true; return nil }
+
+func TestFSM_Snapshot(t *testing.T) {
+	c := NewRaftProxy(lrucache.NewCache(100), "", "", "node1")
+	c.members = map[string]string{"node1": "http://127.0.0.1:8081"}
+	c.Imp.Put("1", "one")
+	c.Imp.Put("2", "two\n")
+
+	snapshot, err := (*fsm)(c).Snapshot()
+	if err != nil {
+		t.Fatalf("Expected a snapshot but got %v", err)
+	}
+	sink := &bufferSink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatalf("Expected the snapshot to be persisted but got %v", err)
+	}
+	snapshot.Release()
+	if !sink.closed || sink.cancelled {
+		t.Errorf("Expected the sink to be closed but got closed %v cancelled %v", sink.closed, sink.cancelled)
+	}
+
+	restored := NewRaftProxy(lrucache.NewCache(100), "", "", "node2")
+	if err := (*fsm)(restored).Restore(ioutil.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatalf("Expected the snapshot to be restored but got %v", err)
+	}
+	if restored.members["node1"] != "http://127.0.0.1:8081" {
+		t.Errorf("Expected the API of node1 to be restored but got %v", restored.members)
+	}
+	for key, expected := range map[string]string{"1": "one", "2": "two\n"} {
+		if value, ok := restored.Imp.Get(key); !ok || value != expected {
+			t.Errorf("Expected %s to be %q but got %q", key, expected, value)
+		}
+	}
+}
+
+func TestFSM_SnapshotCompressed(t *testing.T) {
+	c := NewRaftProxy(lrucache.NewCache(1<<20), "", "", "node1")
+	c.Compression = cache.Gzip
+	value := strings.Repeat("x", 10000)
+	c.Imp.Put("1", value)
+	snapshot, err := (*fsm)(c).Snapshot()
+	if err != nil {
+		t.Fatalf("Expected a snapshot but got %v", err)
+	}
+	sink := &bufferSink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatalf("Expected the snapshot to be persisted but got %v", err)
+	}
+	if !sink.closed || sink.cancelled || sink.Len() > 1000 {
+		t.Errorf("Expected a closed compressed snapshot but got %d bytes", sink.Len())
+	}
+
+	restored := NewRaftProxy(lrucache.NewCache(1<<20), "", "", "node2")
+	if err := (*fsm)(restored).Restore(ioutil.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatalf("Expected the snapshot to be restored but got %v", err)
+	}
+	if got, ok := restored.Imp.Get("1"); !ok || got != value {
+		t.Errorf("Expected 1 to be restored")
+	}
+}
+
+func TestFSM_SnapshotNotSupported(t *testing.T) {
+	c := NewRaftProxy(cache.UnImplementedCache(nil), "", "", "node1")
+	if _, err := (*fsm)(c).Snapshot(); err != cache.ErrNotSupported {
+		t.Errorf("Expected ErrNotSupported but got %v", err)
+	}
+}
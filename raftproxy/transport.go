@@ -0,0 +1,54 @@
+package raftproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/hashicorp/raft"
+	"io"
+	"net"
+	"time"
+)
+
+// TransportTLS is the TLS of the raft transport between the nodes
+type TransportTLS struct {
+	// Server is the config of the listener of the node, it verifies the
+	// certificates of the other nodes if it requires client certificates
+	Server *tls.Config
+	// Dial dials a TLS connection to another node, it presents the
+	// certificate of the node if the others verify it
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// tlsStreamLayer is the raft stream layer of the TLS connections
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	dial      func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newTLSTransport returns the raft transport of the TLS listener of the bind
+// address, the other nodes reach it at the advertised address
+func newTLSTransport(bindAddr string, advertise net.Addr, t *TransportTLS, maxPool int,
+	timeout time.Duration, logOutput io.Writer) (*raft.NetworkTransport, error) {
+	listener, err := tls.Listen("tcp", bindAddr, t.Server)
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := advertise.(*net.TCPAddr); !ok || addr.Port == 0 {
+		advertise = listener.Addr()
+	}
+	stream := &tlsStreamLayer{Listener: listener, advertise: advertise, dial: t.Dial}
+	return raft.NewNetworkTransport(stream, maxPool, timeout, logOutput), nil
+}
+
+// Dial dials the node at the address within the timeout
+func (l *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return l.dial(ctx, "tcp", string(address))
+}
+
+// Addr returns the address the other nodes reach the node at
+func (l *tlsStreamLayer) Addr() net.Addr {
+	return l.advertise
+}
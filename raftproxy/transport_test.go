@@ -0,0 +1,111 @@
+package raftproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/hashicorp/raft"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSigned returns the TLS of the raft transport of a self-signed
+// certificate that every node presents and trusts
+func selfSigned(t *testing.T) *TransportTLS {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "node"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	certificate := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	client := &tls.Config{Certificates: []tls.Certificate{certificate}, RootCAs: pool}
+	return &TransportTLS{
+		Server: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+		Dial: (&tls.Dialer{Config: client}).DialContext,
+	}
+}
+
+func TestTLSTransport(t *testing.T) {
+	secure := selfSigned(t)
+	addr, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	a, err := newTLSTransport("127.0.0.1:0", addr, secure, 2, time.Second, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := newTLSTransport("127.0.0.1:0", addr, secure, 2, time.Second, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	go func() {
+		rpc := <-b.Consumer()
+		rpc.Respond(&raft.AppendEntriesResponse{Term: 7, Success: true}, nil)
+	}()
+	var resp raft.AppendEntriesResponse
+	err = a.AppendEntries("b", b.LocalAddr(), &raft.AppendEntriesRequest{Term: 7}, &resp)
+	if err != nil || !resp.Success || resp.Term != 7 {
+		t.Errorf("Expected the response of the other node over TLS but got %+v %v", resp, err)
+	}
+
+	plain := &net.Dialer{}
+	conn, err := plain.DialContext(context.Background(), "tcp", string(b.LocalAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	insecure := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := insecure.Handshake(); err == nil {
+		if _, err = insecure.Read(make([]byte, 1)); err == nil {
+			t.Errorf("Expected a node without a certificate to be rejected")
+		}
+	}
+}
+
+func TestOpenRaft_TLS(t *testing.T) {
+	c := NewRaftProxy(nil, "127.0.0.1:0", "", "node")
+	c.TransportTLS = selfSigned(t)
+	if err := c.OpenRaft(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.raft.Shutdown()
+	deadline := time.Now().Add(5 * time.Second)
+	for !c.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !c.IsLeader() {
+		t.Errorf("Expected the node to lead its cluster over the TLS transport")
+	}
+	if role := c.Role(); role != "leader" {
+		t.Errorf("Expected the role of the leader but got %s", role)
+	}
+}
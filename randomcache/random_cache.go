@@ -0,0 +1,207 @@
+// Package randomcache implements a cache that evicts random entries,
+// it is a cheap baseline to compare the other eviction policies with
+package randomcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value string
+	index int
+}
+
+// RandomCache data structure, keys holds the keys of the entries so a
+// uniformly random entry can be picked in O(1) time
+type RandomCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	entries  map[string]*entry
+	keys     []string
+	rand     *rand.Rand
+	capacity bytesize.ByteSize
+	size     bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache RandomCache constructor
+func NewCache(capacity bytesize.ByteSize) *RandomCache {
+	return NewCacheWithSeed(capacity, time.Now().UnixNano())
+}
+
+// NewCacheWithSeed RandomCache constructor with a seeded random
+// generator, so the evictions are deterministic
+func NewCacheWithSeed(capacity bytesize.ByteSize, seed int64) *RandomCache {
+	return &RandomCache{
+		entries:  map[string]*entry{},
+		rand:     rand.New(rand.NewSource(seed)),
+		capacity: capacity,
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+}
+
+// Get returns the value for the key, nothing is reordered on a hit
+func (c *RandomCache) Get(key string) (value string, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	return e.value, true
+}
+
+// Put updates or inserts a new entry, evicts random entries
+// if cache size is larger than capacity
+func (c *RandomCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.size += bytesize.ByteSize(len(value) - len(e.value))
+		e.value = value
+	} else {
+		c.counters.Adds.Inc()
+		c.entries[key] = &entry{value: value, index: len(c.keys)}
+		c.keys = append(c.keys, key)
+		c.size += bytesize.ByteSize(len(value))
+		created = true
+	}
+	for c.size > c.capacity {
+		c.counters.Evictions.Inc()
+		c.remove(c.keys[c.rand.Intn(len(c.keys))])
+	}
+	return created
+}
+
+// Delete deletes the key from the cache
+func (c *RandomCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	if _, ok = c.entries[key]; ok {
+		c.remove(key)
+	}
+	return ok
+}
+
+// Resize changes the capacity of the cache, a smaller capacity evicts
+// random entries in batches of cache.ResizeBatch
+func (c *RandomCache) Resize(capacity bytesize.ByteSize) error {
+	c.Lock()
+	c.capacity = capacity
+	c.Unlock()
+	for more := true; more; {
+		c.Lock()
+		for n := 0; n < cache.ResizeBatch && c.size > c.capacity; n++ {
+			c.counters.Evictions.Inc()
+			c.remove(c.keys[c.rand.Intn(len(c.keys))])
+		}
+		more = c.size > c.capacity
+		c.Unlock()
+	}
+	return nil
+}
+
+// remove swaps the key with the last key and pops it,
+// the caller must hold the lock
+func (c *RandomCache) remove(key string) {
+	c.counters.Deletes.Inc()
+	e := c.entries[key]
+	last := len(c.keys) - 1
+	c.keys[e.index] = c.keys[last]
+	c.entries[c.keys[last]].index = e.index
+	c.keys = c.keys[:last]
+	c.size -= bytesize.ByteSize(len(e.value))
+	delete(c.entries, key)
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *RandomCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *RandomCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.entries)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *RandomCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.size, len(c.entries))
+}
+
+func (c *RandomCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := make(map[string]string)
+
+	for k, v := range c.entries {
+		o[k] = v.value
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+// Restore inserts the entries of the snapshot in a single pass under the
+// lock without evicting, random entries are evicted once they are all in
+func (c *RandomCache) Restore(closer io.ReadCloser) error {
+	// no lock is required according to the Hashicorp docs, it is taken
+	// once so the entries are not locked and evicted one by one
+	defer c.Unlock()
+	c.Lock()
+	before := len(c.entries)
+	err := cache.ReadSnapshot(closer, func(k, v string) {
+		if e, ok := c.entries[k]; ok {
+			c.size += bytesize.ByteSize(len(v) - len(e.value))
+			e.value = v
+			return
+		}
+		c.entries[k] = &entry{value: v, index: len(c.keys)}
+		c.keys = append(c.keys, k)
+		c.size += bytesize.ByteSize(len(v))
+	})
+	c.counters.Adds.Add(float64(len(c.entries) - before))
+	for c.size > c.capacity {
+		c.counters.Evictions.Inc()
+		c.remove(c.keys[c.rand.Intn(len(c.keys))])
+	}
+	return err
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
@@ -0,0 +1,110 @@
+package randomcache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func evictions(seed int64) []string {
+	cache := NewCacheWithSeed(4, seed)
+	var evicted []string
+	for i := 0; i < 20; i++ {
+		cache.Put(strconv.Itoa(i%10), "x")
+		for j := 0; j < 10; j++ {
+			key := strconv.Itoa(j)
+			if _, ok := cache.entries[key]; !ok {
+				evicted = append(evicted, key)
+			}
+		}
+	}
+	return evicted
+}
+
+func TestRandomCache_Seed(t *testing.T) {
+	first := evictions(42)
+	second := evictions(42)
+	if len(first) != len(second) {
+		t.Fatalf("Expected the same evictions but got %v and %v", first, second)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Expected the same evictions but got %v and %v", first, second)
+		}
+	}
+}
+
+func TestRandomCache_Evict(t *testing.T) {
+	cache := NewCacheWithSeed(3, 1)
+	for i := 0; i < 10; i++ {
+		cache.Put(strconv.Itoa(i), "x")
+		if cache.Len() > 3 || cache.size > 3 {
+			t.Fatalf("Expected at most 3 entries but got %d", cache.Len())
+		}
+	}
+	for key, e := range cache.entries {
+		if cache.keys[e.index] != key {
+			t.Errorf("Expected key %s at index %d", key, e.index)
+		}
+	}
+}
+
+func TestRandomCache_Delete(t *testing.T) {
+	cache := NewCache(10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	if !cache.Delete("1") {
+		t.Errorf("Expected 1 to be deleted")
+	}
+	if cache.Delete("1") {
+		t.Errorf("Expected 1 to be already deleted")
+	}
+	if _, ok := cache.Get("3"); !ok || cache.Len() != 2 || cache.size != 2 {
+		t.Errorf("Expected 2 entries left")
+	}
+}
+
+func TestRandomCache_Restore(t *testing.T) {
+	store := map[string]string{}
+	for i := 0; i < 10; i++ {
+		store[strconv.Itoa(i)] = "x"
+	}
+	b, _ := json.Marshal(store)
+	cache := NewCacheWithSeed(3, 1)
+	if err := cache.Restore(ioutil.NopCloser(strings.NewReader(string(b)))); err != nil {
+		t.Fatalf("Restore failed %v", err)
+	}
+	if cache.Len() != 3 || cache.size != 3 || len(cache.keys) != 3 {
+		t.Errorf("Expected 3 entries in the capacity but got %d of size %d", cache.Len(), cache.size)
+	}
+	for key, e := range cache.entries {
+		if cache.keys[e.index] != key {
+			t.Errorf("Expected key %s at index %d", key, e.index)
+		}
+	}
+}
+
+func TestRandomCache_Resize(t *testing.T) {
+	c := NewCacheWithSeed(1000, 1)
+	for i := 0; i < 500; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if err := c.Resize(10); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 10 || c.size != 10 || len(c.keys) != 10 {
+		t.Errorf("Expected 10 entries of size 10 but got %d entries of size %d", c.Len(), c.size)
+	}
+	if err := c.Resize(1000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "1")
+	}
+	if c.Len() != 510 {
+		t.Errorf("Expected the grown cache to keep all the entries but got %d", c.Len())
+	}
+}
@@ -0,0 +1,239 @@
+// Package ratelimit limits the rate of the requests of each client IP and
+// of each token with token buckets, and the number of the connections a
+// server holds at once, so a single client cannot starve the others
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBuckets is the number of the buckets a limiter keeps at most, once it
+// is reached the limiter prunes them
+const maxBuckets = 1 << 16
+
+// maxRejects is the number of the connections over the limit handed to
+// reject at once, the others are closed right away
+const maxRejects = 16
+
+// bucket is the token bucket of a key
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per key that refills rate tokens per second
+// up to burst, each request takes a token
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	max     int
+	now     func() time.Time
+}
+
+// NewLimiter Limiter constructor, a burst below 1 is the rate rounded up
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}, max: maxBuckets, now: time.Now}
+}
+
+// Allow takes a token of the bucket of the key, it returns false if the
+// bucket is empty
+func (l *Limiter) Allow(key string) bool {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.max {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = l.refill(b, now)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill returns the tokens of the bucket at now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// prune drops the buckets that refilled, they are the same as new ones. If
+// there are still too many it drops the ones used the longest ago down to
+// three quarters of the maximum, so it does not sort them for every new key
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) < l.max {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last)
+	})
+	for _, key := range keys[:len(keys)-l.max*3/4] {
+		delete(l.buckets, key)
+	}
+}
+
+// Limits are the limits of a server, a zero field means no limit
+type Limits struct {
+	// Rate is the requests per second of each client IP
+	Rate float64
+	// Burst is the requests a client IP makes at once above its rate
+	Burst int
+	// TokenRate is the requests per second of each token
+	TokenRate float64
+	// TokenBurst is the requests a token makes at once above its rate
+	TokenBurst int
+	// MaxConns is the number of the connections the server holds at once
+	MaxConns int
+}
+
+// Guard enforces the limits of a server, a nil guard has no limits
+type Guard struct {
+	ip, token *Limiter
+	maxConns  int64
+	conns     int64
+}
+
+// NewGuard Guard constructor
+func NewGuard(limits Limits) *Guard {
+	g := &Guard{maxConns: int64(limits.MaxConns)}
+	if limits.Rate > 0 {
+		g.ip = NewLimiter(limits.Rate, limits.Burst)
+	}
+	if limits.TokenRate > 0 {
+		g.token = NewLimiter(limits.TokenRate, limits.TokenBurst)
+	}
+	return g
+}
+
+// Allow returns whether the request of the client at addr is within the
+// rate of its IP
+func (g *Guard) Allow(addr string) bool {
+	return g == nil || g.ip == nil || g.ip.Allow(host(addr))
+}
+
+// AllowToken returns whether the request with the token, empty if none, is
+// within the rate of the token. It must only be called once the token is
+// authenticated, so that made up tokens do not take the buckets
+func (g *Guard) AllowToken(token string) bool {
+	return g == nil || g.token == nil || token == "" || g.token.Allow(token)
+}
+
+// host returns the IP of the address, the address itself if it has no port
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
+// Acquire takes a connection, it returns false if the server holds the
+// maximum number of them
+func (g *Guard) Acquire() bool {
+	if g == nil || g.maxConns <= 0 {
+		return true
+	}
+	if atomic.AddInt64(&g.conns, 1) > g.maxConns {
+		atomic.AddInt64(&g.conns, -1)
+		return false
+	}
+	return true
+}
+
+// Release gives back a connection Acquire took
+func (g *Guard) Release() {
+	if g == nil || g.maxConns <= 0 {
+		return
+	}
+	atomic.AddInt64(&g.conns, -1)
+}
+
+// Conns returns the number of the connections held
+func (g *Guard) Conns() int {
+	if g == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&g.conns))
+}
+
+// Listener returns the listener that only accepts the connections the guard
+// acquires, the others are handed to reject, which closes them, or closed
+// if reject is nil or maxRejects of them are being rejected already. A nil
+// guard returns the listener as is
+func (g *Guard) Listener(l net.Listener, reject func(conn net.Conn)) net.Listener {
+	if g == nil || g.maxConns <= 0 {
+		return l
+	}
+	return &limitListener{Listener: l, guard: g, reject: reject, rejects: make(chan struct{}, maxRejects)}
+}
+
+// limitListener is the listener of Guard.Listener
+type limitListener struct {
+	net.Listener
+	guard   *Guard
+	reject  func(conn net.Conn)
+	rejects chan struct{}
+}
+
+// Accept waits for the next connection within the limit
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.guard.Acquire() {
+			return &limitConn{Conn: conn, guard: l.guard}, nil
+		}
+		if l.reject == nil {
+			conn.Close()
+			continue
+		}
+		select {
+		case l.rejects <- struct{}{}:
+			go func() {
+				defer func() { <-l.rejects }()
+				l.reject(conn)
+			}()
+		default:
+			conn.Close()
+		}
+	}
+}
+
+// limitConn releases its connection once it is closed
+type limitConn struct {
+	net.Conn
+	guard *Guard
+	once  sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.guard.Release)
+	return c.Conn.Close()
+}
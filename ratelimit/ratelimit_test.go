@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Errorf("Expected request %d within the burst", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Errorf("Expected the empty bucket to reject")
+	}
+	if !l.Allow("b") {
+		t.Errorf("Expected the keys to have buckets of their own")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Errorf("Expected a single token to refill in half a second at 2 per second")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Allow("a")
+	}
+	if l.Allow("a") {
+		t.Errorf("Expected the bucket to refill up to the burst only")
+	}
+
+	l.prune(now.Add(time.Hour))
+	if len(l.buckets) != 0 {
+		t.Errorf("Expected the refilled buckets to be pruned but got %d", len(l.buckets))
+	}
+	l = NewLimiter(1, 1)
+	l.now = func() time.Time { return now }
+	l.max = 8
+	for i := 0; i < 8; i++ {
+		l.Allow(strconv.Itoa(i))
+		now = now.Add(time.Millisecond)
+	}
+	l.Allow("new")
+	if len(l.buckets) != 7 {
+		t.Errorf("Expected the oldest buckets to be dropped down to 6 plus the new one but got %d", len(l.buckets))
+	}
+	if _, ok := l.buckets["0"]; ok {
+		t.Errorf("Expected the bucket used the longest ago to be dropped")
+	}
+	if _, ok := l.buckets["7"]; !ok {
+		t.Errorf("Expected the bucket used last to be kept")
+	}
+	if burst := NewLimiter(2.5, 0).burst; burst != 3 {
+		t.Errorf("Expected the burst to default to the rate rounded up but got %v", burst)
+	}
+}
+
+func TestGuard_Allow(t *testing.T) {
+	g := NewGuard(Limits{Rate: 1, Burst: 2, TokenRate: 1, TokenBurst: 1})
+	if !g.Allow("10.0.0.1:1000") || !g.Allow("10.0.0.1:2000") {
+		t.Errorf("Expected the burst of the IP")
+	}
+	if g.Allow("10.0.0.1:3000") {
+		t.Errorf("Expected the connections of an IP to share its rate")
+	}
+	if !g.AllowToken("token") || g.AllowToken("token") {
+		t.Errorf("Expected the rate of the token")
+	}
+	if !g.AllowToken("") {
+		t.Errorf("Expected the requests without a token not to be limited by token")
+	}
+	if !NewGuard(Limits{Rate: 1}).AllowToken("token") {
+		t.Errorf("Expected no limit without a token rate")
+	}
+	var none *Guard
+	if !none.Allow("10.0.0.1:1000") || !none.AllowToken("token") || !none.Acquire() {
+		t.Errorf("Expected a nil guard to have no limits")
+	}
+}
+
+func TestGuard_Listener(t *testing.T) {
+	g := NewGuard(Limits{MaxConns: 1})
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := make(chan net.Conn, 1)
+	l := g.Listener(inner, func(conn net.Conn) {
+		rejected <- conn
+	})
+	defer l.Close()
+
+	first, _ := net.Dial("tcp", l.Addr().String())
+	defer first.Close()
+	accepted, err := l.Accept()
+	if err != nil || g.Conns() != 1 {
+		t.Fatalf("Expected the first connection to be accepted but got %d %v", g.Conns(), err)
+	}
+	second, _ := net.Dial("tcp", l.Addr().String())
+	defer second.Close()
+	go l.Accept()
+	select {
+	case conn := <-rejected:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the connection over the limit to be rejected")
+	}
+
+	accepted.Close()
+	accepted.Close()
+	if g.Conns() != 0 {
+		t.Errorf("Expected the closed connection to be released once but got %d", g.Conns())
+	}
+	if NewGuard(Limits{}).Listener(inner, nil) != inner {
+		t.Errorf("Expected the listener as is without a limit of the connections")
+	}
+}
+
+func TestGuard_ListenerRejects(t *testing.T) {
+	g := NewGuard(Limits{MaxConns: 1})
+	g.Acquire()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make(chan struct{})
+	defer close(block)
+	rejected := make(chan struct{}, maxRejects+1)
+	l := g.Listener(inner, func(conn net.Conn) {
+		defer conn.Close()
+		rejected <- struct{}{}
+		<-block
+	})
+	defer l.Close()
+	go l.Accept()
+
+	for i := 0; i < maxRejects; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		select {
+		case <-rejected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the connection %d to be handed to reject", i)
+		}
+	}
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Errorf("Expected the connection over the full reject pool to be closed but got %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Errorf("Expected the connection over the full reject pool not to be handed to reject")
+	}
+}
+
+// isTimeout reports whether the error is a timeout of the network
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
@@ -0,0 +1,63 @@
+package readthrough
+
+import (
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PrefixLoader returns a loader that loads each key with the loader of its
+// longest prefix in loaders, or with fallback if no prefix matches. A nil
+// fallback returns cache.ErrNotFound for the keys without a loader
+func PrefixLoader(loaders map[string]cache.Loader, fallback cache.Loader) cache.Loader {
+	prefixes := make([]string, 0, len(loaders))
+	for prefix := range loaders {
+		prefixes = append(prefixes, prefix)
+	}
+	// the longest prefixes are tried first
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	return func(key string) (string, error) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return loaders[prefix](key)
+			}
+		}
+		if fallback == nil {
+			return "", cache.ErrNotFound
+		}
+		return fallback(key)
+	}
+}
+
+// HTTPLoader returns a loader that gets each key from an HTTP origin, the
+// escaped key is appended to base. A 404 is cache.ErrNotFound and the other
+// statuses but 200 are errors. A nil client is http.DefaultClient
+func HTTPLoader(client *http.Client, base string) cache.Loader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(key string) (string, error) {
+		response, err := client.Get(base + url.PathEscape(key))
+		if err != nil {
+			return "", err
+		}
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return "", err
+		}
+		switch response.StatusCode {
+		case http.StatusOK:
+			return string(body), nil
+		case http.StatusNotFound:
+			return "", cache.ErrNotFound
+		}
+		return "", fmt.Errorf("get %s from %s: %s", key, base, response.Status)
+	}
+}
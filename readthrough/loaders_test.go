@@ -0,0 +1,55 @@
+package readthrough
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefixLoader(t *testing.T) {
+	loader := func(value string) cache.Loader {
+		return func(key string) (string, error) {
+			return value, nil
+		}
+	}
+	route := PrefixLoader(map[string]cache.Loader{
+		"user:":       loader("users"),
+		"user:admin:": loader("admins"),
+	}, nil)
+	for key, want := range map[string]string{"user:1": "users", "user:admin:1": "admins"} {
+		if value, err := route(key); err != nil || value != want {
+			t.Errorf("Expected %s for %s but got %s %v", want, key, value, err)
+		}
+	}
+	if _, err := route("order:1"); err != cache.ErrNotFound {
+		t.Errorf("Expected ErrNotFound without a fallback but got %v", err)
+	}
+	if value, _ := PrefixLoader(nil, loader("fallback"))("order:1"); value != "fallback" {
+		t.Errorf("Expected the fallback but got %s", value)
+	}
+}
+
+func TestHTTPLoader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/origin/a b":
+			w.Write([]byte("found"))
+		case "/origin/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	load := HTTPLoader(nil, server.URL+"/origin/")
+	if value, err := load("a b"); err != nil || value != "found" {
+		t.Errorf("Expected found but got %s %v", value, err)
+	}
+	if _, err := load("missing"); err != cache.ErrNotFound {
+		t.Errorf("Expected ErrNotFound but got %v", err)
+	}
+	if _, err := load("broken"); err == nil || err == cache.ErrNotFound {
+		t.Errorf("Expected the status to be an error but got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Package readthrough implements a cache that loads its misses with the
+// loader it is constructed with
+package readthrough
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"sync"
+	"time"
+)
+
+// maxFailures is the number of the failed loads that are remembered
+const maxFailures = 10000
+
+// Policy is how the loaded values and the failed loads are cached
+type Policy struct {
+	// TTL is the time to live of the loaded values if the cache is a
+	// cache.TTLCache, zero puts them with the default TTL of the cache
+	TTL time.Duration
+	// NotFoundTTL is how long a key the loader returns cache.ErrNotFound
+	// for is a miss without being loaded again, zero loads it every time
+	NotFoundTTL time.Duration
+	// ErrorTTL is how long the other errors of the loader are returned
+	// without loading the key again, zero loads it every time
+	ErrorTTL time.Duration
+	// Now returns the current time, time.Now if it is nil
+	Now func() time.Time
+}
+
+// failure is a failed load that is remembered until it expires
+type failure struct {
+	err     error
+	expires time.Time
+}
+
+// ReadThroughCache wraps a cache, a miss is loaded by the loader, put in the
+// cache with the TTL of the policy and returned. The concurrent misses of a
+// key wait for a single load, and a failed load is remembered for the TTL
+// of the policy so a missing key or a failing origin is not loaded on every
+// Get. Unlike cache.LoaderCache the loader is configured once for all the
+// keys, PrefixLoader routes the keys to several loaders
+type ReadThroughCache struct {
+	cache.UnImplementedCache
+	loader   cache.Loader
+	policy   Policy
+	loads    cache.Group
+	mu       sync.Mutex
+	failures map[string]failure
+}
+
+// the cache must satisfy the interfaces used by the servers
+var (
+	_ cache.UnImplementedCache = (*ReadThroughCache)(nil)
+	_ cache.Closer             = (*ReadThroughCache)(nil)
+)
+
+// NewCache ReadThroughCache constructor
+func NewCache(c cache.UnImplementedCache, loader cache.Loader, policy Policy) *ReadThroughCache {
+	if policy.Now == nil {
+		policy.Now = time.Now
+	}
+	return &ReadThroughCache{
+		UnImplementedCache: c,
+		loader:             loader,
+		policy:             policy,
+		failures:           map[string]failure{},
+	}
+}
+
+// ttlCache puts the loaded values with the TTL of the policy
+type ttlCache struct {
+	cache.UnImplementedCache
+	ttl time.Duration
+}
+
+// Put puts the value with the TTL if the cache supports it
+func (c ttlCache) Put(key, value string) bool {
+	if ttl, ok := c.UnImplementedCache.(cache.TTLCache); ok && c.ttl > 0 {
+		return ttl.PutWithTTL(key, value, c.ttl)
+	}
+	return c.UnImplementedCache.Put(key, value)
+}
+
+// Get returns the value for the key, a miss is loaded. A failed load is a
+// miss, GetWithError returns its error
+func (c *ReadThroughCache) Get(key string) (value string, ok bool) {
+	value, err := c.GetWithError(key)
+	return value, err == nil
+}
+
+// GetWithError returns the value for the key like Get, or the error of the
+// loader, which is cache.ErrNotFound if the origin does not have the key
+func (c *ReadThroughCache) GetWithError(key string) (value string, err error) {
+	if value, ok := c.UnImplementedCache.Get(key); ok {
+		return value, nil
+	}
+	if err := c.failed(key); err != nil {
+		return "", err
+	}
+	value, err = c.loads.GetOrLoad(ttlCache{c.UnImplementedCache, c.policy.TTL}, key, c.loader)
+	if err != nil {
+		c.fail(key, err)
+	}
+	return value, err
+}
+
+// failed returns the error of the last load of the key if it is remembered
+func (c *ReadThroughCache) failed(key string) error {
+	defer c.mu.Unlock()
+	c.mu.Lock()
+	f, ok := c.failures[key]
+	if !ok {
+		return nil
+	}
+	if !c.policy.Now().Before(f.expires) {
+		delete(c.failures, key)
+		return nil
+	}
+	return f.err
+}
+
+// fail remembers the failed load for the TTL of its error, once there are
+// too many of them the expired ones are dropped and the new ones are not
+// remembered until there is room
+func (c *ReadThroughCache) fail(key string, err error) {
+	ttl := c.policy.ErrorTTL
+	if err == cache.ErrNotFound {
+		ttl = c.policy.NotFoundTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	defer c.mu.Unlock()
+	c.mu.Lock()
+	now := c.policy.Now()
+	if len(c.failures) >= maxFailures {
+		for k, f := range c.failures {
+			if !now.Before(f.expires) {
+				delete(c.failures, k)
+			}
+		}
+		if len(c.failures) >= maxFailures {
+			return
+		}
+	}
+	c.failures[key] = failure{err: err, expires: now.Add(ttl)}
+}
+
+// Put updates or inserts the entry and forgets the failed load of the key
+func (c *ReadThroughCache) Put(key string, value string) (created bool) {
+	c.Forget(key)
+	return c.UnImplementedCache.Put(key, value)
+}
+
+// Forget forgets the failed load of the key, so the next Get loads it again
+func (c *ReadThroughCache) Forget(key string) {
+	defer c.mu.Unlock()
+	c.mu.Lock()
+	delete(c.failures, key)
+}
+
+// Close closes the wrapped cache
+func (c *ReadThroughCache) Close() error {
+	return cache.Close(c.UnImplementedCache)
+}
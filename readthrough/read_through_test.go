@@ -0,0 +1,124 @@
+package readthrough
+
+import (
+	"errors"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// origin counts the loads and fails the keys of its errors
+type origin struct {
+	loads  int32
+	data   map[string]string
+	errors map[string]error
+}
+
+func (o *origin) load(key string) (string, error) {
+	atomic.AddInt32(&o.loads, 1)
+	if err, ok := o.errors[key]; ok {
+		return "", err
+	}
+	value, ok := o.data[key]
+	if !ok {
+		return "", cache.ErrNotFound
+	}
+	return value, nil
+}
+
+func TestReadThroughCache(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	o := &origin{data: map[string]string{"1": "one"}}
+	c := NewCache(lrucache.NewCache(100, cache.WithClock(clock)), o.load, Policy{TTL: time.Minute, Now: clock})
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if value, ok := c.Get("1"); !ok || value != "one" {
+			t.Errorf("Expected one but got %s", value)
+		}
+	}
+	if o.loads != 1 {
+		t.Errorf("Expected a single load but got %d", o.loads)
+	}
+	now = now.Add(2 * time.Minute)
+	if value, ok := c.Get("1"); !ok || value != "one" || o.loads != 2 {
+		t.Errorf("Expected the expired value to be loaded again but got %s after %d loads", value, o.loads)
+	}
+	if _, err := c.GetWithError("2"); err != cache.ErrNotFound {
+		t.Errorf("Expected ErrNotFound but got %v", err)
+	}
+	if _, ok := c.Get("2"); ok || o.loads != 4 {
+		t.Errorf("Expected the absent key to be loaded every time without a NotFoundTTL but got %d loads", o.loads)
+	}
+}
+
+func TestReadThroughCache_ErrorCaching(t *testing.T) {
+	now := time.Now()
+	failed := errors.New("origin is down")
+	o := &origin{data: map[string]string{}, errors: map[string]error{"down": failed}}
+	c := NewCache(lrucache.NewCache(100), o.load, Policy{
+		NotFoundTTL: time.Minute,
+		ErrorTTL:    time.Second,
+		Now:         func() time.Time { return now },
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetWithError("missing"); err != cache.ErrNotFound {
+			t.Errorf("Expected ErrNotFound but got %v", err)
+		}
+		if _, err := c.GetWithError("down"); err != failed {
+			t.Errorf("Expected the error of the origin but got %v", err)
+		}
+	}
+	if o.loads != 2 {
+		t.Errorf("Expected the failures to be remembered but got %d loads", o.loads)
+	}
+
+	now = now.Add(2 * time.Second)
+	c.GetWithError("missing")
+	c.GetWithError("down")
+	if o.loads != 3 {
+		t.Errorf("Expected only the error to be loaded again but got %d loads", o.loads)
+	}
+
+	c.Put("missing", "here")
+	if value, ok := c.Get("missing"); !ok || value != "here" {
+		t.Errorf("Expected the put to replace the absence but got %s", value)
+	}
+	delete(o.errors, "down")
+	o.data["down"] = "up"
+	c.Forget("down")
+	if value, ok := c.Get("down"); !ok || value != "up" {
+		t.Errorf("Expected the forgotten key to be loaded again but got %s", value)
+	}
+}
+
+func TestReadThroughCache_SingleLoad(t *testing.T) {
+	release := make(chan struct{})
+	var loads int32
+	c := NewCache(lrucache.NewCache(100), func(key string) (string, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "value", nil
+	}, Policy{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, ok := c.Get("key"); !ok || value != "value" {
+				t.Errorf("Expected value but got %s", value)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("Expected the concurrent misses to share a load but got %d", loads)
+	}
+}
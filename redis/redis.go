@@ -1,58 +1,157 @@
 package redis
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/ratelimit"
+	"github.com/arazmj/gerdu/tracing"
 	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/redcon"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Version is the server version reported by HELLO and INFO
+const Version = "0.1"
+
 func Serve(host string, gerdu cache.UnImplementedCache) {
-	go log.Infof("Gerdu started listening Redis at %s", host)
-	err := redcon.ListenAndServe(host,
-		handleCommands(gerdu),
-		handleAccept,
-		handleClose,
+	ServeLimited(host, nil, nil, gerdu)
+}
+
+func newServer(host string, guard *ratelimit.Guard, gerdu cache.UnImplementedCache) *redcon.Server {
+	accept, closed := handleConns(guard)
+	return redcon.NewServer(host,
+		limited(guard, handleCommands(gerdu)),
+		accept,
+		closed,
 	)
-	if err != nil {
-		log.Fatal(err)
+}
+
+// handleConns returns the callbacks of the connections, they reject the
+// connections over the limit of the guard like redis does with maxclients
+func handleConns(guard *ratelimit.Guard) (accept func(conn redcon.Conn) bool, closed func(conn redcon.Conn, err error)) {
+	accept = func(conn redcon.Conn) bool {
+		if !guard.Acquire() {
+			log.Printf("reject: %s", conn.RemoteAddr())
+			conn.WriteError("ERR max number of clients reached")
+			return false
+		}
+		log.Printf("accept: %s", conn.RemoteAddr())
+		return true
 	}
+	closed = func(conn redcon.Conn, err error) {
+		guard.Release()
+		log.Printf("closed: %s, err: %v", conn.RemoteAddr(), err)
+	}
+	return accept, closed
+}
 
+// limited rejects the commands over the rate of the client IP of the guard
+func limited(guard *ratelimit.Guard, handler func(conn redcon.Conn, cmd redcon.Command)) func(conn redcon.Conn, cmd redcon.Command) {
+	if guard == nil {
+		return handler
+	}
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		if !guard.Allow(conn.RemoteAddr()) {
+			conn.WriteError("ERR rate limit exceeded")
+			return
+		}
+		handler(conn, cmd)
+	}
 }
 
-func handleClose(conn redcon.Conn, err error) {
-	log.Printf("closed: %s, err: %v", conn.RemoteAddr(), err)
+func ServeTLS(host string, tlsCert, tlsKey string, gerdu cache.UnImplementedCache) {
+	certificate, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ServeTLSConfig(host, &tls.Config{Certificates: []tls.Certificate{certificate}}, gerdu)
 }
 
-func handleAccept(conn redcon.Conn) bool {
-	log.Printf("accept: %s", conn.RemoteAddr())
-	return true
+// ServeTLSConfig starts the redis server with the TLS config, e.g. one that
+// reloads its certificate or verifies the certificates of the clients
+func ServeTLSConfig(host string, config *tls.Config, gerdu cache.UnImplementedCache) {
+	ServeLimited(host, config, nil, gerdu)
 }
 
-func ServeTLS(host string, tlsCert, tlsKey string, gerdu cache.UnImplementedCache) {
+// ServeLimited starts the redis server with the TLS config, plain text if
+// it is nil, within the limits of the guard, none if it is nil
+func ServeLimited(host string, config *tls.Config, guard *ratelimit.Guard, gerdu cache.UnImplementedCache) {
 	go log.Infof("Gerdu started listening Redis at %s", host)
-	certificate, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
-	err = redcon.ListenAndServeTLS(host,
-		handleCommands(gerdu),
+	var err error
+	if config == nil {
+		err = newServer(host, guard, gerdu).ListenAndServe()
+	} else {
+		accept, closed := handleConns(guard)
+		err = redcon.ListenAndServeTLS(host, limited(guard, handleCommands(gerdu)), accept, closed, config)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// StartLimited starts the redis server of ServeLimited in the background, it
+// returns the shutdown of the server that stops accepting the connections,
+// closes them and waits for the commands they are running until the
+// context is done
+func StartLimited(host string, config *tls.Config, guard *ratelimit.Guard,
+	gerdu cache.UnImplementedCache) (shutdown func(ctx context.Context) error) {
+	var ln net.Listener
+	var err error
+	if config == nil {
+		ln, err = net.Listen("tcp", host)
+	} else {
+		ln, err = tls.Listen("tcp", host, config)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var conns sync.WaitGroup
+	accept, closed := handleConns(guard)
+	server := redcon.NewServer(host, limited(guard, handleCommands(gerdu)),
 		func(conn redcon.Conn) bool {
-			log.Printf("accept: %s", conn.RemoteAddr())
+			if !accept(conn) {
+				return false
+			}
+			conns.Add(1)
 			return true
 		},
 		func(conn redcon.Conn, err error) {
-			log.Printf("closed: %s, err: %v", conn.RemoteAddr(), err)
-		},
-
-		&tls.Config{Certificates: []tls.Certificate{certificate}},
-	)
-
-	if err != nil {
-		log.Fatal(err)
+			closed(conn, err)
+			conns.Done()
+		})
+	log.Infof("Gerdu started listening Redis at %s", host)
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		server.Close()
+		done := make(chan struct{})
+		go func() {
+			conns.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 }
 
 func handleCommands(gerdu cache.UnImplementedCache) func(conn redcon.Conn, cmd redcon.Command) {
 	return func(conn redcon.Conn, cmd redcon.Command) {
+		_, span := tracing.Start(context.Background(), "redis "+strings.ToLower(string(cmd.Args[0])))
+		defer span.End()
 		switch strings.ToLower(string(cmd.Args[0])) {
 		default:
 			conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
@@ -62,11 +161,27 @@ func handleCommands(gerdu cache.UnImplementedCache) func(conn redcon.Conn, cmd r
 			conn.WriteString("OK")
 			conn.Close()
 		case "set":
-			if len(cmd.Args) != 3 {
+			if len(cmd.Args) != 3 && len(cmd.Args) != 5 {
 				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 				return
 			}
-			gerdu.Put(string(cmd.Args[1]), string(cmd.Args[2]))
+			key, value := string(cmd.Args[1]), string(cmd.Args[2])
+			if len(cmd.Args) == 3 {
+				gerdu.Put(key, value)
+				conn.WriteString("OK")
+				return
+			}
+			ttl, err := parseTTL(string(cmd.Args[3]), string(cmd.Args[4]))
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			ttlCache, ok := gerdu.(cache.TTLCache)
+			if !ok {
+				conn.WriteError("ERR expiration is not supported by the cache")
+				return
+			}
+			ttlCache.PutWithTTL(key, value, ttl)
 			conn.WriteString("OK")
 		case "get":
 			if len(cmd.Args) != 2 {
@@ -75,21 +190,406 @@ func handleCommands(gerdu cache.UnImplementedCache) func(conn redcon.Conn, cmd r
 			}
 			val, ok := gerdu.Get(string(cmd.Args[1]))
 			if !ok {
-				conn.WriteNull()
+				writeNull(conn)
 			} else {
 				conn.WriteBulk([]byte(val))
 			}
 		case "del":
+			if len(cmd.Args) < 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			conn.WriteInt(cache.DeleteMulti(gerdu, argStrings(cmd.Args[1:])))
+		case "exists":
+			if len(cmd.Args) < 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			count := 0
+			for _, key := range cmd.Args[1:] {
+				if exists(gerdu, string(key)) {
+					count++
+				}
+			}
+			conn.WriteInt(count)
+		case "mget":
+			if len(cmd.Args) < 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			keys := argStrings(cmd.Args[1:])
+			values := cache.GetMulti(gerdu, keys)
+			conn.WriteArray(len(keys))
+			for _, key := range keys {
+				if value, ok := values[key]; ok {
+					conn.WriteBulkString(value)
+				} else {
+					writeNull(conn)
+				}
+			}
+		case "mset":
+			if len(cmd.Args) < 3 || len(cmd.Args)%2 != 1 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			entries := make(map[string]string, len(cmd.Args)/2)
+			for i := 1; i < len(cmd.Args); i += 2 {
+				entries[string(cmd.Args[i])] = string(cmd.Args[i+1])
+			}
+			cache.PutMulti(gerdu, entries)
+			conn.WriteString("OK")
+		case "incr", "decr":
+			if len(cmd.Args) != 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			writeCounter(conn, gerdu, strings.ToLower(string(cmd.Args[0])), string(cmd.Args[1]), 1)
+		case "incrby", "decrby":
+			if len(cmd.Args) != 3 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			delta, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+			if err != nil {
+				conn.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+			op := strings.TrimSuffix(strings.ToLower(string(cmd.Args[0])), "by")
+			writeCounter(conn, gerdu, op, string(cmd.Args[1]), delta)
+		case "keys":
 			if len(cmd.Args) != 2 {
 				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 				return
 			}
-			ok := gerdu.Delete(string(cmd.Args[1]))
+			scanner, ok := gerdu.(cache.ScanCache)
 			if !ok {
-				conn.WriteInt(0)
-			} else {
-				conn.WriteInt(1)
+				conn.WriteError("ERR keys is not supported by the cache")
+				return
+			}
+			keys, err := matchKeys(scanner.Keys(), string(cmd.Args[1]))
+			if err != nil {
+				conn.WriteError("ERR invalid pattern")
+				return
+			}
+			conn.WriteArray(len(keys))
+			for _, key := range keys {
+				conn.WriteBulkString(key)
+			}
+		case "scan":
+			if len(cmd.Args) < 2 || len(cmd.Args)%2 != 0 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
 			}
+			scanner, ok := gerdu.(cache.ScanCache)
+			if !ok {
+				conn.WriteError("ERR scan is not supported by the cache")
+				return
+			}
+			cursor, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+			if err != nil {
+				conn.WriteError("ERR invalid cursor")
+				return
+			}
+			count, pattern := 0, "*"
+			for i := 2; i < len(cmd.Args); i += 2 {
+				switch strings.ToLower(string(cmd.Args[i])) {
+				case "count":
+					if count, err = strconv.Atoi(string(cmd.Args[i+1])); err != nil || count <= 0 {
+						conn.WriteError("ERR value is not an integer or out of range")
+						return
+					}
+				case "match":
+					pattern = string(cmd.Args[i+1])
+				default:
+					conn.WriteError("ERR syntax error")
+					return
+				}
+			}
+			keys, next, err := cache.ScanMatch(scanner, cursor, count, pattern)
+			if err != nil {
+				conn.WriteError("ERR invalid pattern")
+				return
+			}
+			conn.WriteArray(2)
+			conn.WriteBulkString(strconv.FormatUint(next, 10))
+			conn.WriteArray(len(keys))
+			for _, key := range keys {
+				conn.WriteBulkString(key)
+			}
+		case "touch":
+			if len(cmd.Args) < 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			peeker, ok := gerdu.(cache.PeekCache)
+			if !ok {
+				conn.WriteError("ERR touch is not supported by the cache")
+				return
+			}
+			count := 0
+			for _, key := range cmd.Args[1:] {
+				if peeker.Touch(string(key)) {
+					count++
+				}
+			}
+			conn.WriteInt(count)
+		case "ttl", "pttl", "expire", "pexpire", "persist":
+			if op := strings.ToLower(string(cmd.Args[0])); op == "expire" || op == "pexpire" {
+				if len(cmd.Args) != 3 {
+					conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+					return
+				}
+			} else if len(cmd.Args) != 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			expirer, ok := gerdu.(cache.ExpireCache)
+			if !ok {
+				conn.WriteError("ERR expiration is not supported by the cache")
+				return
+			}
+			writeExpire(conn, expirer, cmd.Args)
+		case "hello":
+			proto := protocol(conn)
+			if len(cmd.Args) > 1 {
+				n, err := strconv.Atoi(string(cmd.Args[1]))
+				if err != nil {
+					conn.WriteError("ERR Protocol version is not an integer or out of range")
+					return
+				}
+				if n != 2 && n != 3 {
+					conn.WriteError("NOPROTO unsupported protocol version")
+					return
+				}
+				proto = n
+			}
+			for i := 2; i < len(cmd.Args); i++ {
+				switch option := strings.ToLower(string(cmd.Args[i])); {
+				case option == "auth" && i+2 < len(cmd.Args):
+					i += 2
+				case option == "setname" && i+1 < len(cmd.Args):
+					i++
+				default:
+					conn.WriteError("ERR syntax error")
+					return
+				}
+			}
+			conn.SetContext(proto)
+			writeMap(conn, 6)
+			conn.WriteBulkString("server")
+			conn.WriteBulkString("gerdu")
+			conn.WriteBulkString("version")
+			conn.WriteBulkString(Version)
+			conn.WriteBulkString("proto")
+			conn.WriteInt(proto)
+			conn.WriteBulkString("mode")
+			conn.WriteBulkString("standalone")
+			conn.WriteBulkString("role")
+			conn.WriteBulkString("master")
+			conn.WriteBulkString("modules")
+			conn.WriteArray(0)
+		case "select":
+			if len(cmd.Args) != 2 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			if string(cmd.Args[1]) != "0" {
+				conn.WriteError("ERR DB index is out of range")
+				return
+			}
+			conn.WriteString("OK")
+		case "command":
+			conn.WriteArray(0)
+		case "info":
+			if len(cmd.Args) > 2 {
+				conn.WriteError("ERR syntax error")
+				return
+			}
+			section := "default"
+			if len(cmd.Args) == 2 {
+				section = strings.ToLower(string(cmd.Args[1]))
+			}
+			conn.WriteBulkString(info(gerdu, section))
+		case "dbsize":
+			if len(cmd.Args) != 1 {
+				conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+				return
+			}
+			sizer, ok := gerdu.(cache.Sizer)
+			if !ok {
+				conn.WriteError("ERR dbsize is not supported by the cache")
+				return
+			}
+			conn.WriteInt(sizer.Len())
+		}
+	}
+}
+
+// protocol returns the RESP version the connection negotiated with HELLO
+func protocol(conn redcon.Conn) int {
+	if proto, ok := conn.Context().(int); ok {
+		return proto
+	}
+	return 2
+}
+
+// writeNull writes the null of the RESP version of the connection
+func writeNull(conn redcon.Conn) {
+	if protocol(conn) == 3 {
+		conn.WriteRaw([]byte("_\r\n"))
+		return
+	}
+	conn.WriteNull()
+}
+
+// writeMap writes the header of a map of n pairs, RESP2 has no maps so the
+// pairs are written as a flat array
+func writeMap(conn redcon.Conn, n int) {
+	if protocol(conn) == 3 {
+		conn.WriteRaw([]byte("%" + strconv.Itoa(n) + "\r\n"))
+		return
+	}
+	conn.WriteArray(2 * n)
+}
+
+// exists reports whether the key is in the cache, without touching its
+// entry if the cache can peek
+func exists(gerdu cache.UnImplementedCache, key string) bool {
+	if peeker, ok := gerdu.(cache.PeekCache); ok {
+		_, ok = peeker.Peek(key)
+		return ok
+	}
+	_, ok := gerdu.Get(key)
+	return ok
+}
+
+// info returns the INFO text of the section, server, stats or keyspace. The
+// default and all sections hold all of them
+func info(gerdu cache.UnImplementedCache, section string) string {
+	all := section == "default" || section == "all" || section == "everything"
+	var b strings.Builder
+	if all || section == "server" {
+		b.WriteString("# Server\r\n")
+		fmt.Fprintf(&b, "redis_version:%s\r\n", Version)
+		fmt.Fprintf(&b, "gerdu_version:%s\r\n", Version)
+		b.WriteString("redis_mode:standalone\r\n\r\n")
+	}
+	if stats, ok := gerdu.(cache.StatsCache); ok && (all || section == "stats") {
+		s := stats.Stats()
+		b.WriteString("# Stats\r\n")
+		fmt.Fprintf(&b, "keyspace_hits:%d\r\n", s.Hits)
+		fmt.Fprintf(&b, "keyspace_misses:%d\r\n", s.Misses)
+		fmt.Fprintf(&b, "evicted_keys:%d\r\n\r\n", s.Evictions)
+	}
+	if sizer, ok := gerdu.(cache.Sizer); ok && (all || section == "keyspace") {
+		b.WriteString("# Keyspace\r\n")
+		if n := sizer.Len(); n > 0 {
+			fmt.Fprintf(&b, "db0:keys=%d\r\n", n)
 		}
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// writeCounter increments or decrements the counter of the key by delta
+// and writes its new value
+func writeCounter(conn redcon.Conn, gerdu cache.UnImplementedCache, op string, key string, delta int64) {
+	var value int64
+	var err error
+	if op == "incr" {
+		value, err = cache.Incr(gerdu, key, delta)
+	} else {
+		value, err = cache.Decr(gerdu, key, delta)
+	}
+	switch err {
+	case nil:
+		conn.WriteInt64(value)
+	case cache.ErrOverflow:
+		conn.WriteError("ERR increment or decrement would overflow")
+	default:
+		conn.WriteError("ERR value is not an integer or out of range")
+	}
+}
+
+// writeExpire runs the ttl, pttl, expire, pexpire or persist command on
+// the key. TTL and PTTL write -2 for a missing key and -1 for a key that
+// never expires, the others write 1 if the expiration changed and 0 if not
+func writeExpire(conn redcon.Conn, expirer cache.ExpireCache, args [][]byte) {
+	key := string(args[1])
+	unit := time.Second
+	switch op := strings.ToLower(string(args[0])); op {
+	case "pttl":
+		unit = time.Millisecond
+		fallthrough
+	case "ttl":
+		ttl, ok := expirer.TTL(key)
+		switch {
+		case !ok:
+			conn.WriteInt(-2)
+		case ttl == 0:
+			conn.WriteInt(-1)
+		default:
+			conn.WriteInt64(int64((ttl + unit/2) / unit))
+		}
+	case "pexpire":
+		unit = time.Millisecond
+		fallthrough
+	case "expire":
+		n, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		if expirer.Expire(key, time.Duration(n)*unit) {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	case "persist":
+		if ttl, ok := expirer.TTL(key); ok && ttl > 0 && expirer.Persist(key) {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	}
+}
+
+// matchKeys returns the keys that match the glob pattern
+func matchKeys(keys []string, pattern string) ([]string, error) {
+	matched := keys[:0]
+	for _, key := range keys {
+		ok, err := cache.Match(pattern, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
+
+// argStrings converts the arguments of a command to strings
+func argStrings(args [][]byte) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = string(arg)
+	}
+	return strs
+}
+
+// parseTTL parses the EX seconds or PX milliseconds option of the set command
+func parseTTL(option, value string) (time.Duration, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("ERR invalid expire time in 'set' command")
+	}
+	switch strings.ToLower(option) {
+	case "ex":
+		return time.Duration(n) * time.Second, nil
+	case "px":
+		return time.Duration(n) * time.Millisecond, nil
+	default:
+		return 0, errors.New("ERR syntax error")
 	}
 }
@@ -0,0 +1,267 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/arazmj/gerdu/ratelimit"
+	"github.com/arazmj/gerdu/slrucache"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type ttlRecorder struct {
+	*lrucache.LRUCache
+	ttl time.Duration
+}
+
+func (c *ttlRecorder) PutWithTTL(key string, value string, ttl time.Duration) (created bool) {
+	c.ttl = ttl
+	return c.Put(key, value)
+}
+
+func startServer(t *testing.T, gerdu cache.UnImplementedCache) net.Conn {
+	server := newServer("127.0.0.1:0", nil, gerdu)
+	signal := make(chan error)
+	go func() {
+		_ = server.ListenServeAndSignal(signal)
+	}()
+	if err := <-signal; err != nil {
+		t.Fatalf("Failed to start the server %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to the server %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+func TestServer_Limits(t *testing.T) {
+	guard := ratelimit.NewGuard(ratelimit.Limits{Rate: 1, Burst: 2, MaxConns: 1})
+	server := newServer("127.0.0.1:0", guard, lrucache.NewCache(100))
+	signal := make(chan error)
+	go func() {
+		_ = server.ListenServeAndSignal(signal)
+	}()
+	if err := <-signal; err != nil {
+		t.Fatalf("Failed to start the server %v", err)
+	}
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectReplies(t, conn, "PING\r\nPING\r\nPING\r\n", "+PONG\r\n+PONG\r\n-ERR rate limit exceeded\r\n")
+
+	other, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_ = other.SetReadDeadline(time.Now().Add(time.Second))
+	reply, _ := bufio.NewReader(other).ReadString('\n')
+	if reply != "-ERR max number of clients reached\r\n" {
+		t.Errorf("Expected the connection over the limit to be rejected but got %q", reply)
+	}
+}
+
+func TestStartLimited(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := l.Addr().String()
+	l.Close()
+	shutdown := StartLimited(host, nil, nil, lrucache.NewCache(100))
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectReplies(t, conn, "PING\r\n", "+PONG\r\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("Expected the connections to close but got %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+	if conn, err := net.Dial("tcp", host); err == nil {
+		conn.Close()
+		t.Errorf("Expected the server to stop accepting the connections")
+	}
+}
+
+func expectReplies(t *testing.T, conn net.Conn, request string, replies string) {
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatalf("Failed to write the request %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, len(replies))
+	if _, err := io.ReadFull(bufio.NewReader(conn), buf); err != nil {
+		t.Fatalf("Failed to read the replies %v, got %q", err, buf)
+	}
+	if string(buf) != replies {
+		t.Errorf("Expected replies %q but got %q", replies, buf)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n")
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$5\r\nhello\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$5\r\nhello\r\n")
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$0\r\n\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\nb\r\n", "$0\r\n\r\n")
+	expectReplies(t, conn, "*1\r\n$6\r\nDBSIZE\r\n", ":2\r\n")
+	expectReplies(t, conn, "*4\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", ":2\r\n")
+	expectReplies(t, conn, "*1\r\n$6\r\nDBSIZE\r\n", ":0\r\n")
+	expectReplies(t, conn, "*5\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nEX\r\n$2\r\n10\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*3\r\n$5\r\nTOUCH\r\n$1\r\na\r\n$1\r\nb\r\n", ":1\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nSET\r\n$1\r\na\r\n",
+		"-ERR wrong number of arguments for 'SET' command\r\n")
+}
+
+func TestCommands_Expire(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*2\r\n$3\r\nTTL\r\n$1\r\na\r\n", ":-2\r\n")
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nTTL\r\n$1\r\na\r\n", ":-1\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nEXPIRE\r\n$1\r\na\r\n$3\r\n100\r\n", ":1\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nTTL\r\n$1\r\na\r\n", ":100\r\n")
+	expectReplies(t, conn, "*3\r\n$7\r\nPEXPIRE\r\n$1\r\na\r\n$6\r\n200000\r\n", ":1\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nPTTL\r\n$1\r\na\r\n", ":200000\r\n")
+	expectReplies(t, conn, "*2\r\n$7\r\nPERSIST\r\n$1\r\na\r\n", ":1\r\n")
+	expectReplies(t, conn, "*2\r\n$7\r\nPERSIST\r\n$1\r\na\r\n", ":0\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nTTL\r\n$1\r\na\r\n", ":-1\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nEXPIRE\r\n$1\r\nb\r\n$2\r\n10\r\n", ":0\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nEXPIRE\r\n$1\r\na\r\n$1\r\nx\r\n",
+		"-ERR value is not an integer or out of range\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nEXPIRE\r\n$1\r\na\r\n$1\r\n0\r\n", ":1\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n")
+}
+
+func TestCommands_Multi(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*5\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*4\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nc\r\n$1\r\nb\r\n",
+		"*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n")
+	expectReplies(t, conn, "*4\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n",
+		"-ERR wrong number of arguments for 'MSET' command\r\n")
+	expectReplies(t, conn, "*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n", ":2\r\n")
+	expectReplies(t, conn, "*1\r\n$6\r\nDBSIZE\r\n", ":0\r\n")
+}
+
+func TestCommands_Counters(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*2\r\n$4\r\nINCR\r\n$1\r\nn\r\n", ":1\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nINCRBY\r\n$1\r\nn\r\n$2\r\n10\r\n", ":11\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nDECR\r\n$1\r\nn\r\n", ":10\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nDECRBY\r\n$1\r\nn\r\n$2\r\n15\r\n", ":-5\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\nn\r\n", "$2\r\n-5\r\n")
+	expectReplies(t, conn, "*3\r\n$6\r\nINCRBY\r\n$1\r\nn\r\n$1\r\nx\r\n",
+		"-ERR value is not an integer or out of range\r\n")
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\ns\r\n$1\r\nx\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nINCR\r\n$1\r\ns\r\n",
+		"-ERR value is not an integer or out of range\r\n")
+}
+
+func TestCommands_Keys(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$3\r\nab1\r\n$1\r\n1\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nSCAN\r\n$1\r\n0\r\n", "*2\r\n$1\r\n0\r\n*1\r\n$3\r\nab1\r\n")
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$2\r\nb1\r\n$1\r\n1\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nKEYS\r\n$2\r\na*\r\n", "*1\r\n$3\r\nab1\r\n")
+	expectReplies(t, conn, "*4\r\n$4\r\nSCAN\r\n$1\r\n0\r\n$5\r\nMATCH\r\n$2\r\nb*\r\n",
+		"*2\r\n$1\r\n0\r\n*1\r\n$2\r\nb1\r\n")
+	expectReplies(t, conn, "*4\r\n$4\r\nSCAN\r\n$1\r\n0\r\n$5\r\nMATCH\r\n$2\r\nb[\r\n",
+		"-ERR invalid pattern\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nSCAN\r\n$1\r\nx\r\n", "-ERR invalid cursor\r\n")
+}
+
+func TestCommands_TTLNotSupported(t *testing.T) {
+	conn := startServer(t, slrucache.NewCache(100))
+
+	expectReplies(t, conn, "*5\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nEX\r\n$2\r\n10\r\n",
+		"-ERR expiration is not supported by the cache\r\n")
+}
+
+func TestPipeline(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn,
+		"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"+
+			"*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n"+
+			"*2\r\n$3\r\nGET\r\n$1\r\na\r\n"+
+			"*2\r\n$3\r\nDEL\r\n$1\r\nb\r\n"+
+			"*2\r\n$3\r\nGET\r\n$1\r\nb\r\n",
+		"+OK\r\n+OK\r\n$1\r\n1\r\n:1\r\n$-1\r\n")
+}
+
+func TestSetWithExpiration(t *testing.T) {
+	gerdu := &ttlRecorder{LRUCache: lrucache.NewCache(100)}
+	conn := startServer(t, gerdu)
+
+	expectReplies(t, conn, "*5\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nEX\r\n$2\r\n10\r\n", "+OK\r\n")
+	if gerdu.ttl != 10*time.Second {
+		t.Errorf("Expected ttl of 10s but got %v", gerdu.ttl)
+	}
+	expectReplies(t, conn, "*5\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\npx\r\n$3\r\n250\r\n", "+OK\r\n")
+	if gerdu.ttl != 250*time.Millisecond {
+		t.Errorf("Expected ttl of 250ms but got %v", gerdu.ttl)
+	}
+	expectReplies(t, conn, "*5\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nEX\r\n$1\r\n0\r\n",
+		"-ERR invalid expire time in 'set' command\r\n")
+	expectReplies(t, conn, "*5\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nXX\r\n$1\r\n1\r\n",
+		"-ERR syntax error\r\n")
+}
+
+func TestCommands_Exists(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*4\r\n$6\r\nEXISTS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\na\r\n", ":2\r\n")
+	expectReplies(t, conn, "*1\r\n$6\r\nEXISTS\r\n",
+		"-ERR wrong number of arguments for 'EXISTS' command\r\n")
+}
+
+func TestCommands_Hello(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "$-1\r\n")
+	expectReplies(t, conn, "*2\r\n$5\r\nHELLO\r\n$1\r\n4\r\n", "-NOPROTO unsupported protocol version\r\n")
+	expectReplies(t, conn, "*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n",
+		"%6\r\n$6\r\nserver\r\n$5\r\ngerdu\r\n$7\r\nversion\r\n$3\r\n0.1\r\n$5\r\nproto\r\n:3\r\n"+
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", "_\r\n")
+	expectReplies(t, conn, "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n", "-ERR DB index is out of range\r\n")
+}
+
+func TestCommands_Info(t *testing.T) {
+	conn := startServer(t, lrucache.NewCache(100))
+
+	expectReplies(t, conn, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n", "+OK\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nINFO\r\n$8\r\nkeyspace\r\n", "$26\r\n# Keyspace\r\ndb0:keys=1\r\n\r\n\r\n")
+	expectReplies(t, conn, "*2\r\n$3\r\nGET\r\n$1\r\nb\r\n", "$-1\r\n")
+	expectReplies(t, conn, "*2\r\n$4\r\nINFO\r\n$5\r\nstats\r\n",
+		"$63\r\n# Stats\r\nkeyspace_hits:0\r\nkeyspace_misses:1\r\nevicted_keys:0\r\n\r\n\r\n")
+}
@@ -0,0 +1,118 @@
+// Package ring implements a consistent hash ring with virtual nodes
+package ring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultReplicas is the default number of virtual nodes of each node
+const DefaultReplicas = 100
+
+// Ring maps the keys to the nodes by consistent hashing. Each node is placed
+// on the ring as a number of virtual nodes and a key belongs to the first
+// virtual node after its hash, so adding or removing a node only moves the
+// keys of its virtual nodes. It is safe for concurrent use
+type Ring struct {
+	sync.RWMutex
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]string
+	nodes    map[string]bool
+}
+
+// New Ring constructor, replicas is the number of virtual nodes of each
+// node, DefaultReplicas if it is not positive
+func New(replicas int, nodes ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{
+		replicas: replicas,
+		owners:   map[uint32]string{},
+		nodes:    map[string]bool{},
+	}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// Add adds the node to the ring
+func (r *Ring) Add(node string) {
+	defer r.Unlock()
+	r.Lock()
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+	r.rebuild()
+}
+
+// Remove removes the node from the ring
+func (r *Ring) Remove(node string) {
+	defer r.Unlock()
+	r.Lock()
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+	r.rebuild()
+}
+
+// rebuild places the virtual nodes of all the nodes on the ring, a hash
+// collision goes to the smallest node so the ring does not depend on the
+// order the nodes are added, the caller must hold the lock
+func (r *Ring) rebuild() {
+	r.hashes = r.hashes[:0]
+	r.owners = map[uint32]string{}
+	for node := range r.nodes {
+		for i := 0; i < r.replicas; i++ {
+			h := hash(strconv.Itoa(i) + "-" + node)
+			if owner, ok := r.owners[h]; ok {
+				if node < owner {
+					r.owners[h] = node
+				}
+				continue
+			}
+			r.owners[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Nodes returns the nodes of the ring
+func (r *Ring) Nodes() []string {
+	defer r.RUnlock()
+	r.RLock()
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Node returns the node the key belongs to, false if the ring is empty
+func (r *Ring) Node(key string) (node string, ok bool) {
+	defer r.RUnlock()
+	r.RLock()
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]], true
+}
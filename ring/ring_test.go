@@ -0,0 +1,73 @@
+package ring
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRing_Balance(t *testing.T) {
+	r := New(0, "n1", "n2", "n3", "n4", "n5")
+	counts := map[string]int{}
+	for i := 0; i < 50000; i++ {
+		node, _ := r.Node("key" + strconv.Itoa(i))
+		counts[node]++
+	}
+	for node, count := range counts {
+		if count < 5000 || count > 15000 {
+			t.Errorf("Expected about 10000 keys on %s but got %d", node, count)
+		}
+	}
+}
+
+func TestRing_Remap(t *testing.T) {
+	r := New(0, "n1", "n2", "n3", "n4")
+	before := map[string]string{}
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before[key], _ = r.Node(key)
+	}
+
+	r.Add("n5")
+	moved := 0
+	for key, node := range before {
+		after, _ := r.Node(key)
+		if after != node {
+			if after != "n5" {
+				t.Fatalf("Expected %s to move only to the new node but moved to %s", key, after)
+			}
+			moved++
+		}
+	}
+	if moved < 1000 || moved > 3000 {
+		t.Errorf("Expected about a fifth of the keys to move but %d moved", moved)
+	}
+
+	r.Remove("n5")
+	r.Remove("n2")
+	for key, node := range before {
+		after, _ := r.Node(key)
+		if node != "n2" && after != node {
+			t.Fatalf("Expected %s to stay on %s but moved to %s", key, node, after)
+		}
+	}
+	if nodes := r.Nodes(); strings.Join(nodes, ",") != "n1,n3,n4" {
+		t.Errorf("Expected n1,n3,n4 but got %v", nodes)
+	}
+}
+
+func TestRing_Empty(t *testing.T) {
+	r := New(0)
+	if _, ok := r.Node("1"); ok {
+		t.Errorf("Expected an empty ring to have no node")
+	}
+	r.Add("a")
+	r.Add("a")
+	if node, ok := r.Node("1"); !ok || node != "a" {
+		t.Errorf("Expected a but got %s", node)
+	}
+	r.Remove("a")
+	if nodes := r.Nodes(); len(nodes) != 0 {
+		t.Errorf("Expected no nodes but got %v", nodes)
+	}
+}
@@ -0,0 +1,129 @@
+// Package shardedcache implements a cache that spreads the keys over several
+// independently locked caches, so the operations on different shards do not
+// wait for each other
+package shardedcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+)
+
+// shard is one of the wrapped caches with its counters
+type shard struct {
+	cache.UnImplementedCache
+	hits   uint64
+	misses uint64
+}
+
+// ShardedCache data structure, each key belongs to the shard of its hash
+type ShardedCache struct {
+	cache.UnImplementedCache
+	shards []*shard
+}
+
+// Stats are the counters and the number of entries of a shard or of all of them
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	Len    int
+}
+
+// NewCache ShardedCache constructor with n LRU shards that share the
+// capacity, the metrics of each shard are labeled shard-i
+func NewCache(n int, capacity bytesize.ByteSize) *ShardedCache {
+	if n < 1 {
+		n = 1
+	}
+	return NewShardedCache(n, func(i int) cache.UnImplementedCache {
+		return lrucache.NewCache(capacity/bytesize.ByteSize(n),
+			cache.WithMetricsName("shard-"+strconv.Itoa(i)))
+	})
+}
+
+// NewShardedCache wraps n caches that are created by newShard, at least one
+func NewShardedCache(n int, newShard func(i int) cache.UnImplementedCache) *ShardedCache {
+	if n < 1 {
+		n = 1
+	}
+	c := &ShardedCache{shards: make([]*shard, n)}
+	for i := range c.shards {
+		c.shards[i] = &shard{UnImplementedCache: newShard(i)}
+	}
+	return c
+}
+
+// shard returns the shard of the key
+func (c *ShardedCache) shard(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// Get returns the value for the key from its shard
+func (c *ShardedCache) Get(key string) (value string, ok bool) {
+	s := c.shard(key)
+	if value, ok = s.Get(key); ok {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+	return value, ok
+}
+
+// Put updates or inserts the entry in its shard
+func (c *ShardedCache) Put(key string, value string) (created bool) {
+	return c.shard(key).Put(key, value)
+}
+
+// Delete deletes the key from its shard
+func (c *ShardedCache) Delete(key string) (ok bool) {
+	return c.shard(key).Delete(key)
+}
+
+// Len returns the number of entries of all the shards
+func (c *ShardedCache) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		n += shardLen(s)
+	}
+	return n
+}
+
+// Stats returns the counters and the number of entries of all the shards
+// and of each of them
+func (c *ShardedCache) Stats() (total Stats, shards []Stats) {
+	shards = make([]Stats, len(c.shards))
+	for i, s := range c.shards {
+		shards[i] = Stats{
+			Hits:   atomic.LoadUint64(&s.hits),
+			Misses: atomic.LoadUint64(&s.misses),
+			Len:    shardLen(s),
+		}
+		total.Hits += shards[i].Hits
+		total.Misses += shards[i].Misses
+		total.Len += shards[i].Len
+	}
+	return total, shards
+}
+
+// Close closes all the shards and returns the first error
+func (c *ShardedCache) Close() error {
+	var err error
+	for _, s := range c.shards {
+		if e := cache.Close(s.UnImplementedCache); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func shardLen(s *shard) int {
+	if sizer, ok := s.UnImplementedCache.(cache.Sizer); ok {
+		return sizer.Len()
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package shardedcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestShardedCache(t *testing.T) {
+	c := NewCache(4, 400)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if !c.Put(key, key) {
+			t.Errorf("Expected %s to be created", key)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if value, ok := c.Get(key); !ok || value != key {
+			t.Errorf("Expected %s but got %s", key, value)
+		}
+	}
+	c.Get("missing")
+	if !c.Delete("0") || c.Delete("0") {
+		t.Errorf("Expected 0 to be deleted once")
+	}
+	total, shards := c.Stats()
+	if total.Hits != 100 || total.Misses != 1 || total.Len != 99 || c.Len() != 99 {
+		t.Errorf("Expected 100 hits, 1 miss and 99 entries but got %+v", total)
+	}
+	for i, s := range shards {
+		if s.Len == 0 || s.Len == 99 {
+			t.Errorf("Expected the keys to spread over the shards but shard %d has %d", i, s.Len)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Expected the shards to close but got %v", err)
+	}
+}
+
+func TestShardedCache_ThreadSafety(t *testing.T) {
+	c := NewShardedCache(8, func(i int) cache.UnImplementedCache {
+		return lrucache.NewCache(1000)
+	})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(g*1000 + i)
+				c.Put(key, "x")
+				c.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if total, _ := c.Stats(); total.Hits+total.Misses != 8000 {
+		t.Errorf("Expected 8000 gets but got %+v", total)
+	}
+}
+
+// benchmarkParallel runs the gets and puts of a read-heavy
+// workload from all the processors
+func benchmarkParallel(b *testing.B, c cache.UnImplementedCache) {
+	for i := 0; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "x")
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := strconv.Itoa(i % 1000)
+			if i%10 == 0 {
+				c.Put(key, "x")
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkShardedCache_Parallel(b *testing.B) {
+	benchmarkParallel(b, NewCache(16, 1<<20))
+}
+
+func BenchmarkLRUCache_Parallel(b *testing.B) {
+	benchmarkParallel(b, lrucache.NewCache(1<<20))
+}
@@ -0,0 +1,234 @@
+// Package slrucache implements SLRU (Segmented Least Recently Used) cache
+package slrucache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+// defaultProtectedRatio is the default share of the capacity reserved for
+// the protected segment
+const defaultProtectedRatio = 0.8
+
+// SLRUCache data structure, new entries enter the probationary segment and
+// a second hit promotes them to the protected segment. When the protected
+// segment is full its least recently used entry is demoted back to the
+// probationary segment, so one-hit entries of a scan never push out the
+// entries that were accessed more than once.
+type SLRUCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	node          map[string]*dlinklist.Node
+	probation     *dlinklist.DLinkedList
+	protected     *dlinklist.DLinkedList
+	capacity      bytesize.ByteSize
+	protectedCap  bytesize.ByteSize
+	probationSize bytesize.ByteSize
+	protectedSize bytesize.ByteSize
+	counters      *metrics.Counters
+}
+
+// NewCache SLRUCache constructor
+func NewCache(capacity bytesize.ByteSize) *SLRUCache {
+	return NewCacheWithRatio(capacity, defaultProtectedRatio)
+}
+
+// NewCacheWithRatio SLRUCache constructor, protectedRatio is the share of
+// the capacity for the protected segment and the rest is for the
+// probationary one, it is clamped between 0 and 1
+func NewCacheWithRatio(capacity bytesize.ByteSize, protectedRatio float64) *SLRUCache {
+	if protectedRatio < 0 {
+		protectedRatio = 0
+	}
+	if protectedRatio > 1 {
+		protectedRatio = 1
+	}
+	return &SLRUCache{
+		node:         map[string]*dlinklist.Node{},
+		probation:    dlinklist.NewLinkedList(),
+		protected:    dlinklist.NewLinkedList(),
+		capacity:     capacity,
+		protectedCap: bytesize.ByteSize(float64(capacity) * protectedRatio),
+		counters:     metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+}
+
+// Get returns the value for the key, a hit on a probationary entry
+// promotes it to the protected segment
+func (c *SLRUCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	node, ok := c.node[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.hit(node)
+	return node.Value, true
+}
+
+// Put updates or inserts a new entry, new entries are added to the
+// probationary segment and updates count as a hit
+func (c *SLRUCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	if node, ok := c.node[key]; ok {
+		c.resize(node, bytesize.ByteSize(len(value)-len(node.Value)))
+		node.Value = value
+		c.hit(node)
+		c.evict()
+		return false
+	}
+	c.counters.Adds.Inc()
+	node := &dlinklist.Node{Key: key, Value: value, Freq: 1}
+	c.node[key] = node
+	c.probation.AddNode(node)
+	c.probationSize += bytesize.ByteSize(len(value))
+	c.evict()
+	return true
+}
+
+// Delete deletes the key from the cache
+func (c *SLRUCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	node, ok := c.node[key]
+	if !ok {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	c.unlink(node)
+	delete(c.node, key)
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *SLRUCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *SLRUCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.node)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *SLRUCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.probationSize+c.protectedSize, len(c.node))
+}
+
+// hit moves the node to the head of the protected segment and demotes
+// the least recently used protected entries if the segment overflows.
+// The Freq of a node is 1 in the probationary segment and 2 in the
+// protected one
+func (c *SLRUCache) hit(node *dlinklist.Node) {
+	c.unlink(node)
+	node.Freq = 2
+	c.protected.AddNode(node)
+	c.protectedSize += bytesize.ByteSize(len(node.Value))
+	for c.protectedSize > c.protectedCap && c.protected.Size() > 1 {
+		tail := c.protected.PopTail()
+		c.protectedSize -= bytesize.ByteSize(len(tail.Value))
+		tail.Freq = 1
+		c.probation.AddNode(tail)
+		c.probationSize += bytesize.ByteSize(len(tail.Value))
+	}
+}
+
+// unlink removes the node from its segment
+func (c *SLRUCache) unlink(node *dlinklist.Node) {
+	if node.Freq > 1 {
+		c.protected.RemoveNode(node)
+		c.protectedSize -= bytesize.ByteSize(len(node.Value))
+	} else {
+		c.probation.RemoveNode(node)
+		c.probationSize -= bytesize.ByteSize(len(node.Value))
+	}
+}
+
+// resize adjusts the size of the segment the node belongs to
+func (c *SLRUCache) resize(node *dlinklist.Node, delta bytesize.ByteSize) {
+	if node.Freq > 1 {
+		c.protectedSize += delta
+	} else {
+		c.probationSize += delta
+	}
+}
+
+// evict pops the least recently used probationary entries, and the
+// protected ones once probation is empty, until the size fits in the
+// capacity. It stops and counts an anomaly if both segments are empty
+// while there are entries
+func (c *SLRUCache) evict() {
+	for c.probationSize+c.protectedSize > c.capacity && len(c.node) > 0 {
+		var tail *dlinklist.Node
+		if c.probation.Size() > 0 {
+			tail = c.probation.PopTail()
+			c.probationSize -= bytesize.ByteSize(len(tail.Value))
+		} else if tail = c.protected.PopTail(); tail != nil {
+			c.protectedSize -= bytesize.ByteSize(len(tail.Value))
+		} else {
+			metrics.Anomalies.Inc()
+			return
+		}
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		delete(c.node, tail.Key)
+	}
+}
+
+func (c *SLRUCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := make(map[string]string)
+
+	for k, v := range c.node {
+		o[k] = v.Value
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+func (c *SLRUCache) Restore(closer io.ReadCloser) error {
+	// Set the state from the snapshot as it is decoded, no lock required
+	// according to Hashicorp docs.
+	return cache.ReadSnapshot(closer, func(k, v string) {
+		c.Put(k, v)
+	})
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
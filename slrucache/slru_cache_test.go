@@ -0,0 +1,126 @@
+package slrucache
+
+import (
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/arazmj/gerdu/metrics"
+	"strconv"
+	"testing"
+)
+
+func TestSLRUCache(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	if value, ok := cache.Get("1"); ok {
+		t.Errorf("Expected value 1 to be evicted but got %s %t", value, ok)
+	}
+	if value, ok := cache.Get("2"); value != "2" || !ok {
+		t.Errorf("Expected value 2 but got %s %t", value, ok)
+	}
+	if value, ok := cache.Get("3"); value != "3" || !ok {
+		t.Errorf("Expected value 3 but got %s %t", value, ok)
+	}
+}
+
+func TestSLRUCache_ScanResistance(t *testing.T) {
+	cache := NewCache(10)
+	lru := lrucache.NewCache(10)
+	hot := []string{"a", "b", "c"}
+	for _, key := range hot {
+		cache.Put(key, key)
+		cache.Get(key)
+		lru.Put(key, key)
+		lru.Get(key)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i % 10)
+		cache.Put(key, key)
+		lru.Put(key, key)
+	}
+
+	for _, key := range hot {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Expected the hot key %s to survive the scan", key)
+		}
+		if _, ok := lru.Get(key); ok {
+			t.Errorf("Expected the hot key %s to be evicted from LRU by the scan", key)
+		}
+	}
+}
+
+func TestSLRUCache_Demotion(t *testing.T) {
+	cache := NewCache(5)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		cache.Put(key, key)
+		cache.Get(key)
+	}
+	if cache.protectedSize != 4 || cache.probationSize != 1 {
+		t.Errorf("Expected protected segment of 4 and probation of 1 but got %d %d",
+			cache.protectedSize, cache.probationSize)
+	}
+	if node := cache.node["a"]; node.Freq != 1 {
+		t.Errorf("Expected a to be demoted to probation")
+	}
+
+	cache.Put("f", "f")
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Expected the demoted a to be evicted first")
+	}
+	if cache.Len() != 5 {
+		t.Errorf("Expected 5 entries but got %d", cache.Len())
+	}
+}
+
+func TestSLRUCache_Delete(t *testing.T) {
+	cache := NewCache(10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Get("2")
+	if !cache.Delete("1") || !cache.Delete("2") {
+		t.Fatal("Expected the keys to be deleted")
+	}
+	if cache.Delete("1") {
+		t.Fatal("Expected the key to be already deleted")
+	}
+	if cache.probationSize != 0 || cache.protectedSize != 0 || cache.Len() != 0 {
+		t.Errorf("Expected an empty cache")
+	}
+}
+
+func TestSLRUCache_EvictInconsistent(t *testing.T) {
+	cache := NewCache(2)
+	cache.Put("1", "1")
+	// an entry that is in neither segment
+	cache.node["lost"] = &dlinklist.Node{Key: "lost", Value: "lost"}
+	cache.protectedSize = 10
+	anomalies := metrics.Value(metrics.Anomalies)
+	cache.Put("2", "2")
+	if metrics.Value(metrics.Anomalies) != anomalies+1 {
+		t.Errorf("Expected the eviction to stop on an anomaly")
+	}
+}
+
+func TestSLRUCache_Ratio(t *testing.T) {
+	cache := NewCacheWithRatio(10, 0.5)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		cache.Put(key, key)
+		cache.Get(key)
+	}
+	if cache.protectedSize != 5 || cache.probationSize != 2 {
+		t.Errorf("Expected protected segment of 5 and probation of 2 but got %d %d",
+			cache.protectedSize, cache.probationSize)
+	}
+
+	// without a protected segment every hit is demoted right away
+	cache = NewCacheWithRatio(3, -1)
+	cache.Put("a", "a")
+	cache.Get("a")
+	cache.Put("b", "b")
+	cache.Get("b")
+	if cache.protected.Size() != 1 || cache.node["a"].Freq != 1 {
+		t.Errorf("Expected only the last hit to stay protected")
+	}
+}
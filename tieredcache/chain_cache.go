@@ -0,0 +1,142 @@
+package tieredcache
+
+import (
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// WritePolicy is how a ChainCache applies the puts to its front tier
+type WritePolicy int
+
+const (
+	// WriteThrough writes the puts to the back tier and then to the front
+	// tier, so the next read of the key is served by the front tier
+	WriteThrough WritePolicy = iota
+	// WriteInvalidate writes the puts to the back tier and drops the copy
+	// of the front tier, so the next read of the key fetches it again
+	WriteInvalidate
+)
+
+// ParseWritePolicy returns the policy of the name, through or invalidate
+func ParseWritePolicy(name string) (WritePolicy, error) {
+	switch strings.ToLower(name) {
+	case "through":
+		return WriteThrough, nil
+	case "invalidate":
+		return WriteInvalidate, nil
+	}
+	return WriteThrough, fmt.Errorf("unknown write policy %s", name)
+}
+
+// chainStripes is the number of the locks a ChainCache stripes the keys over
+const chainStripes = 64
+
+// ChainCache chains a front tier to a back tier that holds all the entries,
+// typically a small local cache in front of a remote cluster through
+// client.Cache. A miss of the front tier is read through from the back tier
+// and promoted to the front tier, the puts and the deletes are applied to
+// the back tier first. Unlike TieredCache the front tier is a copy of a part
+// of the back tier, an entry evicted from it is not demoted. The writes of
+// the other clients of the back tier are not seen by the front tier, so its
+// entries should expire
+type ChainCache struct {
+	l1       cache.UnImplementedCache
+	l2       cache.UnImplementedCache
+	policy   WritePolicy
+	locks    [chainStripes]sync.Mutex
+	l1Hits   uint64
+	l2Hits   uint64
+	misses   uint64
+	promotes uint64
+}
+
+// the cache must satisfy the interfaces used by the servers
+var (
+	_ cache.UnImplementedCache = (*ChainCache)(nil)
+	_ cache.Closer             = (*ChainCache)(nil)
+)
+
+// NewChain ChainCache constructor with the front tier l1, the back tier l2
+// and the write policy of the front tier
+func NewChain(l1, l2 cache.UnImplementedCache, policy WritePolicy) *ChainCache {
+	return &ChainCache{l1: l1, l2: l2, policy: policy}
+}
+
+// lock returns the lock of the key, it orders a promotion after the writes
+// of the key so a stale value read from the back tier is not promoted
+func (c *ChainCache) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &c.locks[h.Sum32()%chainStripes]
+}
+
+// Get checks the front tier first, an entry found in the back tier is
+// promoted to the front tier
+func (c *ChainCache) Get(key string) (value string, ok bool) {
+	if value, ok = c.l1.Get(key); ok {
+		atomic.AddUint64(&c.l1Hits, 1)
+		return value, true
+	}
+	mu := c.lock(key)
+	defer mu.Unlock()
+	mu.Lock()
+	if value, ok = c.l2.Get(key); ok {
+		atomic.AddUint64(&c.l2Hits, 1)
+		atomic.AddUint64(&c.promotes, 1)
+		c.l1.Put(key, value)
+		return value, true
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return "", false
+}
+
+// Put writes the entry to the back tier and then applies the write policy
+// to the front tier, created is reported by the back tier
+func (c *ChainCache) Put(key, value string) (created bool) {
+	mu := c.lock(key)
+	defer mu.Unlock()
+	mu.Lock()
+	created = c.l2.Put(key, value)
+	if c.policy == WriteInvalidate {
+		c.l1.Delete(key)
+	} else {
+		c.l1.Put(key, value)
+	}
+	return created
+}
+
+// Delete deletes the key from the back tier and then from the front tier
+func (c *ChainCache) Delete(key string) (ok bool) {
+	mu := c.lock(key)
+	defer mu.Unlock()
+	mu.Lock()
+	ok2 := c.l2.Delete(key)
+	ok1 := c.l1.Delete(key)
+	return ok1 || ok2
+}
+
+// Stats returns the counters and the number of entries of each tier, a
+// tier that cannot count its entries reports none
+func (c *ChainCache) Stats() Stats {
+	return Stats{
+		L1Hits:   atomic.LoadUint64(&c.l1Hits),
+		L2Hits:   atomic.LoadUint64(&c.l2Hits),
+		Misses:   atomic.LoadUint64(&c.misses),
+		Promotes: atomic.LoadUint64(&c.promotes),
+		L1Len:    tierLen(c.l1),
+		L2Len:    tierLen(c.l2),
+	}
+}
+
+// Close closes both tiers and returns the first error
+func (c *ChainCache) Close() error {
+	err := cache.Close(c.l1)
+	if e := cache.Close(c.l2); err == nil {
+		err = e
+	}
+	return err
+}
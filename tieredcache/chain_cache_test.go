@@ -0,0 +1,125 @@
+package tieredcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// remote counts the reads of the back tier
+type remote struct {
+	*lrucache.LRUCache
+	mu   sync.Mutex
+	gets int
+}
+
+func (r *remote) Get(key string) (string, bool) {
+	r.mu.Lock()
+	r.gets++
+	r.mu.Unlock()
+	return r.LRUCache.Get(key)
+}
+
+func newChain(policy WritePolicy) (*ChainCache, *lrucache.LRUCache, *remote) {
+	l1 := lrucache.NewCache(100, cache.WithMaxEntries(2))
+	l2 := &remote{LRUCache: lrucache.NewCache(100)}
+	return NewChain(l1, l2, policy), l1, l2
+}
+
+func TestChainCache_WriteThrough(t *testing.T) {
+	c, l1, l2 := newChain(WriteThrough)
+	if !c.Put("1", "1") || c.Put("1", "one") {
+		t.Errorf("Expected created to be reported by the back tier")
+	}
+	if value, ok := l1.Get("1"); !ok || value != "one" {
+		t.Errorf("Expected the put to be written through to L1 but got %s", value)
+	}
+	if value, ok := c.Get("1"); !ok || value != "one" || l2.gets != 0 {
+		t.Errorf("Expected one from L1 without a read of L2 but got %s after %d reads", value, l2.gets)
+	}
+	c.Put("2", "2")
+	c.Put("3", "3")
+
+	// 1 is evicted from L1 but not from L2, it is read through and promoted
+	if _, ok := l1.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted from L1")
+	}
+	if value, ok := c.Get("1"); !ok || value != "one" || l2.gets != 1 {
+		t.Errorf("Expected one read through from L2 but got %s", value)
+	}
+	if _, ok := l1.Get("1"); !ok {
+		t.Errorf("Expected 1 to be promoted to L1")
+	}
+	c.Get("4")
+	stats := c.Stats()
+	if stats.L1Hits != 1 || stats.L2Hits != 1 || stats.Misses != 1 || stats.Promotes != 1 || stats.Demotes != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.L1Len != 2 || stats.L2Len != 3 {
+		t.Errorf("Expected L2 to hold all the entries but got %+v", stats)
+	}
+
+	if !c.Delete("1") || c.Delete("1") {
+		t.Errorf("Expected 1 to be deleted once")
+	}
+	if _, ok := c.Get("1"); ok {
+		t.Errorf("Expected 1 to be deleted from both tiers")
+	}
+}
+
+func TestChainCache_WriteInvalidate(t *testing.T) {
+	c, l1, l2 := newChain(WriteInvalidate)
+	c.Put("1", "1")
+	if _, ok := l1.Get("1"); ok {
+		t.Errorf("Expected the put not to be written to L1")
+	}
+	c.Get("1")
+	c.Put("1", "one")
+	if _, ok := l1.Get("1"); ok {
+		t.Errorf("Expected the put to invalidate the copy of L1")
+	}
+	if value, ok := c.Get("1"); !ok || value != "one" || l2.gets != 2 {
+		t.Errorf("Expected one read through from L2 but got %s", value)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close failed %v", err)
+	}
+}
+
+func TestChainCache_Concurrent(t *testing.T) {
+	c, _, _ := newChain(WriteInvalidate)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				c.Put("key", strconv.Itoa(i))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				c.Get("key")
+			}
+		}()
+	}
+	wg.Wait()
+	c.Put("key", "last")
+	if value, ok := c.Get("key"); !ok || value != "last" {
+		t.Errorf("Expected the last put but got %s", value)
+	}
+}
+
+func TestParseWritePolicy(t *testing.T) {
+	for name, expected := range map[string]WritePolicy{"through": WriteThrough, "INVALIDATE": WriteInvalidate} {
+		if policy, err := ParseWritePolicy(name); err != nil || policy != expected {
+			t.Errorf("Expected %s to be %d but got %d %v", name, expected, policy, err)
+		}
+	}
+	if _, err := ParseWritePolicy("back"); err == nil {
+		t.Errorf("Expected an unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+// Package tieredcache implements a two-tier cache, a small front tier for
+// the recently used entries backed by a larger tier for the frequently used
+// ones, and a chain of a local front tier to a back tier such as a remote cluster
+package tieredcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lfucache"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"sync/atomic"
+)
+
+// TieredCache data structure
+type TieredCache struct {
+	cache.UnImplementedCache
+	l1       cache.UnImplementedCache
+	l2       cache.UnImplementedCache
+	l1Hits   uint64
+	l2Hits   uint64
+	misses   uint64
+	demotes  uint64
+	promotes uint64
+}
+
+// Stats are the counters and the number of entries of each tier
+type Stats struct {
+	L1Hits   uint64
+	L2Hits   uint64
+	Misses   uint64
+	Demotes  uint64
+	Promotes uint64
+	L1Len    int
+	L2Len    int
+}
+
+// NewCache TieredCache constructor with an LRU front tier of l1 capacity
+// and an LFU back tier of l2 capacity
+func NewCache(l1, l2 bytesize.ByteSize) *TieredCache {
+	return NewTieredCache(func(demote cache.Option) cache.UnImplementedCache {
+		return lrucache.NewCache(l1, demote, cache.WithMetricsName("l1"))
+	}, lfucache.NewCache(l2, cache.WithMetricsName("l2")))
+}
+
+// NewTieredCache wraps two caches, newL1 creates the front tier with the
+// option that demotes its evicted entries to l2
+func NewTieredCache(newL1 func(demote cache.Option) cache.UnImplementedCache,
+	l2 cache.UnImplementedCache) *TieredCache {
+	c := &TieredCache{l2: l2}
+	c.l1 = newL1(cache.WithOnEvict(c.demote))
+	return c
+}
+
+// demote moves an entry evicted from the front tier to the back tier, it is
+// called while the front tier is locked so it must not call the front tier
+func (c *TieredCache) demote(key, value string) {
+	atomic.AddUint64(&c.demotes, 1)
+	c.l2.Put(key, value)
+}
+
+// Get checks the front tier first, an entry found in the back tier is
+// promoted to the front tier and its copy is kept in the back tier
+func (c *TieredCache) Get(key string) (value string, ok bool) {
+	if value, ok = c.l1.Get(key); ok {
+		atomic.AddUint64(&c.l1Hits, 1)
+		return value, true
+	}
+	if value, ok = c.l2.Get(key); ok {
+		atomic.AddUint64(&c.l2Hits, 1)
+		atomic.AddUint64(&c.promotes, 1)
+		c.l1.Put(key, value)
+		return value, true
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return "", false
+}
+
+// Put drops the stale copy of the back tier if there is any and writes
+// the entry to the front tier, the stale copy is dropped first since the
+// entry itself may be demoted if it does not fit in the front tier
+func (c *TieredCache) Put(key, value string) (created bool) {
+	stale := c.l2.Delete(key)
+	return c.l1.Put(key, value) && !stale
+}
+
+// Delete deletes the key from both tiers
+func (c *TieredCache) Delete(key string) (ok bool) {
+	ok1 := c.l1.Delete(key)
+	ok2 := c.l2.Delete(key)
+	return ok1 || ok2
+}
+
+// Len returns the number of entries of both tiers, a promoted
+// entry is counted in each tier that holds a copy of it
+func (c *TieredCache) Len() int {
+	return tierLen(c.l1) + tierLen(c.l2)
+}
+
+// Stats returns the counters and the number of entries of each tier
+func (c *TieredCache) Stats() Stats {
+	return Stats{
+		L1Hits:   atomic.LoadUint64(&c.l1Hits),
+		L2Hits:   atomic.LoadUint64(&c.l2Hits),
+		Misses:   atomic.LoadUint64(&c.misses),
+		Demotes:  atomic.LoadUint64(&c.demotes),
+		Promotes: atomic.LoadUint64(&c.promotes),
+		L1Len:    tierLen(c.l1),
+		L2Len:    tierLen(c.l2),
+	}
+}
+
+// Close closes both tiers and returns the first error
+func (c *TieredCache) Close() error {
+	err := cache.Close(c.l1)
+	if e := cache.Close(c.l2); err == nil {
+		err = e
+	}
+	return err
+}
+
+func tierLen(tier cache.UnImplementedCache) int {
+	if sizer, ok := tier.(cache.Sizer); ok {
+		return sizer.Len()
+	}
+	return 0
+}
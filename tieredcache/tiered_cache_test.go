@@ -0,0 +1,96 @@
+package tieredcache
+
+import (
+	"testing"
+)
+
+func TestTieredCache_Demote(t *testing.T) {
+	cache := NewCache(2, 10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	if _, ok := cache.l1.Get("1"); ok {
+		t.Errorf("Expected 1 to be evicted from L1")
+	}
+	if value, ok := cache.l2.Get("1"); !ok || value != "1" {
+		t.Errorf("Expected 1 to be demoted to L2 but got %s %t", value, ok)
+	}
+	stats := cache.Stats()
+	if stats.Demotes != 1 || stats.L1Len != 2 || stats.L2Len != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestTieredCache_Promote(t *testing.T) {
+	cache := NewCache(2, 10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	if value, ok := cache.Get("1"); !ok || value != "1" {
+		t.Errorf("Expected 1 from L2 but got %s %t", value, ok)
+	}
+	if _, ok := cache.l1.Get("1"); !ok {
+		t.Errorf("Expected 1 to be promoted to L1")
+	}
+	if _, ok := cache.l2.Get("2"); !ok {
+		t.Errorf("Expected 2 to be demoted by the promotion")
+	}
+	if _, ok := cache.Get("1"); !ok {
+		t.Errorf("Expected 1 to be found in L1")
+	}
+	cache.Get("4")
+
+	stats := cache.Stats()
+	if stats.L1Hits != 1 || stats.L2Hits != 1 || stats.Misses != 1 || stats.Promotes != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if cache.Len() != stats.L1Len+stats.L2Len {
+		t.Errorf("Expected Len to aggregate the tiers")
+	}
+}
+
+func TestTieredCache_Delete(t *testing.T) {
+	cache := NewCache(2, 10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	cache.Get("1")
+
+	if !cache.Delete("1") {
+		t.Errorf("Expected 1 to be deleted")
+	}
+	if _, ok := cache.l1.Get("1"); ok {
+		t.Errorf("Expected 1 to be deleted from L1")
+	}
+	if _, ok := cache.l2.Get("1"); ok {
+		t.Errorf("Expected 1 to be deleted from L2")
+	}
+	if _, ok := cache.Get("1"); ok {
+		t.Errorf("Expected 1 to be deleted")
+	}
+	if cache.Delete("1") {
+		t.Errorf("Expected no deleted key")
+	}
+}
+
+func TestTieredCache_PutStale(t *testing.T) {
+	cache := NewCache(2, 10)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+
+	if cache.Put("1", "a") {
+		t.Errorf("Expected 1 to be updated")
+	}
+	if _, ok := cache.l2.Get("1"); ok {
+		t.Errorf("Expected the stale copy of 1 to be dropped")
+	}
+	if value, _ := cache.Get("1"); value != "a" {
+		t.Errorf("Expected a but got %s", value)
+	}
+	if !cache.Put("4", "4") {
+		t.Errorf("Expected 4 to be created")
+	}
+}
@@ -0,0 +1,337 @@
+// Package tinylfucache implements W-TinyLFU (Window Tiny Least Frequently Used) cache
+package tinylfucache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/internal/admission"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+const (
+	// windowRatio is the share of the capacity for the admission window
+	windowRatio = 0.01
+	// protectedRatio is the share of the main space for the protected segment
+	protectedRatio = 0.8
+	// maxSketchKeys bounds the number of keys the sketch is sized for
+	maxSketchKeys = 1 << 20
+	// sampleFactor is the number of accesses per sketch key before the
+	// sketch is halved
+	sampleFactor = 10
+	// doorkeeperFPRate is the false positive rate of the doorkeeper
+	doorkeeperFPRate = 0.01
+)
+
+// segment is one of the three segments of W-TinyLFU
+type segment int
+
+const (
+	// window holds the new entries in LRU order
+	window segment = iota
+	// probation holds the admitted entries that are not hit since
+	probation
+	// protected holds the admitted entries that are hit again
+	protected
+)
+
+type entry struct {
+	node    *dlinklist.Node
+	segment segment
+}
+
+// TinyLFUCache data structure, new entries enter a small LRU window and the
+// entries that leave it compete with the next victim of the main segmented
+// LRU, the one estimated to be accessed less often is evicted. The access
+// frequencies are estimated by a count-min sketch that is halved after a
+// sample of accesses instead of being kept per entry, and a doorkeeper bloom
+// filter keeps the keys that are accessed once out of the sketch
+type TinyLFUCache struct {
+	sync.Mutex
+	cache.UnImplementedCache
+	entries   map[string]*entry
+	segments  [3]*dlinklist.DLinkedList
+	sizes     [3]bytesize.ByteSize
+	capacity  bytesize.ByteSize
+	windowCap bytesize.ByteSize
+	protCap   bytesize.ByteSize
+	sketch    *admission.Sketch
+	keeper    *admission.Doorkeeper
+	counters  *metrics.Counters
+}
+
+// NewCache TinyLFUCache constructor
+func NewCache(capacity bytesize.ByteSize) *TinyLFUCache {
+	windowCap := bytesize.ByteSize(float64(capacity) * windowRatio)
+	if windowCap < 1 && capacity > 0 {
+		windowCap = 1
+	}
+	keys := maxSketchKeys
+	if capacity < maxSketchKeys {
+		keys = int(capacity)
+	}
+	c := &TinyLFUCache{
+		entries:   map[string]*entry{},
+		capacity:  capacity,
+		windowCap: windowCap,
+		protCap:   bytesize.ByteSize(float64(capacity-windowCap) * protectedRatio),
+		sketch:    admission.NewSketch(keys * sampleFactor),
+		keeper:    admission.NewDoorkeeper(keys, doorkeeperFPRate, 0),
+		counters:  metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+	for i := range c.segments {
+		c.segments[i] = dlinklist.NewLinkedList()
+	}
+	return c
+}
+
+// cost sizes an entry by the length of its value, at least a byte
+func cost(value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+// record counts an access of the key, the first one only in the doorkeeper
+func (c *TinyLFUCache) record(key string) {
+	if c.keeper.Seen(key) && c.sketch.Increment(key) {
+		c.keeper.Reset()
+	}
+}
+
+// frequency estimates the number of accesses of the key
+func (c *TinyLFUCache) frequency(key string) uint32 {
+	freq := c.sketch.Estimate(key)
+	if c.keeper.Contains(key) {
+		freq++
+	}
+	return freq
+}
+
+// Get returns the value for the key, a hit on a probationary entry
+// promotes it to the protected segment
+func (c *TinyLFUCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	c.record(key)
+	e, ok := c.entries[key]
+	if !ok {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	c.hit(e)
+	return e.node.Value, true
+}
+
+// Put updates or inserts a new entry, a new entry enters the window and an
+// update counts as a hit. An entry larger than the capacity is rejected
+func (c *TinyLFUCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	size := cost(value)
+	if size > c.capacity {
+		return false
+	}
+	c.record(key)
+	e, ok := c.entries[key]
+	if ok {
+		c.sizes[e.segment] += size - bytesize.ByteSize(e.node.Size)
+		e.node.Value = value
+		e.node.Size = int64(size)
+		c.hit(e)
+	} else {
+		c.counters.Adds.Inc()
+		e = &entry{node: &dlinklist.Node{Key: key, Value: value, Size: int64(size)}}
+		c.entries[key] = e
+		c.link(e, window)
+	}
+	c.evict()
+	return !ok
+}
+
+// hit moves the entry to the head of its segment, a probationary entry
+// is promoted to the protected segment and the least recently used
+// protected entries are demoted while the segment overflows
+func (c *TinyLFUCache) hit(e *entry) {
+	to := e.segment
+	if to == probation {
+		to = protected
+	}
+	c.unlink(e)
+	c.link(e, to)
+	for c.sizes[protected] > c.protCap && c.segments[protected].Size() > 1 {
+		c.move(c.entries[c.segments[protected].Tail().Key], probation)
+	}
+}
+
+// evict moves the entries over the window size to the main segments, each
+// of them is admitted only if it is estimated to be accessed more often
+// than the victims that make room for it. Then it evicts from the main
+// segments until the size fits in the capacity
+func (c *TinyLFUCache) evict() {
+	for c.sizes[window] > c.windowCap {
+		candidate := c.entries[c.segments[window].Tail().Key]
+		c.unlink(candidate)
+		c.admit(candidate)
+	}
+	for c.total() > c.capacity {
+		victim := c.victim()
+		if victim == nil {
+			tail := c.segments[window].Tail()
+			if tail == nil {
+				metrics.Anomalies.Inc()
+				return
+			}
+			victim = c.entries[tail.Key]
+		}
+		c.counters.Evictions.Inc()
+		c.remove(victim)
+	}
+}
+
+// admit adds the candidate to the probationary segment if it wins against
+// the victims that make room for it, or evicts it
+func (c *TinyLFUCache) admit(candidate *entry) {
+	size := bytesize.ByteSize(candidate.node.Size)
+	freq := c.frequency(candidate.node.Key)
+	for c.total()+size > c.capacity {
+		victim := c.victim()
+		if victim == nil {
+			break
+		}
+		if freq <= c.frequency(victim.node.Key) {
+			c.counters.Deletes.Inc()
+			c.counters.Evictions.Inc()
+			delete(c.entries, candidate.node.Key)
+			return
+		}
+		c.counters.Evictions.Inc()
+		c.remove(victim)
+	}
+	c.link(candidate, probation)
+}
+
+// victim returns the next entry to evict from the main segments, the least
+// recently used probationary entry or the protected one if there is none
+func (c *TinyLFUCache) victim() *entry {
+	if tail := c.segments[probation].Tail(); tail != nil {
+		return c.entries[tail.Key]
+	}
+	if tail := c.segments[protected].Tail(); tail != nil {
+		return c.entries[tail.Key]
+	}
+	return nil
+}
+
+// total returns the size of all the segments
+func (c *TinyLFUCache) total() bytesize.ByteSize {
+	return c.sizes[window] + c.sizes[probation] + c.sizes[protected]
+}
+
+// move moves the entry to the head of the segment
+func (c *TinyLFUCache) move(e *entry, to segment) {
+	c.unlink(e)
+	c.link(e, to)
+}
+
+// link adds the entry to the head of the segment
+func (c *TinyLFUCache) link(e *entry, to segment) {
+	e.segment = to
+	c.segments[to].AddNode(e.node)
+	c.sizes[to] += bytesize.ByteSize(e.node.Size)
+}
+
+// unlink removes the entry from its segment
+func (c *TinyLFUCache) unlink(e *entry) {
+	c.segments[e.segment].RemoveNode(e.node)
+	c.sizes[e.segment] -= bytesize.ByteSize(e.node.Size)
+}
+
+// remove evicts the entry
+func (c *TinyLFUCache) remove(e *entry) {
+	c.counters.Deletes.Inc()
+	c.unlink(e)
+	delete(c.entries, e.node.Key)
+}
+
+// Delete deletes the key from the cache
+func (c *TinyLFUCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.remove(e)
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *TinyLFUCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries in the cache
+func (c *TinyLFUCache) Len() int {
+	defer c.Unlock()
+	c.Lock()
+	return len(c.entries)
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *TinyLFUCache) Stats() cache.Stats {
+	defer c.Unlock()
+	c.Lock()
+	return cache.NewStats(c.counters, c.total(), len(c.entries))
+}
+
+func (c *TinyLFUCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	o := make(map[string]string)
+
+	for k, e := range c.entries {
+		o[k] = e.node.Value
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+func (c *TinyLFUCache) Restore(closer io.ReadCloser) error {
+	// Set the state from the snapshot as it is decoded, no lock required
+	// according to Hashicorp docs.
+	return cache.ReadSnapshot(closer, func(k, v string) {
+		c.Put(k, v)
+	})
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
@@ -0,0 +1,134 @@
+package tinylfucache
+
+import (
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// check verifies the sizes of the segments and the capacity
+func check(t *testing.T, c *TinyLFUCache) {
+	t.Helper()
+	entries := 0
+	for i, l := range c.segments {
+		size := bytesize.ByteSize(0)
+		l.Walk(func(node *dlinklist.Node) {
+			if e := c.entries[node.Key]; e == nil || e.node != node || e.segment != segment(i) {
+				t.Fatalf("Expected %s to be an entry of segment %d", node.Key, i)
+			}
+			size += bytesize.ByteSize(node.Size)
+		})
+		if size != c.sizes[i] {
+			t.Fatalf("Expected the size of segment %d to be %d but got %d", i, size, c.sizes[i])
+		}
+		entries += l.Size()
+	}
+	if entries != len(c.entries) {
+		t.Fatalf("Expected %d entries in the segments but got %d", len(c.entries), entries)
+	}
+	if c.total() > c.capacity || c.sizes[window] > c.windowCap {
+		t.Fatalf("Expected the segments to fit in the capacity but got %v", c.sizes)
+	}
+}
+
+func TestTinyLFUCache(t *testing.T) {
+	c := NewCache(100)
+	if !c.Put("a", "a") || c.Put("a", "aa") {
+		t.Errorf("Expected a to be created and then updated")
+	}
+	if value, ok := c.Get("a"); !ok || value != "aa" {
+		t.Errorf("Expected aa but got %s", value)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Expected b not to be found")
+	}
+	if !c.Delete("a") || c.Delete("a") || c.Len() != 0 {
+		t.Errorf("Expected a to be deleted once")
+	}
+	if c.Put("big", strings.Repeat("x", 101)) {
+		t.Errorf("Expected the oversized entry to be rejected")
+	}
+	check(t, c)
+}
+
+func TestTinyLFUCache_Admission(t *testing.T) {
+	c := NewCache(100)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		c.Put(key, "x")
+		c.Get(key)
+		c.Get(key)
+	}
+	check(t, c)
+	// the keys of a scan are accessed once and lose against the main entries
+	for i := 1000; i < 2000; i++ {
+		c.Put(strconv.Itoa(i), "x")
+		check(t, c)
+	}
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := c.entries[strconv.Itoa(i)]; ok {
+			kept++
+		}
+	}
+	if kept < 95 {
+		t.Errorf("Expected the frequent keys to survive the scan but kept %d", kept)
+	}
+	// a key that becomes frequent is admitted
+	for i := 0; i < 5; i++ {
+		c.Get("new")
+	}
+	c.Put("new", "x")
+	c.Put("flush", "x")
+	if _, ok := c.entries["new"]; !ok {
+		t.Errorf("Expected the frequent new key to be admitted")
+	}
+}
+
+func TestTinyLFUCache_Random(t *testing.T) {
+	c := NewCache(50)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(r.Intn(1 + r.Intn(200)))
+		switch r.Intn(6) {
+		case 0:
+			c.Delete(key)
+		case 1, 2:
+			c.Put(key, strings.Repeat("x", r.Intn(8)))
+		default:
+			c.Get(key)
+		}
+		check(t, c)
+	}
+}
+
+// hitRatio replays a skewed workload that puts the missed keys
+func hitRatio(c interface {
+	Get(key string) (string, bool)
+	Put(key, value string) bool
+}) float64 {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 100000)
+	hits := 0
+	for i := 0; i < 200000; i++ {
+		key := strconv.FormatUint(zipf.Uint64(), 10)
+		if _, ok := c.Get(key); ok {
+			hits++
+		} else {
+			c.Put(key, "x")
+		}
+	}
+	return float64(hits) / 200000
+}
+
+func TestTinyLFUCache_HitRatio(t *testing.T) {
+	tinyLFU := hitRatio(NewCache(1000))
+	lru := hitRatio(lrucache.NewCache(1000))
+	if tinyLFU <= lru {
+		t.Errorf("Expected W-TinyLFU to beat LRU but got %.3f and %.3f", tinyLFU, lru)
+	}
+}
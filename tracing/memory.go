@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// MemorySpan is a span recorded by MemoryTracer
+type MemorySpan struct {
+	Name       string
+	Parent     *MemorySpan
+	Attributes map[string]interface{}
+	Ended      bool
+	tracer     *MemoryTracer
+}
+
+// IsRecording reports true, the attributes are always kept
+func (s *MemorySpan) IsRecording() bool {
+	return true
+}
+
+// SetAttributes sets the attributes of the span
+func (s *MemorySpan) SetAttributes(attrs ...Attribute) {
+	defer s.tracer.mu.Unlock()
+	s.tracer.mu.Lock()
+	for _, attr := range attrs {
+		s.Attributes[attr.Key] = attr.Value
+	}
+}
+
+// End ends the span
+func (s *MemorySpan) End() {
+	defer s.tracer.mu.Unlock()
+	s.tracer.mu.Lock()
+	s.Ended = true
+}
+
+// MemoryTracer keeps the spans it starts in memory, it is meant for the
+// tests and for debugging
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+type memorySpanKey struct{}
+
+// Start starts a span that is the child of the span of ctx if it has one
+func (t *MemoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(memorySpanKey{}).(*MemorySpan)
+	span := &MemorySpan{Name: name, Parent: parent, Attributes: map[string]interface{}{}, tracer: t}
+	defer t.mu.Unlock()
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, memorySpanKey{}, span), span
+}
+
+// Spans returns the spans started so far by their start order
+func (t *MemoryTracer) Spans() []*MemorySpan {
+	defer t.mu.Unlock()
+	t.mu.Lock()
+	return append([]*MemorySpan(nil), t.spans...)
+}
@@ -0,0 +1,63 @@
+// Package oteltracing starts the spans of the caches and the servers with
+// OpenTelemetry
+package oteltracing
+
+import (
+	"context"
+	"github.com/arazmj/gerdu/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Tracer starts the spans with an OpenTelemetry tracer and continues the
+// traces of the callers with the global propagator
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a tracer that starts the spans with tracer, it is set
+// with tracing.SetTracer
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+// Start starts an OpenTelemetry span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	ctx, span := t.tracer.Start(ctx, name)
+	return ctx, otelSpan{span: span}
+}
+
+// Extract returns ctx with the remote span of the headers
+func (t *Tracer) Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) IsRecording() bool {
+	return s.span.IsRecording()
+}
+
+func (s otelSpan) SetAttributes(attrs ...tracing.Attribute) {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		switch value := attr.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(attr.Key, value))
+		case int64:
+			kvs = append(kvs, attribute.Int64(attr.Key, value))
+		case bool:
+			kvs = append(kvs, attribute.Bool(attr.Key, value))
+		}
+	}
+	s.span.SetAttributes(kvs...)
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
@@ -0,0 +1,147 @@
+// Package tracing starts the spans of the cache operations and the server
+// requests with a pluggable tracer, it does nothing until a tracer is set
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// The attributes of the spans of the cache operations
+const (
+	// KeyHashAttribute is the hash of the key, the keys are not recorded
+	// as they may be sensitive
+	KeyHashAttribute = "cache.key_hash"
+	// HitAttribute reports whether the key was in the cache
+	HitAttribute = "cache.hit"
+	// ValueSizeAttribute is the size of the value read, written or deleted
+	ValueSizeAttribute = "cache.value_size"
+	// EvictionsAttribute is the number of entries the operation evicted
+	EvictionsAttribute = "cache.evictions"
+)
+
+// Attribute is a key and a string, int64 or bool value of a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an integer attribute
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a traced operation
+type Span interface {
+	// IsRecording reports whether the attributes of the span are kept, so
+	// they are not computed for nothing
+	IsRecording() bool
+	SetAttributes(attrs ...Attribute)
+	End()
+}
+
+// Tracer starts the spans, a span started with a context that carries a
+// span is its child
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Extractor is a tracer that can continue a trace of a remote caller from
+// the headers of its request
+type Extractor interface {
+	Extract(ctx context.Context, header http.Header) context.Context
+}
+
+// Noop starts the spans that record nothing, it is the tracer until
+// SetTracer is called
+var Noop Tracer = noopTracer{}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) IsRecording() bool { return false }
+
+func (noopSpan) SetAttributes(...Attribute) {}
+
+func (noopSpan) End() {}
+
+// tracer holds the Tracer of Start in a holder, atomic.Value needs the
+// same concrete type on every Store
+var tracer atomic.Value
+
+type holder struct {
+	Tracer
+}
+
+func init() {
+	tracer.Store(holder{Noop})
+}
+
+// SetTracer sets the tracer of the spans started after it
+func SetTracer(t Tracer) {
+	tracer.Store(holder{t})
+}
+
+// Start starts a span with the tracer
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	return tracer.Load().(holder).Start(ctx, name)
+}
+
+// Extract returns ctx with the remote span of the headers if the tracer is
+// an Extractor, otherwise ctx
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if e, ok := tracer.Load().(holder).Tracer.(Extractor); ok {
+		return e.Extract(ctx, header)
+	}
+	return ctx
+}
+
+// StartOp starts the span of a cache operation on the key
+func StartOp(ctx context.Context, name, key string) Span {
+	_, span := Start(ctx, name)
+	if span.IsRecording() {
+		span.SetAttributes(String(KeyHashAttribute, KeyHash(key)))
+	}
+	return span
+}
+
+// EndOp ends the span of a cache operation with whether the key was in the
+// cache, the size of the value and the number of entries it evicted
+func EndOp(span Span, hit bool, size int, evictions uint64) {
+	if span.IsRecording() {
+		span.SetAttributes(
+			Bool(HitAttribute, hit),
+			Int64(ValueSizeAttribute, int64(size)),
+			Int64(EvictionsAttribute, int64(evictions)),
+		)
+	}
+	span.End()
+}
+
+// KeyHash returns the 64-bit FNV-1a hash of the key in hex, it is the
+// same for a key on every node so the spans of a key can be found
+func KeyHash(key string) string {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return strconv.FormatUint(h, 16)
+}
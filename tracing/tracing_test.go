@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// extractor is a MemoryTracer that continues the trace of the parent header
+type extractor struct {
+	MemoryTracer
+	parent *MemorySpan
+}
+
+func (e *extractor) Extract(ctx context.Context, header http.Header) context.Context {
+	if header.Get("parent") == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, memorySpanKey{}, e.parent)
+}
+
+func TestStart_Noop(t *testing.T) {
+	ctx := context.Background()
+	started, span := Start(ctx, "noop")
+	if started != ctx || span.IsRecording() {
+		t.Errorf("Expected the noop tracer to record nothing")
+	}
+	EndOp(StartOp(ctx, "noop", "key"), true, 1, 0)
+}
+
+func TestStartOp(t *testing.T) {
+	tracer := &MemoryTracer{}
+	SetTracer(tracer)
+	defer SetTracer(Noop)
+
+	ctx, parent := Start(context.Background(), "request")
+	EndOp(StartOp(ctx, "cache.Put", "key"), false, 5, 2)
+	parent.End()
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans but got %d", len(spans))
+	}
+	op := spans[1]
+	if op.Name != "cache.Put" || op.Parent != spans[0] || !op.Ended {
+		t.Errorf("Expected an ended child span of the request but got %+v", op)
+	}
+	expected := map[string]interface{}{
+		KeyHashAttribute:   KeyHash("key"),
+		HitAttribute:       false,
+		ValueSizeAttribute: int64(5),
+		EvictionsAttribute: int64(2),
+	}
+	for key, value := range expected {
+		if op.Attributes[key] != value {
+			t.Errorf("Expected %s %v but got %v", key, value, op.Attributes[key])
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	if ctx := Extract(context.Background(), http.Header{"Parent": {"1"}}); ctx != context.Background() {
+		t.Errorf("Expected the noop tracer to leave the context as it is")
+	}
+	tracer := &extractor{}
+	_, remote := tracer.Start(context.Background(), "remote")
+	tracer.parent = remote.(*MemorySpan)
+	SetTracer(tracer)
+	defer SetTracer(Noop)
+
+	ctx := Extract(context.Background(), http.Header{"Parent": {"1"}})
+	_, span := Start(ctx, "request")
+	if span.(*MemorySpan).Parent != tracer.parent {
+		t.Errorf("Expected the request to continue the remote trace")
+	}
+}
+
+func TestKeyHash(t *testing.T) {
+	if KeyHash("") != "cbf29ce484222325" || KeyHash("a") != "af63dc4c8601ec8c" {
+		t.Errorf("Expected the FNV-1a hashes but got %s %s", KeyHash(""), KeyHash("a"))
+	}
+	if KeyHash("a") == KeyHash("b") {
+		t.Errorf("Expected different keys to have different hashes")
+	}
+}
@@ -0,0 +1,171 @@
+// Package tracingcache implements a cache wrapper that tracks the hottest keys
+package tracingcache
+
+import (
+	"container/heap"
+	"github.com/arazmj/gerdu/cache"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const sketchDepth = 4
+
+// KeyCount is a key and its estimated number of accesses
+type KeyCount struct {
+	Key   string
+	Count uint64
+}
+
+// TracingCache wraps a cache and counts the gets and the puts of the keys in
+// a count-min sketch, the k keys with the highest estimates are kept in a
+// min-heap so the hottest keys are known without storing a count per key.
+// The estimates are upper bounds that never decrease
+type TracingCache struct {
+	cache.UnImplementedCache
+	sync.Mutex
+	enabled  int32
+	counters [sketchDepth][]uint64
+	mask     uint64
+	k        int
+	top      topHeap
+	index    map[string]*entry
+}
+
+// entry is a tracked key and its position in the heap
+type entry struct {
+	KeyCount
+	position int
+}
+
+// NewCache TracingCache constructor, k is the number of the hottest keys
+// that are tracked. The tracing is enabled
+func NewCache(c cache.UnImplementedCache, k int) *TracingCache {
+	width := 1024
+	for width < k*256 {
+		width <<= 1
+	}
+	t := &TracingCache{
+		UnImplementedCache: c,
+		enabled:            1,
+		mask:               uint64(width - 1),
+		k:                  k,
+		index:              map[string]*entry{},
+	}
+	for i := range t.counters {
+		t.counters[i] = make([]uint64, width)
+	}
+	return t
+}
+
+// Enable starts counting the accesses
+func (t *TracingCache) Enable() {
+	atomic.StoreInt32(&t.enabled, 1)
+}
+
+// Disable stops counting the accesses, the counts so far are kept
+func (t *TracingCache) Disable() {
+	atomic.StoreInt32(&t.enabled, 0)
+}
+
+// Get returns the value for the key from the wrapped cache
+func (t *TracingCache) Get(key string) (value string, ok bool) {
+	t.record(key)
+	return t.UnImplementedCache.Get(key)
+}
+
+// Put updates or inserts the entry in the wrapped cache
+func (t *TracingCache) Put(key string, value string) (created bool) {
+	t.record(key)
+	return t.UnImplementedCache.Put(key, value)
+}
+
+// Close closes the wrapped cache
+func (t *TracingCache) Close() error {
+	return cache.Close(t.UnImplementedCache)
+}
+
+// record counts an access of the key and updates the hottest keys
+func (t *TracingCache) record(key string) {
+	if atomic.LoadInt32(&t.enabled) == 0 || t.k <= 0 {
+		return
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+
+	defer t.Unlock()
+	t.Lock()
+	var estimate uint64
+	for i := range t.counters {
+		index := (h1 + uint64(i)*h2) & t.mask
+		t.counters[i][index]++
+		if count := t.counters[i][index]; i == 0 || count < estimate {
+			estimate = count
+		}
+	}
+	if e, ok := t.index[key]; ok {
+		e.Count = estimate
+		heap.Fix(&t.top, e.position)
+		return
+	}
+	if len(t.top) < t.k {
+		e := &entry{KeyCount: KeyCount{Key: key, Count: estimate}}
+		t.index[key] = e
+		heap.Push(&t.top, e)
+		return
+	}
+	if coldest := t.top[0]; estimate > coldest.Count {
+		delete(t.index, coldest.Key)
+		e := &entry{KeyCount: KeyCount{Key: key, Count: estimate}}
+		t.index[key] = e
+		t.top[0] = e
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// TopKeys returns up to n of the hottest keys, the hottest first
+func (t *TracingCache) TopKeys(n int) []KeyCount {
+	t.Lock()
+	keys := make([]KeyCount, len(t.top))
+	for i, e := range t.top {
+		keys[i] = e.KeyCount
+	}
+	t.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// topHeap is a min-heap of the hottest keys by their count
+type topHeap []*entry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].position = i
+	h[j].position = j
+}
+
+func (h *topHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.position = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,72 @@
+package tracingcache
+
+import (
+	"github.com/arazmj/gerdu/lfucache"
+	"github.com/arazmj/gerdu/lrucache"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestTracingCache_TopKeys(t *testing.T) {
+	c := NewCache(lrucache.NewCache(1000), 10)
+	r := rand.New(rand.NewSource(1))
+	// key i is accessed about twice as often as key i+1
+	for i := 0; i < 20000; i++ {
+		key := 0
+		for key < 50 && r.Intn(2) == 0 {
+			key++
+		}
+		if _, ok := c.Get(strconv.Itoa(key)); !ok {
+			c.Put(strconv.Itoa(key), "x")
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		c.Get("cold" + strconv.Itoa(i))
+	}
+	top := c.TopKeys(5)
+	if len(top) != 5 {
+		t.Fatalf("Expected 5 keys but got %d", len(top))
+	}
+	for i, kc := range top {
+		if kc.Key != strconv.Itoa(i) {
+			t.Errorf("Expected %d to be the hottest key %d but got %s", i, i, kc.Key)
+		}
+	}
+	if top[0].Count < 9000 {
+		t.Errorf("Expected about 10000 accesses of 0 but got %d", top[0].Count)
+	}
+	if len(c.TopKeys(100)) != 10 {
+		t.Errorf("Expected at most 10 tracked keys")
+	}
+}
+
+func TestTracingCache_Disable(t *testing.T) {
+	c := NewCache(lfucache.NewCache(100), 10)
+	c.Put("1", "1")
+	c.Disable()
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Get("1"); !ok {
+			t.Fatalf("Expected the disabled tracing to still delegate")
+		}
+	}
+	if top := c.TopKeys(1); len(top) != 1 || top[0].Count != 1 {
+		t.Errorf("Expected the accesses not to be counted while disabled but got %v", top)
+	}
+	c.Enable()
+	c.Get("1")
+	if top := c.TopKeys(1); top[0].Count != 2 {
+		t.Errorf("Expected the accesses to be counted again but got %v", top)
+	}
+}
+
+func BenchmarkTracingCache_Get(b *testing.B) {
+	c := NewCache(lrucache.NewCache(1000), 100)
+	for i := 0; i < 1000; i++ {
+		c.Put(strconv.Itoa(i), "x")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(strconv.Itoa(i % 1000))
+	}
+}
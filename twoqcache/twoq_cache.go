@@ -0,0 +1,273 @@
+// Package twoqcache implements 2Q (Two Queue) cache
+package twoqcache
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/metrics"
+	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"sync"
+)
+
+const (
+	// inRatio is the share of the capacity for the A1in queue
+	inRatio = 0.25
+	// outRatio is the size of the A1out ghosts as a share of the capacity
+	outRatio = 0.5
+)
+
+// queue is one of the three queues of 2Q
+type queue int
+
+const (
+	// a1in holds the resident entries that are put once, in FIFO order
+	a1in queue = iota
+	// a1out remembers the keys evicted from a1in, in FIFO order
+	a1out
+	// am holds the resident entries that are put again, in LRU order
+	am
+)
+
+type entry struct {
+	node  *dlinklist.Node
+	queue queue
+}
+
+// TwoQCache data structure, a new entry enters the A1in FIFO and its key is
+// remembered in the A1out ghost FIFO once it is evicted. Only a key that is
+// put again while it is remembered enters the Am LRU, so the entries of a
+// scan are evicted from A1in without flushing the frequently used entries
+// of Am. The sizes are in bytes, a ghost keeps the size of its evicted entry
+type TwoQCache struct {
+	sync.RWMutex
+	cache.UnImplementedCache
+	entries  map[string]*entry
+	queues   [3]*dlinklist.DLinkedList
+	sizes    [3]bytesize.ByteSize
+	capacity bytesize.ByteSize
+	inCap    bytesize.ByteSize
+	outCap   bytesize.ByteSize
+	counters *metrics.Counters
+}
+
+// NewCache TwoQCache constructor
+func NewCache(capacity bytesize.ByteSize) *TwoQCache {
+	c := &TwoQCache{
+		entries:  map[string]*entry{},
+		capacity: capacity,
+		inCap:    bytesize.ByteSize(float64(capacity) * inRatio),
+		outCap:   bytesize.ByteSize(float64(capacity) * outRatio),
+		counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName),
+	}
+	for i := range c.queues {
+		c.queues[i] = dlinklist.NewLinkedList()
+	}
+	return c
+}
+
+// cost sizes an entry by the length of its value, at least a byte
+func cost(value string) bytesize.ByteSize {
+	if len(value) == 0 {
+		return 1
+	}
+	return bytesize.ByteSize(len(value))
+}
+
+// Get returns the value for the key, a hit in Am moves the entry to the
+// head of Am and a hit in A1in leaves it in place
+func (c *TwoQCache) Get(key string) (value string, ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok || e.queue == a1out {
+		c.counters.Miss.Inc()
+		return "", false
+	}
+	c.counters.Hits.Inc()
+	if e.queue == am {
+		c.move(e, am)
+	}
+	return e.node.Value, true
+}
+
+// Put updates or inserts a new entry, a new key enters A1in and a key
+// remembered in A1out enters Am. An update keeps an A1in entry in its place
+// and moves an Am entry to the head of Am. An entry larger than the
+// capacity is rejected
+func (c *TwoQCache) Put(key string, value string) (created bool) {
+	defer c.Unlock()
+	c.Lock()
+	size := cost(value)
+	if size > c.capacity {
+		return false
+	}
+	e, ok := c.entries[key]
+	if ok && e.queue == a1in {
+		c.sizes[a1in] += size - bytesize.ByteSize(e.node.Size)
+		e.node.Value = value
+		e.node.Size = int64(size)
+		c.reclaim()
+		return false
+	}
+	if ok && e.queue == am {
+		c.unlink(e)
+		e.node.Value = value
+		e.node.Size = int64(size)
+		c.link(e, am)
+		c.reclaim()
+		return false
+	}
+
+	c.counters.Adds.Inc()
+	to := a1in
+	if ok {
+		c.unlink(e)
+		to = am
+	} else {
+		e = &entry{node: &dlinklist.Node{Key: key}}
+		c.entries[key] = e
+	}
+	e.node.Value = value
+	e.node.Size = int64(size)
+	c.link(e, to)
+	c.reclaim()
+	return true
+}
+
+// reclaim evicts until the resident entries fit in the capacity, from A1in
+// to A1out while A1in is over its share and from Am otherwise, then it
+// forgets the oldest ghosts of A1out that are over its share
+func (c *TwoQCache) reclaim() {
+	for c.sizes[a1in]+c.sizes[am] > c.capacity {
+		in := c.queues[a1in].Size()
+		if in > 0 && (c.queues[am].Size() == 0 || c.sizes[a1in] > c.inCap && in > 1) {
+			c.counters.Deletes.Inc()
+			c.counters.Evictions.Inc()
+			e := c.entries[c.queues[a1in].Tail().Key]
+			c.unlink(e)
+			e.node.Value = ""
+			c.link(e, a1out)
+			continue
+		}
+		tail := c.queues[am].Tail()
+		if tail == nil {
+			metrics.Anomalies.Inc()
+			return
+		}
+		c.counters.Deletes.Inc()
+		c.counters.Evictions.Inc()
+		c.unlink(c.entries[tail.Key])
+		delete(c.entries, tail.Key)
+	}
+	for c.sizes[a1out] > c.outCap && c.queues[a1out].Size() > 0 {
+		tail := c.queues[a1out].Tail()
+		c.unlink(c.entries[tail.Key])
+		delete(c.entries, tail.Key)
+	}
+}
+
+// move moves the entry to the head of the queue
+func (c *TwoQCache) move(e *entry, to queue) {
+	c.unlink(e)
+	c.link(e, to)
+}
+
+// link adds the entry to the head of the queue
+func (c *TwoQCache) link(e *entry, to queue) {
+	e.queue = to
+	c.queues[to].AddNode(e.node)
+	c.sizes[to] += bytesize.ByteSize(e.node.Size)
+}
+
+// unlink removes the entry from its queue
+func (c *TwoQCache) unlink(e *entry) {
+	c.queues[e.queue].RemoveNode(e.node)
+	c.sizes[e.queue] -= bytesize.ByteSize(e.node.Size)
+}
+
+// Delete deletes the key from the cache, a ghost of the key is
+// forgotten but it is not counted as a delete
+func (c *TwoQCache) Delete(key string) (ok bool) {
+	defer c.Unlock()
+	c.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.unlink(e)
+	delete(c.entries, key)
+	if e.queue == a1out {
+		return false
+	}
+	c.counters.Deletes.Inc()
+	return true
+}
+
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *TwoQCache) Close() error {
+	return nil
+}
+
+// Len returns the number of resident entries in the cache
+func (c *TwoQCache) Len() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.queues[a1in].Size() + c.queues[am].Size()
+}
+
+// Stats returns the counters of the cache and its size and number of entries
+func (c *TwoQCache) Stats() cache.Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return cache.NewStats(c.counters, c.sizes[a1in]+c.sizes[am], c.queues[a1in].Size()+c.queues[am].Size())
+}
+
+func (c *TwoQCache) Snapshot() (raft.FSMSnapshot, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	o := make(map[string]string)
+
+	for k, e := range c.entries {
+		if e.queue != a1out {
+			o[k] = e.node.Value
+		}
+	}
+
+	return &fsmSnapshot{store: o}, nil
+}
+
+func (c *TwoQCache) Restore(closer io.ReadCloser) error {
+	// Set the state from the snapshot as it is decoded, no lock required
+	// according to Hashicorp docs.
+	return cache.ReadSnapshot(closer, func(k, v string) {
+		c.Put(k, v)
+	})
+}
+
+type fsmSnapshot struct {
+	store map[string]string
+}
+
+func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := func() error {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
+			return err
+		}
+
+		// Close the sink.
+		return sink.Close()
+	}()
+
+	if err != nil {
+		sink.Cancel()
+	}
+
+	return err
+}
+
+func (f *fsmSnapshot) Release() {}
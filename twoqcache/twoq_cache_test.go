@@ -0,0 +1,129 @@
+package twoqcache
+
+import (
+	"github.com/arazmj/gerdu/dlinklist"
+	"github.com/arazmj/gerdu/lrucache"
+	"github.com/inhies/go-bytesize"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// check verifies the sizes of the queues and the bounds of 2Q
+func check(t *testing.T, c *TwoQCache) {
+	t.Helper()
+	entries := 0
+	for i, q := range c.queues {
+		size := bytesize.ByteSize(0)
+		q.Walk(func(node *dlinklist.Node) {
+			if e := c.entries[node.Key]; e == nil || e.node != node || e.queue != queue(i) {
+				t.Fatalf("Expected %s to be an entry of queue %d", node.Key, i)
+			}
+			size += bytesize.ByteSize(node.Size)
+		})
+		if size != c.sizes[i] {
+			t.Fatalf("Expected the size of queue %d to be %d but got %d", i, size, c.sizes[i])
+		}
+		entries += q.Size()
+	}
+	if entries != len(c.entries) {
+		t.Fatalf("Expected %d entries in the queues but got %d", len(c.entries), entries)
+	}
+	if c.sizes[a1in]+c.sizes[am] > c.capacity || c.sizes[a1out] > c.outCap {
+		t.Fatalf("Expected the queues to fit in the capacity but got %v", c.sizes)
+	}
+}
+
+func TestTwoQCache(t *testing.T) {
+	c := NewCache(8)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+		c.Put(key, key)
+	}
+	check(t, c)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected a to be evicted from A1in")
+	}
+	if e := c.entries["a"]; e == nil || e.queue != a1out {
+		t.Errorf("Expected a to be remembered in A1out")
+	}
+	if !c.Put("a", "a") || c.entries["a"].queue != am {
+		t.Errorf("Expected the remembered a to enter Am")
+	}
+	if c.Put("a", "aa") || c.entries["a"].queue != am || c.sizes[am] != 2 {
+		t.Errorf("Expected a to be updated in Am")
+	}
+	check(t, c)
+	if c.Len() != 7 {
+		t.Errorf("Expected 7 entries but got %d", c.Len())
+	}
+}
+
+func TestTwoQCache_ScanResistance(t *testing.T) {
+	c := NewCache(20)
+	lru := lrucache.NewCache(20)
+	hot := []string{"a", "b", "c"}
+	for _, key := range hot {
+		c.Put(key, key)
+		lru.Put(key, key)
+	}
+	// flush the hot keys to A1out so the next puts promote them to Am
+	for i := 0; i < 20; i++ {
+		c.Put(strconv.Itoa(i), "x")
+	}
+	for _, key := range hot {
+		c.Put(key, key)
+		lru.Get(key)
+	}
+	for i := 100; i < 200; i++ {
+		key := strconv.Itoa(i)
+		c.Put(key, "x")
+		lru.Put(key, "x")
+		check(t, c)
+	}
+	for _, key := range hot {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected the hot key %s to survive the scan", key)
+		}
+		if _, ok := lru.Get(key); ok {
+			t.Errorf("Expected the hot key %s to be evicted from LRU by the scan", key)
+		}
+	}
+}
+
+func TestTwoQCache_Delete(t *testing.T) {
+	c := NewCache(2)
+	c.Put("a", "a")
+	c.Put("b", "b")
+	c.Put("c", "c")
+	if c.Delete("a") {
+		t.Errorf("Expected the ghost a not to be deleted")
+	}
+	if !c.Delete("b") || c.Delete("b") {
+		t.Errorf("Expected b to be deleted once")
+	}
+	check(t, c)
+	if c.Len() != 1 || len(c.entries) != 1 {
+		t.Errorf("Expected only c to be left")
+	}
+}
+
+func TestTwoQCache_Random(t *testing.T) {
+	c := NewCache(50)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := strconv.Itoa(r.Intn(1 + r.Intn(200)))
+		switch r.Intn(6) {
+		case 0:
+			c.Delete(key)
+		case 1, 2:
+			c.Put(key, strings.Repeat("x", r.Intn(8)))
+		default:
+			c.Get(key)
+		}
+		check(t, c)
+	}
+	if c.Put("big", strings.Repeat("x", 51)) {
+		t.Errorf("Expected the oversized entry to be rejected")
+	}
+}
@@ -0,0 +1,119 @@
+// Package warmup loads the entries of a seed file or of a peer node into a
+// cache before the node starts serving, so a cold start does not send all
+// its misses to the origin
+package warmup
+
+import (
+	"fmt"
+	"github.com/arazmj/gerdu/cache"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Source reads the entries to warm up with and calls put for each of them
+type Source func(put func(e cache.DumpEntry)) error
+
+// File returns the source of a seed file in the format of cache.Dump, such
+// as an export or a periodic snapshot of lru or lfu, compressed or not
+func File(path string) Source {
+	return func(put func(e cache.DumpEntry)) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, err := cache.NewDecompressReader(f)
+		if err != nil {
+			return err
+		}
+		return cache.ReadDumpEntries(r, put)
+	}
+}
+
+// Peer returns the source of the dump of a peer node streamed from the
+// /dump endpoint of its HTTP API at base, e.g. http://127.0.0.1:8080. A nil
+// client is http.DefaultClient
+func Peer(client *http.Client, base string) Source {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(put func(e cache.DumpEntry)) error {
+		response, err := client.Get(strings.TrimSuffix(base, "/") + "/dump")
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("get the dump of %s: %s", base, response.Status)
+		}
+		return cache.ReadDumpEntries(response.Body, put)
+	}
+}
+
+// Warmup is the progress of a warm-up, Ready is closed once it is done
+type Warmup struct {
+	loaded int64
+	ready  chan struct{}
+	err    error
+}
+
+// Start loads the entries of the source into c in the background, the
+// entries without an expiration are put batch of them at a time with
+// cache.PutMulti and the ones with an expiration are put with the time they
+// have left if c is a cache.TTLCache, otherwise they are batched with the
+// others. The expired entries are skipped
+func Start(c cache.UnImplementedCache, source Source, batch int) *Warmup {
+	if batch < 1 {
+		batch = 1
+	}
+	w := &Warmup{ready: make(chan struct{})}
+	go func() {
+		defer close(w.ready)
+		pending := make(map[string]string, batch)
+		flush := func() {
+			cache.PutMulti(c, pending)
+			atomic.AddInt64(&w.loaded, int64(len(pending)))
+			pending = make(map[string]string, batch)
+		}
+		ttl, _ := c.(cache.TTLCache)
+		w.err = source(func(e cache.DumpEntry) {
+			if e.Expires != 0 {
+				left := time.Until(time.Unix(0, e.Expires))
+				if left <= 0 {
+					return
+				}
+				if ttl != nil {
+					ttl.PutWithTTL(e.Key, e.Value, left)
+					atomic.AddInt64(&w.loaded, 1)
+					return
+				}
+			}
+			pending[e.Key] = e.Value
+			if len(pending) >= batch {
+				flush()
+			}
+		})
+		flush()
+	}()
+	return w
+}
+
+// Loaded returns the number of the entries loaded so far
+func (w *Warmup) Loaded() int {
+	return int(atomic.LoadInt64(&w.loaded))
+}
+
+// Ready returns a channel that is closed once the warm-up is done
+func (w *Warmup) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// Wait waits for the warm-up and returns its error, the entries loaded
+// before an error stay in the cache
+func (w *Warmup) Wait() error {
+	<-w.ready
+	return w.err
+}
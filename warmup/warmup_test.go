@@ -0,0 +1,100 @@
+package warmup
+
+import (
+	"errors"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStart_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.json.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := cache.NewCompressWriter(f, cache.Gzip)
+	entries := []cache.DumpEntry{
+		{Key: "expired", Value: "x", Expires: time.Now().Add(-time.Minute).UnixNano()},
+		{Key: "ttl", Value: "x", Expires: time.Now().Add(time.Hour).UnixNano()},
+	}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, cache.DumpEntry{Key: strconv.Itoa(i), Value: strconv.Itoa(i)})
+	}
+	if err := cache.WriteDump(w, entries); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	f.Close()
+
+	c := lrucache.NewCache(100)
+	warmup := Start(c, File(path), 3)
+	if err := warmup.Wait(); err != nil {
+		t.Fatalf("Warm-up failed %v", err)
+	}
+	if warmup.Loaded() != 11 || c.Len() != 11 {
+		t.Errorf("Expected 11 entries to be loaded but got %d %d", warmup.Loaded(), c.Len())
+	}
+	if _, ok := c.Get("expired"); ok {
+		t.Errorf("Expected the expired entry to be skipped")
+	}
+	if ttl, ok := c.TTL("ttl"); !ok || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected the entry to keep the time it has left but got %v", ttl)
+	}
+	if value, ok := c.Get("9"); !ok || value != "9" {
+		t.Errorf("Expected 9 from the last batch but got %s", value)
+	}
+}
+
+func TestStart_Peer(t *testing.T) {
+	peer := lrucache.NewCache(100)
+	peer.Put("1", "one")
+	peer.Put("2", "two")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dump" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		peer.Export(w)
+	}))
+	defer server.Close()
+
+	c := lrucache.NewCache(100)
+	warmup := Start(c, Peer(nil, server.URL+"/"), 100)
+	select {
+	case <-warmup.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the warm-up to be ready")
+	}
+	if err := warmup.Wait(); err != nil || c.Len() != 2 {
+		t.Errorf("Expected the 2 entries of the peer but got %d %v", c.Len(), err)
+	}
+
+	if err := Start(c, Peer(nil, server.URL+"/missing"), 100).Wait(); err == nil {
+		t.Errorf("Expected the status of the peer to be an error")
+	}
+}
+
+func TestStart_Error(t *testing.T) {
+	failed := errors.New("broken seed")
+	c := lrucache.NewCache(100)
+	warmup := Start(c, func(put func(e cache.DumpEntry)) error {
+		put(cache.DumpEntry{Key: "1", Value: "one"})
+		return failed
+	}, 10)
+	if err := warmup.Wait(); err != failed {
+		t.Errorf("Expected the error of the source but got %v", err)
+	}
+	if _, ok := c.Get("1"); !ok || warmup.Loaded() != 1 {
+		t.Errorf("Expected the entries before the error to stay")
+	}
+	if err := Start(c, File(filepath.Join(t.TempDir(), "missing")), 10).Wait(); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing seed file to be an error but got %v", err)
+	}
+}
@@ -2,11 +2,11 @@
 package weakcache
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/arazmj/gerdu/cache"
 	"github.com/arazmj/gerdu/metrics"
 	"github.com/hashicorp/raft"
+	"github.com/inhies/go-bytesize"
 	"github.com/ivanrad/go-weakref/weakref"
 	"io"
 	"sync"
@@ -16,16 +16,17 @@ import (
 type WeakCache struct {
 	sync.Map
 	cache.UnImplementedCache
+	counters *metrics.Counters
 }
 
 // NewWeakCache constructor
 func NewWeakCache() *WeakCache {
-	return &WeakCache{}
+	return &WeakCache{counters: metrics.NewCounters(metrics.Prometheus, metrics.DefaultName)}
 }
 
 // Put a new key value pair
 func (c *WeakCache) Put(key string, value string) (created bool) {
-	metrics.Adds.Inc()
+	c.counters.Adds.Inc()
 	ref := weakref.NewWeakRef(value)
 	c.Store(key, ref)
 	return true
@@ -37,30 +38,62 @@ func (c *WeakCache) Get(key string) (value string, ok bool) {
 	if ok {
 		ref := v.(*weakref.WeakRef)
 		if ref.IsAlive() {
-			metrics.Hits.Inc()
+			c.counters.Hits.Inc()
 			return ref.GetTarget().(string), true
 		}
-		metrics.Deletes.Inc()
+		c.counters.Evictions.Inc()
 		c.Delete(key)
 	}
-	metrics.Miss.Inc()
+	c.counters.Miss.Inc()
 	return "", false
 }
 
 //Delete deletes the key
 func (c *WeakCache) Delete(key string) bool {
-	metrics.Deletes.Inc()
+	c.counters.Deletes.Inc()
 	c.Map.Delete(key)
 	return true
 }
 
+// Close does nothing, the cache has no background work and stays usable
+// after it is closed
+func (c *WeakCache) Close() error {
+	return nil
+}
+
+// Len returns the number of entries that are not yet collected
+func (c *WeakCache) Len() int {
+	count := 0
+	c.Map.Range(func(key, value interface{}) bool {
+		if value.(*weakref.WeakRef).IsAlive() {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Stats returns the counters of the cache and the size and number of the
+// entries that are not collected yet
+func (c *WeakCache) Stats() cache.Stats {
+	size, entries := bytesize.ByteSize(0), 0
+	c.Map.Range(func(key, value interface{}) bool {
+		if target, ok := value.(*weakref.WeakRef).GetTarget().(string); ok {
+			size += bytesize.ByteSize(len(target))
+			entries++
+		}
+		return true
+	})
+	return cache.NewStats(c.counters, size, entries)
+}
+
 func (c *WeakCache) Snapshot() (raft.FSMSnapshot, error) {
 	o := make(map[string]string)
 
 	c.Map.Range(func(key, value interface{}) bool {
 		ref := value.(*weakref.WeakRef)
 		if ref.IsAlive() {
-			metrics.Hits.Inc()
+			c.counters.Hits.Inc()
 			o[fmt.Sprint(key)] = ref.GetTarget().(string)
 		}
 		return true
@@ -71,18 +104,11 @@ func (c *WeakCache) Snapshot() (raft.FSMSnapshot, error) {
 }
 
 func (c *WeakCache) Restore(closer io.ReadCloser) error {
-	o := make(map[string]string)
-	if err := json.NewDecoder(closer).Decode(&o); err != nil {
-		return err
-	}
-
-	// Set the state from the snapshot, no lock required according to
-	// Hashicorp docs.
-	for k, v := range o {
+	// Set the state from the snapshot as it is decoded, no lock required
+	// according to Hashicorp docs.
+	return cache.ReadSnapshot(closer, func(k, v string) {
 		c.Put(k, v)
-	}
-
-	return nil
+	})
 }
 
 type fsmSnapshot struct {
@@ -91,14 +117,8 @@ type fsmSnapshot struct {
 
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
-		// Encode data.
-		b, err := json.Marshal(f.store)
-		if err != nil {
-			return err
-		}
-
-		// Write data to sink.
-		if _, err := sink.Write(b); err != nil {
+		// Stream the entries to the sink.
+		if err := cache.WriteSnapshot(sink, f.store); err != nil {
 			return err
 		}
 
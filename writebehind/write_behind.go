@@ -0,0 +1,312 @@
+// Package writebehind implements a cache that writes the puts and the
+// deletes to a backing store asynchronously
+package writebehind
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// flushBatch is the number of the dirty entries a flush saves in a single
+// call of a cache.BatchStore
+const flushBatch = 128
+
+// The defaults of the arguments of NewStoreCache that are not positive
+const (
+	defaultMaxDirty = 1024
+	defaultInterval = time.Second
+)
+
+// Writer writes an entry to the backing store, it is a cache.Store that
+// has nothing to load and ignores the deletes
+type Writer func(key, value string) error
+
+// Load returns cache.ErrNotFound, a writer cannot read the store
+func (w Writer) Load(key string) (string, error) {
+	return "", cache.ErrNotFound
+}
+
+// Save calls w
+func (w Writer) Save(key, value string) error {
+	return w(key, value)
+}
+
+// Delete does nothing, a writer cannot delete from the store
+func (w Writer) Delete(key string) error {
+	return nil
+}
+
+// pending is a dirty entry, the value to save or a delete
+type pending struct {
+	value   string
+	deleted bool
+}
+
+// WriteBehindCache wraps a cache, the puts and the deletes are applied to
+// the cache and queued as dirty entries that a background flusher writes to
+// the backing store every interval. The dirty entries are kept until they
+// are written, so an entry evicted from the cache before it is written is
+// not lost and is still returned by Get. A miss of both is loaded from the
+// store. A failed write is retried on the next flush
+type WriteBehindCache struct {
+	cache.UnImplementedCache
+	sync.Mutex
+	store    cache.Store
+	dirty    map[string]pending
+	order    []string
+	maxDirty int
+	space    *sync.Cond
+	flushing sync.Mutex
+	done     chan struct{}
+	stopped  chan struct{}
+	closed   bool
+}
+
+// NewCache WriteBehindCache constructor, maxDirty is the number of dirty
+// entries that can be queued before the puts wait for the flusher, see
+// NewStoreCache for the defaults
+func NewCache(c cache.UnImplementedCache, writer Writer, maxDirty int, interval time.Duration) *WriteBehindCache {
+	return NewStoreCache(c, writer, maxDirty, interval)
+}
+
+// NewStoreCache WriteBehindCache constructor in front of a store, the dirty
+// entries are saved in batches if the store is a cache.BatchStore. A
+// maxDirty that is not positive is 1024 and such an interval is a second
+func NewStoreCache(c cache.UnImplementedCache, store cache.Store, maxDirty int, interval time.Duration) *WriteBehindCache {
+	if maxDirty <= 0 {
+		maxDirty = defaultMaxDirty
+	}
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	w := &WriteBehindCache{
+		UnImplementedCache: c,
+		store:              store,
+		dirty:              map[string]pending{},
+		maxDirty:           maxDirty,
+		done:               make(chan struct{}),
+		stopped:            make(chan struct{}),
+	}
+	w.space = sync.NewCond(&w.Mutex)
+	go w.run(interval)
+	return w
+}
+
+func (w *WriteBehindCache) run(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = w.flush()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Get returns the value for the key, a dirty entry that is evicted from
+// the cache is returned from the queue and a key that is in neither is
+// loaded from the store and put in the cache
+func (w *WriteBehindCache) Get(key string) (value string, ok bool) {
+	if value, ok = w.UnImplementedCache.Get(key); ok {
+		return value, true
+	}
+	w.Lock()
+	p, dirty := w.dirty[key]
+	w.Unlock()
+	if dirty {
+		return p.value, !p.deleted
+	}
+	value, err := w.store.Load(key)
+	if err != nil {
+		if err != cache.ErrNotFound {
+			log.Errorf("Cannot load %s from the store %v", key, err)
+		}
+		return "", false
+	}
+	defer w.Unlock()
+	w.Lock()
+	// a put or a delete queued during the load is newer than the store
+	if p, dirty := w.dirty[key]; dirty {
+		return p.value, !p.deleted
+	}
+	if !w.closed {
+		w.UnImplementedCache.Put(key, value)
+	}
+	return value, true
+}
+
+// Put updates or inserts the entry in the cache and queues it to be
+// written, it waits for the flusher if the queue is full. A put after
+// Close is ignored and one that is waiting when the cache is closed is
+// not written, both return false
+func (w *WriteBehindCache) Put(key string, value string) (created bool) {
+	if _, ok := w.queue(key, pending{value: value}); !ok {
+		return false
+	}
+	return w.UnImplementedCache.Put(key, value)
+}
+
+// Delete deletes the key from the cache and queues its delete from the
+// store, ok is false if the key is neither in the cache nor queued even
+// though its delete is queued, the store is not read to tell
+func (w *WriteBehindCache) Delete(key string) (ok bool) {
+	previous, queued := w.queue(key, pending{deleted: true})
+	if !queued {
+		return false
+	}
+	ok = w.UnImplementedCache.Delete(key)
+	return ok || (previous != nil && !previous.deleted)
+}
+
+// queue queues the dirty entry before it is applied to the cache, so a load
+// of the key that completes after it does not overwrite it in the cache. It
+// returns the entry it replaces and false if the cache is closed
+func (w *WriteBehindCache) queue(key string, p pending) (*pending, bool) {
+	defer w.Unlock()
+	w.Lock()
+	if w.closed {
+		return nil, false
+	}
+	previous, queued := w.dirty[key]
+	for !queued && len(w.dirty) >= w.maxDirty && !w.closed {
+		w.space.Wait()
+		previous, queued = w.dirty[key]
+	}
+	if w.closed && !queued {
+		return nil, false
+	}
+	if !queued {
+		w.order = append(w.order, key)
+		w.dirty[key] = p
+		return nil, true
+	}
+	w.dirty[key] = p
+	return &previous, true
+}
+
+// Dirty returns the number of entries that are not written yet
+func (w *WriteBehindCache) Dirty() int {
+	defer w.Unlock()
+	w.Lock()
+	return len(w.dirty)
+}
+
+// Flush writes all the dirty entries once and returns the first error
+func (w *WriteBehindCache) Flush() error {
+	_, err := w.flush()
+	return err
+}
+
+// Close stops the flusher and writes the dirty entries, the failed writes
+// are retried as long as each pass writes some of them. Then it closes the
+// wrapped cache. It returns the error of the last pass if there are still
+// dirty entries
+func (w *WriteBehindCache) Close() error {
+	w.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+		w.space.Broadcast()
+	}
+	w.Unlock()
+	<-w.stopped
+	for {
+		written, err := w.flush()
+		if err == nil || written == 0 {
+			if e := cache.Close(w.UnImplementedCache); err == nil {
+				err = e
+			}
+			return err
+		}
+	}
+}
+
+// flush writes the dirty entries in the order they were queued and returns
+// the number of written entries and the first error, an entry that is put
+// or deleted again while it is written stays dirty
+func (w *WriteBehindCache) flush() (written int, err error) {
+	defer w.flushing.Unlock()
+	w.flushing.Lock()
+
+	w.Lock()
+	batch := make([]string, len(w.order))
+	copy(batch, w.order)
+	entries := make([]pending, len(batch))
+	for i, key := range batch {
+		entries[i] = w.dirty[key]
+	}
+	w.Unlock()
+
+	for start := 0; start < len(batch); start += flushBatch {
+		end := start + flushBatch
+		if end > len(batch) {
+			end = len(batch)
+		}
+		errs := w.write(batch[start:end], entries[start:end])
+		for i, key := range batch[start:end] {
+			if errs[i] != nil {
+				if err == nil {
+					err = errs[i]
+				}
+				continue
+			}
+			written++
+			w.Lock()
+			if w.dirty[key] == entries[start+i] {
+				delete(w.dirty, key)
+			}
+			w.Unlock()
+		}
+	}
+
+	w.Lock()
+	// a key written and put again is queued twice
+	order := make([]string, 0, len(w.dirty))
+	seen := make(map[string]bool, len(w.dirty))
+	for _, key := range w.order {
+		if _, ok := w.dirty[key]; ok && !seen[key] {
+			seen[key] = true
+			order = append(order, key)
+		}
+	}
+	w.order = order
+	w.space.Broadcast()
+	w.Unlock()
+	return written, err
+}
+
+// write writes the dirty entries to the store and returns the error of
+// each of them, the values are saved in a single call of a cache.BatchStore
+// and the deletes of the keys that are not in the store succeed
+func (w *WriteBehindCache) write(keys []string, entries []pending) []error {
+	errs := make([]error, len(keys))
+	batch, _ := w.store.(cache.BatchStore)
+	saves := map[string]string{}
+	for i, key := range keys {
+		switch {
+		case entries[i].deleted:
+			if errs[i] = w.store.Delete(key); errs[i] == cache.ErrNotFound {
+				errs[i] = nil
+			}
+		case batch != nil:
+			saves[key] = entries[i].value
+		default:
+			errs[i] = w.store.Save(key, entries[i].value)
+		}
+	}
+	if len(saves) == 0 {
+		return errs
+	}
+	err := batch.SaveMulti(saves)
+	for i := range keys {
+		if !entries[i].deleted {
+			errs[i] = err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,269 @@
+package writebehind
+
+import (
+	"errors"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// store is a backing store that fails every other write when it is flaky
+type store struct {
+	sync.Mutex
+	data  map[string]string
+	calls int
+	flaky bool
+}
+
+func (s *store) write(key, value string) error {
+	defer s.Unlock()
+	s.Lock()
+	s.calls++
+	if s.flaky && s.calls%2 == 0 {
+		return errors.New("write failed")
+	}
+	s.data[key] = value
+	return nil
+}
+
+func (s *store) get(key string) (string, bool) {
+	defer s.Unlock()
+	s.Lock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func TestWriteBehindCache(t *testing.T) {
+	s := &store{data: map[string]string{}}
+	cache := NewCache(lrucache.NewCache(100), s.write, 10, time.Millisecond)
+	defer cache.Close()
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	deadline := time.Now().Add(time.Second)
+	for cache.Dirty() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if value, ok := s.get("2"); !ok || value != "2" {
+		t.Errorf("Expected 2 to be written in the background")
+	}
+	if value, ok := cache.Get("1"); !ok || value != "1" {
+		t.Errorf("Expected 1 from the cache but got %s %t", value, ok)
+	}
+}
+
+func TestWriteBehindCache_FlakyClose(t *testing.T) {
+	s := &store{data: map[string]string{}, flaky: true}
+	cache := NewCache(lrucache.NewCache(5), s.write, 100, time.Hour)
+	for i := 0; i < 50; i++ {
+		cache.Put(strconv.Itoa(i), strconv.Itoa(i))
+	}
+	cache.Put("0", "last")
+	if err := cache.Flush(); err == nil {
+		t.Errorf("Expected the flaky writer to fail")
+	}
+	if err := cache.Close(); err != nil {
+		t.Errorf("Expected Close to retry the failed writes but got %v", err)
+	}
+	if cache.Dirty() != 0 {
+		t.Errorf("Expected no dirty entry left but got %d", cache.Dirty())
+	}
+	for i := 1; i < 50; i++ {
+		if value, ok := s.get(strconv.Itoa(i)); !ok || value != strconv.Itoa(i) {
+			t.Errorf("Expected %d to be written but got %s %t", i, value, ok)
+		}
+	}
+	if value, _ := s.get("0"); value != "last" {
+		t.Errorf("Expected the last value of 0 to be written but got %s", value)
+	}
+}
+
+func TestWriteBehindCache_EvictedDirty(t *testing.T) {
+	s := &store{data: map[string]string{}}
+	cache := NewCache(lrucache.NewCache(2), s.write, 10, time.Hour)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	cache.Put("3", "3")
+	if value, ok := cache.Get("1"); !ok || value != "1" {
+		t.Errorf("Expected the evicted dirty entry 1 but got %s %t", value, ok)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if value, ok := s.get("1"); !ok || value != "1" {
+		t.Errorf("Expected the evicted dirty entry to be written")
+	}
+}
+
+func TestWriteBehindCache_Bounded(t *testing.T) {
+	s := &store{data: map[string]string{}}
+	cache := NewCache(lrucache.NewCache(100), s.write, 2, time.Hour)
+	cache.Put("1", "1")
+	cache.Put("2", "2")
+	put := make(chan bool)
+	go func() {
+		put <- cache.Put("3", "3")
+	}()
+	select {
+	case <-put:
+		t.Fatalf("Expected the put to wait for the full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatalf("Flush failed %v", err)
+	}
+	select {
+	case <-put:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the flush to make room for the put")
+	}
+	if err := cache.Close(); err != nil || cache.Dirty() != 0 {
+		t.Errorf("Expected all the entries to be written")
+	}
+}
+
+func TestWriteBehindCache_Defaults(t *testing.T) {
+	s := &store{data: map[string]string{}}
+	cache := NewCache(lrucache.NewCache(100), s.write, 0, 0)
+	defer cache.Close()
+	if cache.maxDirty != defaultMaxDirty {
+		t.Errorf("Expected the default of the dirty entries but got %d", cache.maxDirty)
+	}
+	put := make(chan bool)
+	go func() {
+		put <- cache.Put("1", "1")
+	}()
+	select {
+	case <-put:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the put not to wait with no dirty entries")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cache.Dirty() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := s.get("1"); !ok {
+		t.Errorf("Expected 1 to be written every default interval")
+	}
+	NewCache(lrucache.NewCache(100), s.write, -1, -time.Second).Close()
+}
+
+func TestWriteBehindCache_CloseGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s := &store{data: map[string]string{}}
+	for i := 0; i < 20; i++ {
+		cache := NewCache(lrucache.NewCache(100), s.write, 10, time.Millisecond)
+		cache.Put(strconv.Itoa(i), "x")
+		if err := cache.Close(); err != nil {
+			t.Fatalf("Close failed %v", err)
+		}
+		if err := cache.Close(); err != nil {
+			t.Fatalf("Expected Close to be idempotent but got %v", err)
+		}
+		if cache.Put("after", "x") {
+			t.Errorf("Expected a put after Close to be ignored")
+		}
+	}
+	if _, ok := s.get("after"); ok || len(s.data) != 20 {
+		t.Errorf("Expected the puts before Close to be written and the ones after ignored")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected no goroutine leak but got %d goroutines, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// db is a batch store that records the calls
+type db struct {
+	sync.Mutex
+	data    map[string]string
+	batches int
+	deletes int
+}
+
+func (d *db) Load(key string) (string, error) {
+	defer d.Unlock()
+	d.Lock()
+	value, ok := d.data[key]
+	if !ok {
+		return "", cache.ErrNotFound
+	}
+	return value, nil
+}
+
+func (d *db) Save(key, value string) error {
+	return d.SaveMulti(map[string]string{key: value})
+}
+
+func (d *db) SaveMulti(entries map[string]string) error {
+	defer d.Unlock()
+	d.Lock()
+	d.batches++
+	for key, value := range entries {
+		d.data[key] = value
+	}
+	return nil
+}
+
+func (d *db) Delete(key string) error {
+	defer d.Unlock()
+	d.Lock()
+	d.deletes++
+	if _, ok := d.data[key]; !ok {
+		return cache.ErrNotFound
+	}
+	delete(d.data, key)
+	return nil
+}
+
+func TestWriteBehindCache_Store(t *testing.T) {
+	d := &db{data: map[string]string{"1": "one", "2": "two"}}
+	c := lrucache.NewCache(100)
+	w := NewStoreCache(c, d, 1000, time.Hour)
+	defer w.Close()
+
+	if value, ok := w.Get("1"); !ok || value != "one" {
+		t.Errorf("Expected one loaded from the store but got %s", value)
+	}
+	if _, ok := c.Get("1"); !ok || w.Dirty() != 0 {
+		t.Errorf("Expected the loaded entry to be cached and not dirty")
+	}
+	if _, ok := w.Get("3"); ok {
+		t.Errorf("Expected a miss")
+	}
+
+	if w.Delete("2") {
+		t.Errorf("Expected 2 to be unknown to the cache")
+	}
+	if _, ok := w.Get("2"); ok {
+		t.Errorf("Expected the queued delete to hide 2 of the store")
+	}
+	for i := 0; i < 300; i++ {
+		w.Put(strconv.Itoa(i+10), "x")
+	}
+	w.Put("4", "four")
+	if !w.Delete("4") {
+		t.Errorf("Expected the queued 4 to be deleted")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed %v", err)
+	}
+	if _, ok := d.data["2"]; ok {
+		t.Errorf("Expected the delete to be written")
+	}
+	if len(d.data) != 301 || d.deletes != 2 {
+		t.Errorf("Expected the puts and the deletes to be written but got %d entries %d deletes", len(d.data), d.deletes)
+	}
+	if d.batches != 3 {
+		t.Errorf("Expected the 300 puts in 3 batches but got %d", d.batches)
+	}
+	if _, ok := w.Get("2"); ok {
+		t.Errorf("Expected 2 to stay deleted")
+	}
+}
@@ -0,0 +1,101 @@
+// Package writethrough implements a cache that writes the puts and the
+// deletes to a backing store synchronously
+package writethrough
+
+import (
+	"github.com/arazmj/gerdu/cache"
+	log "github.com/sirupsen/logrus"
+	"hash/fnv"
+	"sync"
+)
+
+// stripes is the number of the locks the keys are striped over
+const stripes = 64
+
+// WriteThroughCache wraps a cache in front of a store, a put or a delete is
+// applied to the store first and to the cache only once the store accepts
+// it, so the cache never holds a value the store does not. A miss is loaded
+// from the store and put in the cache, the loads and the writes of a key are
+// ordered by a lock so a load does not put a value a write replaced
+type WriteThroughCache struct {
+	cache.UnImplementedCache
+	store cache.Store
+	locks [stripes]sync.Mutex
+}
+
+// the cache must satisfy the interfaces used by the servers
+var (
+	_ cache.UnImplementedCache = (*WriteThroughCache)(nil)
+	_ cache.Closer             = (*WriteThroughCache)(nil)
+)
+
+// NewCache WriteThroughCache constructor
+func NewCache(c cache.UnImplementedCache, store cache.Store) *WriteThroughCache {
+	return &WriteThroughCache{UnImplementedCache: c, store: store}
+}
+
+// lock returns the lock of the key
+func (w *WriteThroughCache) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &w.locks[h.Sum32()%stripes]
+}
+
+// Get returns the value for the key from the cache, or loads it from the
+// store and puts it in the cache. An error of the store is logged and
+// counts as a miss
+func (w *WriteThroughCache) Get(key string) (value string, ok bool) {
+	if value, ok = w.UnImplementedCache.Get(key); ok {
+		return value, true
+	}
+	mu := w.lock(key)
+	defer mu.Unlock()
+	mu.Lock()
+	// another Get may have loaded the key in the meantime
+	if value, ok = w.UnImplementedCache.Get(key); ok {
+		return value, true
+	}
+	value, err := w.store.Load(key)
+	if err != nil {
+		if err != cache.ErrNotFound {
+			log.Errorf("Cannot load %s from the store %v", key, err)
+		}
+		return "", false
+	}
+	w.UnImplementedCache.Put(key, value)
+	return value, true
+}
+
+// Put saves the entry to the store and then puts it in the cache, it
+// returns false without changing the cache if the store fails
+func (w *WriteThroughCache) Put(key string, value string) (created bool) {
+	mu := w.lock(key)
+	defer mu.Unlock()
+	mu.Lock()
+	if err := w.store.Save(key, value); err != nil {
+		log.Errorf("Cannot save %s to the store %v", key, err)
+		return false
+	}
+	return w.UnImplementedCache.Put(key, value)
+}
+
+// Delete deletes the key from the store and then from the cache, ok is
+// false if the key is in neither or the store fails. The key is kept in the
+// cache if the store fails
+func (w *WriteThroughCache) Delete(key string) (ok bool) {
+	mu := w.lock(key)
+	defer mu.Unlock()
+	mu.Lock()
+	err := w.store.Delete(key)
+	if err != nil && err != cache.ErrNotFound {
+		log.Errorf("Cannot delete %s from the store %v", key, err)
+		return false
+	}
+	ok = w.UnImplementedCache.Delete(key)
+	return ok || err == nil
+}
+
+// Close closes the wrapped cache
+func (w *WriteThroughCache) Close() error {
+	return cache.Close(w.UnImplementedCache)
+}
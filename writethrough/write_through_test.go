@@ -0,0 +1,145 @@
+package writethrough
+
+import (
+	"errors"
+	"github.com/arazmj/gerdu/cache"
+	"github.com/arazmj/gerdu/lrucache"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// store is a backing store that fails the writes while it is down
+type store struct {
+	sync.Mutex
+	data  map[string]string
+	loads int
+	down  bool
+}
+
+var errDown = errors.New("the store is down")
+
+func (s *store) Load(key string) (string, error) {
+	defer s.Unlock()
+	s.Lock()
+	s.loads++
+	value, ok := s.data[key]
+	if !ok {
+		return "", cache.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *store) Save(key, value string) error {
+	defer s.Unlock()
+	s.Lock()
+	if s.down {
+		return errDown
+	}
+	s.data[key] = value
+	return nil
+}
+
+func (s *store) Delete(key string) error {
+	defer s.Unlock()
+	s.Lock()
+	if s.down {
+		return errDown
+	}
+	if _, ok := s.data[key]; !ok {
+		return cache.ErrNotFound
+	}
+	delete(s.data, key)
+	return nil
+}
+
+func TestWriteThroughCache(t *testing.T) {
+	s := &store{data: map[string]string{"1": "one"}}
+	c := lrucache.NewCache(100)
+	w := NewCache(c, s)
+	defer w.Close()
+
+	if value, ok := w.Get("1"); !ok || value != "one" || s.loads != 1 {
+		t.Errorf("Expected one loaded from the store but got %s", value)
+	}
+	if value, ok := w.Get("1"); !ok || value != "one" || s.loads != 1 {
+		t.Errorf("Expected one from the cache without a load but got %s", value)
+	}
+	if _, ok := w.Get("2"); ok {
+		t.Errorf("Expected a miss")
+	}
+
+	w.Put("2", "two")
+	if value := s.data["2"]; value != "two" {
+		t.Errorf("Expected the put to be saved but got %s", value)
+	}
+	if value, ok := c.Get("2"); !ok || value != "two" {
+		t.Errorf("Expected the put to be cached but got %s", value)
+	}
+
+	if !w.Delete("1") {
+		t.Errorf("Expected 1 to be deleted")
+	}
+	if _, ok := s.data["1"]; ok {
+		t.Errorf("Expected 1 to be deleted from the store")
+	}
+	if _, ok := w.Get("1"); ok {
+		t.Errorf("Expected 1 to be deleted from both")
+	}
+	if w.Delete("1") {
+		t.Errorf("Expected 1 to be already deleted")
+	}
+
+	// a key that is only in the store is deleted from it
+	s.data["3"] = "three"
+	if !w.Delete("3") {
+		t.Errorf("Expected 3 to be deleted from the store")
+	}
+}
+
+func TestWriteThroughCache_StoreDown(t *testing.T) {
+	s := &store{data: map[string]string{}}
+	c := lrucache.NewCache(100)
+	w := NewCache(c, s)
+	w.Put("1", "one")
+	s.down = true
+	if w.Put("2", "two") {
+		t.Errorf("Expected the put to fail")
+	}
+	if _, ok := c.Get("2"); ok {
+		t.Errorf("Expected a failed put not to be cached")
+	}
+	if w.Delete("1") {
+		t.Errorf("Expected the delete to fail")
+	}
+	if value, ok := w.Get("1"); !ok || value != "one" {
+		t.Errorf("Expected a failed delete to keep the entry but got %s", value)
+	}
+}
+
+func TestWriteThroughCache_Concurrent(t *testing.T) {
+	s := &store{data: map[string]string{}}
+	w := NewCache(lrucache.NewCache(100), s)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				w.Put("key", strconv.Itoa(i))
+				w.Delete("key")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				w.Get("key")
+			}
+		}()
+	}
+	wg.Wait()
+	w.Put("key", "last")
+	if value, ok := w.Get("key"); !ok || value != s.data["key"] {
+		t.Errorf("Expected the cache to agree with the store but got %s", value)
+	}
+}